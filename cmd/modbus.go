@@ -0,0 +1,519 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core"
+	"github.com/fluxionwatt/gridbeat/internal/db"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// modbusFlags：modbus 子命令共用的连接与输出参数
+// modbusFlags: connection and output flags shared by the modbus subcommands.
+type modbusFlags struct {
+	url      string
+	channel  string
+	speed    uint
+	dataBits uint
+	parity   string
+	stopBits uint
+	timeout  time.Duration
+	unit     uint8
+	output   string
+}
+
+// modbusValue：读写命令输出的一行
+// modbusValue: one output row of the read and write commands.
+type modbusValue struct {
+	Address uint16        `json:"address"`
+	Raw     string        `json:"raw"` // 寄存器十六进制或线圈状态 / registers in hex, or coil states
+	Value   models.Scalar `json:"value"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// modbusCmd：现场调试用的 Modbus 读、写与总线扫描命令
+// modbusCmd: Modbus read, write and bus scan commands for field work.
+func modbusCmd() *cobra.Command {
+	f := &modbusFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "modbus",
+		Short: "Ad-hoc Modbus reads, writes and bus scans",
+		Long: `Ad-hoc Modbus reads, writes and bus scans.
+
+The target is given with --url (tcp://host:502, rtu:///dev/ttyUSB0, rtuovertcp://host:port, ...)
+or taken from a channel in the database with --channel (channel uuid or serial device), in which
+case the channel's serial settings and timeout are used unless overridden by flags. TCP channels
+are reached over Modbus TCP at their primary address, or at their backup address when the
+primary cannot be connected to, as the channel itself does.
+Stop the server first when using a serial port it owns.`,
+	}
+
+	pf := cmd.PersistentFlags()
+	pf.StringVar(&f.url, "url", "", "target url, e.g. tcp://10.0.0.5:502 or rtu:///dev/ttyUSB0")
+	pf.StringVar(&f.channel, "channel", "", "use the connection settings of a channel (uuid or serial device)")
+	pf.UintVar(&f.speed, "speed", 0, "serial speed in bps (default 19200)")
+	pf.UintVar(&f.dataBits, "data-bits", 0, "serial data bits (default 8)")
+	pf.StringVar(&f.parity, "parity", "", "serial parity: none, even or odd (default none)")
+	pf.UintVar(&f.stopBits, "stop-bits", 0, "serial stop bits (default 2 without parity, 1 otherwise)")
+	pf.DurationVar(&f.timeout, "timeout", 0, "request timeout (default 300ms for rtu, 1s otherwise)")
+	pf.Uint8VarP(&f.unit, "unit", "u", 1, "unit (slave) id")
+	pf.StringVarP(&f.output, "output", "o", "table", "output format: table or json")
+
+	cmd.AddCommand(modbusReadCmd(f), modbusWriteCmd(f), modbusScanCmd(f))
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(modbusCmd())
+}
+
+// modbusReadCmd：读线圈、离散输入或寄存器并按数据类型解码
+// modbusReadCmd: read coils, discrete inputs or registers and decode them by data type.
+func modbusReadCmd(f *modbusFlags) *cobra.Command {
+	var kind, dataType, byteOrder string
+	var registers uint16
+
+	cmd := &cobra.Command{
+		Use:   "read <address> [count]",
+		Short: "Read coils, discrete inputs or registers",
+		Example: `  gridbeat modbus read --url tcp://10.0.0.5:502 -u 3 100 2 --type float32 --byte-order CDAB
+  gridbeat modbus read --channel /dev/ttyUSB0 0 16 --kind coil -o json`,
+		Args:         cobra.RangeArgs(1, 2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr, count, err := parseAddressCount(args)
+			if err != nil {
+				return err
+			}
+
+			p, err := cliPoint(kind, dataType, byteOrder, addr, registers)
+			if err != nil {
+				return err
+			}
+			codec, err := point.NewCodec(p)
+			if err != nil {
+				return err
+			}
+
+			client, err := openModbusClient(f, 0)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			rows, err := readValues(client, p, codec, count)
+			if err != nil {
+				return err
+			}
+			return printValues(cmd.OutOrStdout(), f.output, rows)
+		},
+	}
+
+	fl := cmd.Flags()
+	fl.StringVarP(&kind, "kind", "k", "holding", "object kind: coil, discrete, holding or input")
+	fl.StringVarP(&dataType, "type", "t", "uint16", "data type: int16, uint16, int32, uint32, int64, uint64, float32, float64, bool, string, bcd, bitmask")
+	fl.StringVar(&byteOrder, "byte-order", "ABCD", "byte order: ABCD, BADC, CDAB or DCBA")
+	fl.Uint16Var(&registers, "registers", 1, "registers per value for string, bcd and bitmask types")
+	return cmd
+}
+
+// modbusWriteCmd：按数据类型编码并写入线圈或保持寄存器
+// modbusWriteCmd: encode values by data type and write them to coils or holding registers.
+func modbusWriteCmd(f *modbusFlags) *cobra.Command {
+	var kind, dataType, byteOrder string
+	var registers uint16
+	var multiple bool
+
+	cmd := &cobra.Command{
+		Use:   "write <address> <value>...",
+		Short: "Write coils or holding registers",
+		Example: `  gridbeat modbus write --url tcp://10.0.0.5:502 -u 3 100 21.5 --type float32
+  gridbeat modbus write --url tcp://10.0.0.5:502 -u 3 --type int16 -- 100 -20
+  gridbeat modbus write --channel /dev/ttyUSB0 -u 2 0 on off --kind coil`,
+		Args:         cobra.MinimumNArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr64, err := strconv.ParseUint(args[0], 0, 16)
+			if err != nil {
+				return fmt.Errorf("invalid address %q", args[0])
+			}
+			addr := uint16(addr64)
+
+			if kind != "coil" && kind != "holding" {
+				return fmt.Errorf("kind must be coil or holding")
+			}
+			p, err := cliPoint(kind, dataType, byteOrder, addr, registers)
+			if err != nil {
+				return err
+			}
+			codec, err := point.NewCodec(p)
+			if err != nil {
+				return err
+			}
+
+			var rows []modbusValue
+			var words []uint16
+			var bits []bool
+			for i, s := range args[1:] {
+				v := parseCLIValue(s)
+				row := modbusValue{Value: v}
+				if kind == "coil" {
+					on, err := codec.EncodeBit(v)
+					if err != nil {
+						return fmt.Errorf("value %q: %w", s, err)
+					}
+					row.Address = addr + uint16(i)
+					row.Raw = formatBits([]bool{on})
+					row.Value.SetBool(on)
+					bits = append(bits, on)
+				} else {
+					w, err := codec.Encode(v)
+					if err != nil {
+						return fmt.Errorf("value %q: %w", s, err)
+					}
+					row.Address = addr + uint16(len(words))
+					row.Raw = formatWords(w)
+					// 回显编码后的值 / echo the value as encoded
+					if d, err := codec.Decode(w); err == nil {
+						row.Value = d
+					}
+					words = append(words, w...)
+				}
+				rows = append(rows, row)
+			}
+
+			client, err := openModbusClient(f, 0)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			// 单个对象默认用单写功能码（0x05/0x06），--multiple 强制多写（0x0f/0x10）
+			// Single objects use the single write codes (0x05/0x06) unless --multiple forces 0x0f/0x10.
+			switch {
+			case kind == "coil" && len(bits) == 1 && !multiple:
+				err = client.WriteCoil(addr, bits[0])
+			case kind == "coil":
+				err = client.WriteCoils(addr, bits)
+			case len(words) == 1 && !multiple:
+				err = client.WriteRegister(addr, words[0])
+			default:
+				err = client.WriteRegisters(addr, words)
+			}
+			if err != nil {
+				return err
+			}
+			return printValues(cmd.OutOrStdout(), f.output, rows)
+		},
+	}
+
+	fl := cmd.Flags()
+	fl.StringVarP(&kind, "kind", "k", "holding", "object kind: coil or holding")
+	fl.StringVarP(&dataType, "type", "t", "uint16", "data type: int16, uint16, int32, uint32, int64, uint64, float32, float64, bool, string, bcd, bitmask")
+	fl.StringVar(&byteOrder, "byte-order", "ABCD", "byte order: ABCD, BADC, CDAB or DCBA")
+	fl.Uint16Var(&registers, "registers", 1, "registers per value for string, bcd and bitmask types")
+	fl.BoolVar(&multiple, "multiple", false, "always use write multiple coils/registers (0x0f/0x10)")
+	return cmd
+}
+
+// parseAddressCount：解析 <address> [count] 参数
+// parseAddressCount: parse the <address> [count] arguments.
+func parseAddressCount(args []string) (addr, count uint16, err error) {
+	n, err := strconv.ParseUint(args[0], 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid address %q", args[0])
+	}
+	addr, count = uint16(n), 1
+
+	if len(args) > 1 {
+		n, err = strconv.ParseUint(args[1], 0, 16)
+		if err != nil || n == 0 {
+			return 0, 0, fmt.Errorf("invalid count %q", args[1])
+		}
+		count = uint16(n)
+	}
+	return addr, count, nil
+}
+
+// cliPoint：由命令行参数构造点位定义，复用点位编解码
+// cliPoint: build a point definition from command line flags, to reuse the point codec.
+func cliPoint(kind, dataType, byteOrder string, addr, registers uint16) (*models.DeviceTypePoint, error) {
+	p := &models.DeviceTypePoint{
+		Address:   addr,
+		Quantity:  registers,
+		DataType:  dataType,
+		ByteOrder: byteOrder,
+		Scale:     1,
+	}
+
+	switch kind {
+	case "coil":
+		p.FC, p.PointKind, p.DataType = 1, models.RegCoil, "bool"
+	case "discrete":
+		p.FC, p.PointKind, p.DataType = 2, models.RegDiscrete, "bool"
+	case "holding":
+		p.FC, p.PointKind = 3, models.RegHolding
+	case "input":
+		p.FC, p.PointKind = 4, models.RegInput
+	default:
+		return nil, fmt.Errorf("unknown kind %q (coil, discrete, holding or input)", kind)
+	}
+	return p, nil
+}
+
+// parseCLIValue：把命令行上的值转换为 Scalar，数值与枚举标签由编解码器解析
+// parseCLIValue: turn a command line value into a Scalar; numbers and enum
+// labels are parsed by the codec.
+func parseCLIValue(s string) models.Scalar {
+	var v models.Scalar
+	switch strings.ToLower(s) {
+	case "true", "on":
+		v.SetBool(true)
+	case "false", "off":
+		v.SetBool(false)
+	default:
+		v.SetString(s)
+	}
+	return v
+}
+
+// readValues：读取 count 个连续的值并解码
+// readValues: read count consecutive values and decode them.
+func readValues(client *modbus.ModbusClient, p *models.DeviceTypePoint, codec *point.Codec, count uint16) ([]modbusValue, error) {
+	var rows []modbusValue
+
+	if p.FC == 1 || p.FC == 2 {
+		var bits []bool
+		var err error
+		if p.FC == 1 {
+			bits, err = client.ReadCoils(p.Address, count)
+		} else {
+			bits, err = client.ReadDiscreteInputs(p.Address, count)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range bits {
+			v, _ := codec.DecodeBits(bits[i : i+1])
+			rows = append(rows, modbusValue{Address: p.Address + uint16(i), Raw: formatBits(bits[i : i+1]), Value: v})
+		}
+		return rows, nil
+	}
+
+	width := codec.Registers()
+	total := width * int(count)
+	if total > 125 {
+		return nil, fmt.Errorf("%d values of %d registers exceed the 125 registers of a single read", count, width)
+	}
+
+	regType := modbus.HOLDING_REGISTER
+	if p.FC == 4 {
+		regType = modbus.INPUT_REGISTER
+	}
+	words, err := client.ReadRegisters(p.Address, uint16(total), regType)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < int(count); i++ {
+		w := words[i*width : (i+1)*width]
+		row := modbusValue{Address: p.Address + uint16(i*width), Raw: formatWords(w)}
+		if v, err := codec.Decode(w); err != nil {
+			row.Error = err.Error()
+		} else {
+			row.Value = v
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// openModbusClient：按参数（或通道配置）创建并打开 client；timeout 非 0 时作为未指定 --timeout 时的默认值
+// openModbusClient: create and open a client from the flags (or the channel
+// config); a non-zero timeout is the default when --timeout is not given.
+func openModbusClient(f *modbusFlags, timeout time.Duration) (*modbus.ModbusClient, error) {
+	conf, urls, err := modbusClientConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if f.timeout > 0 {
+		conf.Timeout = f.timeout
+	} else if timeout > 0 {
+		conf.Timeout = timeout
+	}
+
+	client, err := openFirst(conf, urls)
+	if err != nil {
+		return nil, err
+	}
+	_ = client.SetUnitId(f.unit)
+	return client, nil
+}
+
+// openFirst：依次尝试 urls，返回第一个能打开的 client
+// openFirst: try urls in order and return a client on the first one that opens.
+func openFirst(conf *modbus.ClientConfiguration, urls []string) (client *modbus.ModbusClient, err error) {
+	for _, url := range urls {
+		c := *conf
+		c.URL = url
+		if client, err = modbus.NewClient(&c); err != nil {
+			return nil, err
+		}
+		if err = client.Open(); err == nil {
+			return client, nil
+		}
+		err = fmt.Errorf("open %s: %w", url, err)
+	}
+	return nil, err
+}
+
+// modbusClientConfig：由 --url 或 --channel 生成 client 配置与依次尝试的地址，串口参数可被命令行覆盖
+// modbusClientConfig: build the client config and the urls to try in turn from
+// --url or --channel; serial settings can be overridden on the command line.
+func modbusClientConfig(f *modbusFlags) (*modbus.ClientConfiguration, []string, error) {
+	conf := &modbus.ClientConfiguration{URL: f.url}
+	urls := []string{f.url}
+
+	switch {
+	case f.channel != "" && f.url != "":
+		return nil, nil, errors.New("use either --url or --channel")
+	case f.channel != "":
+		ch, err := lookupChannel(f.channel)
+		if err != nil {
+			return nil, nil, err
+		}
+		conf, urls = channelClientConfig(ch)
+	case f.url == "":
+		return nil, nil, errors.New("--url or --channel required")
+	}
+
+	if f.speed > 0 {
+		conf.Speed = f.speed
+	}
+	if f.dataBits > 0 {
+		conf.DataBits = f.dataBits
+	}
+	if f.stopBits > 0 {
+		conf.StopBits = f.stopBits
+	}
+	switch strings.ToLower(f.parity) {
+	case "":
+	case "none", "n":
+		conf.Parity = modbus.PARITY_NONE
+	case "even", "e":
+		conf.Parity = modbus.PARITY_EVEN
+	case "odd", "o":
+		conf.Parity = modbus.PARITY_ODD
+	default:
+		return nil, nil, fmt.Errorf("invalid parity %q", f.parity)
+	}
+	return conf, urls, nil
+}
+
+// channelClientConfig：通道的 client 配置与地址：串口通道为其设备，
+// TCP 通道为主地址，配置了不同的备用地址时随后为备用地址（与通道自身的主备切换一致）
+// channelClientConfig: client config and urls of a channel: its device for
+// serial channels; for TCP channels the primary address, followed by the
+// backup address when a different one is set (as the channel itself fails over).
+func channelClientConfig(ch *models.Channel) (*modbus.ClientConfiguration, []string) {
+	conf := &modbus.ClientConfiguration{
+		Timeout:        ch.Delay,
+		ConnectTimeout: ch.OnnectTimeout,
+	}
+
+	if ch.PhysicalLink == "serial" {
+		conf.URL = "rtu://" + ch.Device
+		conf.Speed = ch.Speed
+		conf.DataBits = ch.DataBits
+		conf.Parity = ch.Parity
+		conf.StopBits = ch.StopBits
+		return conf, []string{conf.URL}
+	}
+
+	primary := fmt.Sprintf("%s:%d", ch.TCPIPAddr, ch.TCPPort)
+	conf.URL = "tcp://" + primary
+	urls := []string{conf.URL}
+	if ch.BackupTCPIPAddr != "" && ch.BackupTCPPort != 0 {
+		if backup := fmt.Sprintf("%s:%d", ch.BackupTCPIPAddr, ch.BackupTCPPort); backup != primary {
+			urls = append(urls, "tcp://"+backup)
+		}
+	}
+	return conf, urls
+}
+
+// lookupChannel：按 UUID 或串口设备路径从数据库读取通道
+// lookupChannel: load a channel from the database by uuid or serial device path.
+func lookupChannel(key string) (*models.Channel, error) {
+	gdb, err := db.Open(&core.Gconfig, logrus.New())
+	if err != nil {
+		return nil, err
+	}
+	if sqlDB, err := gdb.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	var ch models.Channel
+	if err := gdb.Where("uuid = ? OR (physical_link = ? AND device = ?)", key, "serial", key).
+		First(&ch).Error; err != nil {
+		return nil, fmt.Errorf("channel %s: %w", key, err)
+	}
+	return &ch, nil
+}
+
+// formatWords / formatBits：原始数据的文本形式
+// formatWords / formatBits: text form of raw data.
+func formatWords(words []uint16) string {
+	parts := make([]string, len(words))
+	for i, w := range words {
+		parts[i] = fmt.Sprintf("0x%04x", w)
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatBits(bits []bool) string {
+	parts := make([]string, len(bits))
+	for i, b := range bits {
+		parts[i] = "0"
+		if b {
+			parts[i] = "1"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// printValues：以表格或 JSON 输出读写结果
+// printValues: print read/write results as a table or JSON.
+func printValues(w io.Writer, output string, rows []modbusValue) error {
+	if output == "json" {
+		return printJSON(w, rows)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDRESS\tRAW\tVALUE")
+	for _, r := range rows {
+		value := point.Format(r.Value)
+		if r.Error != "" {
+			value = "error: " + r.Error
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", r.Address, r.Raw, value)
+	}
+	return tw.Flush()
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
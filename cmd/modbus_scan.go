@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// 扫描时默认的请求超时：无应答的站号很多，超时不宜过长
+	// default request timeout while scanning: most unit ids are silent, keep it short
+	defaultScanTimeout = 200 * time.Millisecond
+)
+
+// scanProbe：扫描时对每个站号发出的一个读请求，格式 KIND:START[-END]
+// scanProbe: one read request sent to every unit id while scanning, written KIND:START[-END].
+type scanProbe struct {
+	Kind     string `json:"kind"`
+	Address  uint16 `json:"address"`
+	Quantity uint16 `json:"quantity"`
+}
+
+func (p scanProbe) String() string {
+	if p.Quantity == 1 {
+		return fmt.Sprintf("%s:%d", p.Kind, p.Address)
+	}
+	return fmt.Sprintf("%s:%d-%d", p.Kind, p.Address, p.Address+p.Quantity-1)
+}
+
+// scanProbeResult：一个探测请求的结果
+// scanProbeResult: the outcome of one probe.
+type scanProbeResult struct {
+	scanProbe
+	Result string `json:"result"`        // ok，或异常/错误描述 / ok, or the exception/error
+	Raw    string `json:"raw,omitempty"` // 读到的原始数据 / raw data read
+}
+
+// scanUnit：一个有应答的站号
+// scanUnit: a unit id which answered.
+type scanUnit struct {
+	Unit     uint8             `json:"unit"`
+	Probes   []scanProbeResult `json:"probes"`
+	Identity map[string]string `json:"identity,omitempty"`
+}
+
+// modbusScanCmd：遍历站号并探测寄存器区间，找出总线上有应答的设备
+// modbusScanCmd: sweep unit ids and probe register ranges to find the devices answering on a bus.
+func modbusScanCmd(f *modbusFlags) *cobra.Command {
+	var from, to uint8
+	var probes []string
+	var identify bool
+
+	cmd := &cobra.Command{
+		Use:   "scan",
+		Short: "Sweep unit ids and probe register ranges for responsive devices",
+		Long: `Sweep unit ids and probe register ranges for responsive devices.
+
+Every unit id in --from..--to is sent each probe; a unit counts as responsive when it
+answers any probe, with data or with a Modbus exception. Probes are KIND:START[-END]
+with KIND one of coil, discrete, holding or input. --timeout defaults to 200ms here.`,
+		Example: `  gridbeat modbus scan --channel /dev/ttyUSB0
+  gridbeat modbus scan --url rtu:///dev/ttyUSB1 --speed 9600 --parity even --from 1 --to 32 --probe holding:0-9 --probe input:30000-30001 --identify`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if from == 0 || to < from || to > 247 {
+				return errors.New("unit range must be within 1..247")
+			}
+
+			var list []scanProbe
+			for _, s := range probes {
+				p, err := parseScanProbe(s)
+				if err != nil {
+					return err
+				}
+				list = append(list, p)
+			}
+
+			client, err := openModbusClient(f, defaultScanTimeout)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			units, err := scanBus(client, from, to, list, identify, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			if f.output == "json" {
+				return printJSON(cmd.OutOrStdout(), units)
+			}
+			return printScan(cmd.OutOrStdout(), units)
+		},
+	}
+
+	fl := cmd.Flags()
+	fl.Uint8Var(&from, "from", 1, "first unit id")
+	fl.Uint8Var(&to, "to", 247, "last unit id")
+	fl.StringSliceVar(&probes, "probe", []string{"holding:0-9", "input:0-9"}, "probe KIND:START[-END], repeatable")
+	fl.BoolVar(&identify, "identify", false, "read the basic device identification (0x2b/0x0e) of responsive units")
+	return cmd
+}
+
+// parseScanProbe：解析 KIND:START[-END]
+// parseScanProbe: parse KIND:START[-END].
+func parseScanProbe(s string) (scanProbe, error) {
+	var p scanProbe
+
+	kind, span, ok := strings.Cut(s, ":")
+	if !ok {
+		return p, fmt.Errorf("invalid probe %q (KIND:START[-END])", s)
+	}
+	switch kind {
+	case "coil", "discrete", "holding", "input":
+	default:
+		return p, fmt.Errorf("probe %q: unknown kind %q", s, kind)
+	}
+
+	first, last, ranged := strings.Cut(span, "-")
+	start, err := strconv.ParseUint(first, 0, 16)
+	if err != nil {
+		return p, fmt.Errorf("probe %q: invalid start address", s)
+	}
+	end := start
+	if ranged {
+		if end, err = strconv.ParseUint(last, 0, 16); err != nil || end < start {
+			return p, fmt.Errorf("probe %q: invalid end address", s)
+		}
+	}
+
+	max := uint64(125)
+	if kind == "coil" || kind == "discrete" {
+		max = 2000
+	}
+	if end-start+1 > max {
+		return p, fmt.Errorf("probe %q: more than %d objects", s, max)
+	}
+
+	p.Kind, p.Address, p.Quantity = kind, uint16(start), uint16(end-start+1)
+	return p, nil
+}
+
+// scanBus：对每个站号执行全部探测，返回有应答的站号；链路错误（非 Modbus 错误）时中止
+// scanBus: run every probe against every unit id and return the units which
+// answered; link errors (anything but Modbus errors) abort the scan.
+func scanBus(client *modbus.ModbusClient, from, to uint8, probes []scanProbe, identify bool, progress io.Writer) ([]scanUnit, error) {
+	units := []scanUnit{}
+
+	for id := int(from); id <= int(to); id++ {
+		fmt.Fprintf(progress, "\rscanning unit %d/%d, %d found", id, to, len(units))
+
+		if err := client.SetUnitId(uint8(id)); err != nil {
+			return nil, err
+		}
+
+		unit := scanUnit{Unit: uint8(id)}
+		answered := false
+		for _, p := range probes {
+			raw, err := runProbe(client, p)
+			if err != nil {
+				var merr modbus.Error
+				if !errors.As(err, &merr) {
+					fmt.Fprintln(progress)
+					return nil, fmt.Errorf("unit %d: %w", id, err)
+				}
+			}
+
+			res := scanProbeResult{scanProbe: p, Result: "ok", Raw: raw}
+			if err != nil {
+				res.Result = err.Error()
+			}
+			if scanAnswered(err) {
+				answered = true
+			}
+			unit.Probes = append(unit.Probes, res)
+		}
+		if !answered {
+			continue
+		}
+
+		if identify {
+			if objects, err := client.ReadDeviceIdentification(modbus.DEVICE_ID_BASIC); err == nil {
+				unit.Identity = map[string]string{}
+				for obj, v := range objects {
+					unit.Identity[identityName(obj)] = v
+				}
+			}
+		}
+		units = append(units, unit)
+	}
+	fmt.Fprintf(progress, "\rscanned units %d-%d, %d found\n", from, to, len(units))
+
+	return units, nil
+}
+
+// runProbe：执行一个探测请求，返回原始数据的文本形式
+// runProbe: run a probe and return the raw data as text.
+func runProbe(client *modbus.ModbusClient, p scanProbe) (string, error) {
+	switch p.Kind {
+	case "coil", "discrete":
+		var bits []bool
+		var err error
+		if p.Kind == "coil" {
+			bits, err = client.ReadCoils(p.Address, p.Quantity)
+		} else {
+			bits, err = client.ReadDiscreteInputs(p.Address, p.Quantity)
+		}
+		if err != nil {
+			return "", err
+		}
+		return formatBits(bits), nil
+	}
+
+	regType := modbus.HOLDING_REGISTER
+	if p.Kind == "input" {
+		regType = modbus.INPUT_REGISTER
+	}
+	words, err := client.ReadRegisters(p.Address, p.Quantity, regType)
+	if err != nil {
+		return "", err
+	}
+	return formatWords(words), nil
+}
+
+// scanAnswered：设备是否作出了应答（数据或异常响应）
+// scanAnswered: whether the device answered, with data or an exception response.
+func scanAnswered(err error) bool {
+	switch err {
+	case nil, modbus.ErrIllegalFunction, modbus.ErrIllegalDataAddress,
+		modbus.ErrIllegalDataValue, modbus.ErrServerDeviceFailure,
+		modbus.ErrAcknowledge, modbus.ErrServerDeviceBusy, modbus.ErrMemoryParityError:
+		return true
+	}
+	return false
+}
+
+// identityName：基本设备标识对象的名称
+// identityName: name of a basic device identification object.
+func identityName(id uint8) string {
+	switch id {
+	case 0x00:
+		return "vendor"
+	case 0x01:
+		return "product_code"
+	case 0x02:
+		return "revision"
+	}
+	return fmt.Sprintf("0x%02x", id)
+}
+
+// printScan：以表格输出扫描结果，每个探测一行
+// printScan: print scan results as a table, one row per probe.
+func printScan(w io.Writer, units []scanUnit) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "UNIT\tPROBE\tRESULT\tRAW")
+	for _, u := range units {
+		for _, p := range u.Probes {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", u.Unit, p.scanProbe, p.Result, p.Raw)
+		}
+		if len(u.Identity) > 0 {
+			fmt.Fprintf(tw, "%d\tidentity\t%s %s %s\t\n", u.Unit,
+				u.Identity["vendor"], u.Identity["product_code"], u.Identity["revision"])
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+func TestParseAddressCount(t *testing.T) {
+	tests := []struct {
+		args    []string
+		addr    uint16
+		count   uint16
+		wantErr bool
+	}{
+		{[]string{"100"}, 100, 1, false},
+		{[]string{"0x10", "4"}, 16, 4, false},
+		{[]string{"65535", "1"}, 65535, 1, false},
+		{[]string{"65536"}, 0, 0, true},
+		{[]string{"-1"}, 0, 0, true},
+		{[]string{"abc"}, 0, 0, true},
+		{[]string{"1", "0"}, 0, 0, true},
+		{[]string{"1", "x"}, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		addr, count, err := parseAddressCount(tt.args)
+		if (err != nil) != tt.wantErr || addr != tt.addr || count != tt.count {
+			t.Errorf("%v: expected %d, %d (error=%v), got %d, %d (%v)", tt.args, tt.addr, tt.count, tt.wantErr, addr, count, err)
+		}
+	}
+}
+
+func TestCLIPoint(t *testing.T) {
+	tests := []struct {
+		kind     string
+		dataType string
+		fc       uint8
+		pkind    models.RegType
+		wantType string
+		wantErr  bool
+	}{
+		{"coil", "float32", 1, models.RegCoil, "bool", false},
+		{"discrete", "uint16", 2, models.RegDiscrete, "bool", false},
+		{"holding", "float32", 3, models.RegHolding, "float32", false},
+		{"input", "int16", 4, models.RegInput, "int16", false},
+		{"register", "uint16", 0, "", "", true},
+	}
+
+	for _, tt := range tests {
+		p, err := cliPoint(tt.kind, tt.dataType, "CDAB", 10, 2)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.kind)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.kind, err)
+			continue
+		}
+		if p.FC != tt.fc || p.PointKind != tt.pkind || p.DataType != tt.wantType ||
+			p.Address != 10 || p.Quantity != 2 || p.ByteOrder != "CDAB" || p.Scale != 1 {
+			t.Errorf("%s: unexpected point %+v", tt.kind, p)
+		}
+	}
+}
+
+func TestParseScanProbe(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    scanProbe
+		wantErr bool
+	}{
+		{"holding:0-9", scanProbe{"holding", 0, 10}, false},
+		{"input:30000", scanProbe{"input", 30000, 1}, false},
+		{"coil:0x10-0x1f", scanProbe{"coil", 16, 16}, false},
+		{"holding:0-124", scanProbe{"holding", 0, 125}, false},
+		{"discrete:0-1999", scanProbe{"discrete", 0, 2000}, false},
+		{"holding:0-125", scanProbe{}, true},
+		{"coil:0-2000", scanProbe{}, true},
+		{"holding:10-9", scanProbe{}, true},
+		{"holding:a", scanProbe{}, true},
+		{"holding:1-b", scanProbe{}, true},
+		{"register:0", scanProbe{}, true},
+		{"holding", scanProbe{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseScanProbe(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%q: expected %+v (error=%v), got %+v (%v)", tt.in, tt.want, tt.wantErr, got, err)
+		}
+	}
+
+	// String 与解析互逆 / String is the inverse of parsing
+	for _, s := range []string{"holding:0-9", "input:30000"} {
+		if p, _ := parseScanProbe(s); p.String() != s {
+			t.Errorf("%q: String gave %q", s, p.String())
+		}
+	}
+}
+
+func TestScanAnswered(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, true},
+		{modbus.ErrIllegalFunction, true},
+		{modbus.ErrIllegalDataAddress, true},
+		{modbus.ErrServerDeviceBusy, true},
+		{modbus.ErrRequestTimedOut, false},
+		{modbus.ErrBadCRC, false},
+		{modbus.ErrGWTargetFailedToRespond, false},
+		{io.EOF, false},
+	}
+
+	for _, tt := range tests {
+		if got := scanAnswered(tt.err); got != tt.want {
+			t.Errorf("%v: expected %v, got %v", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestChannelClientConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		ch   models.Channel
+		urls []string
+	}{
+		{"serial", models.Channel{PhysicalLink: "serial", Device: "/dev/ttyUSB0", Speed: 9600}, []string{"rtu:///dev/ttyUSB0"}},
+		{"tcp", models.Channel{PhysicalLink: "tcp", TCPIPAddr: "10.0.0.5", TCPPort: 502}, []string{"tcp://10.0.0.5:502"}},
+		{"tcp with backup", models.Channel{PhysicalLink: "tcp", TCPIPAddr: "10.0.0.5", TCPPort: 502, BackupTCPIPAddr: "10.0.0.6", BackupTCPPort: 502},
+			[]string{"tcp://10.0.0.5:502", "tcp://10.0.0.6:502"}},
+		{"backup same as primary", models.Channel{PhysicalLink: "tcp", TCPIPAddr: "10.0.0.5", TCPPort: 502, BackupTCPIPAddr: "10.0.0.5", BackupTCPPort: 502},
+			[]string{"tcp://10.0.0.5:502"}},
+		{"backup without port", models.Channel{PhysicalLink: "tcp", TCPIPAddr: "10.0.0.5", TCPPort: 502, BackupTCPIPAddr: "10.0.0.6"},
+			[]string{"tcp://10.0.0.5:502"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.ch.Delay = 300 * time.Millisecond
+			conf, urls := channelClientConfig(&tt.ch)
+			if !reflect.DeepEqual(urls, tt.urls) || conf.URL != tt.urls[0] || conf.Timeout != tt.ch.Delay || conf.Speed != tt.ch.Speed {
+				t.Errorf("unexpected config %+v, urls %v", conf, urls)
+			}
+		})
+	}
+}
+
+// scanHandler：站号 3 与 7 应答保持寄存器与设备标识，输入寄存器以非法功能应答；其余站号不应答
+// scanHandler: unit ids 3 and 7 answer holding registers and identification,
+// and input registers with an illegal function; other unit ids stay silent.
+type scanHandler struct{}
+
+func scanUnitPresent(unitId uint8) bool { return unitId == 3 || unitId == 7 }
+
+func (scanHandler) HandleCoils(*modbus.CoilsRequest) ([]bool, error) {
+	return nil, modbus.ErrNoResponse
+}
+
+func (scanHandler) HandleDiscreteInputs(*modbus.DiscreteInputsRequest) ([]bool, error) {
+	return nil, modbus.ErrNoResponse
+}
+
+func (scanHandler) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) ([]uint16, error) {
+	if !scanUnitPresent(req.UnitId) {
+		return nil, modbus.ErrNoResponse
+	}
+	res := make([]uint16, req.Quantity)
+	for i := range res {
+		res[i] = uint16(req.UnitId)
+	}
+	return res, nil
+}
+
+func (scanHandler) HandleInputRegisters(req *modbus.InputRegistersRequest) ([]uint16, error) {
+	if !scanUnitPresent(req.UnitId) {
+		return nil, modbus.ErrNoResponse
+	}
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (scanHandler) HandleMaskWriteRegister(*modbus.MaskWriteRegisterRequest) error {
+	return modbus.ErrNoResponse
+}
+
+func (scanHandler) HandleReadWriteRegisters(*modbus.ReadWriteRegistersRequest) ([]uint16, error) {
+	return nil, modbus.ErrNoResponse
+}
+
+func (scanHandler) HandleFIFOQueue(*modbus.FIFOQueueRequest) ([]uint16, error) {
+	return nil, modbus.ErrNoResponse
+}
+
+func (scanHandler) HandleFileRecords(*modbus.FileRecordRequest) ([][]uint16, error) {
+	return nil, modbus.ErrNoResponse
+}
+
+func (scanHandler) HandleDeviceIdentification(req *modbus.DeviceIdentificationRequest) (map[uint8]string, error) {
+	if !scanUnitPresent(req.UnitId) {
+		return nil, modbus.ErrNoResponse
+	}
+	return map[uint8]string{
+		modbus.OBJ_VENDOR_NAME:          "acme",
+		modbus.OBJ_PRODUCT_CODE:         "m1",
+		modbus.OBJ_MAJOR_MINOR_REVISION: "1.0",
+	}, nil
+}
+
+// 对本地 TCP 服务端扫描：主地址不可连接时经备用地址打开，找到两个站号
+// A scan against a local TCP server: the client opens through the backup
+// address as the primary refuses connections, and finds both unit ids.
+func TestScanBus(t *testing.T) {
+	server, err := modbus.NewServer(&modbus.ServerConfiguration{URL: "tcp://localhost:5520", MaxClients: 2}, scanHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err = server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	conf, urls := channelClientConfig(&models.Channel{
+		PhysicalLink: "tcp", TCPIPAddr: "localhost", TCPPort: 5521, BackupTCPIPAddr: "localhost", BackupTCPPort: 5520,
+	})
+	conf.Timeout = 50 * time.Millisecond
+	conf.ConnectTimeout = time.Second
+	client, err := openFirst(conf, urls)
+	if err != nil {
+		t.Fatalf("failed to open a client: %v", err)
+	}
+	defer client.Close()
+
+	probes := []scanProbe{{"holding", 0, 2}, {"input", 0, 1}}
+	units, err := scanBus(client, 1, 8, probes, true, io.Discard)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	want := []scanUnit{}
+	for _, id := range []uint8{3, 7} {
+		raw := formatWords([]uint16{uint16(id), uint16(id)})
+		want = append(want, scanUnit{
+			Unit: id,
+			Probes: []scanProbeResult{
+				{scanProbe: probes[0], Result: "ok", Raw: raw},
+				{scanProbe: probes[1], Result: modbus.ErrIllegalFunction.Error()},
+			},
+			Identity: map[string]string{"vendor": "acme", "product_code": "m1", "revision": "1.0"},
+		})
+	}
+	if !reflect.DeepEqual(units, want) {
+		t.Errorf("expected %+v, got %+v", want, units)
+	}
+
+	// 链路断开时中止扫描 / a broken link aborts the scan
+	server.Stop()
+	if _, err = scanBus(client, 1, 2, probes, false, io.Discard); err == nil {
+		t.Errorf("expected the scan to abort on a broken link")
+	} else {
+		var merr modbus.Error
+		if errors.As(err, &merr) {
+			t.Errorf("expected a link error, got %v", err)
+		}
+	}
+
+	if _, err = openFirst(conf, urls[:1]); err == nil {
+		t.Errorf("expected opening the unreachable primary alone to fail")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"syscall"
 
 	"github.com/fluxionwatt/gridbeat/core"
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
 	"github.com/fluxionwatt/gridbeat/internal/auth"
 	"github.com/fluxionwatt/gridbeat/internal/db"
 	"github.com/fluxionwatt/gridbeat/internal/models"
@@ -20,9 +21,7 @@ import (
 	"github.com/spf13/viper"
 
 	http "github.com/fluxionwatt/gridbeat/core/http"
-	"github.com/fluxionwatt/gridbeat/core/plugin/cmbus"
 	_ "github.com/fluxionwatt/gridbeat/core/plugin/goose"
-	"github.com/fluxionwatt/gridbeat/core/plugin/mbus"
 	_ "github.com/fluxionwatt/gridbeat/core/plugin/stream"
 )
 
@@ -174,8 +173,12 @@ var serverCmd = &cobra.Command{
 			}
 		}()
 
+		// 实时点位库 / real-time point database
+		points := rtdb.New()
+
 		// 宿主环境 / host environment
 		env := &pluginapi.HostEnv{
+			RTDB:      points,
 			Logger:    logger,
 			DB:        gdb,
 			MQTT:      server,
@@ -196,6 +199,7 @@ var serverCmd = &cobra.Command{
 			Mgr:          mgr,
 			WG:           &wg,
 			AccessLogger: logger.AccessLogger,
+			RTDB:         points,
 		}
 
 		if err := core.CreatePidFile(core.Gconfig.PID); err != nil {
@@ -222,25 +226,16 @@ var serverCmd = &cobra.Command{
 			return
 		}
 		for _, channel := range items {
-
-			if core.Gconfig.Simulator {
-				if _, err = mgr.Create("cmbus", channel.UUID, cmbus.InstanceConfig{
-					Model: channel,
-				}); err != nil {
-					cobra.CheckErr(fmt.Errorf("mgr create instance %w", err))
-				}
-			}
-
-			if _, err = mgr.Create("mbus", channel.UUID, mbus.InstanceConfig{
-				Model:     channel,
-				UnitID:    1,
-				Quantity:  1,
-				StartAddr: 100,
-			}); err != nil {
+			if err = mgr.ApplyChannel(channel, core.Gconfig.Simulator); err != nil {
 				cobra.CheckErr(fmt.Errorf("mgr create instance %w", err))
 			}
 		}
 
+		// 通道实例就绪后再启动网关 / start the gateway once the channel instances are up
+		if err = mgr.ApplyGateway(cfg); err != nil {
+			cobra.CheckErr(fmt.Errorf("mgr create gateway %w", err))
+		}
+
 		select {}
 	},
 }
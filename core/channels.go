@@ -0,0 +1,53 @@
+package core
+
+import (
+	"github.com/fluxionwatt/gridbeat/core/plugin/cmbus"
+	"github.com/fluxionwatt/gridbeat/core/plugin/mbus"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+)
+
+// ApplyChannel 按通道配置创建或更新其实例（模拟器开启时同时维护 cmbus 实例）
+// 已存在的实例通过 UpdateConfig 以新配置重启；停用的通道销毁其实例。
+// ApplyChannel creates or updates the instances of a channel (plus the cmbus
+// instance when the simulator is on). Existing instances restart with the new
+// config through UpdateConfig; a disabled channel has its instances destroyed.
+func (m *InstanceManager) ApplyChannel(ch models.Channel, simulator bool) error {
+	// 停用的通道不运行任何实例
+	// A disabled channel runs no instances.
+	if ch.Disable {
+		return m.RemoveChannel(ch.UUID)
+	}
+
+	if simulator {
+		if err := m.apply("cmbus", ch.UUID, cmbus.InstanceConfig{Model: ch}); err != nil {
+			return err
+		}
+	}
+	return m.apply("mbus", ch.UUID, mbus.InstanceConfig{Model: ch})
+}
+
+// RemoveChannel 销毁通道的全部实例，不存在的实例忽略
+// RemoveChannel destroys all instances of a channel, ignoring missing ones.
+func (m *InstanceManager) RemoveChannel(uuid string) error {
+	var first error
+	for _, typ := range []string{"mbus", "cmbus"} {
+		if _, ok := m.Get(typ, uuid); !ok {
+			continue
+		}
+		if err := m.Destroy(typ, uuid); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// apply：实例存在则更新，否则创建
+// apply: update the instance if it exists, otherwise create it.
+func (m *InstanceManager) apply(typ, id string, cfg pluginapi.InstanceConfig) error {
+	if _, ok := m.Get(typ, id); ok {
+		return m.Update(typ, id, cfg)
+	}
+	_, err := m.Create(typ, id, cfg)
+	return err
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"sync"
 
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
 	"github.com/fluxionwatt/gridbeat/internal/config"
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/sirupsen/logrus"
@@ -17,4 +18,5 @@ type Cycle struct {
 	Mgr          *InstanceManager
 	AccessLogger *logrus.Logger
 	WG           *sync.WaitGroup
+	RTDB         *rtdb.DB
 }
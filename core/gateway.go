@@ -0,0 +1,27 @@
+package core
+
+import (
+	"github.com/fluxionwatt/gridbeat/core/plugin/mbgw"
+	"github.com/fluxionwatt/gridbeat/internal/config"
+)
+
+// gatewayID 是唯一的网关实例 ID / ID of the single gateway instance
+const gatewayID = "gateway"
+
+// ApplyGateway 按配置创建、更新或销毁 Modbus TCP 到 RTU 网关实例
+// ApplyGateway creates, updates or destroys the Modbus TCP-to-RTU gateway instance from the config.
+func (m *InstanceManager) ApplyGateway(conf *config.Config) error {
+	if conf.Gateway.Listen == "" {
+		if _, ok := m.Get("mbgw", gatewayID); ok {
+			return m.Destroy("mbgw", gatewayID)
+		}
+		return nil
+	}
+
+	return m.apply("mbgw", gatewayID, mbgw.InstanceConfig{
+		Listen:     conf.Gateway.Listen,
+		MaxClients: conf.Gateway.MaxClients,
+		Timeout:    conf.Gateway.Timeout,
+		Routes:     conf.Gateway.Routes,
+	})
+}
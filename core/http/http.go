@@ -98,6 +98,7 @@ func (s *HTTPServerInstance) Init(parent context.Context, cycle *core.Cycle) err
 	s.Server.DB = cycle.DB
 	s.Server.MQTT = cycle.MQTT
 	s.Server.Mgr = cycle.Mgr
+	s.Server.RTDB = cycle.RTDB
 
 	s.Server.Route(s.app)
 
@@ -23,11 +23,18 @@ func NewInstanceManager(rootCtx context.Context, env *pluginapi.HostEnv) *Instan
 	if rootCtx == nil {
 		rootCtx = context.Background()
 	}
-	return &InstanceManager{
+	m := &InstanceManager{
 		instances: make(map[string]map[string]pluginapi.Instance),
 		rootCtx:   rootCtx,
 		env:       env,
 	}
+
+	// 让实例之间可以互相查找（如网关查找通道实例）
+	// Let instances find each other (e.g. the gateway finding channel instances).
+	if env != nil && env.Instances == nil {
+		env.Instances = m
+	}
+	return m
 }
 
 // CreateWithContext 使用指定 parentCtx 创建实例；parentCtx 为 nil 则使用 rootCtx
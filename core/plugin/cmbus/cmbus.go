@@ -19,6 +19,11 @@ import (
 type InstanceConfig struct {
 	Model models.Channel
 	URL   string `mapstructure:"url"`
+
+	// 故障注入配置，nil 表示保持当前配置；修改故障配置不需要重启实例
+	// Faults is the fault profile to inject, nil keeping the current one;
+	// changing it needs no restart.
+	Faults *pluginapi.FaultProfile
 }
 
 // ModbusInstance：具体插件实例，实现 pluginapi.Instance
@@ -49,20 +54,16 @@ type ModbusInstance struct {
 	// (1 goroutine per client)
 	lock sync.RWMutex
 
-	// simple uptime counter, incremented in the main() above and exposed
-	// as a 32-bit input register (2 consecutive 16-bit modbus registers).
-	uptime uint32
-
-	// these are here to hold client-provided (written) values, for both coils and
-	// holding registers
-	coils [100]bool
+	// simulated slaves keyed by unit id, rebuilt from the devices of the
+	// channel on Init and Reload
+	units map[uint8]*simUnit
 
-	inputReg   [65636]uint16
-	holdingReg [65636]uint16
+	// injected faults, kept across restarts
+	faults faultState
 
-	// unix timestamp register, incremented in the main() function above and exposed
-	// as a 32-bit holding register (2 consecutive 16-bit modbus registers).
-	clock uint32
+	// file records (function codes 0x14/0x15), allocated on first access
+	// and keyed by file number
+	files map[uint16][]uint16
 }
 
 func (m *ModbusInstance) ID() string   { return m.id }
@@ -156,8 +157,19 @@ func (m *ModbusInstance) Init(parent context.Context, env *pluginapi.HostEnv) er
 		}
 	}
 
-	// 启动一个协程：负责自动 Open/Close + 周期读寄存器
-	// Start one goroutine: handles Open/Close + periodic register reads.
+	// 按通道上的设备生成模拟从站；加载失败时不应答任何站号，但不影响启动
+	// Build the simulated slaves from the channel's devices; on failure no
+	// unit answers, without failing the start.
+	units, err := m.loadUnits()
+	if err != nil {
+		m.logger.Errorf("modbus simulator load units failed: %v", err)
+	}
+	m.lock.Lock()
+	m.units = units
+	m.lock.Unlock()
+
+	// 启动一个协程：负责启动 server，失败时重试
+	// Start one goroutine: starts the server, retrying on failure.
 	m.wg.Add(1)
 	go func(cfg InstanceConfig) {
 		defer m.wg.Done()
@@ -165,17 +177,14 @@ func (m *ModbusInstance) Init(parent context.Context, env *pluginapi.HostEnv) er
 	}(m.cfg)
 
 	m.init = true
-	m.logger.Infof("modbus simulator instance initialized, url=%s", m.cfg.URL)
+	m.logger.Infof("modbus simulator instance initialized, url=%s, units=%d", m.cfg.URL, len(units))
 
 	return nil
 }
 
-// runPoller：内部轮询逻辑，在单独协程中运行
-// runPoller: internal polling loop, runs in a dedicated goroutine.
+// runPoller：启动 server 并保持运行，直到 ctx 取消，在单独协程中运行
+// runPoller: starts the server and keeps it running until ctx is canceled, runs in a dedicated goroutine.
 func (m *ModbusInstance) runPoller(cfg InstanceConfig) {
-
-	m.logger.Infof("modbus simulator poller started, interval=%s", cfg.Model.RetryInterval)
-
 	for {
 		// 如果上层 ctx 已取消，直接退出
 		// If parent context is done, exit.
@@ -189,9 +198,9 @@ func (m *ModbusInstance) runPoller(cfg InstanceConfig) {
 		m.Status.Working = true
 		m.Status.Linking = false
 
-		// 尝试建立连接 / try to open connection.
-		if err := m.server1.Start(); err != nil {
-			m.logger.Errorf("modbus simulator open %s failed: %v", m.cfg.URL, err)
+		// 尝试启动 server / try to start the server.
+		if err := m.startServer(); err != nil {
+			m.logger.Errorf("modbus simulator open %s failed: %v", cfg.URL, err)
 			if !sleepWithContext(m.ctx, 2*time.Second) {
 				m.logger.Infof("modbus simulator poller exit during reconnect wait")
 				return
@@ -199,35 +208,86 @@ func (m *ModbusInstance) runPoller(cfg InstanceConfig) {
 			continue
 		}
 
-		m.logger.Infof("modbus simulator connected to %s", cfg.URL)
-
+		m.logger.Infof("modbus simulator listening on %s", cfg.URL)
 		m.Status.Linking = true
 
-		ticker := time.NewTicker(cfg.Model.RetryInterval)
-		connected := true
+		ticker := time.NewTicker(time.Second)
+		up := time.Now()
+		serving := true
 
-		for connected {
+		for serving {
 			select {
 			case <-m.ctx.Done():
-				// 上层取消：关闭连接并退出
-				// Parent canceled: close connection and exit.
+				// 上层取消：停止 server 并退出
+				// Parent canceled: stop the server and exit.
 				ticker.Stop()
-				_ = m.server1.Stop()
+				m.stopServer()
 				m.logger.Infof("modbus simulator poller exit on ctx done")
 				return
 			case <-ticker.C:
-				// 轮询读寄存器 / poll holding/input registers.
-
-				//m.cfg.Model.BytesReceived = m.cfg.Model.BytesReceived + 1
-				//m.cfg.Model.BytesSent = m.cfg.Model.BytesSent + 1
-
-				// 打印调试信息 / log debug values.
-				m.logger.Debugf("modbus simulator recv ok")
+				// 故障注入：周期性断开（停止服务一段时间后重新启动）
+				// Fault injection: periodic disconnects (the server stops for a while, then restarts).
+				due, length := m.disconnectDue(up)
+				if !due {
+					continue
+				}
+				ticker.Stop()
+				m.stopServer()
+				m.Status.Linking = false
+				serving = false
+				m.logger.Warnf("modbus simulator injected disconnect for %s", length)
+				if !sleepWithContext(m.ctx, length) {
+					m.logger.Infof("modbus simulator poller exit during disconnect")
+					return
+				}
 			}
 		}
 	}
 }
 
+// startServer / stopServer：启动或停止串口（RTU）或网络 server
+// startServer / stopServer: start or stop the serial (RTU) or network server.
+func (m *ModbusInstance) startServer() error {
+	if m.server1 != nil {
+		return m.server1.Start()
+	}
+	return m.server2.Start()
+}
+
+func (m *ModbusInstance) stopServer() {
+	if m.server1 != nil {
+		_ = m.server1.Stop()
+	}
+	if m.server2 != nil {
+		_ = m.server2.Stop()
+	}
+}
+
+// Reload：实现 pluginapi.Reloader，按通道上的设备重新生成模拟从站（已写入的值丢弃）
+// Reload: implements pluginapi.Reloader; rebuilds the simulated slaves from
+// the channel's devices (written values are dropped).
+func (m *ModbusInstance) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.init {
+		return nil
+	}
+
+	units, err := m.loadUnits()
+	if err != nil {
+		m.logger.Errorf("modbus simulator reload units failed: %v", err)
+		return err
+	}
+
+	m.lock.Lock()
+	m.units = units
+	m.lock.Unlock()
+
+	m.logger.Infof("modbus simulator reloaded, units=%d", len(units))
+	return nil
+}
+
 func (m *ModbusInstance) Get() any {
 	return m.Status
 }
@@ -250,10 +310,9 @@ func (m *ModbusInstance) Close() error {
 
 	// 关闭底层 client（如果轮询协程已经关闭，这里 Close() 基本是幂等的）
 	// Close underlying client (poller already closed it, so this is mostly idempotent).
-	if m.server1 != nil {
-		_ = m.server1.Stop()
-		m.server1 = nil
-	}
+	m.stopServer()
+	m.server1 = nil
+	m.server2 = nil
 
 	m.ctx = nil
 	m.cancel = nil
@@ -279,6 +338,16 @@ func (m *ModbusInstance) UpdateConfig(raw pluginapi.InstanceConfig) error {
 		}
 	}
 
+	// 故障配置即时生效，不参与重启判断
+	// The fault profile applies at once and takes no part in the restart decision.
+	if newCfg.Faults != nil {
+		if err := m.SetFaults(*newCfg.Faults); err != nil {
+			m.mu.Unlock()
+			return fmt.Errorf("modbus[%s] simulator: %w", m.id, err)
+		}
+		newCfg.Faults = nil
+	}
+
 	// 填充默认值（防止被设置成 0） / fill defaults.
 
 	// 判断是否需要重启（任意字段变化就重启，简单粗暴但安全）
@@ -349,11 +418,18 @@ func (f *ModbusFactory) New(id string, raw pluginapi.InstanceConfig) (pluginapi.
 		}
 	}
 
-	return &ModbusInstance{
+	m := &ModbusInstance{
 		id:  id,
 		typ: f.Type(),
 		cfg: cfg,
-	}, nil
+	}
+	if cfg.Faults != nil {
+		if err := m.SetFaults(*cfg.Faults); err != nil {
+			return nil, fmt.Errorf("modbus simulator: %w", err)
+		}
+		m.cfg.Faults = nil
+	}
+	return m, nil
 }
 
 // init：注册工厂
@@ -0,0 +1,192 @@
+package cmbus
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+const (
+	// 断开时长的默认值 / default length of a disconnect
+	defaultDisconnectFor = 5 * time.Second
+)
+
+// faultExceptions：故障配置中可用的异常名
+// faultExceptions: exception names usable in fault profiles.
+var faultExceptions = map[string]error{
+	"illegal_function": modbus.ErrIllegalFunction,
+	"illegal_address":  modbus.ErrIllegalDataAddress,
+	"illegal_value":    modbus.ErrIllegalDataValue,
+	"device_failure":   modbus.ErrServerDeviceFailure,
+	"acknowledge":      modbus.ErrAcknowledge,
+	"busy":             modbus.ErrServerDeviceBusy,
+	"gateway_path":     modbus.ErrGWPathUnavailable,
+	"gateway_target":   modbus.ErrGWTargetFailedToRespond,
+}
+
+// faultState：实例当前的故障配置与脚本进度
+// faultState: the instance's current fault profile and script position.
+type faultState struct {
+	mu      sync.Mutex
+	profile pluginapi.FaultProfile
+	step    int // 脚本的下一步 / next script step
+	corrupt any // 应答 CRC 须损坏的请求 / the request whose response CRC is to be corrupted
+}
+
+// validateFaults：检查故障配置 / validateFaults: check a fault profile.
+func validateFaults(p pluginapi.FaultProfile) error {
+	for name, rate := range map[string]float64{
+		"exception_rate": p.ExceptionRate,
+		"drop_rate":      p.DropRate,
+		"bad_crc_rate":   p.BadCRCRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be within 0..1", name)
+		}
+	}
+	if p.ExceptionRate+p.DropRate+p.BadCRCRate > 1 {
+		return fmt.Errorf("exception_rate, drop_rate and bad_crc_rate must add up to at most 1")
+	}
+	if p.Latency < 0 || p.Jitter < 0 || p.DisconnectEvery < 0 || p.DisconnectFor < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	for _, e := range p.Exceptions {
+		if _, ok := faultExceptions[e]; !ok {
+			return fmt.Errorf("unknown exception %q", e)
+		}
+	}
+	for _, step := range p.Script {
+		if _, ok := faultExceptions[step]; !ok && step != "ok" && step != "drop" && step != "bad_crc" {
+			return fmt.Errorf("unknown script step %q", step)
+		}
+	}
+	return nil
+}
+
+// Faults：实现 pluginapi.FaultInjector，返回当前故障配置
+// Faults: implements pluginapi.FaultInjector, returns the current fault profile.
+func (m *ModbusInstance) Faults() pluginapi.FaultProfile {
+	m.faults.mu.Lock()
+	defer m.faults.mu.Unlock()
+
+	return m.faults.profile
+}
+
+// SetFaults：实现 pluginapi.FaultInjector，立即生效，脚本从头开始
+// SetFaults: implements pluginapi.FaultInjector; applies at once, the script
+// starting over.
+func (m *ModbusInstance) SetFaults(p pluginapi.FaultProfile) error {
+	if err := validateFaults(p); err != nil {
+		return err
+	}
+
+	m.faults.mu.Lock()
+	m.faults.profile = p
+	m.faults.step = 0
+	m.faults.corrupt = nil
+	m.faults.mu.Unlock()
+
+	if m.logger != nil {
+		m.logger.Infof("modbus simulator fault profile updated: %+v", p)
+	}
+	return nil
+}
+
+// inject：在处理请求 req 前执行故障注入（不持有 eh.lock），
+// 返回非 nil 时以该错误（异常或 modbus.ErrNoResponse）代替正常处理；
+// 抽中 bad_crc 时记下 req，只损坏它自己的应答
+// inject: runs fault injection before the request req is handled (without
+// holding eh.lock); a non-nil error (an exception or modbus.ErrNoResponse)
+// replaces the normal handling. Drawing bad_crc records req, so that only its
+// own response is corrupted.
+func (m *ModbusInstance) inject(req any, unitId uint8) error {
+	f := &m.faults
+
+	f.mu.Lock()
+	p := f.profile
+	if len(p.Units) > 0 && !slices.Contains(p.Units, unitId) {
+		f.mu.Unlock()
+		return nil
+	}
+
+	// 脚本优先，否则按概率随机 / the script first, random faults otherwise
+	action := "ok"
+	if len(p.Script) > 0 {
+		action = p.Script[f.step%len(p.Script)]
+		f.step++
+	} else {
+		// 单次抽样与累计阈值比较，使各故障的实际概率等于配置值
+		// A single draw against cumulative thresholds, so that each fault
+		// occurs at exactly its configured rate.
+		r := rand.Float64()
+		switch {
+		case r < p.DropRate:
+			action = "drop"
+		case r < p.DropRate+p.ExceptionRate:
+			action = "busy"
+			if len(p.Exceptions) > 0 {
+				action = p.Exceptions[rand.IntN(len(p.Exceptions))]
+			}
+		case r < p.DropRate+p.ExceptionRate+p.BadCRCRate:
+			action = "bad_crc"
+		}
+	}
+	if action == "bad_crc" {
+		f.corrupt = req
+	}
+	f.mu.Unlock()
+
+	if action == "drop" {
+		return modbus.ErrNoResponse
+	}
+
+	delay := p.Latency
+	if p.Jitter > 0 {
+		delay += rand.N(p.Jitter)
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return faultExceptions[action]
+}
+
+// FilterRTUResponse：实现 modbus.RTUResponseFilter，损坏 inject 记下的请求的应答 CRC
+// FilterRTUResponse: implements modbus.RTUResponseFilter, corrupting the
+// response CRC of the request recorded by inject.
+func (m *ModbusInstance) FilterRTUResponse(req any, adu []byte) []byte {
+	f := &m.faults
+
+	f.mu.Lock()
+	corrupt := req != nil && req == f.corrupt
+	if corrupt {
+		f.corrupt = nil
+	}
+	f.mu.Unlock()
+
+	if !corrupt || len(adu) < 2 {
+		return adu
+	}
+	out := append([]byte(nil), adu...)
+	out[len(out)-1] ^= 0xff
+	return out
+}
+
+// disconnectDue：按故障配置是否应断开（服务已运行 since 起）
+// disconnectDue: whether the fault profile calls for a disconnect, the server
+// having run since since.
+func (m *ModbusInstance) disconnectDue(since time.Time) (bool, time.Duration) {
+	p := m.Faults()
+	if p.DisconnectEvery <= 0 || time.Since(since) < p.DisconnectEvery {
+		return false, 0
+	}
+	if p.DisconnectFor <= 0 {
+		return true, defaultDisconnectFor
+	}
+	return true, p.DisconnectFor
+}
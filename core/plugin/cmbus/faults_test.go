@@ -0,0 +1,156 @@
+package cmbus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+func TestValidateFaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile pluginapi.FaultProfile
+		wantErr bool
+	}{
+		{"empty", pluginapi.FaultProfile{}, false},
+		{"rates", pluginapi.FaultProfile{ExceptionRate: 0.2, DropRate: 0.3, BadCRCRate: 0.5}, false},
+		{"rate above 1", pluginapi.FaultProfile{DropRate: 1.5}, true},
+		{"negative rate", pluginapi.FaultProfile{BadCRCRate: -0.1}, true},
+		{"rates above 1 together", pluginapi.FaultProfile{ExceptionRate: 0.6, DropRate: 0.6}, true},
+		{"negative latency", pluginapi.FaultProfile{Latency: -time.Second}, true},
+		{"negative disconnect", pluginapi.FaultProfile{DisconnectFor: -time.Second}, true},
+		{"exceptions", pluginapi.FaultProfile{Exceptions: []string{"busy", "gateway_target"}}, false},
+		{"unknown exception", pluginapi.FaultProfile{Exceptions: []string{"timeout"}}, true},
+		{"script", pluginapi.FaultProfile{Script: []string{"ok", "drop", "bad_crc", "illegal_address"}}, false},
+		{"unknown script step", pluginapi.FaultProfile{Script: []string{"ok", "explode"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateFaults(tt.profile); (err != nil) != tt.wantErr {
+				t.Errorf("expected error=%v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// 脚本按请求顺序循环，bad_crc 只损坏抽中它的请求的应答
+// The script loops over successive requests, and bad_crc corrupts the
+// response of the request that drew it only.
+func TestInjectScript(t *testing.T) {
+	m := &ModbusInstance{}
+	if err := m.SetFaults(pluginapi.FaultProfile{Units: []uint8{1}, Script: []string{"ok", "busy", "drop", "bad_crc"}}); err != nil {
+		t.Fatalf("SetFaults: %v", err)
+	}
+
+	adu := []byte{0x01, 0x03, 0x02, 0x00, 0x07, 0xf9, 0x86}
+	want := []error{nil, modbus.ErrServerDeviceBusy, modbus.ErrNoResponse, nil, nil, modbus.ErrServerDeviceBusy}
+	for i, w := range want {
+		req := &modbus.HoldingRegistersRequest{UnitId: 1}
+		if err := m.inject(req, req.UnitId); err != w {
+			t.Errorf("request #%d: expected %v, got %v", i, w, err)
+		}
+
+		corrupted := !bytes.Equal(m.FilterRTUResponse(req, adu), adu)
+		if corrupted != (i == 3) {
+			t.Errorf("request #%d: expected corrupted=%v", i, i == 3)
+		}
+	}
+
+	// 不受影响的站号不推进脚本 / units not listed don't advance the script
+	if err := m.inject(&modbus.CoilsRequest{UnitId: 2}, 2); err != nil {
+		t.Errorf("unit 2: expected no fault, got %v", err)
+	}
+	if err := m.inject(&modbus.CoilsRequest{UnitId: 1}, 1); err != modbus.ErrNoResponse {
+		t.Errorf("unit 1: expected the script's drop, got %v", err)
+	}
+}
+
+// bad_crc 的决定随请求而定：未经过滤的请求（如 TCP 上的）不会损坏后续请求的应答
+// The bad_crc decision belongs to its request: one that was never filtered
+// (e.g. over TCP) does not corrupt the response of a later request.
+func TestBadCRCStaysWithItsRequest(t *testing.T) {
+	m := &ModbusInstance{}
+	if err := m.SetFaults(pluginapi.FaultProfile{Script: []string{"bad_crc", "ok"}}); err != nil {
+		t.Fatalf("SetFaults: %v", err)
+	}
+
+	adu := []byte{0x01, 0x03, 0x02, 0x00, 0x07, 0xf9, 0x86}
+	first := &modbus.HoldingRegistersRequest{UnitId: 1}
+	second := &modbus.HoldingRegistersRequest{UnitId: 1}
+	m.inject(first, 1)
+	m.inject(second, 1)
+
+	if out := m.FilterRTUResponse(second, adu); !bytes.Equal(out, adu) {
+		t.Errorf("the second response was corrupted: % x", out)
+	}
+	if out := m.FilterRTUResponse(nil, adu); !bytes.Equal(out, adu) {
+		t.Errorf("a response to no request was corrupted: % x", out)
+	}
+
+	out := m.FilterRTUResponse(first, adu)
+	if bytes.Equal(out, adu) || !bytes.Equal(out[:len(out)-1], adu[:len(adu)-1]) {
+		t.Errorf("expected only the last CRC byte of the first response corrupted, got % x", out)
+	}
+	if !bytes.Equal(adu, []byte{0x01, 0x03, 0x02, 0x00, 0x07, 0xf9, 0x86}) {
+		t.Errorf("the original frame was changed: % x", adu)
+	}
+}
+
+// 随机故障的实际概率等于配置值 / random faults occur at their configured rates
+func TestInjectRates(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile pluginapi.FaultProfile
+		drop    float64
+		exc     float64
+		badCRC  float64
+	}{
+		{"none", pluginapi.FaultProfile{}, 0, 0, 0},
+		{"drop only", pluginapi.FaultProfile{DropRate: 1}, 1, 0, 0},
+		{"exception only", pluginapi.FaultProfile{ExceptionRate: 1, Exceptions: []string{"illegal_value"}}, 0, 1, 0},
+		{"mixed", pluginapi.FaultProfile{DropRate: 0.3, ExceptionRate: 0.2, BadCRCRate: 0.1}, 0.3, 0.2, 0.1},
+	}
+
+	const n = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &ModbusInstance{}
+			if err := m.SetFaults(tt.profile); err != nil {
+				t.Fatalf("SetFaults: %v", err)
+			}
+
+			adu := []byte{0x01, 0x03, 0x02, 0x00, 0x07, 0xf9, 0x86}
+			var drop, exc, badCRC int
+			for i := 0; i < n; i++ {
+				req := &modbus.InputRegistersRequest{UnitId: 1}
+				switch err := m.inject(req, 1); err {
+				case nil:
+					if !bytes.Equal(m.FilterRTUResponse(req, adu), adu) {
+						badCRC++
+					}
+				case modbus.ErrNoResponse:
+					drop++
+				case modbus.ErrServerDeviceBusy, modbus.ErrIllegalDataValue:
+					exc++
+				default:
+					t.Fatalf("unexpected fault %v", err)
+				}
+			}
+
+			for _, c := range []struct {
+				what string
+				got  int
+				want float64
+			}{{"drop", drop, tt.drop}, {"exception", exc, tt.exc}, {"bad crc", badCRC, tt.badCRC}} {
+				if rate := float64(c.got) / n; math.Abs(rate-c.want) > 0.02 {
+					t.Errorf("%s rate: expected %.2f, got %.3f", c.what, c.want, rate)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package cmbus
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/config"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+const (
+	// 正弦周期、步进与 CSV 行间隔的默认值
+	// defaults of the sine period, the step interval and the CSV row interval
+	defaultSimPeriod   = time.Minute
+	defaultSimInterval = time.Second
+
+	// 随机游走一次最多补算的步数（长时间无人读取时）
+	// max random walk steps caught up at once (after a long time without reads)
+	maxWalkSteps = 1000
+)
+
+// generator：点位数据源，按时间给出工程值
+// generator: point data source, giving an engineering value for a time.
+type generator interface {
+	value(now time.Time) float64
+}
+
+// constGen：常量 / constGen: constant.
+type constGen struct {
+	v float64
+}
+
+func (g *constGen) value(time.Time) float64 { return g.v }
+
+// sineGen：在 [min, max] 之间按周期摆动
+// sineGen: swings between min and max over a period.
+type sineGen struct {
+	start    time.Time
+	min, max float64
+	period   time.Duration
+}
+
+func (g *sineGen) value(now time.Time) float64 {
+	phase := 2 * math.Pi * float64(now.Sub(g.start)) / float64(g.period)
+	return g.min + (g.max-g.min)*(1+math.Sin(phase))/2
+}
+
+// rampGen：每个间隔增加 step（如电能累计量），设置了上限时到顶后回到下限
+// rampGen: adds step every interval (e.g. energy counters); with an upper
+// bound set, it wraps back to the lower bound past it.
+type rampGen struct {
+	start    time.Time
+	v        float64
+	min, max float64
+	step     float64
+	interval time.Duration
+}
+
+func (g *rampGen) value(now time.Time) float64 {
+	v := g.v + g.step*math.Floor(float64(now.Sub(g.start))/float64(g.interval))
+	if g.max > g.min {
+		v = g.min + math.Mod(v-g.min, g.max-g.min)
+		if v < g.min {
+			v += g.max - g.min
+		}
+	}
+	return v
+}
+
+// walkGen：每个间隔随机变化 ±step，设置了上下限时限制在范围内
+// walkGen: moves by up to ±step every interval, clamped to [min, max] when set.
+type walkGen struct {
+	last     time.Time
+	v        float64
+	min, max float64
+	step     float64
+	interval time.Duration
+}
+
+func (g *walkGen) value(now time.Time) float64 {
+	steps := int(now.Sub(g.last) / g.interval)
+	if steps <= 0 {
+		return g.v
+	}
+	g.last = g.last.Add(time.Duration(steps) * g.interval)
+
+	for i := 0; i < min(steps, maxWalkSteps); i++ {
+		g.v += (2*rand.Float64() - 1) * g.step
+		if g.max > g.min {
+			g.v = math.Max(g.min, math.Min(g.max, g.v))
+		}
+	}
+	return g.v
+}
+
+// replayGen：按间隔逐行回放 CSV 中的一列，循环播放
+// replayGen: plays back a CSV column, one row per interval, in a loop.
+type replayGen struct {
+	start    time.Time
+	rows     []float64
+	interval time.Duration
+}
+
+func (g *replayGen) value(now time.Time) float64 {
+	i := int(now.Sub(g.start)/g.interval) % len(g.rows)
+	return g.rows[i]
+}
+
+// newGenerator：由配置创建点位的数据源
+// newGenerator: create the data source of a point from its config.
+func newGenerator(spec config.SimGenerator, p *models.DeviceTypePoint, now time.Time) (generator, error) {
+	period := spec.Period
+	if period <= 0 {
+		period = defaultSimPeriod
+	}
+	interval := spec.Interval
+	if interval <= 0 {
+		interval = defaultSimInterval
+	}
+
+	switch spec.Kind {
+	case "", "constant":
+		return &constGen{v: spec.Value}, nil
+	case "sine":
+		return &sineGen{start: now, min: spec.Min, max: spec.Max, period: period}, nil
+	case "ramp":
+		return &rampGen{start: now, v: spec.Value, min: spec.Min, max: spec.Max, step: spec.Step, interval: interval}, nil
+	case "random_walk":
+		return &walkGen{last: now, v: spec.Value, min: spec.Min, max: spec.Max, step: spec.Step, interval: interval}, nil
+	case "replay":
+		column := spec.Column
+		if column == "" {
+			column = p.PointCode
+		}
+		rows, err := loadReplay(spec.File, column)
+		if err != nil {
+			return nil, err
+		}
+		return &replayGen{start: now, rows: rows, interval: interval}, nil
+	}
+	return nil, fmt.Errorf("unknown generator kind %q", spec.Kind)
+}
+
+// defaultGenerator：未配置数据源的点位：有工程范围时在范围内随机游走，否则为 0
+// defaultGenerator: for points without a data source: a random walk within
+// the engineering range when there is one, 0 otherwise.
+func defaultGenerator(p *models.DeviceTypePoint, now time.Time) generator {
+	if p.RangeMin != nil && p.RangeMax != nil && *p.RangeMax > *p.RangeMin {
+		lo, hi := *p.RangeMin, *p.RangeMax
+		return &walkGen{last: now, v: (lo + hi) / 2, min: lo, max: hi, step: (hi - lo) / 100, interval: defaultSimInterval}
+	}
+	return &constGen{}
+}
+
+// loadReplay：读取 CSV 文件中的一列（按表头匹配，忽略大小写），空单元格沿用上一行的值
+// loadReplay: read one column of a CSV file (matched by header, ignoring
+// case); empty cells repeat the previous row's value.
+func loadReplay(file, column string) ([]float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// 允许各行字段数不同，缺少的单元格按空单元格处理
+	// Rows may have different field counts; missing cells count as empty.
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("replay %s: %w", file, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("replay %s: no data rows", file)
+	}
+
+	col := -1
+	for i, name := range records[0] {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		return nil, fmt.Errorf("replay %s: no column %q", file, column)
+	}
+
+	rows := make([]float64, 0, len(records)-1)
+	var last float64
+	for n, rec := range records[1:] {
+		if col < len(rec) && strings.TrimSpace(rec[col]) != "" {
+			if last, err = strconv.ParseFloat(strings.TrimSpace(rec[col]), 64); err != nil {
+				return nil, fmt.Errorf("replay %s: row %d: %w", file, n+2, err)
+			}
+		}
+		rows = append(rows, last)
+	}
+	return rows, nil
+}
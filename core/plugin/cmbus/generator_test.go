@@ -0,0 +1,246 @@
+package cmbus
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+)
+
+func TestSineGen(t *testing.T) {
+	t0 := time.Now()
+	g := &sineGen{start: t0, min: 10, max: 20, period: time.Minute}
+
+	tests := []struct {
+		at   time.Duration
+		want float64
+	}{
+		{0, 15},
+		{15 * time.Second, 20},
+		{30 * time.Second, 15},
+		{45 * time.Second, 10},
+		{time.Minute, 15},
+	}
+
+	for _, tt := range tests {
+		if got := g.value(t0.Add(tt.at)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("at %s: expected %v, got %v", tt.at, tt.want, got)
+		}
+	}
+}
+
+func TestRampGen(t *testing.T) {
+	t0 := time.Now()
+
+	tests := []struct {
+		name string
+		gen  rampGen
+		at   time.Duration
+		want float64
+	}{
+		{"start", rampGen{v: 5, step: 2}, 0, 5},
+		{"partial interval", rampGen{v: 5, step: 2}, 1500 * time.Millisecond, 7},
+		{"unbounded", rampGen{v: 5, step: 2}, 100 * time.Second, 205},
+		{"below the upper bound", rampGen{v: 0, min: 0, max: 10, step: 3}, 3 * time.Second, 9},
+		{"wraps", rampGen{v: 0, min: 0, max: 10, step: 3}, 4 * time.Second, 2},
+		{"wraps to the lower bound", rampGen{v: 100, min: 100, max: 110, step: 5}, 2 * time.Second, 100},
+		{"negative step wraps", rampGen{v: 0, min: 0, max: 10, step: -1}, time.Second, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := tt.gen
+			g.start, g.interval = t0, time.Second
+			if got := g.value(t0.Add(tt.at)); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWalkGen(t *testing.T) {
+	t0 := time.Now()
+
+	// 步长远大于范围：每一步都被限制在范围内
+	// A step far wider than the range: every step is clamped.
+	g := &walkGen{last: t0, v: 5, min: 0, max: 10, step: 100, interval: time.Second}
+	for i := 1; i <= 50; i++ {
+		if v := g.value(t0.Add(time.Duration(i) * time.Second)); v < 0 || v > 10 {
+			t.Fatalf("step %d: %v outside [0, 10]", i, v)
+		}
+	}
+
+	// 不足一个间隔时不变 / unchanged within an interval
+	v := g.value(t0.Add(50 * time.Second))
+	if got := g.value(t0.Add(50*time.Second + 500*time.Millisecond)); got != v {
+		t.Errorf("changed within an interval: %v -> %v", v, got)
+	}
+
+	// 未设置范围时不限制，每步最多变化 step
+	// Without a range the walk is free, moving at most step per interval.
+	g = &walkGen{last: t0, v: 0, step: 1, interval: time.Second}
+	if got := g.value(t0.Add(3 * time.Second)); math.Abs(got) > 3 {
+		t.Errorf("moved %v in 3 steps of at most 1", got)
+	}
+
+	// 长时间无人读取时只补算 maxWalkSteps 步，调度时间仍追上
+	// After a long time without reads only maxWalkSteps are computed, but the
+	// schedule still catches up.
+	g = &walkGen{last: t0, v: 0, step: 1, interval: time.Millisecond}
+	g.value(t0.Add(time.Hour))
+	if !g.last.Equal(t0.Add(time.Hour)) {
+		t.Errorf("expected the walk to catch up to %s, got %s", t0.Add(time.Hour), g.last)
+	}
+}
+
+func TestLoadReplay(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return file
+	}
+
+	// 第三行缺少 Q 列，第四行 P 为空：均沿用上一行的值
+	// Row 3 has no Q cell and row 4 an empty P cell: both repeat the previous value.
+	good := write("good.csv", "time, P ,Q\n0,1.5,10\n1,2.5\n2,,30\n3,4,40\n")
+
+	tests := []struct {
+		name    string
+		file    string
+		column  string
+		want    []float64
+		wantErr bool
+	}{
+		{"column", good, "p", []float64{1.5, 2.5, 2.5, 4}, false},
+		{"short rows", good, "Q", []float64{10, 10, 30, 40}, false},
+		{"no such column", good, "S", nil, true},
+		{"no data rows", write("empty.csv", "time,P\n"), "P", nil, true},
+		{"bad number", write("bad.csv", "P\n1\nx\n"), "P", nil, true},
+		{"no such file", filepath.Join(dir, "missing.csv"), "P", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadReplay(tt.file, tt.column)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// 按间隔逐行回放并循环 / one row per interval, in a loop
+	t0 := time.Now()
+	g := &replayGen{start: t0, rows: []float64{1, 2, 3}, interval: time.Second}
+	for i, want := range []float64{1, 2, 3, 1, 2} {
+		if got := g.value(t0.Add(time.Duration(i) * time.Second)); got != want {
+			t.Errorf("row %d: expected %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestSimUnitRefreshAndHold(t *testing.T) {
+	defs := []models.DeviceTypePoint{
+		{PointCode: "a", RW: "RW", FC: 3, Address: 0, Quantity: 1, DataType: "uint16", Scale: 1},
+		{PointCode: "b", RW: "RW", FC: 3, Address: 1, Quantity: 2, DataType: "uint32", Scale: 1},
+		{PointCode: "c", RW: "R", FC: 4, Address: 1, Quantity: 1, DataType: "uint16", Scale: 1},
+		{PointCode: "d", RW: "RW", FC: 1, Address: 5, Quantity: 1, DataType: "bool", Scale: 1},
+	}
+
+	newUnit := func(t *testing.T) *simUnit {
+		u := &simUnit{
+			coils:    map[uint16]bool{},
+			discrete: map[uint16]bool{},
+			holding:  map[uint16]uint16{},
+			input:    map[uint16]uint16{},
+		}
+		for i := range defs {
+			c, err := point.NewCodec(&defs[i])
+			if err != nil {
+				t.Fatalf("point %s: %v", defs[i].PointCode, err)
+			}
+			u.points = append(u.points, &simPoint{def: defs[i], codec: c, gen: &constGen{v: 1}, fc: point.ReadFunctionCode(&defs[i])})
+		}
+		return u
+	}
+
+	// setAll：让所有数据源给出 v 后刷新 / setAll: refresh with every source giving v.
+	setAll := func(u *simUnit, v float64) {
+		for _, sp := range u.points {
+			sp.gen = &constGen{v: v}
+		}
+		u.refresh(time.Now())
+	}
+
+	tests := []struct {
+		name     string
+		fc       uint8
+		addr     uint16
+		quantity uint16
+		held     []string
+	}{
+		{"nothing written", 3, 10, 1, nil},
+		{"single register", 3, 0, 1, []string{"a"}},
+		{"second register of a 32-bit point", 3, 2, 1, []string{"b"}},
+		{"range over two points", 3, 0, 3, []string{"a", "b"}},
+		{"input table is separate", 4, 0, 3, []string{"c"}},
+		{"coil", 1, 5, 1, []string{"d"}},
+		{"next to a coil", 1, 6, 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUnit(t)
+			setAll(u, 1)
+			u.hold(tt.fc, tt.addr, tt.quantity)
+			setAll(u, 7)
+
+			held := map[string]bool{}
+			for _, code := range tt.held {
+				held[code] = true
+			}
+			for _, sp := range u.points {
+				if sp.held != held[sp.def.PointCode] {
+					t.Errorf("point %s: expected held=%v, got %v", sp.def.PointCode, held[sp.def.PointCode], sp.held)
+				}
+			}
+
+			// 被写入的点位保持旧值，其余点位取新值
+			// Held points keep the old value, the others take the new one.
+			want := func(code string) uint16 {
+				if held[code] {
+					return 1
+				}
+				return 7
+			}
+			if got := u.holding[0]; got != want("a") {
+				t.Errorf("a: expected %d, got %d", want("a"), got)
+			}
+			if got := u.holding[2]; u.holding[1] != 0 || got != want("b") {
+				t.Errorf("b: expected [0 %d], got [%d %d]", want("b"), u.holding[1], got)
+			}
+			if got := u.input[1]; got != want("c") {
+				t.Errorf("c: expected %d, got %d", want("c"), got)
+			}
+			if got := u.coils[5]; got != (want("d") != 0) {
+				t.Errorf("d: expected %v, got %v", want("d") != 0, got)
+			}
+		})
+	}
+}
@@ -3,66 +3,105 @@ package cmbus
 import (
 	"crypto/rand"
 	"encoding/binary"
-	"fmt"
-	"math"
 	"time"
 
 	"github.com/fluxionwatt/gridbeat/utils/modbus"
 )
 
+const (
+	// number of files and records per file served through file records
+	simFileCount   = 16
+	simFileRecords = 10000
+)
+
+// ServesUnit implements modbus.UnitHandler: the simulator answers the unit ids
+// of the devices bound to its channel, and stays silent on serial links for
+// any other unit id.
+func (eh *ModbusInstance) ServesUnit(unitId uint8) bool {
+	eh.lock.RLock()
+	defer eh.lock.RUnlock()
+
+	_, ok := eh.units[unitId]
+	return ok
+}
+
 // Coil handler method.
 // This method gets called whenever a valid modbus request asking for a coil operation is
 // received by the server.
-// It exposes 100 read/writable coils at addresses 0-99, except address 80 which is
-// read-only.
-// (read them with ./modbus-cli --target tcp://localhost:5502 rc:0+99, write to register n
-// with ./modbus-cli --target tcp://localhost:5502 wr:n:<true|false>)
+// Coils are those of the unit's coil points; writes are accepted on writable points only
+// and take the points off their generators.
 func (eh *ModbusInstance) HandleCoils(req *modbus.CoilsRequest) (res []bool, err error) {
-	if req.UnitId != 1 {
-		// only accept unit ID #1
-		// note: we're merely filtering here, but we could as well use the unit
-		// ID field to support multiple register maps in a single server.
-		//err = modbus.ErrIllegalFunction
-		return
-	}
-
-	// make sure that all registers covered by this request actually exist
-	if int(req.Addr)+int(req.Quantity) > len(eh.coils) {
-		err = modbus.ErrIllegalDataAddress
+	// injected faults (latency, exceptions, dropped responses) come first
+	if err = eh.inject(req, req.UnitId); err != nil {
 		return
 	}
 
 	// since we're manipulating variables shared between multiple goroutines,
 	// acquire a lock to avoid concurrency issues.
 	eh.lock.Lock()
-	// release the lock upon return
 	defer eh.lock.Unlock()
 
-	// loop through `req.Quantity` registers, from address `req.Addr` to
-	// `req.Addr + req.Quantity - 1`, which here is conveniently `req.Addr + i`
+	u := eh.units[req.UnitId]
+	if u == nil {
+		// no such device behind this (simulated) gateway
+		err = modbus.ErrGWTargetFailedToRespond
+		return
+	}
+
+	// make sure that all coils covered by this request actually exist
+	// (and are writable, for writes) before touching any of them
 	for i := 0; i < int(req.Quantity); i++ {
-		// ignore the write if the current register address is 80
-		if req.IsWrite && int(req.Addr)+i != 80 {
-			// assign the value
-			eh.coils[int(req.Addr)+i] = req.Args[i]
+		addr := req.Addr + uint16(i)
+		if _, ok := u.coils[addr]; !ok || (req.IsWrite && !u.writableCoils[addr]) {
+			err = modbus.ErrIllegalDataAddress
+			return
+		}
+	}
+
+	if req.IsWrite {
+		for i := 0; i < int(req.Quantity); i++ {
+			u.coils[req.Addr+uint16(i)] = req.Args[i]
 		}
-		// append the value of the requested register to res so they can be
-		// sent back to the client
-		res = append(res, eh.coils[int(req.Addr)+i])
+		u.hold(1, req.Addr, req.Quantity)
+		eh.logger.Debugf("write coils addr %v quantity %v unitID %v", req.Addr, req.Quantity, req.UnitId)
+	} else {
+		u.refresh(time.Now())
+	}
+
+	for i := 0; i < int(req.Quantity); i++ {
+		res = append(res, u.coils[req.Addr+uint16(i)])
 	}
 
 	return
 }
 
 // Discrete input handler method.
-// Note that we're returning ErrIllegalFunction unconditionally.
-// This will cause the client to receive "illegal function", which is the modbus way of
-// reporting that this server does not support/implement the discrete input type.
+// Discrete inputs are those of the unit's discrete input points.
 func (eh *ModbusInstance) HandleDiscreteInputs(req *modbus.DiscreteInputsRequest) (res []bool, err error) {
-	// this is the equivalent of saying
-	// "discrete inputs are not supported by this device"
-	// (try it with modbus-cli --target tcp://localhost:5502 rdi:1)
-	err = modbus.ErrIllegalFunction
+	if err = eh.inject(req, req.UnitId); err != nil {
+		return
+	}
+
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+
+	u := eh.units[req.UnitId]
+	if u == nil {
+		err = modbus.ErrGWTargetFailedToRespond
+		return
+	}
+
+	for i := 0; i < int(req.Quantity); i++ {
+		if _, ok := u.discrete[req.Addr+uint16(i)]; !ok {
+			err = modbus.ErrIllegalDataAddress
+			return
+		}
+	}
+
+	u.refresh(time.Now())
+	for i := 0; i < int(req.Quantity); i++ {
+		res = append(res, u.discrete[req.Addr+uint16(i)])
+	}
 
 	return
 }
@@ -70,36 +109,43 @@ func (eh *ModbusInstance) HandleDiscreteInputs(req *modbus.DiscreteInputsRequest
 // Holding register handler method.
 // This method gets called whenever a valid modbus request asking for a holding register
 // operation (either read or write) received by the server.
+// Registers are those covered by the unit's holding register points, encoded by each
+// point's data type and byte order; writes are accepted on writable points only and
+// take the points off their generators.
 func (eh *ModbusInstance) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) (res []uint16, err error) {
-	var regAddr uint16
-
-	if req.UnitId != 1 {
-		// only accept unit ID #1
-		//err = modbus.ErrIllegalFunction
-		//return
+	if err = eh.inject(req, req.UnitId); err != nil {
+		return
 	}
 
-	// since we're manipulating variables shared between multiple goroutines,
-	// acquire a lock to avoid concurrency issues.
 	eh.lock.Lock()
-	// release the lock upon return
 	defer eh.lock.Unlock()
 
-	// loop through `quantity` registers
+	u := eh.units[req.UnitId]
+	if u == nil {
+		err = modbus.ErrGWTargetFailedToRespond
+		return
+	}
+
 	for i := 0; i < int(req.Quantity); i++ {
-		// compute the target register address
-		regAddr = req.Addr + uint16(i)
+		addr := req.Addr + uint16(i)
+		if _, ok := u.holding[addr]; !ok || (req.IsWrite && !u.writableRegs[addr]) {
+			err = modbus.ErrIllegalDataAddress
+			return
+		}
+	}
 
-		if req.IsWrite {
-			eh.holdingReg[regAddr] = req.Args[i]
-			s := fmt.Sprintf("recive IsWrite regAddr %v quantity %v unitID %v", regAddr, req.Quantity, req.UnitId)
-			eh.logger.Infof(s)
-		} else {
-			s := fmt.Sprintf("recive regAddr %v quantity %v unitID %v", regAddr, req.Quantity, req.UnitId)
-			eh.logger.Infof(s)
+	if req.IsWrite {
+		for i := 0; i < int(req.Quantity); i++ {
+			u.holding[req.Addr+uint16(i)] = req.Args[i]
 		}
+		u.hold(3, req.Addr, req.Quantity)
+		eh.logger.Debugf("write holding registers addr %v quantity %v unitID %v", req.Addr, req.Quantity, req.UnitId)
+	} else {
+		u.refresh(time.Now())
+	}
 
-		res = append(res, eh.holdingReg[regAddr])
+	for i := 0; i < int(req.Quantity); i++ {
+		res = append(res, u.holding[req.Addr+uint16(i)])
 	}
 
 	return
@@ -110,82 +156,104 @@ func (eh *ModbusInstance) HandleHoldingRegisters(req *modbus.HoldingRegistersReq
 // operation is received by the server.
 // Note that input registers are always read-only as per the modbus spec.
 func (eh *ModbusInstance) HandleInputRegisters(req *modbus.InputRegistersRequest) (res []uint16, err error) {
-	var unixTs_s uint32
-	var minusOne int16 = -1
-
-	if req.UnitId != 1 {
-		// only accept unit ID #1
-		//	err = modbus.ErrIllegalFunction
-		//	return
-	}
-
-	// get the current unix timestamp, converted as a 32-bit unsigned integer for
-	// simplicity
-	unixTs_s = uint32(time.Now().Unix() & 0xffffffff)
-
-	// loop through all register addresses from req.addr to req.addr + req.Quantity - 1
-	for regAddr := req.Addr; regAddr < req.Addr+req.Quantity; regAddr++ {
-		switch regAddr {
-		case 100:
-			// return the static value 0x1111 at address 100, as an unsigned
-			// 16-bit integer
-			// (read it with modbus-cli --target tcp://localhost:5502 ri:uint16:100)
-			res = append(res, 0x1111)
-
-		case 101:
-			// return the static value -1 at address 101, as a signed 16-bit
-			// integer
-			// (read it with modbus-cli --target tcp://localhost:5502 ri:int16:101)
-			res = append(res, uint16(minusOne))
-
-		// expose our uptime counter, encoded as a 32-bit unsigned integer in
-		// input registers 200-201
-		// (read it with modbus-cli --target tcp://localhost:5502 ri:uint32:200)
-		case 200:
-			// return the 16 most significant bits of the uptime counter
-			// (using locking to avoid concurrency issues)
-			eh.lock.RLock()
-			res = append(res, uint16((eh.uptime>>16)&0xffff))
-			eh.lock.RUnlock()
-
-		case 201:
-			// return the 16 least significant bits of the uptime counter
-			// (again, using locking to avoid concurrency issues)
-			eh.lock.RLock()
-			res = append(res, uint16(eh.uptime&0xffff))
-			eh.lock.RUnlock()
-
-		// expose the current unix timestamp, encoded as a 32-bit unsigned integer
-		// in input registers 202-203
-		// (read it with modbus-cli --target tcp://localhost:5502 ri:uint32:202)
-		case 202:
-			// return the 16 most significant bits of the current unix time
-			res = append(res, uint16((unixTs_s>>16)&0xffff))
-
-		case 203:
-			// return the 16 least significant bits of the current unix time
-			res = append(res, uint16(unixTs_s&0xffff))
-
-		// return 3.1415, encoded as a 32-bit floating point number in input
-		// registers 300-301
-		// (read it with modbus-cli --target tcp://localhost:5502 ri:float32:300)
-		case 300:
-			// returh the 16 most significant bits of the number
-			res = append(res, uint16((math.Float32bits(3.1415)>>16)&0xffff))
-
-		case 301:
-			// returh the 16 least significant bits of the number
-			res = append(res, uint16((math.Float32bits(3.1415))&0xffff))
-
-		// attempting to access any input register address other than
-		// those defined above will result in an illegal data address
-		// exception client-side.
-		default:
+	if err = eh.inject(req, req.UnitId); err != nil {
+		return
+	}
+
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+
+	u := eh.units[req.UnitId]
+	if u == nil {
+		err = modbus.ErrGWTargetFailedToRespond
+		return
+	}
+
+	// attempting to access any input register address not covered by a
+	// point results in an illegal data address exception client-side.
+	for i := 0; i < int(req.Quantity); i++ {
+		if _, ok := u.input[req.Addr+uint16(i)]; !ok {
+			err = modbus.ErrIllegalDataAddress
+			return
+		}
+	}
+
+	u.refresh(time.Now())
+	for i := 0; i < int(req.Quantity); i++ {
+		res = append(res, u.input[req.Addr+uint16(i)])
+	}
+
+	return
+}
+
+// File record handler method.
+// This method gets called whenever a valid modbus request asking for a read file
+// record (0x14) or write file record (0x15) operation is received by the server.
+// It exposes files 1-16, each holding 10000 read/writable records, e.g. to stand
+// in for meter event logs or BMS fault histories.
+func (eh *ModbusInstance) HandleFileRecords(req *modbus.FileRecordRequest) (res [][]uint16, err error) {
+	// make sure that all records covered by this request actually exist
+	// before touching any file, so that a failed request has no effect
+	for _, record := range req.Records {
+		if record.FileNumber > simFileCount ||
+			int(record.RecordNumber)+int(record.RecordLength) > simFileRecords {
 			err = modbus.ErrIllegalDataAddress
 			return
 		}
 	}
 
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+
+	if eh.files == nil {
+		eh.files = make(map[uint16][]uint16)
+	}
+
+	for _, record := range req.Records {
+		file, ok := eh.files[record.FileNumber]
+		if !ok {
+			file = make([]uint16, simFileRecords)
+			eh.files[record.FileNumber] = file
+		}
+
+		start := int(record.RecordNumber)
+		end := start + int(record.RecordLength)
+
+		if req.IsWrite {
+			copy(file[start:end], record.Values)
+			eh.logger.Infof("receive IsWrite file %v record %v quantity %v unitID %v",
+				record.FileNumber, record.RecordNumber, record.RecordLength, req.UnitId)
+		} else {
+			eh.logger.Infof("receive file %v record %v quantity %v unitID %v",
+				record.FileNumber, record.RecordNumber, record.RecordLength, req.UnitId)
+			res = append(res, append([]uint16(nil), file[start:end]...))
+		}
+	}
+
+	return
+}
+
+// Device identification handler method.
+// This method gets called whenever a valid modbus read device identification
+// request (0x2b/0x0e) is received by the server.
+// Each unit identifies with the vendor, model and version of its device type, so
+// that a channel polling it matches the same type; units of types without a vendor
+// identify as a GridBeat cmbus device.
+func (eh *ModbusInstance) HandleDeviceIdentification(req *modbus.DeviceIdentificationRequest) (res map[uint8]string, err error) {
+	if err = eh.inject(req, req.UnitId); err != nil {
+		return
+	}
+
+	eh.lock.RLock()
+	defer eh.lock.RUnlock()
+
+	u := eh.units[req.UnitId]
+	if u == nil {
+		err = modbus.ErrGWTargetFailedToRespond
+		return
+	}
+	res = u.identity
+
 	return
 }
 
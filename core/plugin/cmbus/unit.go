@@ -0,0 +1,271 @@
+package cmbus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/config"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+	"github.com/fluxionwatt/gridbeat/version"
+	"gorm.io/gorm"
+)
+
+// simPoint：模拟设备上的一个点位
+// simPoint: one point of a simulated device.
+type simPoint struct {
+	def   models.DeviceTypePoint
+	codec *point.Codec
+	gen   generator
+	text  string // 字符串点位的值 / value of string points
+	fc    uint8  // 读功能码，决定所在的表 / read function code, selects the table
+	held  bool   // 已被主站写入，不再由数据源更新 / written by a master, no longer generated
+}
+
+// simUnit：一个模拟的从站，由设备类型的点位表生成寄存器映射
+// simUnit: one simulated slave, its register map built from a device type's point list.
+type simUnit struct {
+	id       uint8
+	identity map[uint8]string
+	points   []*simPoint
+
+	coils    map[uint16]bool
+	discrete map[uint16]bool
+	holding  map[uint16]uint16
+	input    map[uint16]uint16
+
+	// 可写点位覆盖的线圈与保持寄存器 / coils and holding registers covered by writable points
+	writableCoils map[uint16]bool
+	writableRegs  map[uint16]bool
+}
+
+// loadUnits：按通道上启用的设备创建模拟从站（站号取设备的 SlaveID）
+// loadUnits: create a simulated slave for every enabled device of the channel
+// (unit id from the device's SlaveID).
+func (m *ModbusInstance) loadUnits() (map[uint8]*simUnit, error) {
+	units := make(map[uint8]*simUnit)
+	if m.env == nil || m.env.DB == nil {
+		return units, nil
+	}
+
+	var sim config.SimGenerator
+	var specs []config.SimGenerator
+	if m.env.Conf != nil {
+		sim, specs = m.env.Conf.Simulation.Default, m.env.Conf.Simulation.Points
+	}
+
+	var devices []models.Device
+	if err := m.env.DB.
+		Where("channel_id = ? AND disable = ?", m.cfg.Model.UUID, false).
+		Order("slave_id asc").
+		Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("load devices: %w", err)
+	}
+
+	now := time.Now()
+	for _, d := range devices {
+		if d.SlaveID < 1 || d.SlaveID > 247 {
+			m.logger.Warnf("device %s (%s): invalid slave id %d, not simulated", d.ID, d.Name, d.SlaveID)
+			continue
+		}
+		if _, dup := units[uint8(d.SlaveID)]; dup {
+			m.logger.Warnf("device %s (%s): slave id %d already simulated", d.ID, d.Name, d.SlaveID)
+			continue
+		}
+
+		u, err := m.newUnit(m.env.DB, uint8(d.SlaveID), d.DeviceType, sim, specs, now)
+		if err != nil {
+			return nil, fmt.Errorf("device %s (%s): %w", d.ID, d.Name, err)
+		}
+		units[u.id] = u
+	}
+
+	return units, nil
+}
+
+// newUnit：由设备类型的点位表创建模拟从站
+// newUnit: create a simulated slave from a device type's point list.
+func (m *ModbusInstance) newUnit(db *gorm.DB, id uint8, typeKey string, def config.SimGenerator,
+	specs []config.SimGenerator, now time.Time) (*simUnit, error) {
+	u := &simUnit{
+		id:            id,
+		coils:         map[uint16]bool{},
+		discrete:      map[uint16]bool{},
+		holding:       map[uint16]uint16{},
+		input:         map[uint16]uint16{},
+		writableCoils: map[uint16]bool{},
+		writableRegs:  map[uint16]bool{},
+		identity: map[uint8]string{
+			modbus.OBJ_VENDOR_NAME:          version.ProductName,
+			modbus.OBJ_PRODUCT_CODE:         "cmbus",
+			modbus.OBJ_MAJOR_MINOR_REVISION: version.Version,
+			modbus.OBJ_PRODUCT_NAME:         version.ProductName + " modbus simulator",
+		},
+	}
+
+	// 以设备类型的厂商、型号与版本应答设备标识，便于轮询端匹配类型
+	// Identify with the type's vendor, model and version, so that the
+	// polling side matches the device type.
+	var dt models.DeviceType
+	if err := db.Where("type_key = ?", typeKey).First(&dt).Error; err == nil && dt.Vendor != "" {
+		u.identity = map[uint8]string{
+			modbus.OBJ_VENDOR_NAME:          dt.Vendor,
+			modbus.OBJ_PRODUCT_CODE:         dt.Model,
+			modbus.OBJ_MAJOR_MINOR_REVISION: dt.Version,
+			modbus.OBJ_PRODUCT_NAME:         dt.Name,
+		}
+	}
+
+	var rows []models.DeviceTypePoint
+	if err := db.
+		Where("type_key = ? AND enabled = ?", typeKey, true).
+		Order("fc asc, address asc").
+		Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("load points of type %q: %w", typeKey, err)
+	}
+
+	for i := range rows {
+		p := &rows[i]
+		c, err := point.NewCodec(p)
+		if err != nil {
+			m.logger.Warnf("type %q point %s: %v, not simulated", typeKey, p.PointCode, err)
+			continue
+		}
+
+		spec, ok := matchGenerator(specs, typeKey, p.PointCode)
+		if !ok && def.Kind != "" {
+			spec, ok = def, true
+		}
+		gen := defaultGenerator(p, now)
+		if ok {
+			if gen, err = newGenerator(spec, p, now); err != nil {
+				return nil, fmt.Errorf("type %q point %s: %w", typeKey, p.PointCode, err)
+			}
+		}
+
+		sp := &simPoint{def: *p, codec: c, gen: gen, text: spec.Text, fc: point.ReadFunctionCode(p)}
+		u.points = append(u.points, sp)
+
+		// 先为点位覆盖的地址分配空间，编码失败时地址也存在
+		// Allocate the covered addresses first, so they exist even if encoding fails.
+		writable := strings.Contains(p.RW, "W")
+		switch sp.fc {
+		case 1:
+			u.coils[p.Address] = false
+			if writable {
+				u.writableCoils[p.Address] = true
+			}
+		case 2:
+			u.discrete[p.Address] = false
+		case 3, 4:
+			table := u.table(sp.fc)
+			for a := range c.Registers() {
+				table[p.Address+uint16(a)] = 0
+				if writable && sp.fc == 3 {
+					u.writableRegs[p.Address+uint16(a)] = true
+				}
+			}
+		}
+	}
+
+	u.refresh(now)
+	return u, nil
+}
+
+// matchGenerator：查找点位的数据源配置，点位编码精确匹配优先于整个类型的配置
+// matchGenerator: find the data source config of a point; an exact point
+// code match wins over a config for the whole type.
+func matchGenerator(specs []config.SimGenerator, typeKey, code string) (spec config.SimGenerator, ok bool) {
+	for _, s := range specs {
+		if !strings.EqualFold(s.Type, typeKey) {
+			continue
+		}
+		if strings.EqualFold(s.Point, code) {
+			return s, true
+		}
+		if s.Point == "" && !ok {
+			spec, ok = s, true
+		}
+	}
+	return
+}
+
+// table：读功能码对应的寄存器表 / table: register table of a read function code.
+func (u *simUnit) table(fc uint8) map[uint16]uint16 {
+	if fc == 4 {
+		return u.input
+	}
+	return u.holding
+}
+
+// refresh：用数据源的当前值更新未被主站写入的点位（调用方持有锁）
+// refresh: update the points not written by a master from their data
+// sources (the caller holds the lock).
+func (u *simUnit) refresh(now time.Time) {
+	for _, sp := range u.points {
+		if sp.held {
+			continue
+		}
+
+		v := sp.gen.value(now)
+		switch sp.fc {
+		case 1:
+			u.coils[sp.def.Address] = v != 0
+			continue
+		case 2:
+			u.discrete[sp.def.Address] = v != 0
+			continue
+		}
+
+		table := u.table(sp.fc)
+		n := sp.codec.Registers()
+
+		var words []uint16
+		var err error
+		if sp.codec.IsBitPoint() {
+			cur := make([]uint16, n)
+			for i := range cur {
+				cur[i] = table[sp.def.Address+uint16(i)]
+			}
+			words, err = sp.codec.ApplyBit(cur, v != 0)
+		} else {
+			var s models.Scalar
+			if strings.EqualFold(sp.def.DataType, "string") {
+				s.SetString(sp.text)
+			} else {
+				s.SetFloat64(v)
+			}
+			words, err = sp.codec.Encode(s)
+		}
+		// 超出数据类型范围的值保持上一个值
+		// Values out of the data type's range keep the previous value.
+		if err != nil {
+			continue
+		}
+		for i, w := range words {
+			table[sp.def.Address+uint16(i)] = w
+		}
+	}
+}
+
+// hold：主站写入 [addr, addr+quantity) 后，覆盖这些地址的点位不再由数据源更新
+// hold: after a master writes [addr, addr+quantity), the points covering
+// those addresses are no longer generated.
+func (u *simUnit) hold(fc uint8, addr, quantity uint16) {
+	end := uint32(addr) + uint32(quantity)
+	for _, sp := range u.points {
+		if sp.fc != fc {
+			continue
+		}
+		n := uint32(1)
+		if fc == 3 || fc == 4 {
+			n = uint32(sp.codec.Registers())
+		}
+		start := uint32(sp.def.Address)
+		if start < end && start+n > uint32(addr) {
+			sp.held = true
+		}
+	}
+}
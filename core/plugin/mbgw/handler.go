@@ -0,0 +1,243 @@
+package mbgw
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/sirupsen/logrus"
+)
+
+// route：单元 ID 对应的通道与总线从站地址
+// route: the channel and bus slave ID a unit ID maps to.
+type route struct {
+	channel string // 通道 UUID / channel UUID
+	slaveID uint8
+}
+
+// gatewayHandler：实现 modbus.RequestHandler 及可选的处理接口，把请求原样转发到通道总线
+// gatewayHandler: implements modbus.RequestHandler and the optional handler
+// interfaces, forwarding requests as-is onto channel buses.
+type gatewayHandler struct {
+	ctx       context.Context
+	instances pluginapi.InstanceLookup
+	routes    map[uint8]route
+	timeout   time.Duration
+	logger    logrus.FieldLogger
+
+	forwarded atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// forward：按单元 ID 找到通道实例，在其总线上执行 fn，并把错误映射为网关异常码
+// forward: find the channel instance for unitID, run fn on its bus and map
+// errors to gateway exception codes.
+func (h *gatewayHandler) forward(unitID uint8, fn func(client *modbus.ModbusClient) error) error {
+	r, ok := h.routes[unitID]
+	if !ok {
+		h.failed.Add(1)
+		return modbus.ErrGWPathUnavailable
+	}
+
+	in, ok := h.instances.Get("mbus", r.channel)
+	if !ok {
+		h.logger.Warnf("modbus gateway unit=%d: channel %s not running", unitID, r.channel)
+		h.failed.Add(1)
+		return modbus.ErrGWPathUnavailable
+	}
+	fwd, ok := in.(pluginapi.BusForwarder)
+	if !ok {
+		h.failed.Add(1)
+		return modbus.ErrGWPathUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, h.timeout)
+	defer cancel()
+
+	err := gatewayError(fwd.Forward(ctx, r.slaveID, fn))
+	h.forwarded.Add(1)
+	if err == modbus.ErrGWPathUnavailable || err == modbus.ErrGWTargetFailedToRespond {
+		h.failed.Add(1)
+		h.logger.Debugf("modbus gateway unit=%d channel=%s slave=%d: %v", unitID, r.channel, r.slaveID, err)
+	}
+	return err
+}
+
+// gatewayError：把转发结果映射为返回给主站的错误
+// 设备异常原样返回；无应答或应答无效映射为目标无响应；其他链路错误映射为路径不可用。
+// gatewayError: map a forward result to the error returned to the master.
+// Device exceptions pass through; no answer or an invalid answer maps to
+// target failed to respond; other link errors map to path unavailable.
+func gatewayError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return modbus.ErrGWTargetFailedToRespond
+	}
+
+	switch err {
+	case modbus.ErrRequestTimedOut, modbus.ErrBadCRC, modbus.ErrBadLRC, modbus.ErrShortFrame,
+		modbus.ErrProtocolError, modbus.ErrBadUnitId, modbus.ErrBadTransactionId,
+		modbus.ErrUnknownProtocolId:
+		// 注意：ErrProtocolError 不能原样返回，否则服务端会断开主站连接
+		// Note: ErrProtocolError must not pass through, the server would drop the master's connection.
+		return modbus.ErrGWTargetFailedToRespond
+	case modbus.ErrUnexpectedParameters:
+		return modbus.ErrIllegalDataValue
+	case modbus.ErrIllegalFunction, modbus.ErrIllegalDataAddress, modbus.ErrIllegalDataValue,
+		modbus.ErrServerDeviceFailure, modbus.ErrAcknowledge, modbus.ErrServerDeviceBusy,
+		modbus.ErrMemoryParityError, modbus.ErrGWPathUnavailable, modbus.ErrGWTargetFailedToRespond:
+		return err
+	}
+	return modbus.ErrGWPathUnavailable
+}
+
+// HandleCoils：转发读线圈（0x01）、写单个线圈（0x05）与写多个线圈（0x0f）
+// HandleCoils: forwards read coils (0x01), write single coil (0x05) and write multiple coils (0x0f).
+func (h *gatewayHandler) HandleCoils(req *modbus.CoilsRequest) (res []bool, err error) {
+	var out []bool
+	err = h.forward(req.UnitId, func(c *modbus.ModbusClient) (err error) {
+		switch {
+		case !req.IsWrite:
+			out, err = c.ReadCoils(req.Addr, req.Quantity)
+		case req.IsSingleWrite:
+			err = c.WriteCoil(req.Addr, req.Args[0])
+		default:
+			err = c.WriteCoils(req.Addr, req.Args)
+		}
+		return
+	})
+	if err == nil {
+		res = out
+	}
+	return
+}
+
+// HandleDiscreteInputs：转发读离散输入（0x02）
+// HandleDiscreteInputs: forwards read discrete inputs (0x02).
+func (h *gatewayHandler) HandleDiscreteInputs(req *modbus.DiscreteInputsRequest) (res []bool, err error) {
+	var out []bool
+	err = h.forward(req.UnitId, func(c *modbus.ModbusClient) (err error) {
+		out, err = c.ReadDiscreteInputs(req.Addr, req.Quantity)
+		return
+	})
+	if err == nil {
+		res = out
+	}
+	return
+}
+
+// HandleHoldingRegisters：转发读保持寄存器（0x03）、写单个寄存器（0x06）与写多个寄存器（0x10）
+// HandleHoldingRegisters: forwards read holding registers (0x03), write single
+// register (0x06) and write multiple registers (0x10).
+func (h *gatewayHandler) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) (res []uint16, err error) {
+	var out []uint16
+	err = h.forward(req.UnitId, func(c *modbus.ModbusClient) (err error) {
+		switch {
+		case !req.IsWrite:
+			out, err = c.ReadRegisters(req.Addr, req.Quantity, modbus.HOLDING_REGISTER)
+		case req.IsSingleWrite:
+			err = c.WriteRegister(req.Addr, req.Args[0])
+		default:
+			err = c.WriteRegisters(req.Addr, req.Args)
+		}
+		return
+	})
+	if err == nil {
+		res = out
+	}
+	return
+}
+
+// HandleInputRegisters：转发读输入寄存器（0x04）
+// HandleInputRegisters: forwards read input registers (0x04).
+func (h *gatewayHandler) HandleInputRegisters(req *modbus.InputRegistersRequest) (res []uint16, err error) {
+	var out []uint16
+	err = h.forward(req.UnitId, func(c *modbus.ModbusClient) (err error) {
+		out, err = c.ReadRegisters(req.Addr, req.Quantity, modbus.INPUT_REGISTER)
+		return
+	})
+	if err == nil {
+		res = out
+	}
+	return
+}
+
+// HandleMaskWriteRegister：转发掩码写寄存器（0x16）
+// HandleMaskWriteRegister: forwards mask write register (0x16).
+func (h *gatewayHandler) HandleMaskWriteRegister(req *modbus.MaskWriteRegisterRequest) error {
+	return h.forward(req.UnitId, func(c *modbus.ModbusClient) error {
+		return c.MaskWriteRegister(req.Addr, req.AndMask, req.OrMask)
+	})
+}
+
+// HandleReadWriteRegisters：转发读写多个寄存器（0x17）
+// HandleReadWriteRegisters: forwards read/write multiple registers (0x17).
+func (h *gatewayHandler) HandleReadWriteRegisters(req *modbus.ReadWriteRegistersRequest) (res []uint16, err error) {
+	var out []uint16
+	err = h.forward(req.UnitId, func(c *modbus.ModbusClient) (err error) {
+		out, err = c.ReadWriteMultipleRegisters(req.ReadAddr, req.ReadQuantity, req.WriteAddr, req.Args)
+		return
+	})
+	if err == nil {
+		res = out
+	}
+	return
+}
+
+// HandleFIFOQueue：转发读 FIFO 队列（0x18）
+// HandleFIFOQueue: forwards read FIFO queue (0x18).
+func (h *gatewayHandler) HandleFIFOQueue(req *modbus.FIFOQueueRequest) (res []uint16, err error) {
+	var out []uint16
+	err = h.forward(req.UnitId, func(c *modbus.ModbusClient) (err error) {
+		out, err = c.ReadFIFOQueue(req.Addr)
+		return
+	})
+	if err == nil {
+		res = out
+	}
+	return
+}
+
+// HandleFileRecords：转发读文件记录（0x14）与写文件记录（0x15）
+// HandleFileRecords: forwards read file record (0x14) and write file record (0x15).
+func (h *gatewayHandler) HandleFileRecords(req *modbus.FileRecordRequest) (res [][]uint16, err error) {
+	var out [][]uint16
+	err = h.forward(req.UnitId, func(c *modbus.ModbusClient) error {
+		if req.IsWrite {
+			return c.WriteFileRecords(req.Records)
+		}
+
+		records, err := c.ReadFileRecords(req.Records)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			out = append(out, r.Values)
+		}
+		return nil
+	})
+	if err == nil {
+		res = out
+	}
+	return
+}
+
+// HandleDeviceIdentification：转发读设备标识（0x2b/0x0e），按扩展级别读取，由服务端按请求级别筛选
+// HandleDeviceIdentification: forwards read device identification (0x2b/0x0e),
+// reading the extended level and letting the server pick the requested objects.
+func (h *gatewayHandler) HandleDeviceIdentification(req *modbus.DeviceIdentificationRequest) (res map[uint8]string, err error) {
+	var out map[uint8]string
+	err = h.forward(req.UnitId, func(c *modbus.ModbusClient) (err error) {
+		out, err = c.ReadDeviceIdentification(modbus.DEVICE_ID_EXTENDED)
+		return
+	})
+	if err == nil {
+		res = out
+	}
+	return
+}
@@ -0,0 +1,265 @@
+package mbgw
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core/plugin/cmbus"
+	"github.com/fluxionwatt/gridbeat/internal/config"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 未配置 Timeout 时单个转发请求的超时 / per-request forward timeout when Timeout is unset
+	defaultForwardTimeout = 5 * time.Second
+)
+
+// InstanceConfig：网关实例的配置
+// InstanceConfig: configuration of the gateway instance.
+type InstanceConfig struct {
+	Listen     string // Modbus TCP 监听地址 / Modbus TCP listen address, e.g. ":502"
+	MaxClients uint
+	Timeout    time.Duration
+	Routes     []config.GatewayRoute
+}
+
+// Status：网关运行状态
+// Status: gateway runtime status.
+type Status struct {
+	Listening bool   `json:"listening"` // 是否在监听 / whether the server is listening
+	Forwarded uint64 `json:"forwarded"` // 已转发请求数 / requests forwarded
+	Failed    uint64 `json:"failed"`    // 以网关异常码应答的请求数 / requests answered with a gateway exception
+}
+
+// GatewayInstance：Modbus TCP 到 RTU 网关，实现 pluginapi.Instance
+// 每个请求按单元 ID 路由到通道实例（pluginapi.BusForwarder），与通道轮询共享总线。
+// GatewayInstance: Modbus TCP-to-RTU gateway implementing pluginapi.Instance.
+// Each request is routed by unit ID to a channel instance (pluginapi.BusForwarder),
+// sharing the bus with the channel's polling.
+type GatewayInstance struct {
+	id  string
+	typ string
+
+	cfg InstanceConfig
+
+	logger  logrus.FieldLogger // 实例级 logger / per-instance logger
+	server  *modbus.ModbusServer
+	handler *gatewayHandler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	parentCtx context.Context
+	env       *pluginapi.HostEnv
+
+	mu   sync.Mutex
+	init bool
+}
+
+func (g *GatewayInstance) ID() string   { return g.id }
+func (g *GatewayInstance) Type() string { return g.typ }
+
+// Init：校验路由并启动 Modbus TCP 服务
+// Init: validate the routes and start the Modbus TCP server.
+func (g *GatewayInstance) Init(parent context.Context, env *pluginapi.HostEnv) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.init {
+		return nil
+	}
+
+	if parent == nil {
+		parent = context.Background()
+	}
+	g.parentCtx = parent
+	g.env = env
+
+	// logger：优先用 HostEnv.PluginLog，否则用 logrus 标准 logger
+	// logger: prefer HostEnv.PluginLog, otherwise use the logrus standard logger.
+	var log logrus.FieldLogger = logrus.StandardLogger()
+	if env != nil && env.PluginLog != nil {
+		log = env.PluginLog
+	}
+	g.logger = log.WithField("plugin", "mbgw").WithField("instance", g.id)
+
+	if env == nil || env.Instances == nil {
+		return fmt.Errorf("modbus gateway[%s]: no instance lookup", g.id)
+	}
+
+	routes, err := buildRoutes(g.cfg.Routes)
+	if err != nil {
+		return fmt.Errorf("modbus gateway[%s]: %w", g.id, err)
+	}
+
+	timeout := g.cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultForwardTimeout
+	}
+
+	g.ctx, g.cancel = context.WithCancel(parent)
+
+	// 每次 Init 新建 handler：旧连接上仍在执行的请求只会看到旧路由
+	// A new handler per Init: requests still running on old connections only see the old routes.
+	g.handler = &gatewayHandler{
+		ctx:       g.ctx,
+		instances: env.Instances,
+		routes:    routes,
+		timeout:   timeout,
+		logger:    g.logger,
+	}
+
+	l, _, _ := cmbus.ToStdLogger(g.logger, logrus.InfoLevel, "", 0)
+
+	g.server, err = modbus.NewServer(&modbus.ServerConfiguration{
+		URL:        "tcp://" + g.cfg.Listen,
+		MaxClients: g.cfg.MaxClients,
+		Logger:     l,
+	}, g.handler)
+	if err != nil {
+		g.cancel()
+		return fmt.Errorf("modbus gateway[%s]: create server(%s) failed: %w", g.id, g.cfg.Listen, err)
+	}
+
+	if err := g.server.Start(); err != nil {
+		g.cancel()
+		return fmt.Errorf("modbus gateway[%s]: listen on %s failed: %w", g.id, g.cfg.Listen, err)
+	}
+
+	g.init = true
+	g.logger.Infof("modbus gateway listening on %s, routes=%d", g.cfg.Listen, len(routes))
+
+	return nil
+}
+
+// buildRoutes：按单元 ID 建立路由表，拒绝广播地址与重复路由
+// buildRoutes: index the routes by unit ID, rejecting the broadcast address and duplicates.
+func buildRoutes(in []config.GatewayRoute) (map[uint8]route, error) {
+	routes := make(map[uint8]route, len(in))
+	for _, r := range in {
+		if r.UnitID == 0 {
+			return nil, fmt.Errorf("route for unit id 0 (broadcast) is not supported")
+		}
+		if r.Channel == "" {
+			return nil, fmt.Errorf("route for unit id %d has no channel", r.UnitID)
+		}
+		if _, ok := routes[r.UnitID]; ok {
+			return nil, fmt.Errorf("duplicate route for unit id %d", r.UnitID)
+		}
+
+		slaveID := r.SlaveID
+		if slaveID == 0 {
+			slaveID = r.UnitID
+		}
+		routes[r.UnitID] = route{channel: r.Channel, slaveID: slaveID}
+	}
+	return routes, nil
+}
+
+// Close：停止 Modbus TCP 服务并取消进行中的转发
+// Close: stop the Modbus TCP server and cancel forwards in flight.
+func (g *GatewayInstance) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.init {
+		return nil
+	}
+
+	if g.server != nil {
+		_ = g.server.Stop()
+		g.server = nil
+	}
+	if g.cancel != nil {
+		g.cancel()
+	}
+
+	g.ctx = nil
+	g.cancel = nil
+	g.init = false
+
+	if g.logger != nil {
+		g.logger.Infof("modbus gateway closed")
+	}
+	return nil
+}
+
+func (g *GatewayInstance) Get() any {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	st := Status{Listening: g.init}
+	if g.handler != nil {
+		st.Forwarded = g.handler.forwarded.Load()
+		st.Failed = g.handler.failed.Load()
+	}
+	return st
+}
+
+// UpdateConfig：配置变化时以新配置重启网关
+// UpdateConfig: restart the gateway with the new config when it changed.
+func (g *GatewayInstance) UpdateConfig(raw pluginapi.InstanceConfig) error {
+	g.mu.Lock()
+
+	newCfg := g.cfg
+	if v, ok := raw.(InstanceConfig); ok {
+		newCfg = v
+	}
+
+	needRestart := !reflect.DeepEqual(newCfg, g.cfg)
+	g.cfg = newCfg
+
+	parent := g.parentCtx
+	env := g.env
+
+	g.mu.Unlock()
+
+	if !needRestart {
+		return nil
+	}
+
+	if err := g.Close(); err != nil {
+		return fmt.Errorf("modbus gateway[%s]: close before restart failed: %w", g.id, err)
+	}
+
+	if parent == nil {
+		parent = context.Background()
+	}
+	if err := parent.Err(); err != nil {
+		return err
+	}
+	return g.Init(parent, env)
+}
+
+// GatewayFactory：实现 Factory 接口
+// GatewayFactory: implements pluginapi.Factory.
+type GatewayFactory struct{}
+
+func (f *GatewayFactory) Type() string { return "mbgw" }
+
+// New：根据配置创建实例（真正启动在 Init 中完成）
+// New: create an instance from config (real start happens in Init).
+func (f *GatewayFactory) New(id string, raw pluginapi.InstanceConfig) (pluginapi.Instance, error) {
+	if id == "" {
+		return nil, fmt.Errorf("modbus gateway: empty instance id")
+	}
+
+	cfg, _ := raw.(InstanceConfig)
+
+	return &GatewayInstance{
+		id:  id,
+		typ: f.Type(),
+		cfg: cfg,
+	}, nil
+}
+
+// init：注册工厂
+// init: register factory.
+func init() {
+	pluginapi.RegisterFactory(&GatewayFactory{})
+}
@@ -0,0 +1,166 @@
+package mbgw
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core/plugin/mbus"
+	"github.com/fluxionwatt/gridbeat/internal/config"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/sirupsen/logrus"
+)
+
+// slaveHandler：总线上的从站，站号 1 应答保持寄存器读取，其余站号不应答
+// slaveHandler: the slave on the bus; unit id 1 answers holding register
+// reads and every other unit id stays silent.
+type slaveHandler struct{}
+
+func (slaveHandler) HandleCoils(*modbus.CoilsRequest) ([]bool, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (slaveHandler) HandleDiscreteInputs(*modbus.DiscreteInputsRequest) ([]bool, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (slaveHandler) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) ([]uint16, error) {
+	if req.UnitId != 1 {
+		return nil, modbus.ErrNoResponse
+	}
+	res := make([]uint16, req.Quantity)
+	for i := range res {
+		res[i] = req.Addr + uint16(i) + 100
+	}
+	return res, nil
+}
+
+func (slaveHandler) HandleInputRegisters(*modbus.InputRegistersRequest) ([]uint16, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (slaveHandler) HandleMaskWriteRegister(*modbus.MaskWriteRegisterRequest) error {
+	return modbus.ErrIllegalFunction
+}
+
+func (slaveHandler) HandleReadWriteRegisters(*modbus.ReadWriteRegistersRequest) ([]uint16, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (slaveHandler) HandleFIFOQueue(*modbus.FIFOQueueRequest) ([]uint16, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (slaveHandler) HandleFileRecords(*modbus.FileRecordRequest) ([][]uint16, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (slaveHandler) HandleDeviceIdentification(*modbus.DeviceIdentificationRequest) (map[uint8]string, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+// instanceLookup：按 ID 返回 mbus 实例 / instanceLookup: returns mbus instances by id.
+type instanceLookup map[string]pluginapi.Instance
+
+func (l instanceLookup) Get(typ, id string) (pluginapi.Instance, bool) {
+	in, ok := l[id]
+	return in, ok && typ == "mbus"
+}
+
+// 网关经通道转发：有路由的单元得到从站应答，无路由的单元为路径不可用，
+// 不应答的从站为目标无响应；宿主环境没有 logger 时使用标准 logger
+// Forwarding through a channel: a routed unit gets the slave's answer, an
+// unrouted unit gets path unavailable and a silent slave gets target failed
+// to respond. The host environment has no logger, so the standard one is used.
+func TestGatewayForward(t *testing.T) {
+	slave, err := modbus.NewServer(&modbus.ServerConfiguration{URL: "tcp://localhost:5519", MaxClients: 4}, slaveHandler{})
+	if err != nil {
+		t.Fatalf("failed to create slave: %v", err)
+	}
+	if err = slave.Start(); err != nil {
+		t.Fatalf("failed to start slave: %v", err)
+	}
+	defer slave.Stop()
+
+	ch := *models.GetDefaultSerialRow("", "")
+	ch.PhysicalLink = "tcp"
+	ch.TCPIPAddr, ch.TCPPort = "localhost", 5519
+	ch.BackupTCPIPAddr, ch.BackupTCPPort = "", 0
+	ch.Delay = 100 * time.Millisecond
+	ch.RetryMax = 0
+
+	in, err := (&mbus.ModbusFactory{}).New("ch", mbus.InstanceConfig{Model: ch})
+	if err != nil {
+		t.Fatalf("failed to create channel: %v", err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	if err = in.Init(context.Background(), &pluginapi.HostEnv{Logger: &pluginapi.ReopenLogger{}, PluginLog: log}); err != nil {
+		t.Fatalf("failed to init channel: %v", err)
+	}
+	defer in.Close()
+
+	defer logrus.SetOutput(logrus.StandardLogger().Out)
+	logrus.SetOutput(io.Discard)
+
+	gw, err := (&GatewayFactory{}).New("gw", InstanceConfig{
+		Listen:     "localhost:5804",
+		MaxClients: 4,
+		Timeout:    2 * time.Second,
+		Routes: []config.GatewayRoute{
+			{UnitID: 1, Channel: "ch"},
+			{UnitID: 2, Channel: "ch"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create gateway: %v", err)
+	}
+	if err = gw.Init(context.Background(), &pluginapi.HostEnv{Instances: instanceLookup{"ch": in}}); err != nil {
+		t.Fatalf("failed to init gateway: %v", err)
+	}
+	defer gw.Close()
+
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{URL: "tcp://localhost:5804", Timeout: 3 * time.Second})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err = client.Open(); err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	read := func(unitId uint8) ([]uint16, error) {
+		client.SetUnitId(unitId)
+		return client.ReadRegisters(10, 2, modbus.HOLDING_REGISTER)
+	}
+
+	// 通道连上总线之前转发返回路径不可用
+	// Forwards return path unavailable until the channel is on the bus.
+	var regs []uint16
+	deadline := time.Now().Add(5 * time.Second)
+	for regs, err = read(1); err == modbus.ErrGWPathUnavailable && time.Now().Before(deadline); regs, err = read(1) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil || len(regs) != 2 || regs[0] != 110 || regs[1] != 111 {
+		t.Fatalf("routed read: expected [110 111], got %v (%v)", regs, err)
+	}
+
+	if _, err = read(3); err != modbus.ErrGWPathUnavailable {
+		t.Errorf("unrouted unit: expected %v, got %v", modbus.ErrGWPathUnavailable, err)
+	}
+	if _, err = read(2); err != modbus.ErrGWTargetFailedToRespond {
+		t.Errorf("silent slave: expected %v, got %v", modbus.ErrGWTargetFailedToRespond, err)
+	}
+
+	// 无应答后通道仍可转发 / the channel keeps forwarding after a silent slave
+	if regs, err = read(1); err != nil || len(regs) != 2 || regs[0] != 110 {
+		t.Errorf("routed read after a timeout: expected [110 111], got %v (%v)", regs, err)
+	}
+
+	if st := gw.Get().(Status); !st.Listening || st.Failed < 2 {
+		t.Errorf("unexpected status: %+v", st)
+	}
+}
@@ -0,0 +1,56 @@
+package mbus
+
+import (
+	"context"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+)
+
+// Broadcast：实现 pluginapi.BroadcastWriter，以站号 0 写入点位；从站不应答，
+// 发送后等待转换延迟再继续轮询。广播无法回读，不更新实时库
+// Broadcast: implements pluginapi.BroadcastWriter, writing the point to unit
+// id 0; slaves do not answer, so the bus waits out the turnaround delay before
+// polling resumes. Broadcasts cannot be read back and leave the RTDB untouched.
+func (m *ModbusInstance) Broadcast(ctx context.Context, req pluginapi.BroadcastRequest) (v models.Scalar, err error) {
+	p := &req.Point
+
+	if m.cfg.Model.PhysicalLink != "serial" {
+		return v, pluginapi.ErrBroadcastUnsupported
+	}
+	fc := point.ReadFunctionCode(p)
+	if p.RW == "R" || fc == 2 || fc == 4 {
+		return v, pluginapi.ErrReadOnlyPoint
+	}
+
+	codec, err := point.NewCodec(p)
+	if err != nil {
+		return v, err
+	}
+
+	v, err = submitValue(ctx, m, func(out *models.Scalar) error {
+		if err := m.client.SetUnitId(0); err != nil {
+			return err
+		}
+
+		// 寄存器位点位只能用掩码写，无法读-改-写
+		// Bit-in-register points can only use mask write, not read-modify-write.
+		expected, err := m.writeValue(p, codec, req.Value, make([]uint16, codec.Registers()))
+		if err != nil {
+			return err
+		}
+		*out = expected
+		return nil
+	})
+
+	if m.logger != nil {
+		m.logger.Infof("broadcast point %s value=%s result=%s err=%v",
+			p.PointCode, point.Format(req.Value), point.Format(v), err)
+	}
+
+	return v, err
+}
+
+// 编译期检查 / compile-time check
+var _ pluginapi.BroadcastWriter = (*ModbusInstance)(nil)
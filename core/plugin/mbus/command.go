@@ -0,0 +1,122 @@
+package mbus
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+)
+
+var (
+	errStopped  = errors.New("modbus instance stopped")
+	errLinkDown = errors.New("modbus link down")
+)
+
+// command：需要独占总线执行的操作（写点位等），由轮询协程在两次轮询之间执行
+// command: an operation needing exclusive bus access (point writes, ...),
+// run by the poller goroutine between polls.
+type command struct {
+	run  func() error
+	done chan error
+}
+
+// submit：把操作交给轮询协程执行并等待结果
+// submit: hand an operation to the poller goroutine and wait for its result.
+func (m *ModbusInstance) submit(ctx context.Context, fn func() error) error {
+	m.mu.Lock()
+	cmds, ictx, init, sniffing := m.cmds, m.ctx, m.init, m.sniffer != nil
+	m.mu.Unlock()
+
+	if !init || ictx == nil {
+		return errStopped
+	}
+	// 监听模式下总线只读 / the bus is read-only in sniffer mode
+	if sniffing {
+		return pluginapi.ErrSnifferMode
+	}
+
+	cmd := &command{run: fn, done: make(chan error, 1)}
+	select {
+	case cmds <- cmd:
+	case <-ictx.Done():
+		return errStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-cmd.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// submitValue：同 submit，fn 把结果写入 *T；结果经通道交回调用方，
+// 因此超时返回后仍在执行的 fn 不会与调用方竞争。只要 fn 执行完毕即返回其结果（即使出错）
+// submitValue: like submit, with fn filling in a *T. The result is handed back
+// through a channel, so an fn still running after the caller timed out never
+// races it. The result is returned whenever fn completed, even with an error.
+func submitValue[T any](ctx context.Context, m *ModbusInstance, fn func(out *T) error) (out T, err error) {
+	res := make(chan T, 1)
+	err = m.submit(ctx, func() error {
+		var v T
+		err := fn(&v)
+		res <- v
+		return err
+	})
+
+	select {
+	case out = <-res:
+	default:
+	}
+	return out, err
+}
+
+// waitUntil：等待到 t，期间执行排队的命令
+// 返回 ok=false 表示 ctx 已取消；err 为命令遇到的链路级错误。
+// waitUntil: wait until t, running queued commands meanwhile.
+// ok=false means ctx is done; err is a link-level error hit by a command.
+func (m *ModbusInstance) waitUntil(t time.Time) (ok bool, err error) {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return false, nil
+		case <-timer.C:
+			return true, nil
+		case cmd := <-m.cmds:
+			err = cmd.run()
+			cmd.done <- err
+			if isLinkError(err) {
+				return true, err
+			}
+		case <-m.reload:
+			m.reloadDevices()
+			return true, nil
+		}
+	}
+}
+
+// waitOffline：链路断开期间等待 d，排队的命令直接失败
+// waitOffline: wait d while the link is down, failing queued commands right away.
+func (m *ModbusInstance) waitOffline(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case cmd := <-m.cmds:
+			cmd.done <- errLinkDown
+		case <-m.reload:
+			m.reloadDevices()
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package mbus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+)
+
+// defaultPollInterval：设备未配置 PollIntervalMs 时使用的轮询周期
+// defaultPollInterval: poll period used when a device has no PollIntervalMs.
+const defaultPollInterval = time.Second
+
+// devicePoller：通道上单个设备的轮询状态
+// devicePoller: polling state of a single device on the channel.
+type devicePoller struct {
+	dev      models.Device
+	unitID   uint8
+	points   []models.DeviceTypePoint
+	codecs   []*point.Codec // 与 points 一一对应 / parallel to points
+	blocks   []point.Block
+	interval time.Duration
+	next     time.Time
+	failures int // 连续无应答的轮询次数 / consecutive polls without an answer
+}
+
+// loadDevices：加载绑定到本通道的所有启用设备及其点位
+// loadDevices: load every enabled device bound to this channel with its points.
+func (m *ModbusInstance) loadDevices() ([]*devicePoller, error) {
+	if m.env == nil || m.env.DB == nil {
+		return nil, nil
+	}
+
+	var devices []models.Device
+	if err := m.env.DB.
+		Where("channel_id = ? AND disable = ?", m.cfg.Model.UUID, false).
+		Order("slave_id asc").
+		Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("load devices: %w", err)
+	}
+
+	// 同一类型的点位只查询一次
+	// Query the points of each device type only once.
+	byType := make(map[string][]models.DeviceTypePoint)
+	codecs := make(map[string][]*point.Codec)
+
+	out := make([]*devicePoller, 0, len(devices))
+	for _, d := range devices {
+		if d.SlaveID < 1 || d.SlaveID > 247 {
+			m.logger.Warnf("device %s (%s): invalid slave id %d, skipped", d.ID, d.Name, d.SlaveID)
+			continue
+		}
+
+		points, ok := byType[d.DeviceType]
+		if !ok {
+			var rows []models.DeviceTypePoint
+			if err := m.env.DB.
+				Where("type_key = ? AND enabled = ?", d.DeviceType, true).
+				Order("fc asc, address asc").
+				Find(&rows).Error; err != nil {
+				return nil, fmt.Errorf("load points of type %q: %w", d.DeviceType, err)
+			}
+
+			// 只写点位不参与轮询
+			// Write-only points are not polled.
+			points = rows[:0]
+			for _, p := range rows {
+				if p.RW == "W" {
+					continue
+				}
+				points = append(points, p)
+			}
+			byType[d.DeviceType] = points
+
+			// 点位定义无法解析时只跳过该点位
+			// A point whose definition cannot be parsed is skipped on its own.
+			cs := make([]*point.Codec, len(points))
+			for i := range points {
+				c, err := point.NewCodec(&points[i])
+				if err != nil {
+					m.logger.Warnf("type %q point %s: %v", d.DeviceType, points[i].PointCode, err)
+					continue
+				}
+				cs[i] = c
+			}
+			codecs[d.DeviceType] = cs
+		}
+
+		if len(points) == 0 {
+			m.logger.Warnf("device %s (%s): type %q has no readable points", d.ID, d.Name, d.DeviceType)
+		}
+
+		interval := time.Duration(d.PollIntervalMs) * time.Millisecond
+		if interval <= 0 {
+			interval = defaultPollInterval
+		}
+
+		out = append(out, &devicePoller{
+			dev:      d,
+			unitID:   uint8(d.SlaveID),
+			points:   points,
+			codecs:   codecs[d.DeviceType],
+			interval: interval,
+			blocks: point.Plan(points, point.PlanOptions{
+				MaxGap:       d.ReadGap,
+				MaxRegisters: d.MaxReadRegs,
+				MaxBits:      d.MaxReadBits,
+			}),
+		})
+	}
+
+	return out, nil
+}
+
+// Reload：实现 pluginapi.Reloader，通知轮询协程重新加载设备列表
+// Reload: implements pluginapi.Reloader; tells the poller goroutine to reload the device list.
+func (m *ModbusInstance) Reload() error {
+	m.mu.Lock()
+	reload, init := m.reload, m.init
+	m.mu.Unlock()
+
+	if !init {
+		return errStopped
+	}
+
+	// 已有未处理的通知时无需重复发送
+	// No need to queue another notification while one is pending.
+	select {
+	case reload <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// reloadDevices：在轮询协程中重新加载设备列表，新设备立即到期
+// reloadDevices: reload the device list on the poller goroutine; new devices are due at once.
+func (m *ModbusInstance) reloadDevices() {
+	devices, err := m.loadDevices()
+	if err != nil {
+		m.logger.Errorf("reload devices failed: %v", err)
+		return
+	}
+
+	// 保留已有设备的调度时间
+	// Keep the schedule of devices that were already polled.
+	prev := make(map[string]time.Time, len(m.devices))
+	for _, d := range m.devices {
+		prev[d.dev.ID] = d.next
+	}
+	for _, d := range devices {
+		d.next = prev[d.dev.ID]
+	}
+
+	m.devices = devices
+	m.gen++
+	m.logger.Infof("modbus devices reloaded, devices=%d", len(devices))
+}
+
+// readBlock：读取一个合并块，寄存器返回 words，线圈/离散量返回 bits
+// readBlock: read one coalesced block; registers return words, coils/discrete inputs return bits.
+func (m *ModbusInstance) readBlock(b *point.Block) (words []uint16, bits []bool, err error) {
+	err = m.exec(true, func() (err error) {
+		words, bits, err = readBlockOn(m.client, b)
+		return
+	})
+	return
+}
+
+// readBlockOn：用指定 client 读取一个块，不重试
+// readBlockOn: read one block with the given client, without retries.
+func readBlockOn(client *modbus.ModbusClient, b *point.Block) (words []uint16, bits []bool, err error) {
+	switch b.FC {
+	case 1:
+		bits, err = client.ReadCoils(b.Address, b.Quantity)
+	case 2:
+		bits, err = client.ReadDiscreteInputs(b.Address, b.Quantity)
+	case 3:
+		words, err = client.ReadRegisters(b.Address, b.Quantity, modbus.HOLDING_REGISTER)
+	case 4:
+		words, err = client.ReadRegisters(b.Address, b.Quantity, modbus.INPUT_REGISTER)
+	default:
+		err = fmt.Errorf("unsupported function code %d", b.FC)
+	}
+	return
+}
+
+// pollDevice：按合并块轮询一个设备的全部点位，返回遇到的链路级错误（如有）
+// pollDevice: poll every point of one device block by block, returning a link-level error if one occurred.
+func (m *ModbusInstance) pollDevice(d *devicePoller) error {
+	if err := m.client.SetUnitId(d.unitID); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	answered := false
+	for i := range d.blocks {
+		err := m.pollBlock(d, &d.blocks[i])
+		if isLinkError(err) {
+			return err
+		}
+		if isAnswer(err) {
+			answered = true
+			continue
+		}
+
+		// 降级模式下设备无应答时跳过其余块，避免拖慢同一总线上的其他设备
+		// In downgrade mode, skip the remaining blocks of a silent device so it
+		// doesn't stall the other devices on the bus.
+		if m.cfg.Model.Downgrade && !answered {
+			m.skipBlocks(d, d.blocks[i+1:], err)
+			break
+		}
+	}
+	m.Status.CurrentDelay = time.Since(start)
+
+	// 串口通道：记录共享总线的排队情况（同一串口可能被多个实例共用）
+	// Serial channels: record the shared bus queueing (a port may be shared by several instances).
+	if st, err := m.client.BusStats(); err == nil {
+		m.Status.BusQueueDepth = st.QueueDepth
+		m.Status.BusMaxQueueDepth = st.MaxQueueDepth
+		m.Status.BusAvgLatency = st.AvgLatency
+		m.Status.BusMaxLatency = st.MaxLatency
+	}
+
+	if answered {
+		m.linkFails = 0
+		if d.failures > 0 && m.cfg.Model.Downgrade {
+			m.logger.Infof("device %s unit=%d answering again, downgrade cleared", d.dev.Name, d.unitID)
+		}
+		d.failures = 0
+	} else {
+		d.failures++
+		if d.failures == 1 && m.cfg.Model.Downgrade {
+			m.logger.Warnf("device %s unit=%d not answering, downgraded", d.dev.Name, d.unitID)
+		}
+	}
+
+	return nil
+}
+
+// skipBlocks：把未轮询的块中的点位标记为读取失败
+// skipBlocks: mark the points of blocks that were not polled as failed reads.
+func (m *ModbusInstance) skipBlocks(d *devicePoller, blocks []point.Block, err error) {
+	now := time.Now()
+	for i := range blocks {
+		m.Status.PointsToalRead = m.Status.PointsToalRead + uint64(len(blocks[i].Points))
+		m.Status.PointsErrorRead = m.Status.PointsErrorRead + uint64(len(blocks[i].Points))
+		if m.env != nil && m.env.RTDB != nil {
+			for _, p := range blocks[i].Points {
+				m.env.RTDB.SetError(d.dev.ID, d.points[p].PointCode, err, now)
+			}
+		}
+	}
+}
+
+// pollBlock：读取一个块并把结果拆分到各点位，返回读取错误（如有）
+// 多点位的块若被设备以非法地址拒绝（块内有空洞），则退化为逐点读取。
+// pollBlock: read one block and split the result back out per point,
+// returning the read error if any.
+// A multi-point block rejected with an illegal address (a hole inside the
+// block) falls back to reading each point on its own.
+func (m *ModbusInstance) pollBlock(d *devicePoller, b *point.Block) error {
+	words, bits, err := m.readBlock(b)
+
+	m.Status.BytesSent = m.Status.BytesSent + 1
+
+	if err == modbus.ErrIllegalDataAddress && len(b.Points) > 1 {
+		m.logger.Warnf("device %s unit=%d block fc=%d addr=%d qty=%d rejected, reading points one by one",
+			d.dev.Name, d.unitID, b.FC, b.Address, b.Quantity)
+		for _, i := range b.Points {
+			p := &d.points[i]
+			single := point.Block{FC: b.FC, Address: p.Address, Quantity: point.Quantity(p), Points: []int{i}}
+			if err := m.pollBlock(d, &single); isLinkError(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	m.Status.PointsToalRead = m.Status.PointsToalRead + uint64(len(b.Points))
+
+	if err != nil {
+		m.Status.PointsErrorRead = m.Status.PointsErrorRead + uint64(len(b.Points))
+		m.logger.Warnf("device %s unit=%d read failed fc=%d addr=%d qty=%d: %v",
+			d.dev.Name, d.unitID, b.FC, b.Address, b.Quantity, err)
+
+		if m.env != nil && m.env.RTDB != nil {
+			now := time.Now()
+			for _, i := range b.Points {
+				m.env.RTDB.SetError(d.dev.ID, d.points[i].PointCode, err, now)
+			}
+		}
+
+		return err
+	}
+
+	m.Status.BytesReceived = m.Status.BytesReceived + 1
+
+	for _, i := range b.Points {
+		p := &d.points[i]
+		if words != nil {
+			m.handlePoint(d, i, point.Extract(b, words, p), nil)
+		} else {
+			m.handlePoint(d, i, nil, point.Extract(b, bits, p))
+		}
+	}
+
+	return nil
+}
+
+// handlePoint：解码单个点位的读取结果
+// handlePoint: decode the read result of a single point.
+func (m *ModbusInstance) handlePoint(d *devicePoller, idx int, words []uint16, bits []bool) {
+	p := &d.points[idx]
+	c := d.codecs[idx]
+	if c == nil {
+		return
+	}
+
+	var v models.Scalar
+	var err error
+	if bits != nil {
+		v, err = c.DecodeBits(bits)
+	} else {
+		v, err = c.Decode(words)
+	}
+	if err != nil {
+		m.Status.PointsErrorRead = m.Status.PointsErrorRead + 1
+		m.logger.Warnf("device %s unit=%d point %s decode failed: %v", d.dev.Name, d.unitID, p.PointCode, err)
+		if m.env != nil && m.env.RTDB != nil {
+			m.env.RTDB.SetError(d.dev.ID, p.PointCode, err, time.Now())
+		}
+		return
+	}
+
+	// 打印调试信息 / log debug values.
+	m.logger.Debugf("device %s unit=%d point %s value=%s", d.dev.Name, d.unitID, p.PointCode, point.Format(v))
+
+	if m.env != nil && m.env.RTDB != nil {
+		// 超过 3 个轮询周期未刷新即视为 stale
+		// A value not refreshed within 3 poll periods is reported stale.
+		m.env.RTDB.Put(d.dev.ID, p.PointCode, v, pointQuality(p, v), time.Now(), 3*d.interval)
+	}
+}
+
+// pointQuality：按点位量程判断值的质量
+// pointQuality: judge the quality of a value against the point's range.
+func pointQuality(p *models.DeviceTypePoint, v models.Scalar) rtdb.Quality {
+	var f float64
+	switch v.Kind {
+	case models.KindInt64:
+		n, _ := v.Int64()
+		f = float64(n)
+	case models.KindUint64:
+		n, _ := v.Uint64()
+		f = float64(n)
+	case models.KindFloat64:
+		f, _ = v.Float64()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return rtdb.QualityOutOfRange
+		}
+	default:
+		return rtdb.QualityGood
+	}
+
+	if (p.RangeMin != nil && f < *p.RangeMin) || (p.RangeMax != nil && f > *p.RangeMax) {
+		return rtdb.QualityOutOfRange
+	}
+	return rtdb.QualityGood
+}
+
+// commandErrors：命令在发出请求前就被拒绝的错误（非法取值、只读点位等），
+// 以及回读不一致，都与链路无关
+// commandErrors: errors rejecting a command before any request is sent
+// (invalid values, read-only points, ...) and read-back mismatches; none of
+// them says anything about the link.
+var commandErrors = []error{
+	point.ErrShortData, point.ErrOutOfRange, point.ErrUnknownLabel, point.ErrNotWritable,
+	point.ErrInvalidBCD, point.ErrNeedsReadWrite,
+	ErrReadbackMismatch,
+	pluginapi.ErrReadOnlyPoint, pluginapi.ErrBroadcastUnsupported, pluginapi.ErrSnifferMode,
+}
+
+// isLinkError：判断错误是否意味着链路需要重连
+// modbus.Error（异常码、超时、CRC 等）只影响当前设备，命令校验错误不涉及总线，
+// 其他 I/O 错误视为链路故障。
+// isLinkError: report whether the error means the link must be reopened.
+// modbus.Error values (exceptions, timeouts, CRC...) only affect the current
+// device and command validation errors never touch the bus; any other I/O
+// error is treated as a link failure.
+func isLinkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var me modbus.Error
+	if errors.As(err, &me) {
+		return false
+	}
+	for _, e := range commandErrors {
+		if errors.Is(err, e) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package mbus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+// 一个轮询周期：合并块读取、块内空洞退化为逐点读取、按量程判断质量，结果写入实时库
+// One poll cycle: coalesced block reads, the one-by-one fallback for a block
+// with a hole, range-based quality, and the results in the real-time database.
+func TestPollCycle(t *testing.T) {
+	h := &testHandler{
+		regs:  map[uint16]uint16{0: 1, 1: 200, 10: 7, 12: 9},
+		holes: map[uint16]bool{11: true, 20: true},
+	}
+	startTestServer(t, "tcp://localhost:5517", h)
+
+	ch := testChannel(5517)
+	devices := []models.Device{{Name: "d", DeviceType: "t", SlaveID: 1, PollIntervalMs: 60000, ReadGap: 1}}
+	rangeMax := 100.0
+	env := newTestEnv(t, ch, devices, []models.DeviceTypePoint{
+		{TypeKey: "t", PointCode: "a", RW: "R", FC: 3, Address: 0, Quantity: 1, DataType: "uint16", Scale: 1},
+		{TypeKey: "t", PointCode: "b", RW: "R", FC: 3, Address: 1, Quantity: 1, DataType: "uint16", Scale: 1, RangeMax: &rangeMax},
+		{TypeKey: "t", PointCode: "c", RW: "R", FC: 3, Address: 10, Quantity: 1, DataType: "int16", Scale: 0.5},
+		{TypeKey: "t", PointCode: "d", RW: "R", FC: 3, Address: 12, Quantity: 1, DataType: "uint16", Scale: 1},
+		{TypeKey: "t", PointCode: "e", RW: "R", FC: 3, Address: 20, Quantity: 1, DataType: "uint16", Scale: 1},
+	})
+	id := devices[0].ID
+
+	startInstance(t, ch, env)
+
+	// e 位于最后一个块，其结果写入即表示本周期结束
+	// e is in the last block, so its entry marks the end of the cycle.
+	waitFor(t, "the first poll cycle", 5*time.Second, func() bool {
+		_, ok := env.RTDB.Get(id, "e")
+		return ok
+	})
+
+	h.mu.Lock()
+	reads := append([][2]uint16(nil), h.reads...)
+	h.mu.Unlock()
+	want := [][2]uint16{{0, 2}, {10, 3}, {10, 1}, {12, 1}, {20, 1}}
+	if len(reads) < len(want) || !reflect.DeepEqual(reads[:len(want)], want) {
+		t.Errorf("expected reads %v, got %v", want, reads)
+	}
+
+	var u1, u9, u200, f35 models.Scalar
+	u1.SetUint64(1)
+	u9.SetUint64(9)
+	u200.SetUint64(200)
+	f35.SetFloat64(3.5)
+
+	tests := []struct {
+		code    string
+		value   models.Scalar
+		quality rtdb.Quality
+	}{
+		{"a", u1, rtdb.QualityGood},
+		{"b", u200, rtdb.QualityOutOfRange},
+		{"c", f35, rtdb.QualityGood},
+		{"d", u9, rtdb.QualityGood},
+		{"e", models.Scalar{}, rtdb.QualityCommError},
+	}
+	for _, tt := range tests {
+		e, ok := env.RTDB.Get(id, tt.code)
+		if !ok {
+			t.Errorf("point %s: no entry", tt.code)
+			continue
+		}
+		if !e.Value.Equal(tt.value) || e.Quality != tt.quality {
+			t.Errorf("point %s: expected %s (%s), got %+v", tt.code, tt.value, tt.quality, e)
+		}
+	}
+}
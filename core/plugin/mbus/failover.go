@@ -0,0 +1,203 @@
+package mbus
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+const (
+	// 未配置 FailoverAfter 时切换前允许的连续失败次数
+	// consecutive failures tolerated before switching when FailoverAfter is unset
+	defaultFailoverAfter = 3
+)
+
+// 使用备用地址期间探测主地址的周期（测试中缩短）
+// how often the primary is probed while on the backup (shortened by tests)
+var failbackProbeInterval = 30 * time.Second
+
+// endpoint：通道的一个 TCP 端点
+// endpoint: one TCP endpoint of a channel.
+type endpoint struct {
+	name string // primary / backup
+	addr string // host:port
+	url  string
+}
+
+// buildEndpoints：按通道配置生成端点列表，主地址在前；串口通道只有一个端点
+// buildEndpoints: build the endpoint list from the channel, primary first; serial channels have one.
+func (m *ModbusInstance) buildEndpoints() []endpoint {
+	ch := &m.cfg.Model
+	if ch.PhysicalLink == "serial" {
+		return []endpoint{{name: "primary", addr: ch.Device, url: "rtu://" + ch.Device}}
+	}
+
+	primary := fmt.Sprintf("%s:%d", ch.TCPIPAddr, ch.TCPPort)
+	out := []endpoint{{name: "primary", addr: primary, url: "tcp://" + primary}}
+
+	if ch.BackupTCPIPAddr != "" && ch.BackupTCPPort != 0 {
+		backup := fmt.Sprintf("%s:%d", ch.BackupTCPIPAddr, ch.BackupTCPPort)
+		if backup != primary {
+			out = append(out, endpoint{name: "backup", addr: backup, url: "tcp://" + backup})
+		}
+	}
+	return out
+}
+
+// newClient：按通道串口与超时参数创建指向 url 的 client
+// newClient: create a client for url with the channel's serial and timeout settings.
+func (m *ModbusInstance) newClient(url string) (*modbus.ModbusClient, error) {
+	client, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:            url,
+		Timeout:        m.cfg.Model.Delay,
+		ConnectTimeout: m.cfg.Model.OnnectTimeout,
+		Speed:          m.cfg.Model.Speed,
+		DataBits:       m.cfg.Model.DataBits,
+		Parity:         m.cfg.Model.Parity,
+		StopBits:       m.cfg.Model.StopBits,
+		Tracer:         m.trace,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 寄存器按线上的大端顺序收发，字节序由点位编解码器按点位的 ByteOrder 处理
+	// Registers are passed big-endian, as they are on the wire; the point
+	// codec applies each point's ByteOrder.
+	client.SetEncoding(modbus.BIG_ENDIAN, modbus.HIGH_WORD_FIRST)
+	return client, nil
+}
+
+// silentRounds：所有（有点位的）设备都无应答的连续轮询轮数；
+// 只有个别设备不应答时为 0，链路本身正常
+// silentRounds: consecutive poll rounds in which no device (with points)
+// answered; 0 as long as any device answers, the link itself being fine.
+func (m *ModbusInstance) silentRounds() int {
+	n := -1
+	for _, d := range m.devices {
+		if len(d.blocks) == 0 {
+			continue
+		}
+		if n < 0 || d.failures < n {
+			n = d.failures
+		}
+	}
+	return max(n, 0)
+}
+
+// failoverPending：判断是否需要切换端点（当前端点连续出现链路级故障或所有设备都无应答，
+// 或主地址已恢复）
+// failoverPending: report whether to switch endpoints (the current one keeps
+// failing at the link level or no device answers at all, or the primary has
+// recovered).
+func (m *ModbusInstance) failoverPending() bool {
+	if len(m.endpoints) < 2 {
+		return false
+	}
+
+	limit := int(m.cfg.Model.FailoverAfter)
+	if limit <= 0 {
+		limit = defaultFailoverAfter
+	}
+	if m.linkFails >= limit || m.silentRounds() >= limit {
+		return true
+	}
+	return m.active.Load() != 0 && m.failback.Load()
+}
+
+// switchEndpoint：切换到另一个端点并重建 client，只在轮询协程中调用
+// switchEndpoint: move to the other endpoint and recreate the client; poller goroutine only.
+func (m *ModbusInstance) switchEndpoint() error {
+	next := (int(m.active.Load()) + 1) % len(m.endpoints)
+	ep := m.endpoints[next]
+
+	client, err := m.newClient(ep.url)
+	if err != nil {
+		return err
+	}
+
+	_ = m.client.Close()
+	m.client = client
+	m.active.Store(int32(next))
+	m.linkFails = 0
+	m.failback.Store(false)
+	m.Status.ActiveEndpoint = ep.addr
+
+	// 新端点上重新计算设备的无应答次数
+	// Devices start over on the new endpoint.
+	for _, d := range m.devices {
+		d.failures = 0
+	}
+
+	m.logger.Warnf("modbus channel switched to %s endpoint %s", ep.name, ep.addr)
+	return nil
+}
+
+// url：当前端点的 URL
+// url: URL of the active endpoint.
+func (m *ModbusInstance) url() string {
+	return m.endpoints[m.active.Load()].url
+}
+
+// probePrimary：使用备用地址期间定期经主地址读取一个设备，设备应答则请求切回
+// probePrimary: while on the backup, periodically read a device through the
+// primary and request a switch back once it answers.
+func (m *ModbusInstance) probePrimary() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(failbackProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if m.active.Load() == 0 || m.failback.Load() {
+			continue
+		}
+
+		// 探测在轮询协程中执行，与轮询共用设备列表
+		// The probe runs on the poller goroutine, which owns the device list.
+		if err := m.submit(m.ctx, m.probeOnce); err != nil {
+			m.logger.Debugf("modbus primary endpoint %s still down: %v", m.endpoints[0].addr, err)
+			continue
+		}
+
+		m.logger.Infof("modbus primary endpoint %s reachable again", m.endpoints[0].addr)
+		m.failback.Store(true)
+	}
+}
+
+// probeOnce：连接主地址并读取第一个有点位的设备的第一个块；
+// 仅能建立连接而设备不应答（如网关后端故障）不算恢复
+// probeOnce: connect to the primary and read the first block of the first
+// device with points. A connection alone, with no device answering (a
+// gateway whose serial side is down, say), does not count as recovered.
+func (m *ModbusInstance) probeOnce() error {
+	client, err := m.newClient(m.endpoints[0].url)
+	if err != nil {
+		return err
+	}
+	if err := client.Open(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, d := range m.devices {
+		if len(d.blocks) == 0 {
+			continue
+		}
+		if err := client.SetUnitId(d.unitID); err != nil {
+			return err
+		}
+		if _, _, err := readBlockOn(client, &d.blocks[0]); !isAnswer(err) {
+			return err
+		}
+		break
+	}
+	return nil
+}
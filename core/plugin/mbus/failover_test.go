@@ -0,0 +1,70 @@
+package mbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+// 单个从站不应答时不切换；主地址断开后切到备用地址，主地址上的设备恢复应答后切回
+// A single silent slave does not cause a switch; losing the primary moves the
+// channel to the backup, and it moves back once a device answers through the
+// primary again.
+func TestFailoverAndFailback(t *testing.T) {
+	defer func(d time.Duration) { failbackProbeInterval = d }(failbackProbeInterval)
+	failbackProbeInterval = 100 * time.Millisecond
+
+	primary := &testHandler{regs: map[uint16]uint16{}}
+	backup := &testHandler{regs: map[uint16]uint16{}}
+	server := startTestServer(t, "tcp://localhost:5515", primary)
+	startTestServer(t, "tcp://localhost:5516", backup)
+
+	ch := testChannel(5515)
+	ch.BackupTCPIPAddr, ch.BackupTCPPort = "localhost", 5516
+	ch.FailoverAfter = 2
+
+	env := newTestEnv(t, ch,
+		[]models.Device{
+			{Name: "d1", DeviceType: "t", SlaveID: 1, PollIntervalMs: 20},
+			{Name: "d2", DeviceType: "t", SlaveID: 2, PollIntervalMs: 20},
+		},
+		// 三个不相邻的点位，每次轮询读三个块
+		// three points apart from each other, read in three blocks per poll
+		[]models.DeviceTypePoint{
+			{TypeKey: "t", PointCode: "a", RW: "R", FC: 3, Address: 0, Quantity: 1, DataType: "uint16", Scale: 1},
+			{TypeKey: "t", PointCode: "b", RW: "R", FC: 3, Address: 4, Quantity: 1, DataType: "uint16", Scale: 1},
+			{TypeKey: "t", PointCode: "c", RW: "R", FC: 3, Address: 8, Quantity: 1, DataType: "uint16", Scale: 1},
+		},
+	)
+
+	primary.setSilent(2, true)
+	m := startInstance(t, ch, env)
+
+	// 只有 d2 不应答：链路正常，留在主地址
+	// Only d2 is silent: the link is fine and stays on the primary.
+	time.Sleep(time.Second)
+	if m.active.Load() != 0 || backup.requests() != 0 {
+		t.Fatalf("switched to the backup over a single silent slave")
+	}
+
+	// 主地址断开：切到备用地址
+	// The primary goes away: switch to the backup.
+	server.Stop()
+	waitFor(t, "the switch to the backup", 5*time.Second, func() bool { return m.active.Load() == 1 })
+	waitFor(t, "polls through the backup", 2*time.Second, func() bool { return backup.requests() > 0 })
+
+	// 主地址可连接但设备不应答：不切回
+	// The primary accepts connections but no device answers: stay on the backup.
+	primary.setSilent(1, true)
+	server = startTestServer(t, "tcp://localhost:5515", primary)
+	time.Sleep(500 * time.Millisecond)
+	if m.active.Load() != 1 {
+		t.Fatalf("switched back to a primary whose devices do not answer")
+	}
+
+	// 主地址上的设备恢复应答：切回
+	// A device answers through the primary again: switch back.
+	primary.setSilent(1, false)
+	waitFor(t, "the switch back to the primary", 5*time.Second, func() bool { return m.active.Load() == 0 })
+}
@@ -0,0 +1,35 @@
+package mbus
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+// Forward：实现 pluginapi.BusForwarder，由轮询协程在两次轮询之间执行转发请求
+// 请求不重试，由上游主站决定是否重发；寄存器按线上的大端顺序收发（见 newClient）。
+// Forward: implements pluginapi.BusForwarder; the poller goroutine runs the
+// forwarded request between polls. Requests are not retried (the upstream
+// master decides whether to resend) and registers are passed big-endian, as
+// they are on the wire (see newClient).
+func (m *ModbusInstance) Forward(ctx context.Context, slaveID uint8, fn func(client *modbus.ModbusClient) error) error {
+	err := m.submit(ctx, func() error {
+		if err := m.client.SetUnitId(slaveID); err != nil {
+			return err
+		}
+
+		return m.exec(false, func() error {
+			return fn(m.client)
+		})
+	})
+
+	// 实例停止、链路断开或处于监听模式：到目标的路径不可用
+	// Instance stopped, link down or in sniffer mode: the path to the
+	// target is unavailable.
+	if errors.Is(err, errStopped) || errors.Is(err, errLinkDown) || errors.Is(err, pluginapi.ErrSnifferMode) {
+		return modbus.ErrGWPathUnavailable
+	}
+	return err
+}
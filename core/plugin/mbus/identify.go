@@ -0,0 +1,51 @@
+package mbus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+// IdentifyDevice：实现 pluginapi.DeviceIdentifier，读取设备的基本标识对象
+// IdentifyDevice: implements pluginapi.DeviceIdentifier by reading the device's basic identification objects.
+func (m *ModbusInstance) IdentifyDevice(ctx context.Context, slaveID int) (id pluginapi.DeviceIdentity, err error) {
+	if slaveID < 1 || slaveID > 247 {
+		return id, fmt.Errorf("invalid slave id %d", slaveID)
+	}
+
+	id, err = submitValue(ctx, m, func(out *pluginapi.DeviceIdentity) error {
+		if err := m.client.SetUnitId(uint8(slaveID)); err != nil {
+			return err
+		}
+
+		var objects map[uint8]string
+		err := m.exec(true, func() (err error) {
+			objects, err = m.client.ReadDeviceIdentification(modbus.DEVICE_ID_BASIC)
+			return
+		})
+		if err != nil {
+			return err
+		}
+
+		out.Vendor = identString(objects[modbus.OBJ_VENDOR_NAME])
+		out.Product = identString(objects[modbus.OBJ_PRODUCT_CODE])
+		out.Revision = identString(objects[modbus.OBJ_MAJOR_MINOR_REVISION])
+		return nil
+	})
+
+	if m.logger != nil {
+		m.logger.Infof("identify unit=%d vendor=%q product=%q revision=%q err=%v",
+			slaveID, id.Vendor, id.Product, id.Revision, err)
+	}
+
+	return id, err
+}
+
+// identString：去掉设备为定长字段填充的空格与 NUL
+// identString: strip the spaces and NULs devices pad fixed-size fields with.
+func identString(s string) string {
+	return strings.Trim(s, " \x00")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fluxionwatt/gridbeat/internal/models"
@@ -21,8 +22,22 @@ type ModbusInstance struct {
 	cfg    InstanceConfig
 	Status models.ChannelStatus
 
-	logger logrus.FieldLogger // 实例级 logger / per-instance logger
-	client *modbus.ModbusClient
+	logger  logrus.FieldLogger // 实例级 logger / per-instance logger
+	client  *modbus.ModbusClient
+	sniffer *modbus.Sniffer // 监听模式下代替 client / replaces client in sniffer mode
+	devices []*devicePoller
+	cmds    chan *command // 需要独占总线的命令 / commands needing exclusive bus access
+	reload  chan struct{} // 设备列表变更通知 / device list change notifications
+	gen     uint64        // 设备列表版本 / device list generation
+
+	lastFrame time.Time // 上一帧请求完成时间 / when the previous request finished
+
+	endpoints []endpoint   // 主/备端点 / primary and backup endpoints
+	active    atomic.Int32 // 当前端点下标 / index of the active endpoint
+	failback  atomic.Bool  // 主地址已恢复，待切回 / primary recovered, switch back pending
+	linkFails int          // 当前端点连续链路级故障次数 / consecutive link-level failures on the active endpoint
+
+	trace *frameTrace // 调试窗口抓包，跨重启保留 / debug window capture, kept across restarts
 
 	parentCtx context.Context
 	ctx       context.Context
@@ -56,67 +71,81 @@ func (m *ModbusInstance) Init(parent context.Context, env *pluginapi.HostEnv) er
 	m.parentCtx = parent
 	m.env = env
 
-	// 默认配置 / default config
-	m.cfg.URL = "tcp://" + m.cfg.Model.TCPIPAddr + ":" + fmt.Sprintf("%d", m.cfg.Model.TCPPort)
-	if m.cfg.Model.PhysicalLink == "serial" {
-		m.cfg.URL = "rtu://" + m.cfg.Model.Device
-	}
-
-	if m.cfg.Quantity == 0 {
-		m.cfg.Quantity = 10
-	}
-	if m.cfg.RegType == "" {
-		m.cfg.RegType = "holding"
-	}
+	// 默认配置：URL 指向主地址，备用地址（如有）在 endpoints 中
+	// Default config: URL points at the primary; the backup (if any) is in endpoints.
+	m.endpoints = m.buildEndpoints()
+	m.cfg.URL = m.endpoints[0].url
+	m.active.Store(0)
+	m.failback.Store(false)
+	m.linkFails = 0
+	m.Status.ActiveEndpoint = m.endpoints[0].addr
+	m.trace.setWindow(m.cfg.Model)
 
 	// logger：优先用 HostEnv.Logger，否则新建一个
 	// logger: prefer HostEnv.Logger, otherwise create a new one.
 	if env != nil && env.Logger != nil {
 		m.logger = env.PluginLog.WithField("plugin", "mbus").WithField("instance", m.id)
 	}
+
+	// 加载本通道上的设备与点位
+	// Load the devices and points bound to this channel.
+	devices, err := m.loadDevices()
+	if err != nil {
+		return fmt.Errorf("modbus[%s]: %w", m.id, err)
+	}
+	m.devices = devices
+
 	// 实例级 ctx / instance-level ctx
 	m.ctx, m.cancel = context.WithCancel(parent)
+	m.cmds = make(chan *command)
+	m.reload = make(chan struct{}, 1)
+
+	// 监听模式：只读打开串口，不创建 client，从不向总线发送
+	// Sniffer mode: the serial port is opened read-only, no client is
+	// created and nothing is ever sent on the bus.
+	if m.cfg.Model.Sniffer {
+		sniffer, err := m.newSniffer()
+		if err != nil {
+			return fmt.Errorf("modbus[%s]: create sniffer failed: %w", m.id, err)
+		}
+		m.sniffer = sniffer
+
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.runSniffer()
+		}()
+
+		m.init = true
+		m.logger.Infof("modbus sniffer initialized, url=%s devices=%d", m.cfg.URL, len(m.devices))
+		return nil
+	}
 
 	// 创建 Modbus client（每次 Init 都基于当前 cfg 创建一个新 client）
 	// Create Modbus client based on current cfg.
-	client, err := modbus.NewClient(&modbus.ClientConfiguration{
-		URL:      m.cfg.URL,
-		Timeout:  m.cfg.Model.OnnectTimeout,
-		Speed:    m.cfg.Model.Speed,
-		DataBits: m.cfg.Model.DataBits,
-		Parity:   m.cfg.Model.Parity,
-		StopBits: m.cfg.Model.StopBits,
-	})
+	client, err := m.newClient(m.cfg.URL)
 	if err != nil {
 		return fmt.Errorf("modbus[%s]: create client failed: %w", m.id, err)
 	}
 	m.client = client
 
-	// by default, 16-bit integers are decoded as big-endian and 32/64-bit values as
-	// big-endian with the high word first.
-	// change the byte/word ordering of subsequent requests to little endian, with
-	// the low word first (note that the second argument only affects 32/64-bit values)
-	client.SetEncoding(modbus.Endianness(m.cfg.Model.Endianness), modbus.WordOrder(m.cfg.Model.Endianness))
-
-	// 设置 UnitID（如果配置了）
-	// Set unit ID (if configured).
-	if m.cfg.UnitID != 0 {
-		if err := m.client.SetUnitId(m.cfg.UnitID); err != nil {
-			m.logger.Warnf("set unit id=%d failed: %v", m.cfg.UnitID, err)
-		}
-	}
-
-	// 启动一个协程：负责自动 Open/Close + 周期读寄存器
-	// Start one goroutine: handles Open/Close + periodic register reads.
+	// 启动一个协程：负责自动 Open/Close + 按设备周期读点位
+	// Start one goroutine: handles Open/Close + per-device periodic point reads.
 	m.wg.Add(1)
 	go func(cfg *InstanceConfig) {
 		defer m.wg.Done()
 		m.runPoller(cfg)
 	}(&m.cfg)
 
+	// 配置了备用地址时，另起协程探测主地址以便切回
+	// With a backup configured, probe the primary in another goroutine to fail back.
+	if len(m.endpoints) > 1 {
+		m.wg.Add(1)
+		go m.probePrimary()
+	}
+
 	m.init = true
-	m.logger.Infof("modbus instance initialized, url=%s unit=%d start=%d quantity=%d regType=%s",
-		m.cfg.URL, m.cfg.UnitID, m.cfg.StartAddr, m.cfg.Quantity, m.cfg.RegType)
+	m.logger.Infof("modbus instance initialized, url=%s devices=%d", m.cfg.URL, len(m.devices))
 
 	return nil
 }
@@ -124,9 +153,10 @@ func (m *ModbusInstance) Init(parent context.Context, env *pluginapi.HostEnv) er
 // runPoller：内部轮询逻辑，在单独协程中运行
 // runPoller: internal polling loop, runs in a dedicated goroutine.
 func (m *ModbusInstance) runPoller(cfg *InstanceConfig) {
-	regType := parseRegType(cfg.RegType)
+	m.logger.Infof("modbus poller started, devices=%d", len(m.devices))
 
-	m.logger.Infof("modbus poller started, interval=%s", cfg.Model.RetryInterval)
+	// 连续重连失败次数 / consecutive failed reconnects
+	failures := 0
 
 	for {
 		// 如果上层 ctx 已取消，直接退出
@@ -141,63 +171,125 @@ func (m *ModbusInstance) runPoller(cfg *InstanceConfig) {
 		m.Status.Working = true
 		m.Status.Linking = false
 
+		// 当前端点连续失败或主地址恢复时切换端点
+		// Switch endpoints when the current one keeps failing or the primary is back.
+		if m.failoverPending() {
+			if err := m.switchEndpoint(); err != nil {
+				m.logger.Errorf("modbus switch endpoint failed: %v", err)
+			} else {
+				failures = 0
+			}
+		}
+
 		// 尝试建立连接 / try to open connection.
 		if err := m.client.Open(); err != nil {
-			m.logger.Errorf("modbus open %s failed: %v", m.cfg.URL, err)
-			if !sleepWithContext(m.ctx, 2*time.Second) {
+			m.logger.Errorf("modbus open %s failed: %v", m.url(), err)
+			m.linkFails++
+			failures++
+			if !m.waitOffline(m.reconnectDelay(failures - 1)) {
 				m.logger.Infof("modbus poller exit during reconnect wait")
 				return
 			}
 			continue
 		}
 
-		m.logger.Infof("modbus connected to %s", cfg.URL)
+		m.logger.Infof("modbus connected to %s", m.url())
+		failures = 0
 
 		m.Status.Linking = true
 
-		ticker := time.NewTicker(cfg.Model.RetryInterval)
-		connected := true
-
-		for connected {
-			select {
-			case <-m.ctx.Done():
-				// 上层取消：关闭连接并退出
-				// Parent canceled: close connection and exit.
-				ticker.Stop()
-				_ = m.client.Close()
-				m.logger.Infof("modbus poller exit on ctx done")
-				return
-			case <-ticker.C:
-				// 轮询读寄存器 / poll holding/input registers.
-				values, err := m.client.ReadRegisters(
-					cfg.StartAddr,
-					cfg.Quantity,
-					regType,
-				)
-				if err != nil {
-					m.logger.Errorf("modbus read %s failed addr=%d qty=%d: %v", cfg.URL,
-						cfg.StartAddr, cfg.Quantity, err)
-					// 读失败：关闭连接，跳出内层循环，外层循环负责重连
-					// On read failure: close and let outer loop retry.
-					ticker.Stop()
-					_ = m.client.Close()
-					if !sleepWithContext(m.ctx, 2*time.Second) {
-						m.logger.Infof("modbus poller exit during reconnect wait")
-						return
-					}
-					connected = false
-					break
-				}
-
-				m.Status.BytesReceived = m.Status.BytesReceived + 1
-				m.Status.BytesSent = m.Status.BytesSent + 1
-
-				// 打印调试信息 / log debug values.
-				m.logger.Debugf("modbus read ok addr=%d qty=%d values=%v",
-					cfg.StartAddr, cfg.Quantity, values)
+		if !m.pollDevices() {
+			// 上层取消：关闭连接并退出
+			// Parent canceled: close connection and exit.
+			_ = m.client.Close()
+			m.logger.Infof("modbus poller exit on ctx done")
+			return
+		}
+
+		// 链路错误：关闭连接，外层循环负责重连
+		// Link failure: close and let the outer loop reconnect.
+		_ = m.client.Close()
+		m.Status.Linking = false
+		if !m.waitOffline(m.reconnectDelay(0)) {
+			m.logger.Infof("modbus poller exit during reconnect wait")
+			return
+		}
+	}
+}
+
+// pollDevices：在已连接的链路上按各设备周期调度轮询
+// 返回 false 表示 ctx 已取消，返回 true 表示链路故障需要重连。
+// pollDevices: schedule per-device polls on an open link.
+// Returns false when ctx is done, true when the link failed and must be reopened.
+func (m *ModbusInstance) pollDevices() bool {
+	now := time.Now()
+	for _, d := range m.devices {
+		d.next = now
+	}
+
+	for {
+		d := m.nextDevice()
+		if d == nil {
+			// 没有设备可轮询，只处理命令
+			// Nothing to poll, only serve commands.
+			ok, err := m.waitUntil(time.Now().Add(time.Hour))
+			if !ok {
+				return false
 			}
+			if err != nil {
+				m.logger.Errorf("modbus link %s failed: %v", m.url(), err)
+				m.linkFails++
+				return true
+			}
+			continue
+		}
+
+		gen := m.gen
+		ok, err := m.waitUntil(d.next)
+		if !ok {
+			return false
+		}
+		if err != nil {
+			m.logger.Errorf("modbus link %s failed: %v", m.url(), err)
+			m.linkFails++
+			return true
+		}
+		if gen != m.gen {
+			// 设备列表已重新加载，重新挑选
+			// The device list was reloaded, pick again.
+			continue
+		}
+
+		err = m.pollDevice(d)
+
+		// 按设备周期排下一次；若已落后则立即排队，由最早到期者优先
+		// Schedule the next poll; if already behind, queue it now and let the earliest due win.
+		d.next = d.next.Add(m.pollDelay(d))
+		if now := time.Now(); d.next.Before(now) {
+			d.next = now
+		}
+
+		if err != nil {
+			m.logger.Errorf("modbus link %s failed on device %s: %v", m.url(), d.dev.Name, err)
+			m.linkFails++
+			return true
+		}
+		if m.failoverPending() {
+			return true
+		}
+	}
+}
+
+// nextDevice：返回下一个到期的设备
+// nextDevice: return the device that is due next.
+func (m *ModbusInstance) nextDevice() *devicePoller {
+	var next *devicePoller
+	for _, d := range m.devices {
+		if next == nil || d.next.Before(next.next) {
+			next = d
 		}
 	}
+	return next
 }
 
 // Close：停止轮询、关闭 client
@@ -223,6 +315,10 @@ func (m *ModbusInstance) Close() error {
 		_ = m.client.Close()
 		m.client = nil
 	}
+	if m.sniffer != nil {
+		_ = m.sniffer.Close()
+		m.sniffer = nil
+	}
 
 	m.ctx = nil
 	m.cancel = nil
@@ -235,7 +331,9 @@ func (m *ModbusInstance) Close() error {
 }
 
 func (m *ModbusInstance) Get() any {
-	return m.Status
+	st := m.Status
+	st.Tracing = m.trace.active()
+	return st
 }
 
 // UpdateConfig：支持运行时配置更新（包括 URL、端口等），必要时重启 Modbus 客户端
@@ -252,18 +350,11 @@ func (m *ModbusInstance) UpdateConfig(raw pluginapi.InstanceConfig) error {
 		}
 	}
 
-	// 填充默认值（防止被设置成 0） / fill defaults.
-
-	if newCfg.Quantity == 0 {
-		newCfg.Quantity = 10
-	}
-	if newCfg.RegType == "" {
-		newCfg.RegType = "holding"
-	}
-
-	// 判断是否需要重启（任意字段变化就重启，简单粗暴但安全）
-	// Decide if restart is needed (restart on any change: simple and safe).
-	needRestart := (newCfg != m.cfg)
+	// 判断是否需要重启（任意字段变化就重启，简单粗暴但安全；调试窗口直接生效）
+	// Decide if restart is needed (restart on any change: simple and safe;
+	// the debug window applies at once).
+	needRestart := restartKey(newCfg) != restartKey(m.cfg)
+	m.trace.setWindow(newCfg.Model)
 
 	// 更新内存中的配置 / update in-memory cfg.
 	m.cfg = newCfg
@@ -276,15 +367,13 @@ func (m *ModbusInstance) UpdateConfig(raw pluginapi.InstanceConfig) error {
 
 	if !needRestart {
 		if logger != nil {
-			logger.Infof("modbus config updated without restart: url=%s unit=%d start=%d qty=%d regType=%s",
-				m.cfg.URL, m.cfg.UnitID, m.cfg.StartAddr, m.cfg.Quantity, m.cfg.RegType)
+			logger.Infof("modbus config updated without restart: url=%s", m.cfg.URL)
 		}
 		return nil
 	}
 
 	if logger != nil {
-		logger.Infof("modbus config changed, restarting client: url=%s unit=%d start=%d qty=%d regType=%s",
-			m.cfg.URL, m.cfg.UnitID, m.cfg.StartAddr, m.cfg.Quantity, m.cfg.RegType)
+		logger.Infof("modbus config changed, restarting client: url=%s", m.cfg.URL)
 	}
 
 	// 1) 关闭当前实例（停止轮询 + 关闭 client）
@@ -332,9 +421,10 @@ func (f *ModbusFactory) New(id string, raw pluginapi.InstanceConfig) (pluginapi.
 	}
 
 	return &ModbusInstance{
-		id:  id,
-		typ: f.Type(),
-		cfg: cfg,
+		id:    id,
+		typ:   f.Type(),
+		cfg:   cfg,
+		trace: newFrameTrace(),
 	}, nil
 }
 
@@ -344,19 +434,6 @@ func init() {
 	pluginapi.RegisterFactory(&ModbusFactory{})
 }
 
-// parseRegType：把字符串映射到 modbus.RegType
-// parseRegType: map string to modbus.RegType.
-func parseRegType(s string) modbus.RegType {
-	switch s {
-	case "input", "input_register", "ir":
-		return modbus.INPUT_REGISTER
-	case "holding", "holding_register", "hr":
-		fallthrough
-	default:
-		return modbus.HOLDING_REGISTER
-	}
-}
-
 // sleepWithContext：带 ctx 的 sleep，返回是否正常 sleep 完成
 // sleepWithContext: sleep with ctx, returns whether it completed normally.
 func sleepWithContext(ctx context.Context, d time.Duration) bool {
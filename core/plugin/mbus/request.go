@@ -0,0 +1,102 @@
+package mbus
+
+import (
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+const (
+	// 降级设备的最长轮询间隔 / longest poll period of a downgraded device
+	maxDowngradeDelay = 5 * time.Minute
+	// 重连退避上限 / upper bound of the reconnect backoff
+	maxReconnectDelay = 30 * time.Second
+	// 未配置 RetryInterval 时的重连等待 / reconnect wait when RetryInterval is unset
+	defaultReconnectDelay = 2 * time.Second
+)
+
+// exec：在总线上执行一次请求
+// 请求前保证距上一帧至少 SendInterval；retry 为 true 时，可重试的错误按
+// RetryInterval 间隔最多重试 RetryMax 次。
+// exec: run one request on the bus.
+// The request waits until SendInterval has passed since the previous frame;
+// with retry set, retryable errors are retried up to RetryMax times,
+// RetryInterval apart.
+func (m *ModbusInstance) exec(retry bool, fn func() error) (err error) {
+	tries := uint64(1)
+	if retry {
+		tries += m.cfg.Model.RetryMax
+	}
+
+	for i := uint64(0); i < tries; i++ {
+		if i > 0 && !sleepWithContext(m.ctx, m.cfg.Model.RetryInterval) {
+			return errStopped
+		}
+
+		if gap := m.cfg.Model.SendInterval - time.Since(m.lastFrame); gap > 0 {
+			if !sleepWithContext(m.ctx, gap) {
+				return errStopped
+			}
+		}
+
+		err = fn()
+		m.lastFrame = time.Now()
+
+		if !isRetryable(err) {
+			return
+		}
+		m.logger.Debugf("modbus request failed (try %d/%d): %v", i+1, tries, err)
+	}
+	return
+}
+
+// reconnectDelay：第 n 次连续重连前的等待时间，从 RetryInterval 开始指数增长
+// reconnectDelay: wait before the n-th consecutive reconnect, growing exponentially from RetryInterval.
+func (m *ModbusInstance) reconnectDelay(n int) time.Duration {
+	d := m.cfg.Model.RetryInterval
+	if d <= 0 {
+		d = defaultReconnectDelay
+	}
+	for ; n > 0 && d < maxReconnectDelay; n-- {
+		d *= 2
+	}
+	return min(d, maxReconnectDelay)
+}
+
+// pollDelay：设备下一次轮询的间隔；降级模式下连续无应答的设备按指数退避
+// pollDelay: period until a device's next poll; in downgrade mode devices that
+// keep failing to answer back off exponentially.
+func (m *ModbusInstance) pollDelay(d *devicePoller) time.Duration {
+	delay := d.interval
+	if !m.cfg.Model.Downgrade {
+		return delay
+	}
+	for n := d.failures; n > 0 && delay < maxDowngradeDelay; n-- {
+		delay *= 2
+	}
+	return min(delay, maxDowngradeDelay)
+}
+
+// isRetryable：判断错误是否值得重发（超时、帧错误、设备忙）
+// isRetryable: report whether a request is worth resending (timeouts, framing errors, busy device).
+func isRetryable(err error) bool {
+	switch err {
+	case modbus.ErrRequestTimedOut, modbus.ErrBadCRC, modbus.ErrBadLRC, modbus.ErrShortFrame,
+		modbus.ErrProtocolError, modbus.ErrBadUnitId, modbus.ErrBadTransactionId,
+		modbus.ErrServerDeviceBusy:
+		return true
+	}
+	return false
+}
+
+// isAnswer：判断设备是否对请求作出了应答（成功或异常响应）
+// isAnswer: report whether the device answered the request (success or an exception response).
+func isAnswer(err error) bool {
+	switch err {
+	case nil, modbus.ErrIllegalFunction, modbus.ErrIllegalDataAddress,
+		modbus.ErrIllegalDataValue, modbus.ErrServerDeviceFailure,
+		modbus.ErrAcknowledge, modbus.ErrServerDeviceBusy, modbus.ErrMemoryParityError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package mbus
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+func TestPollDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		downgrade bool
+		interval  time.Duration
+		failures  int
+		want      time.Duration
+	}{
+		{"answering", true, time.Second, 0, time.Second},
+		{"no downgrade", false, time.Second, 5, time.Second},
+		{"one failure", true, time.Second, 1, 2 * time.Second},
+		{"three failures", true, time.Second, 3, 8 * time.Second},
+		{"capped", true, time.Second, 10, maxDowngradeDelay},
+		{"many failures", true, time.Second, 1000, maxDowngradeDelay},
+		{"interval above the cap", true, 10 * time.Minute, 1, maxDowngradeDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &ModbusInstance{cfg: InstanceConfig{Model: models.Channel{Downgrade: tt.downgrade}}}
+			if got := m.pollDelay(&devicePoller{interval: tt.interval, failures: tt.failures}); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{modbus.ErrRequestTimedOut, true},
+		{modbus.ErrBadCRC, true},
+		{modbus.ErrBadLRC, true},
+		{modbus.ErrShortFrame, true},
+		{modbus.ErrProtocolError, true},
+		{modbus.ErrBadUnitId, true},
+		{modbus.ErrBadTransactionId, true},
+		{modbus.ErrServerDeviceBusy, true},
+		{modbus.ErrIllegalFunction, false},
+		{modbus.ErrIllegalDataAddress, false},
+		{modbus.ErrIllegalDataValue, false},
+		{modbus.ErrServerDeviceFailure, false},
+		{io.EOF, false},
+		{errors.New("connection reset"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("isRetryable(%v): expected %v, got %v", tt.err, tt.want, got)
+		}
+	}
+}
+
+// 降级模式下不应答的设备按指数退避跳过，退避到期后恢复应答即回到正常周期，
+// 其他设备不受影响
+// In downgrade mode a silent device is skipped with an exponential backoff;
+// once the backoff expires and it answers again it is back on its normal
+// period, and the other devices keep theirs throughout.
+func TestDowngradeBackoff(t *testing.T) {
+	h := &testHandler{regs: map[uint16]uint16{0: 1}}
+	h.setSilent(2, true)
+	startTestServer(t, "tcp://localhost:5518", h)
+
+	ch := testChannel(5518)
+	ch.Downgrade = true
+	devices := []models.Device{
+		{Name: "d1", DeviceType: "t", SlaveID: 1, PollIntervalMs: 50},
+		{Name: "d2", DeviceType: "t", SlaveID: 2, PollIntervalMs: 50},
+	}
+	env := newTestEnv(t, ch, devices, []models.DeviceTypePoint{
+		{TypeKey: "t", PointCode: "a", RW: "R", FC: 3, Address: 0, Quantity: 1, DataType: "uint16", Scale: 1},
+	})
+
+	startInstance(t, ch, env)
+	time.Sleep(2 * time.Second)
+
+	// 每次无应答后间隔翻倍：50ms 周期下依次约为 100、200、400、800ms
+	// The gap doubles after every unanswered poll: about 100, 200, 400 and 800ms
+	// on a 50ms period.
+	silent := h.requestTimes(2)
+	if len(silent) < 4 || len(silent) > 6 {
+		t.Fatalf("expected 4 to 6 polls of the silent device, got %d", len(silent))
+	}
+	for i := 1; i < len(silent); i++ {
+		want := 50 * time.Millisecond << i
+		if gap := silent[i].Sub(silent[i-1]); gap < want*9/10 {
+			t.Errorf("poll #%d of the silent device after %s, expected at least %s", i, gap, want)
+		}
+	}
+	if n := len(h.requestTimes(1)); n < 20 {
+		t.Errorf("the answering device was polled only %d times", n)
+	}
+
+	// 恢复应答：下一次轮询仍须等待退避到期
+	// Answering again: the next poll still waits for the backoff to expire.
+	h.setSilent(2, false)
+	last := silent[len(silent)-1]
+	backoff := 50 * time.Millisecond << len(silent)
+	waitFor(t, "the device to recover", 2*backoff, func() bool {
+		e, ok := env.RTDB.Get(devices[1].ID, "a")
+		return ok && e.Quality == rtdb.QualityGood
+	})
+	times := h.requestTimes(2)
+	if gap := times[len(silent)].Sub(last); gap < backoff*9/10 {
+		t.Errorf("polled %s after the last failure, before the %s backoff expired", gap, backoff)
+	}
+
+	// 恢复后回到正常周期
+	// Back on the normal period after recovering.
+	n := len(times)
+	time.Sleep(500 * time.Millisecond)
+	if got := len(h.requestTimes(2)) - n; got < 5 {
+		t.Errorf("expected the recovered device on its normal period, got %d polls in 500ms", got)
+	}
+}
@@ -0,0 +1,183 @@
+package mbus
+
+import (
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+)
+
+// newSniffer：按通道串口参数创建监听器，应答超时沿用通道时延；
+// 与 newClient 一样按大端解出寄存器，字节序由点位编解码器处理
+// newSniffer: create a sniffer with the channel's serial settings; the
+// response timeout is the channel delay. As with newClient, registers are
+// decoded big-endian and the point codec applies the byte order.
+func (m *ModbusInstance) newSniffer() (*modbus.Sniffer, error) {
+	return modbus.NewSniffer(&modbus.SnifferConfiguration{
+		URL:        m.cfg.URL,
+		Speed:      m.cfg.Model.Speed,
+		DataBits:   m.cfg.Model.DataBits,
+		Parity:     m.cfg.Model.Parity,
+		StopBits:   m.cfg.Model.StopBits,
+		Timeout:    m.cfg.Model.Delay,
+		Endianness: modbus.BIG_ENDIAN,
+		Tracer:     m.trace,
+	})
+}
+
+// runSniffer：监听模式的主循环，在单独协程中运行；从不向总线发送
+// runSniffer: main loop of sniffer mode, runs in a dedicated goroutine;
+// nothing is ever sent on the bus.
+func (m *ModbusInstance) runSniffer() {
+	m.logger.Infof("modbus sniffer started, devices=%d", len(m.devices))
+
+	// 连续打开失败次数 / consecutive failed opens
+	failures := 0
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			m.logger.Infof("modbus sniffer exit: ctx=%v", m.ctx.Err())
+			return
+		default:
+		}
+
+		m.Status.Working = true
+		m.Status.Linking = false
+
+		if err := m.sniffer.Open(); err != nil {
+			m.logger.Errorf("modbus sniffer open %s failed: %v", m.url(), err)
+			failures++
+			if !m.waitOffline(m.reconnectDelay(failures - 1)) {
+				m.logger.Infof("modbus sniffer exit during reconnect wait")
+				return
+			}
+			continue
+		}
+
+		m.logger.Infof("modbus sniffer listening on %s", m.url())
+		failures = 0
+		m.Status.Linking = true
+
+		err := m.sniff()
+		_ = m.sniffer.Close()
+		m.Status.Linking = false
+		if err == nil {
+			m.logger.Infof("modbus sniffer exit on ctx done")
+			return
+		}
+
+		m.logger.Errorf("modbus sniffer link %s failed: %v", m.url(), err)
+		if !m.waitOffline(m.reconnectDelay(0)) {
+			m.logger.Infof("modbus sniffer exit during reconnect wait")
+			return
+		}
+	}
+}
+
+// sniff：在已打开的串口上逐个处理抓到的事务
+// 返回 nil 表示 ctx 已取消，否则为需要重新打开串口的错误。
+// sniff: handle the transactions seen on the open port one by one.
+// Returns nil when ctx is done, otherwise the error calling for a reopen.
+func (m *ModbusInstance) sniff() error {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return nil
+		case <-m.reload:
+			m.reloadDevices()
+		default:
+		}
+
+		// 总线空闲时 Next 按超时返回，以便检查 ctx
+		// Next returns on timeout while the bus is idle, so ctx gets checked.
+		tx, err := m.sniffer.Next()
+		if err == modbus.ErrRequestTimedOut {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		m.handleTransaction(tx)
+	}
+}
+
+// handleTransaction：按设备类型解码事务涉及的点位并写入实时库
+// 读请求取应答中的数据，写请求取请求中的数据（写失败时忽略）；
+// 广播写入应用到通道上的所有设备。
+// handleTransaction: decode the points a transaction covers against the
+// device types and feed them into the RTDB.
+// Reads take their data from the response, writes from the request (ignored
+// when the write failed); broadcast writes apply to every device of the channel.
+func (m *ModbusInstance) handleTransaction(tx *modbus.Transaction) {
+	var fc uint8 // 点位的读功能码 / read function code of the points
+	write := false
+	switch tx.FunctionCode {
+	case 1, 2, 3, 4:
+		fc = tx.FunctionCode
+	case 5, 15:
+		fc, write = 1, true
+	case 6, 16:
+		fc, write = 3, true
+	case 23:
+		fc = 3
+	default:
+		return
+	}
+
+	if tx.Response != nil {
+		m.Status.BytesReceived = m.Status.BytesReceived + 1
+	}
+	if write && tx.Err != nil {
+		return
+	}
+
+	b := point.Block{FC: fc, Address: tx.Addr, Quantity: tx.Quantity}
+	now := time.Now()
+	for _, d := range m.devices {
+		if d.unitID != tx.UnitId && !(write && tx.UnitId == 0) {
+			continue
+		}
+
+		for i := range d.points {
+			p := &d.points[i]
+			if point.ReadFunctionCode(p) != fc {
+				continue
+			}
+			if !covers(&b, p) {
+				continue
+			}
+
+			m.Status.PointsToalRead = m.Status.PointsToalRead + 1
+
+			// 主站读取失败（无应答或异常）时标记点位错误
+			// Mark the point failed when the master's read went wrong
+			// (no response or an exception).
+			if tx.Err != nil {
+				m.Status.PointsErrorRead = m.Status.PointsErrorRead + 1
+				if m.env != nil && m.env.RTDB != nil {
+					m.env.RTDB.SetError(d.dev.ID, p.PointCode, tx.Err, now)
+				}
+				continue
+			}
+
+			if fc == 1 || fc == 2 {
+				bits := point.Extract(&b, tx.Coils, p)
+				if bits != nil {
+					m.handlePoint(d, i, nil, bits)
+				}
+			} else if words := point.Extract(&b, tx.Registers, p); words != nil {
+				m.handlePoint(d, i, words, nil)
+			}
+		}
+	}
+}
+
+// covers：点位是否完全落在块的地址范围内
+// covers: whether the point lies entirely within the block's address range.
+func covers(b *point.Block, p *models.DeviceTypePoint) bool {
+	end := uint32(p.Address) + uint32(point.Quantity(p))
+	return p.Address >= b.Address && end <= uint32(b.Address)+uint32(b.Quantity)
+}
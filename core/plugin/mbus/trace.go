@@ -0,0 +1,67 @@
+package mbus
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+const (
+	// 抓包环形缓冲区容量（帧数）/ capacity of the trace ring buffer, in frames
+	traceFrames = 2000
+)
+
+// frameTrace：通道调试窗口内抓取原始报文，实现 modbus.FrameTracer
+// 窗口由通道的 DebugLog/DebugExpiry 决定，过了 DebugExpiry 自动停止抓取。
+// frameTrace: captures raw frames while the channel's debug window is open,
+// implementing modbus.FrameTracer. The window comes from the channel's
+// DebugLog/DebugExpiry and closes by itself once DebugExpiry passes.
+type frameTrace struct {
+	ring  *modbus.FrameRing
+	until atomic.Int64 // 窗口截止时间（UnixNano），0 表示关闭 / window end (UnixNano), 0 when closed
+}
+
+func newFrameTrace() *frameTrace {
+	return &frameTrace{ring: modbus.NewFrameRing(traceFrames)}
+}
+
+// TraceFrame：窗口开启时记录一帧 / TraceFrame: record a frame while the window is open.
+func (t *frameTrace) TraceFrame(format modbus.FrameFormat, dir modbus.FrameDirection, adu []byte) {
+	if t.active() {
+		t.ring.TraceFrame(format, dir, adu)
+	}
+}
+
+// setWindow：按通道配置开启或关闭调试窗口
+// setWindow: open or close the debug window from the channel config.
+func (t *frameTrace) setWindow(ch models.Channel) {
+	if ch.DebugLog && time.Now().Before(ch.DebugExpiry) {
+		t.until.Store(ch.DebugExpiry.UnixNano())
+		return
+	}
+	t.until.Store(0)
+}
+
+// active：调试窗口是否开启 / active: whether the debug window is open.
+func (t *frameTrace) active() bool {
+	until := t.until.Load()
+	return until != 0 && time.Now().UnixNano() < until
+}
+
+// Trace：返回抓取到的报文（旧的在前），供 API 导出
+// Trace: returns the captured frames, oldest first, for the API to export.
+func (m *ModbusInstance) Trace() []modbus.Frame {
+	return m.trace.ring.Frames()
+}
+
+// restartKey：去掉不需要重启实例的字段（调试窗口、更新时间），用于比较配置
+// restartKey: the config without fields that need no restart (debug window,
+// update time), for comparing configs.
+func restartKey(c InstanceConfig) InstanceConfig {
+	c.Model.DebugLog = false
+	c.Model.DebugExpiry = time.Time{}
+	c.Model.UpdatedAt = time.Time{}
+	return c
+}
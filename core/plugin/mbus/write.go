@@ -0,0 +1,206 @@
+package mbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+)
+
+var ErrReadbackMismatch = errors.New("read-back value does not match the written value")
+
+// WritePoint：实现 pluginapi.PointWriter，把工程值反向缩放后写入设备
+// WritePoint: implements pluginapi.PointWriter; reverses scaling and writes the value to the device.
+func (m *ModbusInstance) WritePoint(ctx context.Context, req pluginapi.WriteRequest) (res pluginapi.WriteResult, err error) {
+	p := &req.Point
+
+	fc := point.ReadFunctionCode(p)
+	if p.RW == "R" || fc == 2 || fc == 4 {
+		return res, pluginapi.ErrReadOnlyPoint
+	}
+	if req.Device.SlaveID < 1 || req.Device.SlaveID > 247 {
+		return res, fmt.Errorf("invalid slave id %d", req.Device.SlaveID)
+	}
+
+	codec, err := point.NewCodec(p)
+	if err != nil {
+		return res, err
+	}
+
+	out, err := submitValue(ctx, m, func(out *pluginapi.WriteResult) error {
+		if err := m.client.SetUnitId(uint8(req.Device.SlaveID)); err != nil {
+			return err
+		}
+
+		// 可读点位先读出旧值；寄存器位点位必须读出当前寄存器做读-改-写
+		// Read the old value of readable points; bit-in-register points need
+		// the current register for read-modify-write.
+		var cur []uint16
+		if p.RW != "W" || codec.IsBitPoint() {
+			old, words, err := m.readValue(p, codec)
+			if err != nil {
+				return err
+			}
+			out.Old, cur = old, words
+		} else if e, ok := m.cachedValue(req.Device.ID, p.PointCode); ok {
+			out.Old = e
+		}
+
+		expected, err := m.writeValue(p, codec, req.Value, cur)
+		if err != nil {
+			return err
+		}
+		out.New = expected
+
+		if !req.Readback {
+			return nil
+		}
+
+		got, _, err := m.readValue(p, codec)
+		if err != nil {
+			return err
+		}
+		out.New = got
+		out.Verified = got.Equal(expected)
+		if !out.Verified {
+			return ErrReadbackMismatch
+		}
+		return nil
+	})
+	if err == nil || errors.Is(err, ErrReadbackMismatch) {
+		res = out
+	}
+
+	if err == nil && m.env != nil && m.env.RTDB != nil {
+		m.env.RTDB.Put(req.Device.ID, p.PointCode, res.New, pointQuality(p, res.New), time.Now(), 0)
+	}
+
+	if m.logger != nil {
+		m.logger.Infof("write device %s unit=%d point %s value=%s result=%s err=%v",
+			req.Device.Name, req.Device.SlaveID, p.PointCode, point.Format(req.Value), point.Format(res.New), err)
+	}
+
+	return res, err
+}
+
+// readValue：读取并解码单个点位，同时返回原始寄存器
+// readValue: read and decode a single point, also returning the raw registers.
+func (m *ModbusInstance) readValue(p *models.DeviceTypePoint, codec *point.Codec) (v models.Scalar, words []uint16, err error) {
+	b := point.Block{FC: point.ReadFunctionCode(p), Address: p.Address, Quantity: point.Quantity(p)}
+
+	words, bits, err := m.readBlock(&b)
+	if err != nil {
+		return
+	}
+	if bits != nil {
+		v, err = codec.DecodeBits(bits)
+	} else {
+		v, err = codec.Decode(words)
+	}
+	return
+}
+
+// writeValue：编码并写入单个点位，返回按写入数据解码出的期望值
+// writeValue: encode and write a single point, returning the value the written data decodes to.
+func (m *ModbusInstance) writeValue(p *models.DeviceTypePoint, codec *point.Codec, v models.Scalar, cur []uint16) (expected models.Scalar, err error) {
+	switch point.ReadFunctionCode(p) {
+	case 1:
+		var on bool
+		if on, err = codec.EncodeBit(v); err != nil {
+			return
+		}
+		// 写请求不自动重试，避免重复执行控制命令
+		// Writes are not retried so a control command never runs twice.
+		err = m.exec(false, func() error {
+			if p.FC == 15 {
+				return m.client.WriteCoils(p.Address, []bool{on})
+			}
+			return m.client.WriteCoil(p.Address, on)
+		})
+		if err != nil {
+			return
+		}
+		return codec.DecodeBits([]bool{on})
+
+	case 3:
+		var words []uint16
+		if codec.IsBitPoint() {
+			var on bool
+			if on, err = codec.EncodeBit(v); err != nil {
+				return
+			}
+
+			// 优先用掩码写只改一位，设备不支持时退回读-改-写
+			// Prefer mask write to flip just the bit; fall back to
+			// read-modify-write when the device doesn't support it.
+			if expected, err = m.maskWriteBit(p, codec, on, cur); err != modbus.ErrIllegalFunction {
+				return
+			}
+			if words, err = codec.ApplyBit(cur, on); err != nil {
+				return
+			}
+		} else if words, err = codec.Encode(v); err != nil {
+			return
+		}
+
+		err = m.exec(false, func() error {
+			if len(words) == 1 && p.FC != 16 {
+				return m.client.WriteRegister(p.Address, words[0])
+			}
+			return m.client.WriteRegisters(p.Address, words)
+		})
+		if err != nil {
+			return
+		}
+		return codec.Decode(words)
+	}
+
+	return expected, pluginapi.ErrReadOnlyPoint
+}
+
+// maskWriteBit：用掩码写（0x16）设置或清除寄存器位点位，返回期望值
+// maskWriteBit: set or clear a bit-in-register point with mask write (0x16), returning the expected value.
+func (m *ModbusInstance) maskWriteBit(p *models.DeviceTypePoint, codec *point.Codec, on bool, cur []uint16) (expected models.Scalar, err error) {
+	off, mask, err := codec.BitMask()
+	if err != nil {
+		return
+	}
+
+	var or uint16
+	if on {
+		or = mask
+	}
+	if err = m.exec(false, func() error {
+		return m.client.MaskWriteRegister(p.Address+uint16(off), ^mask, or)
+	}); err != nil {
+		return
+	}
+
+	words, err := codec.ApplyBit(cur, on)
+	if err != nil {
+		return
+	}
+	return codec.Decode(words)
+}
+
+// cachedValue：从实时库取点位的最近值
+// cachedValue: fetch the latest value of a point from the real-time database.
+func (m *ModbusInstance) cachedValue(device, code string) (models.Scalar, bool) {
+	if m.env == nil || m.env.RTDB == nil {
+		return models.Scalar{}, false
+	}
+	e, ok := m.env.RTDB.Get(device, code)
+	if !ok || e.Quality == rtdb.QualityCommError {
+		return models.Scalar{}, false
+	}
+	return e.Value, true
+}
+
+// 编译期检查 / compile-time check
+var _ pluginapi.PointWriter = (*ModbusInstance)(nil)
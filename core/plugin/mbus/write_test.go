@@ -0,0 +1,261 @@
+package mbus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testHandler：记录保持寄存器请求的最小从站，silent 中的站号不应答，
+// 覆盖 holes 中地址的请求以非法地址拒绝
+// testHandler: minimal slave recording holding register requests; unit ids in
+// silent are left unanswered, and requests covering an address in holes are
+// rejected with an illegal data address.
+type testHandler struct {
+	mu      sync.Mutex
+	regs    map[uint16]uint16
+	holes   map[uint16]bool
+	clients []string
+	reads   [][2]uint16           // 收到的 {地址, 数量} / {address, quantity} received
+	times   map[uint8][]time.Time // 各站号的请求时间，含不应答的 / request times per unit id, silent ones included
+	silent  map[uint8]bool
+}
+
+// setSilent：设置站号是否应答 / setSilent: set whether a unit id answers.
+func (h *testHandler) setSilent(unitId uint8, silent bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.silent == nil {
+		h.silent = map[uint8]bool{}
+	}
+	h.silent[unitId] = silent
+}
+
+// requestTimes：站号收到请求的时间 / requestTimes: when a unit id received requests.
+func (h *testHandler) requestTimes(unitId uint8) []time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]time.Time(nil), h.times[unitId]...)
+}
+
+// requests：已处理的保持寄存器请求数 / requests: holding register requests served.
+func (h *testHandler) requests() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.clients)
+}
+
+func (h *testHandler) HandleCoils(*modbus.CoilsRequest) ([]bool, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (h *testHandler) HandleDiscreteInputs(*modbus.DiscreteInputsRequest) ([]bool, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (h *testHandler) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) ([]uint16, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.times == nil {
+		h.times = map[uint8][]time.Time{}
+	}
+	h.times[req.UnitId] = append(h.times[req.UnitId], time.Now())
+	if h.silent[req.UnitId] {
+		return nil, modbus.ErrNoResponse
+	}
+	h.reads = append(h.reads, [2]uint16{req.Addr, req.Quantity})
+	for a := req.Addr; a < req.Addr+req.Quantity; a++ {
+		if h.holes[a] {
+			return nil, modbus.ErrIllegalDataAddress
+		}
+	}
+	h.clients = append(h.clients, req.ClientAddr)
+	res := make([]uint16, req.Quantity)
+	for i := range res {
+		a := req.Addr + uint16(i)
+		if req.IsWrite {
+			h.regs[a] = req.Args[i]
+		}
+		res[i] = h.regs[a]
+	}
+	return res, nil
+}
+
+func (h *testHandler) HandleInputRegisters(*modbus.InputRegistersRequest) ([]uint16, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (h *testHandler) HandleMaskWriteRegister(*modbus.MaskWriteRegisterRequest) error {
+	return modbus.ErrIllegalFunction
+}
+
+func (h *testHandler) HandleReadWriteRegisters(*modbus.ReadWriteRegistersRequest) ([]uint16, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (h *testHandler) HandleFIFOQueue(*modbus.FIFOQueueRequest) ([]uint16, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (h *testHandler) HandleFileRecords(*modbus.FileRecordRequest) ([][]uint16, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (h *testHandler) HandleDeviceIdentification(*modbus.DeviceIdentificationRequest) (map[uint8]string, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+// startTestServer：在 url 上启动 h 的 Modbus 服务端，测试结束时停止
+// startTestServer: start a Modbus server for h on url, stopped when the test ends.
+func startTestServer(t *testing.T, url string, h modbus.RequestHandler) *modbus.ModbusServer {
+	server, err := modbus.NewServer(&modbus.ServerConfiguration{URL: url, MaxClients: 4}, h)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	if err = server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server
+}
+
+// testChannel：指向 localhost:port 的 TCP 通道，超时短、不重试、无发送间隔
+// testChannel: TCP channel to localhost:port with a short timeout, no retries
+// and no send interval.
+func testChannel(port uint16) models.Channel {
+	ch := *models.GetDefaultSerialRow("", "")
+	ch.PhysicalLink = "tcp"
+	ch.TCPIPAddr, ch.TCPPort = "localhost", port
+	ch.BackupTCPIPAddr, ch.BackupTCPPort = "", 0
+	ch.Delay = 100 * time.Millisecond
+	ch.RetryMax = 0
+	ch.RetryInterval = 50 * time.Millisecond
+	ch.SendInterval = 0
+	return ch
+}
+
+// newTestEnv：基于临时 SQLite 库创建宿主环境，并写入通道、设备与点位
+// newTestEnv: build a host environment on a temporary SQLite database holding
+// the channel, its devices and their points.
+func newTestEnv(t *testing.T, ch models.Channel, devices []models.Device, points []models.DeviceTypePoint) *pluginapi.HostEnv {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err = models.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	if err = db.Create(&ch).Error; err != nil {
+		t.Fatalf("failed to create channel: %v", err)
+	}
+	for i := range devices {
+		devices[i].ChannelID = ch.UUID
+		if err = db.Create(&devices[i]).Error; err != nil {
+			t.Fatalf("failed to create device: %v", err)
+		}
+	}
+	for i := range points {
+		points[i].ID = uuid.New().String()
+		points[i].Enabled = true
+		if err = db.Create(&points[i]).Error; err != nil {
+			t.Fatalf("failed to create point: %v", err)
+		}
+	}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return &pluginapi.HostEnv{DB: db, Logger: &pluginapi.ReopenLogger{}, PluginLog: log, RTDB: rtdb.New()}
+}
+
+// startInstance：用通道与宿主环境创建并启动实例，测试结束时关闭
+// startInstance: create and start an instance for the channel, closed when the test ends.
+func startInstance(t *testing.T, ch models.Channel, env *pluginapi.HostEnv) *ModbusInstance {
+	in, err := (&ModbusFactory{}).New("test", InstanceConfig{Model: ch})
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	m := in.(*ModbusInstance)
+	if err = m.Init(context.Background(), env); err != nil {
+		t.Fatalf("failed to init instance: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// waitFor：轮询 cond 直到成立，超时则测试失败
+// waitFor: poll cond until it holds, failing the test on timeout.
+func waitFor(t *testing.T, what string, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 非法取值的写命令被拒绝，但不应导致轮询协程断开重连
+// A write with an invalid value is rejected without the poller reconnecting.
+func TestWriteRejectedValueKeepsLink(t *testing.T) {
+	h := &testHandler{regs: map[uint16]uint16{}}
+	startTestServer(t, "tcp://localhost:5514", h)
+
+	m := startInstance(t, testChannel(5514), &pluginapi.HostEnv{Logger: &pluginapi.ReopenLogger{}, PluginLog: logrus.New()})
+
+	p := models.DeviceTypePoint{PointCode: "setpoint", RW: "RW", FC: 3, Address: 10, Quantity: 1, DataType: "int16", Scale: 1}
+	write := func(v int64) error {
+		var s models.Scalar
+		s.SetInt64(v)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := m.WritePoint(ctx, pluginapi.WriteRequest{Device: models.Device{Name: "dev", SlaveID: 1}, Point: p, Value: s})
+		return err
+	}
+
+	if err := write(1); err != nil {
+		t.Fatalf("write should have succeeded, got: %v", err)
+	}
+	if err := write(100000); !errors.Is(err, point.ErrOutOfRange) {
+		t.Fatalf("expected %v, got: %v", point.ErrOutOfRange, err)
+	}
+	if err := write(2); err != nil {
+		t.Fatalf("write should have succeeded, got: %v", err)
+	}
+
+	// 所有请求来自同一连接 / every request came over the same connection
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.clients {
+		if c != h.clients[0] {
+			t.Errorf("link was reopened: requests from %v", h.clients)
+			break
+		}
+	}
+
+	// 通道默认的小端设置不影响点位的字节序 / the channel's little-endian
+	// default does not affect the point's byte order
+	if h.regs[10] != 2 {
+		t.Errorf("expected register 10 to hold 2, got %d", h.regs[10])
+	}
+}
@@ -0,0 +1,167 @@
+// Package rtdb 是进程内的实时点位库：南向插件写入，API/MQTT/告警读取。
+// Package rtdb is the in-process real-time point database: south plugins
+// write into it, the API, MQTT and alarm code read from it.
+package rtdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+// Quality：点位值的质量标志
+// Quality: quality flag of a point value.
+type Quality string
+
+const (
+	QualityGood       Quality = "good"         // 最近一次读取成功 / last read succeeded
+	QualityStale      Quality = "stale"        // 超过有效期未刷新 / not refreshed within its TTL
+	QualityCommError  Quality = "comm_error"   // 最近一次读取失败 / last read failed
+	QualityOutOfRange Quality = "out_of_range" // 值超出点位量程 / value outside the point's range
+)
+
+// Entry：单个点位的当前状态
+// Entry: current state of a single point.
+type Entry struct {
+	DeviceID  string        `json:"device_id"`
+	PointCode string        `json:"point_code"`
+	Value     models.Scalar `json:"value"`
+	Quality   Quality       `json:"quality"`
+	Timestamp time.Time     `json:"timestamp"`            // 值的采集时间 / source timestamp of the value
+	LastError string        `json:"last_error,omitempty"` // 最近一次错误 / last error
+
+	// TTL 为 0 表示永不过期
+	// A zero TTL never goes stale.
+	TTL time.Duration `json:"-"`
+}
+
+// DB：按设备 + 点位编码索引的实时库
+// DB: real-time database keyed by device + point code.
+type DB struct {
+	mu      sync.RWMutex
+	devices map[string]map[string]*Entry
+}
+
+// New：创建空的实时库
+// New: create an empty real-time database.
+func New() *DB {
+	return &DB{devices: make(map[string]map[string]*Entry)}
+}
+
+// entry：返回（必要时创建）点位条目，调用方持有写锁
+// entry: return (creating if needed) a point entry; caller holds the write lock.
+func (db *DB) entry(device, code string) *Entry {
+	points, ok := db.devices[device]
+	if !ok {
+		points = make(map[string]*Entry)
+		db.devices[device] = points
+	}
+	e, ok := points[code]
+	if !ok {
+		e = &Entry{DeviceID: device, PointCode: code}
+		points[code] = e
+	}
+	return e
+}
+
+// Put：写入一次成功读取的值，并清除上次错误
+// Put: store a successfully read value and clear the last error.
+func (db *DB) Put(device, code string, v models.Scalar, q Quality, ts time.Time, ttl time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	e := db.entry(device, code)
+	e.Value = v
+	e.Quality = q
+	e.Timestamp = ts
+	e.TTL = ttl
+	e.LastError = ""
+}
+
+// SetError：记录一次读取失败，保留上次的值
+// SetError: record a failed read, keeping the previous value.
+func (db *DB) SetError(device, code string, err error, ts time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	e := db.entry(device, code)
+	e.Quality = QualityCommError
+	if err != nil {
+		e.LastError = err.Error()
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = ts
+	}
+}
+
+// Get：读取单个点位
+// Get: read a single point.
+func (db *DB) Get(device, code string) (Entry, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	e, ok := db.devices[device][code]
+	if !ok {
+		return Entry{}, false
+	}
+	return e.view(time.Now()), true
+}
+
+// Device：读取设备的全部点位，按点位编码排序
+// Device: read every point of a device, sorted by point code.
+func (db *DB) Device(device string) []Entry {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now()
+	points := db.devices[device]
+	out := make([]Entry, 0, len(points))
+	for _, e := range points {
+		out = append(out, e.view(now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PointCode < out[j].PointCode })
+	return out
+}
+
+// Snapshot：在同一把锁下读取全部点位，保证各调用方看到一致的快照
+// Snapshot: read every point under one lock so all readers get a consistent snapshot.
+func (db *DB) Snapshot() []Entry {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now()
+	var out []Entry
+	for _, points := range db.devices {
+		for _, e := range points {
+			out = append(out, e.view(now))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].DeviceID != out[j].DeviceID {
+			return out[i].DeviceID < out[j].DeviceID
+		}
+		return out[i].PointCode < out[j].PointCode
+	})
+	return out
+}
+
+// DeleteDevice：删除设备的全部点位（设备删除或停用时调用）
+// DeleteDevice: drop every point of a device (on device removal or disable).
+func (db *DB) DeleteDevice(device string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.devices, device)
+}
+
+// view：返回条目副本，超过 TTL 的好值降级为 stale
+// view: return a copy of the entry, degrading good values past their TTL to stale.
+func (e *Entry) view(now time.Time) Entry {
+	out := *e
+	if out.Quality == QualityGood && out.TTL > 0 && now.Sub(out.Timestamp) > out.TTL {
+		out.Quality = QualityStale
+	}
+	return out
+}
@@ -0,0 +1,174 @@
+package rtdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+func scalar(v int64) models.Scalar {
+	var s models.Scalar
+	s.SetInt64(v)
+	return s
+}
+
+func TestPutAndSetError(t *testing.T) {
+	t0 := time.Now()
+	t1 := t0.Add(time.Second)
+
+	tests := []struct {
+		name    string
+		apply   func(db *DB)
+		value   models.Scalar
+		quality Quality
+		ts      time.Time
+		lastErr string
+	}{
+		{
+			name:    "put",
+			apply:   func(db *DB) { db.Put("dev", "p", scalar(1), QualityGood, t0, 0) },
+			value:   scalar(1),
+			quality: QualityGood,
+			ts:      t0,
+		},
+		{
+			name: "put overwrites",
+			apply: func(db *DB) {
+				db.Put("dev", "p", scalar(1), QualityGood, t0, 0)
+				db.Put("dev", "p", scalar(2), QualityOutOfRange, t1, 0)
+			},
+			value:   scalar(2),
+			quality: QualityOutOfRange,
+			ts:      t1,
+		},
+		{
+			name:    "error without value",
+			apply:   func(db *DB) { db.SetError("dev", "p", errors.New("timeout"), t0) },
+			quality: QualityCommError,
+			ts:      t0,
+			lastErr: "timeout",
+		},
+		{
+			name: "error keeps value and timestamp",
+			apply: func(db *DB) {
+				db.Put("dev", "p", scalar(1), QualityGood, t0, 0)
+				db.SetError("dev", "p", errors.New("timeout"), t1)
+			},
+			value:   scalar(1),
+			quality: QualityCommError,
+			ts:      t0,
+			lastErr: "timeout",
+		},
+		{
+			name: "put clears error",
+			apply: func(db *DB) {
+				db.SetError("dev", "p", errors.New("timeout"), t0)
+				db.Put("dev", "p", scalar(3), QualityGood, t1, 0)
+			},
+			value:   scalar(3),
+			quality: QualityGood,
+			ts:      t1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := New()
+			tt.apply(db)
+
+			e, ok := db.Get("dev", "p")
+			if !ok {
+				t.Fatalf("point not found")
+			}
+			if !e.Value.Equal(tt.value) || e.Quality != tt.quality || !e.Timestamp.Equal(tt.ts) || e.LastError != tt.lastErr {
+				t.Errorf("unexpected entry: %+v", e)
+			}
+		})
+	}
+
+	if _, ok := New().Get("dev", "p"); ok {
+		t.Errorf("expected no entry in an empty database")
+	}
+}
+
+func TestStaleExpiry(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		quality Quality
+		age     time.Duration
+		ttl     time.Duration
+		want    Quality
+	}{
+		{"fresh", QualityGood, time.Second, time.Minute, QualityGood},
+		{"expired", QualityGood, time.Minute, time.Second, QualityStale},
+		{"no ttl", QualityGood, time.Hour, 0, QualityGood},
+		{"bad values stay bad", QualityOutOfRange, time.Minute, time.Second, QualityOutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := New()
+			db.Put("dev", "p", scalar(1), tt.quality, now.Add(-tt.age), tt.ttl)
+
+			e, _ := db.Get("dev", "p")
+			if e.Quality != tt.want {
+				t.Errorf("Get: expected %s, got %s", tt.want, e.Quality)
+			}
+			if got := db.Snapshot()[0].Quality; got != tt.want {
+				t.Errorf("Snapshot: expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	// 降级只影响读出的副本 / degrading only affects the copy read out
+	db := New()
+	db.Put("dev", "p", scalar(1), QualityGood, now.Add(-time.Minute), time.Second)
+	db.Get("dev", "p")
+	if q := db.devices["dev"]["p"].Quality; q != QualityGood {
+		t.Errorf("stored quality changed to %s", q)
+	}
+}
+
+func TestSnapshotCopies(t *testing.T) {
+	now := time.Now()
+	db := New()
+	db.Put("b", "y", scalar(4), QualityGood, now, 0)
+	db.Put("a", "y", scalar(2), QualityGood, now, 0)
+	db.Put("a", "x", scalar(1), QualityGood, now, 0)
+	db.Put("b", "x", scalar(3), QualityGood, now, 0)
+
+	snap := db.Snapshot()
+	if len(snap) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(snap))
+	}
+	for i, want := range []struct{ device, code string }{{"a", "x"}, {"a", "y"}, {"b", "x"}, {"b", "y"}} {
+		if snap[i].DeviceID != want.device || snap[i].PointCode != want.code || !snap[i].Value.Equal(scalar(int64(i+1))) {
+			t.Errorf("unexpected entry #%d: %+v", i, snap[i])
+		}
+	}
+
+	// 修改快照不影响实时库，后续写入也不改变已取出的快照
+	// Changing the snapshot leaves the database alone, and later writes leave
+	// the snapshot alone.
+	snap[0].Value = scalar(100)
+	db.Put("a", "y", scalar(200), QualityGood, now, 0)
+
+	if e, _ := db.Get("a", "x"); !e.Value.Equal(scalar(1)) {
+		t.Errorf("database changed through the snapshot: %+v", e)
+	}
+	if !snap[1].Value.Equal(scalar(2)) {
+		t.Errorf("snapshot changed by a later write: %+v", snap[1])
+	}
+
+	if dev := db.Device("a"); len(dev) != 2 || dev[0].PointCode != "x" || !dev[1].Value.Equal(scalar(200)) {
+		t.Errorf("unexpected device entries: %+v", dev)
+	}
+	db.DeleteDevice("a")
+	if len(db.Device("a")) != 0 || len(db.Snapshot()) != 2 {
+		t.Errorf("device a should be gone: %+v", db.Snapshot())
+	}
+}
@@ -1,9 +1,20 @@
 package api
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/audit"
 	"github.com/fluxionwatt/gridbeat/internal/models"
 	"github.com/fluxionwatt/gridbeat/internal/response"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
 	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // ListMyTokens lists tokens of current user.
@@ -33,3 +44,415 @@ func (s *Server) ListOnlineChanel(c fiber.Ctx) error {
 	}
 	return response.OK(c, cs)
 }
+
+// GetChannel returns one channel with its live status.
+// GetChannel 返回单个通道及其运行状态。
+//
+// @Summary Get channel / 查询通道
+// @Tags channel
+// @Produce json
+// @Security BearerAuth
+// @Param uuid path string true "channel uuid / 通道 UUID"
+// @Success 200 {object} response.Envelope[models.Channel]
+// @Failure 404 {object} response.Envelope[any]
+// @Router /api/v1/channels/{uuid} [get]
+func (s *Server) GetChannel(c fiber.Ctx) error {
+	ch, err := s.findChannel(c.Params("uuid"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "channel not found")
+		}
+		return response.Internal(c, "db error")
+	}
+
+	if in, ok := s.Mgr.Get("mbus", ch.UUID); ok {
+		ch.Status = in.Get().(models.ChannelStatus)
+	}
+	return response.OK(c, ch)
+}
+
+// CreateChannel creates a TCP or serial channel and starts its instance.
+// CreateChannel 创建 TCP 或串口通道并启动其实例。
+//
+// @Summary Create channel / 创建通道
+// @Description Body uses the same fields as the channel list; id, uuid and status are ignored.
+// @Description 请求体字段与通道列表一致，忽略 id、uuid 与 status。
+// @Tags channel
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body models.Channel true "request / 请求"
+// @Success 200 {object} response.Envelope[models.Channel]
+// @Failure 409 {object} response.Envelope[any]
+// @Router /api/v1/channels [post]
+func (s *Server) CreateChannel(c fiber.Ctx) error {
+	u := MustUser(c)
+
+	// 未提供的字段使用与串口默认行相同的取值，备用链路除外（仅在请求中指定时设置）
+	// Fields missing from the body take the same defaults as the serial rows,
+	// except the backup link, which is only set when the body asks for one.
+	ch := *models.GetDefaultSerialRow("", "")
+	ch.PhysicalLink = "tcp"
+	ch.BackupTCPIPAddr, ch.BackupTCPPort = "", 0
+	if err := c.Bind().JSON(&ch); err != nil {
+		return response.BadRequest(c, "invalid json")
+	}
+	ch.ID = 0
+	ch.UUID = uuid.New().String()
+	ch.Status = models.ChannelStatus{}
+
+	if msg := normalizeChannel(&ch); msg != "" {
+		return response.BadRequest(c, msg)
+	}
+	if s.channelDeviceTaken(&ch) {
+		return response.Conflict(c, "channel device exists")
+	}
+
+	if err := s.DB.Create(&ch).Error; err != nil {
+		return response.Internal(c, "create channel failed")
+	}
+
+	audit.Write(s.DB, c, u, "create_channel", "channel", fiber.Map{"uuid": ch.UUID, "physical_link": ch.PhysicalLink})
+
+	if err := s.Mgr.ApplyChannel(ch, s.Cfg.Simulator); err != nil {
+		return response.Internal(c, "channel saved but instance failed to start: "+err.Error())
+	}
+	return response.OK(c, ch)
+}
+
+// UpdateChannel updates a channel and restarts its instance with the new config.
+// UpdateChannel 更新通道并以新配置重启其实例。
+//
+// @Summary Update channel / 更新通道
+// @Description Fields missing from the body keep their current values.
+// @Description 请求体中缺省的字段保持原值。
+// @Tags channel
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param uuid path string true "channel uuid / 通道 UUID"
+// @Param body body models.Channel true "request / 请求"
+// @Success 200 {object} response.Envelope[models.Channel]
+// @Failure 404 {object} response.Envelope[any]
+// @Failure 409 {object} response.Envelope[any]
+// @Router /api/v1/channels/{uuid} [put]
+func (s *Server) UpdateChannel(c fiber.Ctx) error {
+	u := MustUser(c)
+
+	ch, err := s.findChannel(c.Params("uuid"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "channel not found")
+		}
+		return response.Internal(c, "db error")
+	}
+
+	id, uid, created := ch.ID, ch.UUID, ch.CreatedAt
+	if err := c.Bind().JSON(&ch); err != nil {
+		return response.BadRequest(c, "invalid json")
+	}
+	ch.ID, ch.UUID, ch.CreatedAt = id, uid, created
+	ch.Status = models.ChannelStatus{}
+
+	if msg := normalizeChannel(&ch); msg != "" {
+		return response.BadRequest(c, msg)
+	}
+	if s.channelDeviceTaken(&ch) {
+		return response.Conflict(c, "channel device exists")
+	}
+
+	if err := s.DB.Save(&ch).Error; err != nil {
+		return response.Internal(c, "update channel failed")
+	}
+
+	// 端点变化后设备记录中的传输方式与端点同步更新
+	// Keep the transport/endpoint copies on the channel's devices in sync.
+	transport, endpoint := channelEndpoint(&ch)
+	if err := s.DB.Model(&models.Device{}).Where("channel_id = ?", ch.UUID).
+		Updates(map[string]any{"transport": transport, "endpoint": endpoint}).Error; err != nil {
+		return response.Internal(c, "update devices failed")
+	}
+
+	audit.Write(s.DB, c, u, "update_channel", "channel", fiber.Map{"uuid": ch.UUID, "physical_link": ch.PhysicalLink})
+
+	if err := s.Mgr.ApplyChannel(ch, s.Cfg.Simulator); err != nil {
+		return response.Internal(c, "channel saved but instance failed to restart: "+err.Error())
+	}
+	return response.OK(c, ch)
+}
+
+// DeleteChannel stops a channel's instance and deletes it; channels with devices are refused.
+// DeleteChannel 停止通道实例并删除通道；仍有设备的通道拒绝删除。
+//
+// @Summary Delete channel / 删除通道
+// @Tags channel
+// @Produce json
+// @Security BearerAuth
+// @Param uuid path string true "channel uuid / 通道 UUID"
+// @Success 200 {object} response.Envelope[any]
+// @Failure 404 {object} response.Envelope[any]
+// @Failure 409 {object} response.Envelope[any]
+// @Router /api/v1/channels/{uuid} [delete]
+func (s *Server) DeleteChannel(c fiber.Ctx) error {
+	u := MustUser(c)
+
+	ch, err := s.findChannel(c.Params("uuid"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "channel not found")
+		}
+		return response.Internal(c, "db error")
+	}
+
+	var n int64
+	if err := s.DB.Model(&models.Device{}).Where("channel_id = ?", ch.UUID).Count(&n).Error; err != nil {
+		return response.Internal(c, "db error")
+	}
+	if n > 0 {
+		return response.Conflict(c, "channel has devices")
+	}
+
+	if err := s.Mgr.RemoveChannel(ch.UUID); err != nil {
+		return response.Internal(c, "stop channel instance failed: "+err.Error())
+	}
+
+	if err := s.DB.Delete(&ch).Error; err != nil {
+		return response.Internal(c, "delete failed")
+	}
+
+	audit.Write(s.DB, c, u, "delete_channel", "channel", fiber.Map{"uuid": ch.UUID, "physical_link": ch.PhysicalLink})
+	return response.OK(c, fiber.Map{"deleted": true})
+}
+
+// TraceFrame is one raw frame captured on a channel.
+// TraceFrame 是通道上抓取的一帧原始报文。
+type TraceFrame struct {
+	Time         time.Time `json:"time"`
+	Direction    string    `json:"direction"`           // tx / rx
+	Format       string    `json:"format"`              // mbap / rtu / ascii
+	ADU          string    `json:"adu"`                 // 十六进制原始报文 / raw frame, hex
+	FunctionCode uint8     `json:"function_code"`       // 异常应答时最高位为 1 / bit 7 set on exceptions
+	Exception    uint8     `json:"exception,omitempty"` // 异常码 / exception code
+}
+
+// GetChannelTrace returns the frames captured during the channel's debug window, as JSON or pcap.
+// GetChannelTrace 返回通道调试窗口内抓取的报文，JSON 或 pcap 格式。
+//
+// @Summary Get channel frame trace / 获取通道报文抓包
+// @Description Frames are captured while debug_log is set and debug_expiry has not passed.
+// @Description format=pcap downloads a capture file: Modbus/TCP as raw IPv4 (port 502), RTU as DLT_USER0, ASCII as DLT_USER1.
+// @Description 仅在 debug_log 开启且未过 debug_expiry 时抓取。format=pcap 下载抓包文件。
+// @Tags channel
+// @Produce json
+// @Produce application/vnd.tcpdump.pcap
+// @Security BearerAuth
+// @Param uuid path string true "channel uuid / 通道 UUID"
+// @Param format query string false "json (default) or pcap"
+// @Success 200 {object} response.Envelope[[]TraceFrame]
+// @Failure 404 {object} response.Envelope[any]
+// @Router /api/v1/channels/{uuid}/trace [get]
+func (s *Server) GetChannelTrace(c fiber.Ctx) error {
+	uid := c.Params("uuid")
+
+	in, ok := s.Mgr.Get("mbus", uid)
+	if !ok {
+		return response.NotFound(c, "channel not running")
+	}
+	tr, ok := in.(pluginapi.FrameTraceReader)
+	if !ok {
+		return response.BadRequest(c, "channel does not support tracing")
+	}
+	frames := tr.Trace()
+
+	switch c.Query("format", "json") {
+	case "pcap":
+		var buf bytes.Buffer
+		if err := modbus.WritePcap(&buf, frames); err != nil {
+			return response.Internal(c, "write pcap failed")
+		}
+		c.Set(fiber.HeaderContentType, "application/vnd.tcpdump.pcap")
+		c.Attachment("channel-" + uid + ".pcap")
+		return c.Send(buf.Bytes())
+
+	case "json":
+		out := make([]TraceFrame, 0, len(frames))
+		for _, f := range frames {
+			out = append(out, TraceFrame{
+				Time:         f.Time,
+				Direction:    traceDirection(f.Direction),
+				Format:       traceFormat(f.Format),
+				ADU:          hex.EncodeToString(f.ADU),
+				FunctionCode: f.FunctionCode,
+				Exception:    f.Exception,
+			})
+		}
+		return response.OK(c, out)
+	}
+	return response.BadRequest(c, "format must be json or pcap")
+}
+
+// GetChannelFaults returns the fault profile of the channel's simulator.
+// GetChannelFaults 返回通道模拟器的故障注入配置。
+//
+// @Summary Get simulator faults / 获取模拟器故障注入配置
+// @Description Only available when the server runs with --simulator.
+// @Description 仅在以 --simulator 运行时可用。
+// @Tags channel
+// @Produce json
+// @Security BearerAuth
+// @Param uuid path string true "channel uuid / 通道 UUID"
+// @Success 200 {object} response.Envelope[pluginapi.FaultProfile]
+// @Failure 404 {object} response.Envelope[any]
+// @Router /api/v1/channels/{uuid}/faults [get]
+func (s *Server) GetChannelFaults(c fiber.Ctx) error {
+	fi, err := s.faultInjector(c.Params("uuid"))
+	if err != nil {
+		return response.NotFound(c, err.Error())
+	}
+	return response.OK(c, fi.Faults())
+}
+
+// UpdateChannelFaults replaces the fault profile of the channel's simulator; it applies at once.
+// UpdateChannelFaults 替换通道模拟器的故障注入配置，立即生效。
+//
+// @Summary Update simulator faults / 修改模拟器故障注入配置
+// @Description Latency, random or scripted exceptions, dropped responses, corrupted RTU CRCs and periodic disconnects.
+// @Description An empty profile turns fault injection off.
+// @Description 附加延迟、随机或脚本化异常、丢弃应答、RTU CRC 损坏与周期断开；空配置关闭故障注入。
+// @Tags channel
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param uuid path string true "channel uuid / 通道 UUID"
+// @Param body body pluginapi.FaultProfile true "request / 请求"
+// @Success 200 {object} response.Envelope[pluginapi.FaultProfile]
+// @Failure 400 {object} response.Envelope[any]
+// @Failure 404 {object} response.Envelope[any]
+// @Router /api/v1/channels/{uuid}/faults [put]
+func (s *Server) UpdateChannelFaults(c fiber.Ctx) error {
+	u := MustUser(c)
+	uid := c.Params("uuid")
+
+	fi, err := s.faultInjector(uid)
+	if err != nil {
+		return response.NotFound(c, err.Error())
+	}
+
+	var p pluginapi.FaultProfile
+	if err := c.Bind().JSON(&p); err != nil {
+		return response.BadRequest(c, "invalid json")
+	}
+	if err := fi.SetFaults(p); err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	audit.Write(s.DB, c, u, "update_simulator_faults", "channel", fiber.Map{"uuid": uid})
+	return response.OK(c, fi.Faults())
+}
+
+// faultInjector finds the running simulator instance of a channel.
+// faultInjector 查找通道运行中的模拟器实例。
+func (s *Server) faultInjector(uid string) (pluginapi.FaultInjector, error) {
+	in, ok := s.Mgr.Get("cmbus", uid)
+	if !ok {
+		return nil, errors.New("simulator not running")
+	}
+	fi, ok := in.(pluginapi.FaultInjector)
+	if !ok {
+		return nil, errors.New("simulator does not support fault injection")
+	}
+	return fi, nil
+}
+
+// traceDirection names a frame direction.
+// traceDirection 返回报文方向的名称。
+func traceDirection(d modbus.FrameDirection) string {
+	if d == modbus.FRAME_RX {
+		return "rx"
+	}
+	return "tx"
+}
+
+// traceFormat names a frame format.
+// traceFormat 返回报文格式的名称。
+func traceFormat(f modbus.FrameFormat) string {
+	switch f {
+	case modbus.FRAME_RTU:
+		return "rtu"
+	case modbus.FRAME_ASCII:
+		return "ascii"
+	}
+	return "mbap"
+}
+
+// findChannel loads a channel by uuid.
+// findChannel 按 uuid 读取通道。
+func (s *Server) findChannel(uid string) (ch models.Channel, err error) {
+	err = s.DB.Where("uuid = ?", uid).First(&ch).Error
+	return
+}
+
+// channelDeviceTaken reports whether another channel already uses ch's device paths.
+// channelDeviceTaken 判断设备路径是否已被其他通道占用。
+func (s *Server) channelDeviceTaken(ch *models.Channel) bool {
+	var n int64
+	s.DB.Model(&models.Channel{}).
+		Where("uuid <> ? AND (device IN ? OR device2 IN ?)", ch.UUID, []string{ch.Device, ch.Device2}, []string{ch.Device, ch.Device2}).
+		Count(&n)
+	return n > 0
+}
+
+// normalizeChannel validates a channel and fills derived fields; it returns a message on invalid input.
+// normalizeChannel 校验通道并填充推导字段，输入非法时返回错误信息。
+func normalizeChannel(ch *models.Channel) string {
+	ch.PhysicalLink = strings.TrimSpace(ch.PhysicalLink)
+	ch.Device = strings.TrimSpace(ch.Device)
+	ch.Device2 = strings.TrimSpace(ch.Device2)
+	if ch.Plugin == "" {
+		ch.Plugin = "mbus"
+	}
+
+	if ch.Sniffer && ch.PhysicalLink != "serial" {
+		return "sniffer mode requires a serial channel"
+	}
+
+	if ch.PhysicalLink == "serial" {
+		if ch.Device == "" {
+			return "device required for serial channel"
+		}
+		if ch.Device2 == "" {
+			// device2 唯一且非空，未使用时用通道 UUID 占位
+			// device2 is unique and not null; use the channel UUID when unused.
+			ch.Device2 = ch.UUID
+		}
+		if ch.Speed == 0 {
+			return "speed required"
+		}
+		if ch.DataBits < 5 || ch.DataBits > 8 {
+			return "data_bits must be 5-8"
+		}
+		if ch.StopBits < 1 || ch.StopBits > 2 {
+			return "stop_bits must be 1 or 2"
+		}
+		if ch.Parity > modbus.PARITY_ODD {
+			return "parity must be 0 (none), 1 (even) or 2 (odd)"
+		}
+		return ""
+	}
+
+	if ch.PhysicalLink == "" {
+		ch.PhysicalLink = "tcp"
+	}
+	if ch.PhysicalLink != "tcp" {
+		return "physical_link must be serial or tcp"
+	}
+	ch.TCPIPAddr = strings.TrimSpace(ch.TCPIPAddr)
+	if ch.TCPIPAddr == "" || ch.TCPPort == 0 {
+		return "TCPIPAddr and TCPPort required"
+	}
+
+	// 串口路径列唯一且非空，TCP 通道用 UUID 占位
+	// The serial path columns are unique and not null; TCP channels use the UUID.
+	ch.Device, ch.Device2 = ch.UUID, ch.UUID
+	return ""
+}
@@ -0,0 +1,387 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/audit"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/internal/response"
+	"github.com/fluxionwatt/gridbeat/internal/util"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeviceRequest is request body for creating or updating a device.
+// DeviceRequest 是创建或更新设备的请求体。
+type DeviceRequest struct {
+	Name            string `json:"name"`
+	ChannelID       string `json:"channel_id"`  // channel uuid / 通道 UUID
+	DeviceType      string `json:"device_type"` // device type key; empty on create to identify the device / 设备类型 key，创建时为空则读取设备标识匹配
+	SlaveID         int    `json:"slave_id"`
+	PollIntervalMs  int    `json:"poll_interval_ms"` // 0 means 1000 / 0 表示 1000
+	SN              string `json:"sn"`
+	DevicePlugin    string `json:"device_plugin"`
+	SoftwareVersion string `json:"software_version"`
+	Model           string `json:"model"`
+	Disable         bool   `json:"disable"`
+	MaxReadRegs     uint16 `json:"max_read_regs"`
+	MaxReadBits     uint16 `json:"max_read_bits"`
+	ReadGap         uint16 `json:"read_gap"`
+}
+
+// DevicePage is paged device list.
+// DevicePage 是设备分页列表。
+type DevicePage struct {
+	Total int64           `json:"total"`
+	Page  int             `json:"page"`
+	Size  int             `json:"size"`
+	Items []models.Device `json:"items"`
+}
+
+// ListDevices lists devices with pagination.
+// ListDevices 分页列出设备。
+//
+// @Summary List devices / 列出设备
+// @Description Optional filters by channel, device type and name keyword.
+// @Description 可按通道、设备类型与名称关键字过滤。
+// @Tags device
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "page / 页码" default(1)
+// @Param page_size query int false "page size / 每页数量" default(20)
+// @Param channel_id query string false "channel uuid / 通道 UUID"
+// @Param device_type query string false "device type key / 设备类型 key"
+// @Param name query string false "name keyword / 名称关键字"
+// @Success 200 {object} response.Envelope[DevicePage]
+// @Router /api/v1/devices [get]
+func (s *Server) ListDevices(c fiber.Ctx) error {
+	pq := util.ParsePageQuery(c)
+	offset, limit := pq.OffsetLimit()
+
+	q := s.DB.Model(&models.Device{})
+	if v := c.Query("channel_id"); v != "" {
+		q = q.Where("channel_id = ?", v)
+	}
+	if v := c.Query("device_type"); v != "" {
+		q = q.Where("device_type = ?", v)
+	}
+	if v := c.Query("name"); v != "" {
+		q = q.Where("name LIKE ?", "%"+v+"%")
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return response.Internal(c, "db error")
+	}
+
+	items := make([]models.Device, 0)
+	if err := q.Order("name asc").Offset(offset).Limit(limit).Find(&items).Error; err != nil {
+		return response.Internal(c, "db error")
+	}
+
+	return response.OK(c, DevicePage{Total: total, Page: pq.Page, Size: pq.PageSize, Items: items})
+}
+
+// GetDevice returns one device.
+// GetDevice 返回单个设备。
+//
+// @Summary Get device / 查询设备
+// @Tags device
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "device id / 设备ID"
+// @Success 200 {object} response.Envelope[models.Device]
+// @Failure 404 {object} response.Envelope[any]
+// @Router /api/v1/devices/{id} [get]
+func (s *Server) GetDevice(c fiber.Ctx) error {
+	var dev models.Device
+	if err := s.DB.Where("id = ?", c.Params("id")).First(&dev).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "device not found")
+		}
+		return response.Internal(c, "db error")
+	}
+	return response.OK(c, dev)
+}
+
+// CreateDevice adds a device to a channel; the channel starts polling it right away.
+// CreateDevice 向通道添加设备，通道立即开始轮询该设备。
+//
+// @Summary Create device / 创建设备
+// @Description Transport and endpoint are derived from the channel. Without device_type,
+// @Description the device is identified over the running channel (Modbus 0x2B/0x0E) and the
+// @Description device type is matched by vendor, model and version.
+// @Description 传输方式与端点由所属通道推导。未指定 device_type 时，通过运行中的通道读取设备标识
+// @Description （Modbus 0x2B/0x0E），按厂商、型号与版本匹配设备类型。
+// @Tags device
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body DeviceRequest true "request / 请求"
+// @Success 200 {object} response.Envelope[models.Device]
+// @Failure 409 {object} response.Envelope[any]
+// @Failure 422 {object} response.Envelope[any]
+// @Failure 502 {object} response.Envelope[any]
+// @Router /api/v1/devices [post]
+func (s *Server) CreateDevice(c fiber.Ctx) error {
+	u := MustUser(c)
+
+	var req DeviceRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return response.BadRequest(c, "invalid json")
+	}
+
+	if strings.TrimSpace(req.DeviceType) == "" {
+		if status, code, msg := s.identifyDeviceType(c, &req); msg != "" {
+			return response.Fail(c, status, code, msg)
+		}
+	}
+
+	var dev models.Device
+	if msg := s.applyDeviceRequest(&dev, &req); msg != "" {
+		return response.BadRequest(c, msg)
+	}
+	if s.deviceNameTaken(dev.Name, "") {
+		return response.Conflict(c, "device name exists")
+	}
+
+	if err := s.DB.Omit(clause.Associations).Create(&dev).Error; err != nil {
+		return response.Internal(c, "create device failed")
+	}
+
+	s.reloadChannel(dev.ChannelID)
+
+	audit.Write(s.DB, c, u, "create_device", "device", fiber.Map{"device_id": dev.ID, "name": dev.Name, "channel_id": dev.ChannelID})
+	return response.OK(c, dev)
+}
+
+// UpdateDevice replaces a device's settings; the owning channel picks them up live.
+// UpdateDevice 更新设备配置，所属通道实时生效。
+//
+// @Summary Update device / 更新设备
+// @Description Moving a device to another channel reloads both channels.
+// @Description 设备换通道时两个通道都会重新加载。
+// @Tags device
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "device id / 设备ID"
+// @Param body body DeviceRequest true "request / 请求"
+// @Success 200 {object} response.Envelope[models.Device]
+// @Failure 404 {object} response.Envelope[any]
+// @Failure 409 {object} response.Envelope[any]
+// @Router /api/v1/devices/{id} [put]
+func (s *Server) UpdateDevice(c fiber.Ctx) error {
+	u := MustUser(c)
+
+	var req DeviceRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return response.BadRequest(c, "invalid json")
+	}
+
+	var dev models.Device
+	if err := s.DB.Where("id = ?", c.Params("id")).First(&dev).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "device not found")
+		}
+		return response.Internal(c, "db error")
+	}
+
+	oldChannel, oldType := dev.ChannelID, dev.DeviceType
+	if msg := s.applyDeviceRequest(&dev, &req); msg != "" {
+		return response.BadRequest(c, msg)
+	}
+	if s.deviceNameTaken(dev.Name, dev.ID) {
+		return response.Conflict(c, "device name exists")
+	}
+
+	if err := s.DB.Omit(clause.Associations).Save(&dev).Error; err != nil {
+		return response.Internal(c, "update device failed")
+	}
+
+	// 停用或更换类型后旧的实时值不再有效
+	// Cached values are stale once the device is disabled or its type changes.
+	if s.RTDB != nil && (dev.Disable || dev.DeviceType != oldType) {
+		s.RTDB.DeleteDevice(dev.ID)
+	}
+
+	s.reloadChannel(dev.ChannelID)
+	if oldChannel != dev.ChannelID {
+		s.reloadChannel(oldChannel)
+	}
+
+	audit.Write(s.DB, c, u, "update_device", "device", fiber.Map{"device_id": dev.ID, "name": dev.Name, "channel_id": dev.ChannelID})
+	return response.OK(c, dev)
+}
+
+// DeleteDevice removes a device; the owning channel stops polling it.
+// DeleteDevice 删除设备，所属通道停止轮询该设备。
+//
+// @Summary Delete device / 删除设备
+// @Tags device
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "device id / 设备ID"
+// @Success 200 {object} response.Envelope[any]
+// @Failure 404 {object} response.Envelope[any]
+// @Router /api/v1/devices/{id} [delete]
+func (s *Server) DeleteDevice(c fiber.Ctx) error {
+	u := MustUser(c)
+
+	var dev models.Device
+	if err := s.DB.Where("id = ?", c.Params("id")).First(&dev).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "device not found")
+		}
+		return response.Internal(c, "db error")
+	}
+
+	// 物理删除，名称唯一索引不会被软删除记录占用
+	// Hard delete so soft-deleted rows don't hold on to the unique name.
+	if err := s.DB.Unscoped().Delete(&dev).Error; err != nil {
+		return response.Internal(c, "delete failed")
+	}
+
+	if s.RTDB != nil {
+		s.RTDB.DeleteDevice(dev.ID)
+	}
+	s.reloadChannel(dev.ChannelID)
+
+	audit.Write(s.DB, c, u, "delete_device", "device", fiber.Map{"device_id": dev.ID, "name": dev.Name, "channel_id": dev.ChannelID})
+	return response.OK(c, fiber.Map{"deleted": true})
+}
+
+// applyDeviceRequest validates req and copies it onto dev; it returns a message on invalid input.
+// applyDeviceRequest 校验请求并写入 dev，输入非法时返回错误信息。
+func (s *Server) applyDeviceRequest(dev *models.Device, req *DeviceRequest) string {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return "name required"
+	}
+	if req.SlaveID < 1 || req.SlaveID > 247 {
+		return "slave_id must be 1-247"
+	}
+	if req.PollIntervalMs == 0 {
+		req.PollIntervalMs = 1000
+	}
+	if req.PollIntervalMs < 100 {
+		return "poll_interval_ms must be at least 100"
+	}
+	if req.MaxReadRegs > 125 || req.MaxReadBits > 2000 {
+		return "max_read_regs must be at most 125 and max_read_bits at most 2000"
+	}
+
+	var ch models.Channel
+	if err := s.DB.Where("uuid = ?", req.ChannelID).First(&ch).Error; err != nil {
+		return "channel not found"
+	}
+
+	var n int64
+	if err := s.DB.Model(&models.DeviceType{}).Where("type_key = ?", req.DeviceType).Count(&n).Error; err != nil || n == 0 {
+		return "device type not found"
+	}
+
+	dev.Name = req.Name
+	dev.ChannelID = ch.UUID
+	dev.DeviceType = req.DeviceType
+	dev.Transport, dev.Endpoint = channelEndpoint(&ch)
+	dev.SlaveID = req.SlaveID
+	dev.PollIntervalMs = req.PollIntervalMs
+	dev.SN = req.SN
+	dev.DevicePlugin = req.DevicePlugin
+	dev.SoftwareVersion = req.SoftwareVersion
+	dev.Model = req.Model
+	dev.Disable = req.Disable
+	dev.MaxReadRegs = req.MaxReadRegs
+	dev.MaxReadBits = req.MaxReadBits
+	dev.ReadGap = req.ReadGap
+	return ""
+}
+
+// identifyDeviceType reads the identification of req's device over its channel and
+// sets the matching device type; it returns a status and message on failure.
+// identifyDeviceType 通过所属通道读取设备标识并设置匹配的设备类型，失败时返回状态码与错误信息。
+func (s *Server) identifyDeviceType(c fiber.Ctx, req *DeviceRequest) (int, response.ErrorCode, string) {
+	if req.SlaveID < 1 || req.SlaveID > 247 {
+		return http.StatusBadRequest, response.CodeBadRequest, "slave_id must be 1-247"
+	}
+
+	if s.Mgr == nil {
+		return http.StatusServiceUnavailable, response.CodeInternal, "channel not running"
+	}
+	in, ok := s.Mgr.Get("mbus", req.ChannelID)
+	if !ok {
+		return http.StatusServiceUnavailable, response.CodeInternal, "channel not running"
+	}
+	idf, ok := in.(pluginapi.DeviceIdentifier)
+	if !ok {
+		return http.StatusNotImplemented, response.CodeInternal, "channel does not support device identification"
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	id, err := idf.IdentifyDevice(ctx, req.SlaveID)
+	if err != nil {
+		return http.StatusBadGateway, response.CodeInternal, "read device identification failed: " + err.Error()
+	}
+
+	dt, err := models.MatchDeviceType(s.DB, id.Vendor, id.Product, id.Revision)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusUnprocessableEntity, response.CodeNotFound,
+			fmt.Sprintf("no device type for vendor %q, model %q, version %q", id.Vendor, id.Product, id.Revision)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, response.CodeInternal, "db error"
+	}
+
+	req.DeviceType = dt.TypeKey
+	if req.Model == "" {
+		req.Model = id.Product
+	}
+	if req.SoftwareVersion == "" {
+		req.SoftwareVersion = id.Revision
+	}
+	return 0, response.CodeOK, ""
+}
+
+// deviceNameTaken reports whether another device already uses name.
+// deviceNameTaken 判断名称是否已被其他设备占用。
+func (s *Server) deviceNameTaken(name, selfID string) bool {
+	var n int64
+	s.DB.Model(&models.Device{}).Where("name = ? AND id <> ?", name, selfID).Count(&n)
+	return n > 0
+}
+
+// channelEndpoint derives a device's transport and endpoint from its channel.
+// channelEndpoint 由通道推导设备的传输方式与端点。
+func channelEndpoint(ch *models.Channel) (transport, endpoint string) {
+	if ch.PhysicalLink == "serial" {
+		return "rtu", ch.Device
+	}
+	return "tcp", fmt.Sprintf("%s:%d", ch.TCPIPAddr, ch.TCPPort)
+}
+
+// reloadChannel asks the running instances of a channel (poller and simulator) to reload its devices.
+// reloadChannel 通知通道运行中的实例（采集与模拟器）重新加载设备列表。
+func (s *Server) reloadChannel(uuid string) {
+	if s.Mgr == nil || uuid == "" {
+		return
+	}
+	for _, typ := range []string{"mbus", "cmbus"} {
+		in, ok := s.Mgr.Get(typ, uuid)
+		if !ok {
+			continue
+		}
+		if r, ok := in.(pluginapi.Reloader); ok {
+			_ = r.Reload()
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
+	"github.com/fluxionwatt/gridbeat/internal/audit"
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/internal/response"
+	"github.com/fluxionwatt/gridbeat/pluginapi"
+	"github.com/fluxionwatt/gridbeat/utils/point"
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+// PointValueItem is a point definition joined with its live value.
+// PointValueItem 是点位定义与实时值的组合。
+type PointValueItem struct {
+	DeviceID   string         `json:"device_id"`
+	DeviceName string         `json:"device_name"`
+	DeviceType string         `json:"device_type"`
+	Code       string         `json:"code"`
+	Kind       models.RegType `json:"kind"`
+	NameI18n   models.I18nMap `json:"name_i18n"`
+	Unit       string         `json:"unit"`
+	RW         string         `json:"rw"`
+	Value      *models.Scalar `json:"value,omitempty"`
+	Label      string         `json:"label,omitempty"` // enum label / 枚举标签
+	Quality    rtdb.Quality   `json:"quality,omitempty"`
+	Timestamp  *time.Time     `json:"timestamp,omitempty"`
+	LastError  string         `json:"last_error,omitempty"`
+}
+
+// PointQueryRequest filters a bulk point query; empty fields match everything.
+// PointQueryRequest 是批量点位查询条件，空字段表示不过滤。
+type PointQueryRequest struct {
+	DeviceIDs   []string         `json:"device_ids"`
+	DeviceTypes []string         `json:"device_types"`
+	Codes       []string         `json:"codes"`
+	Kinds       []models.RegType `json:"kinds"`
+}
+
+// ListDevicePoints returns live values of one device's points.
+// ListDevicePoints 返回单个设备点位的实时值。
+//
+// @Summary List device point values / 查询设备点位实时值
+// @Description Current values, units, names, quality and timestamps from the real-time cache.
+// @Description 从实时库返回当前值、单位、名称、质量与时间戳。
+// @Tags point
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "device id / 设备ID"
+// @Param code query string false "comma separated point codes / 逗号分隔的点位编码"
+// @Param kind query string false "comma separated kinds (holding,input,coil,discrete) / 逗号分隔的点位类型"
+// @Success 200 {object} response.Envelope[[]PointValueItem]
+// @Failure 404 {object} response.Envelope[any]
+// @Router /api/v1/devices/{id}/points [get]
+func (s *Server) ListDevicePoints(c fiber.Ctx) error {
+	var dev models.Device
+	if err := s.DB.Where("id = ?", c.Params("id")).First(&dev).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "device not found")
+		}
+		return response.Internal(c, "db error")
+	}
+
+	req := PointQueryRequest{Codes: splitQuery(c.Query("code"))}
+	for _, k := range splitQuery(c.Query("kind")) {
+		req.Kinds = append(req.Kinds, models.RegType(k))
+	}
+
+	items, err := s.pointValues([]models.Device{dev}, &req)
+	if err != nil {
+		return response.Internal(c, "db error")
+	}
+	return response.OK(c, items)
+}
+
+// QueryPoints returns live values of points across devices.
+// QueryPoints 跨设备批量查询点位实时值。
+//
+// @Summary Query point values / 批量查询点位实时值
+// @Description Filter by device, device type, point code and kind.
+// @Description 按设备、设备类型、点位编码与点位类型过滤。
+// @Tags point
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body PointQueryRequest true "request / 请求"
+// @Success 200 {object} response.Envelope[[]PointValueItem]
+// @Router /api/v1/points/query [post]
+func (s *Server) QueryPoints(c fiber.Ctx) error {
+	var req PointQueryRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return response.BadRequest(c, "invalid json")
+	}
+
+	q := s.DB.Model(&models.Device{})
+	if len(req.DeviceIDs) > 0 {
+		q = q.Where("id IN ?", req.DeviceIDs)
+	}
+	if len(req.DeviceTypes) > 0 {
+		q = q.Where("device_type IN ?", req.DeviceTypes)
+	}
+
+	var devs []models.Device
+	if err := q.Order("name asc").Find(&devs).Error; err != nil {
+		return response.Internal(c, "db error")
+	}
+
+	items, err := s.pointValues(devs, &req)
+	if err != nil {
+		return response.Internal(c, "db error")
+	}
+	return response.OK(c, items)
+}
+
+// pointValues joins device type points with the real-time cache.
+// pointValues 把设备类型点位与实时库数据组合。
+func (s *Server) pointValues(devs []models.Device, req *PointQueryRequest) ([]PointValueItem, error) {
+	items := make([]PointValueItem, 0)
+	if len(devs) == 0 {
+		return items, nil
+	}
+
+	typeKeys := make([]string, 0, len(devs))
+	for _, d := range devs {
+		typeKeys = append(typeKeys, d.DeviceType)
+	}
+
+	q := s.DB.Where("type_key IN ? AND enabled = ?", typeKeys, true)
+	if len(req.Codes) > 0 {
+		q = q.Where("point_code IN ?", req.Codes)
+	}
+	if len(req.Kinds) > 0 {
+		q = q.Where("point_kind IN ?", req.Kinds)
+	}
+
+	var rows []models.DeviceTypePoint
+	if err := q.Order("point_code asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	byType := make(map[string][]models.DeviceTypePoint)
+	for _, p := range rows {
+		byType[p.TypeKey] = append(byType[p.TypeKey], p)
+	}
+
+	for _, d := range devs {
+		for i := range byType[d.DeviceType] {
+			p := &byType[d.DeviceType][i]
+			item := PointValueItem{
+				DeviceID:   d.ID,
+				DeviceName: d.Name,
+				DeviceType: d.DeviceType,
+				Code:       p.PointCode,
+				Kind:       p.PointKind,
+				NameI18n:   p.NameI18n,
+				Unit:       p.Unit,
+				RW:         p.RW,
+			}
+
+			if s.RTDB != nil {
+				if e, ok := s.RTDB.Get(d.ID, p.PointCode); ok {
+					item.Quality = e.Quality
+					item.LastError = e.LastError
+					if !e.Timestamp.IsZero() {
+						ts := e.Timestamp
+						item.Timestamp = &ts
+					}
+					if !e.Value.IsZero() {
+						v := e.Value
+						item.Value = &v
+						if codec, err := point.NewCodec(p); err == nil {
+							item.Label, _ = codec.Label(v)
+						}
+					}
+				}
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+// splitQuery splits a comma separated query value.
+// splitQuery 拆分逗号分隔的查询参数。
+func splitQuery(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// WritePointRequest is request body for a point write.
+// WritePointRequest 是点位写入请求体。
+type WritePointRequest struct {
+	Value    json.RawMessage `json:"value" swaggertype:"string" example:"50.5"` // number / bool / string (enum label)
+	Readback bool            `json:"readback"`                                  // read back to confirm / 写后回读确认
+}
+
+// WriteDevicePoint writes a setpoint or remote-control command to a device point.
+// WriteDevicePoint 向设备点位下发设定值或遥控命令。
+//
+// @Summary Write device point / 写设备点位
+// @Description Value is an engineering value; scale and offset are reversed before writing. Read-only points are rejected.
+// @Description value 为工程值，写入前反向换算缩放与偏移；只读点位会被拒绝。
+// @Tags point
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "device id / 设备ID"
+// @Param code path string true "point code / 点位编码"
+// @Param body body WritePointRequest true "request / 请求"
+// @Success 200 {object} response.Envelope[pluginapi.WriteResult]
+// @Failure 403 {object} response.Envelope[any]
+// @Failure 404 {object} response.Envelope[any]
+// @Failure 422 {object} response.Envelope[any]
+// @Failure 502 {object} response.Envelope[any]
+// @Router /api/v1/devices/{id}/points/{code}/write [post]
+func (s *Server) WriteDevicePoint(c fiber.Ctx) error {
+	u := MustUser(c)
+
+	var req WritePointRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return response.BadRequest(c, "invalid json")
+	}
+	value, err := parseScalar(req.Value)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	var dev models.Device
+	if err := s.DB.Where("id = ?", c.Params("id")).First(&dev).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "device not found")
+		}
+		return response.Internal(c, "db error")
+	}
+
+	var p models.DeviceTypePoint
+	if err := s.DB.Where("type_key = ? AND point_code = ?", dev.DeviceType, c.Params("code")).First(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "point not found")
+		}
+		return response.Internal(c, "db error")
+	}
+	if p.RW == "R" {
+		return response.Forbidden(c, "point is read-only")
+	}
+
+	in, ok := s.Mgr.Get("mbus", dev.ChannelID)
+	if !ok {
+		return response.Fail(c, http.StatusServiceUnavailable, response.CodeInternal, "channel not running")
+	}
+	w, ok := in.(pluginapi.PointWriter)
+	if !ok {
+		return response.Fail(c, http.StatusNotImplemented, response.CodeInternal, "channel does not support writes")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	res, err := w.WritePoint(ctx, pluginapi.WriteRequest{
+		Device:   dev,
+		Point:    p,
+		Value:    value,
+		Readback: req.Readback,
+	})
+
+	detail := fiber.Map{
+		"device_id":  dev.ID,
+		"point_code": p.PointCode,
+		"value":      value,
+		"old":        res.Old,
+		"new":        res.New,
+		"readback":   req.Readback,
+		"verified":   res.Verified,
+	}
+	if err != nil {
+		detail["error"] = err.Error()
+	}
+	audit.Write(s.DB, c, u, "write_point", "device", detail)
+
+	if err != nil {
+		status, code := writeErrorStatus(err)
+		return response.Fail(c, status, code, err.Error())
+	}
+	return response.OK(c, res)
+}
+
+// BroadcastPointRequest is request body for a channel broadcast.
+// BroadcastPointRequest 是通道广播请求体。
+type BroadcastPointRequest struct {
+	TypeKey   string          `json:"type_key" example:"meter"`                  // device type of the point / 点位所属设备类型
+	PointCode string          `json:"point_code" example:"sync_time"`            // point code / 点位编码
+	Value     json.RawMessage `json:"value" swaggertype:"string" example:"50.5"` // number / bool / string (enum label)
+}
+
+// BroadcastChannelPoint writes a point to every slave of a serial channel at once (unit id 0).
+// BroadcastChannelPoint 以站号 0 向串口通道上的所有从站同时写入点位。
+//
+// @Summary Broadcast point write / 广播写点位
+// @Description Slaves do not answer broadcasts, so the write cannot be confirmed; the result is the value written. Serial channels only.
+// @Description 从站不应答广播，写入无法确认，返回值为写入的值；仅支持串口通道。
+// @Tags point
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param uuid path string true "channel uuid / 通道UUID"
+// @Param body body BroadcastPointRequest true "request / 请求"
+// @Success 200 {object} response.Envelope[models.Scalar]
+// @Failure 403 {object} response.Envelope[any]
+// @Failure 404 {object} response.Envelope[any]
+// @Failure 422 {object} response.Envelope[any]
+// @Failure 502 {object} response.Envelope[any]
+// @Router /api/v1/channels/{uuid}/broadcast [post]
+func (s *Server) BroadcastChannelPoint(c fiber.Ctx) error {
+	u := MustUser(c)
+
+	var req BroadcastPointRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return response.BadRequest(c, "invalid json")
+	}
+	value, err := parseScalar(req.Value)
+	if err != nil {
+		return response.BadRequest(c, err.Error())
+	}
+
+	var p models.DeviceTypePoint
+	if err := s.DB.Where("type_key = ? AND point_code = ?", req.TypeKey, req.PointCode).First(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return response.NotFound(c, "point not found")
+		}
+		return response.Internal(c, "db error")
+	}
+	if p.RW == "R" {
+		return response.Forbidden(c, "point is read-only")
+	}
+
+	channelID := c.Params("uuid")
+	in, ok := s.Mgr.Get("mbus", channelID)
+	if !ok {
+		return response.Fail(c, http.StatusServiceUnavailable, response.CodeInternal, "channel not running")
+	}
+	w, ok := in.(pluginapi.BroadcastWriter)
+	if !ok {
+		return response.Fail(c, http.StatusNotImplemented, response.CodeInternal, "channel does not support broadcasts")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), 10*time.Second)
+	defer cancel()
+
+	res, err := w.Broadcast(ctx, pluginapi.BroadcastRequest{Point: p, Value: value})
+
+	detail := fiber.Map{
+		"channel_id": channelID,
+		"type_key":   p.TypeKey,
+		"point_code": p.PointCode,
+		"value":      value,
+		"new":        res,
+	}
+	if err != nil {
+		detail["error"] = err.Error()
+	}
+	audit.Write(s.DB, c, u, "broadcast_point", "channel", detail)
+
+	if err != nil {
+		status, code := writeErrorStatus(err)
+		return response.Fail(c, status, code, err.Error())
+	}
+	return response.OK(c, res)
+}
+
+// rejectedWriteErrors are returned before anything is sent on the bus: the
+// value or point definition does not allow the write, or the channel cannot
+// carry it.
+// rejectedWriteErrors 在发送到总线之前返回：取值或点位定义不允许写入，或通道不支持该写入。
+var rejectedWriteErrors = []error{
+	point.ErrOutOfRange, point.ErrUnknownLabel, point.ErrNotWritable,
+	point.ErrNeedsReadWrite, point.ErrInvalidBCD,
+	pluginapi.ErrReadOnlyPoint, pluginapi.ErrBroadcastUnsupported, pluginapi.ErrSnifferMode,
+}
+
+// writeErrorStatus maps a write error to 422 when the write was rejected, and
+// to 502 when the bus or the device failed.
+// writeErrorStatus 写入被拒绝时映射为 422，总线或设备故障时映射为 502。
+func writeErrorStatus(err error) (int, response.ErrorCode) {
+	for _, e := range rejectedWriteErrors {
+		if errors.Is(err, e) {
+			return http.StatusUnprocessableEntity, response.CodeBadRequest
+		}
+	}
+	return http.StatusBadGateway, response.CodeInternal
+}
+
+// parseScalar converts a JSON value into a Scalar.
+// parseScalar 把 JSON 值转换为 Scalar。
+func parseScalar(raw json.RawMessage) (v models.Scalar, err error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var x any
+	if err = dec.Decode(&x); err != nil {
+		return v, errors.New("value must be valid json")
+	}
+
+	switch t := x.(type) {
+	case bool:
+		v.SetBool(t)
+	case string:
+		v.SetString(t)
+	case json.Number:
+		if n, e := t.Int64(); e == nil {
+			v.SetInt64(n)
+		} else if f, e := t.Float64(); e == nil {
+			v.SetFloat64(f)
+		} else {
+			return v, errors.New("value is not a valid number")
+		}
+	default:
+		return v, errors.New("value must be a number, bool or string")
+	}
+	return v, nil
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/fluxionwatt/gridbeat/core"
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
 	_ "github.com/fluxionwatt/gridbeat/docs"
 	"github.com/fluxionwatt/gridbeat/internal/auth"
 	"github.com/fluxionwatt/gridbeat/internal/config"
@@ -21,6 +22,7 @@ type Server struct {
 	Cfg  *config.Config
 	MQTT *mqtt.Server
 	Mgr  *core.InstanceManager
+	RTDB *rtdb.DB
 }
 
 // New creates server instance.
@@ -87,6 +89,27 @@ func (s *Server) Route(app *fiber.App) *fiber.App {
 
 	channels := v1.Group("/channels", auth.AuthMiddleware(s.DB, s.Cfg.Auth.JWT.Secret))
 	channels.Get("/", s.ListOnlineChanel)
+	channels.Post("/", s.CreateChannel)
+	channels.Get("/:uuid", s.GetChannel)
+	channels.Put("/:uuid", s.UpdateChannel)
+	channels.Delete("/:uuid", s.DeleteChannel)
+	channels.Get("/:uuid/trace", s.GetChannelTrace)
+	channels.Get("/:uuid/faults", s.GetChannelFaults)
+	channels.Put("/:uuid/faults", s.UpdateChannelFaults)
+	channels.Post("/:uuid/broadcast", s.BroadcastChannelPoint)
+
+	// 设备与点位实时值 / devices and live point values
+	devices := v1.Group("/devices", auth.AuthMiddleware(s.DB, s.Cfg.Auth.JWT.Secret))
+	devices.Get("/", s.ListDevices)
+	devices.Post("/", s.CreateDevice)
+	devices.Get("/:id", s.GetDevice)
+	devices.Put("/:id", s.UpdateDevice)
+	devices.Delete("/:id", s.DeleteDevice)
+	devices.Get("/:id/points", s.ListDevicePoints)
+	devices.Post("/:id/points/:code/write", s.WriteDevicePoint)
+
+	points := v1.Group("/points", auth.AuthMiddleware(s.DB, s.Cfg.Auth.JWT.Secret))
+	points.Post("/query", s.QueryPoints)
 
 	// settings
 	settings := v1.Group("/settings", auth.AuthMiddleware(s.DB, s.Cfg.Auth.JWT.Secret))
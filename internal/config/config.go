@@ -14,6 +14,32 @@ type Serial struct {
 	Device2 string `mapstructure:"device2"`
 }
 
+// GatewayRoute routes the requests for one unit ID to a channel.
+// GatewayRoute 把某个单元 ID 的请求路由到一个通道。
+type GatewayRoute struct {
+	UnitID  uint8  `mapstructure:"unit_id"`
+	Channel string `mapstructure:"channel"`  // channel UUID / 通道 UUID
+	SlaveID uint8  `mapstructure:"slave_id"` // slave ID on the bus, 0 keeps unit_id / 总线上的从站地址，0 表示同 unit_id
+}
+
+// SimGenerator produces the values of one simulated point (cmbus), in engineering units.
+// SimGenerator 为一个模拟点位（cmbus）生成数据，单位为工程值。
+type SimGenerator struct {
+	Type  string `mapstructure:"type"`  // device type key / 设备类型
+	Point string `mapstructure:"point"` // point code, empty matches every point of the type / 点位编码，为空匹配该类型全部点位
+
+	Kind     string        `mapstructure:"kind"`     // constant, sine, ramp, random_walk or replay
+	Value    float64       `mapstructure:"value"`    // constant value, start of ramp and random walk / 常量值，斜坡与随机游走的初值
+	Text     string        `mapstructure:"text"`     // value of string points / 字符串点位的值
+	Min      float64       `mapstructure:"min"`      // lower bound of sine, ramp and random walk / 正弦、斜坡、随机游走下限
+	Max      float64       `mapstructure:"max"`      // upper bound, ignored when not above min / 上限，不大于 min 时不限制
+	Period   time.Duration `mapstructure:"period"`   // sine period, 0 means 1m / 正弦周期，0 表示 1 分钟
+	Step     float64       `mapstructure:"step"`     // ramp increment, max random walk step / 斜坡增量，随机游走最大步长
+	Interval time.Duration `mapstructure:"interval"` // step or CSV row interval, 0 means 1s / 步进或 CSV 行间隔，0 表示 1 秒
+	File     string        `mapstructure:"file"`     // replay CSV file with a header row / 回放的 CSV 文件（带表头）
+	Column   string        `mapstructure:"column"`   // CSV column, empty means the point code / CSV 列名，为空表示点位编码
+}
+
 // Config holds application configuration.
 // Config 保存应用配置。
 type Config struct {
@@ -39,7 +65,23 @@ type Config struct {
 		Port uint16 `mapstructure:"port"`
 	} `mapstructure:"mqtt"`
 	Serial []Serial `mapstructure:"serial"`
-	Auth   struct {
+
+	// Gateway exposes serial channels to Modbus TCP masters; an empty listen address disables it.
+	// Gateway 把串口通道开放给 Modbus TCP 主站；listen 为空时不启用。
+	Gateway struct {
+		Listen     string         `mapstructure:"listen"`      // e.g. ":502"
+		MaxClients uint           `mapstructure:"max_clients"` // 0 means 10
+		Timeout    time.Duration  `mapstructure:"timeout"`     // per-request forward timeout, 0 means 5s
+		Routes     []GatewayRoute `mapstructure:"routes"`
+	} `mapstructure:"gateway"`
+
+	// Simulation sets the data sources of the simulator (cmbus) points; points without one use Default.
+	// Simulation 配置模拟器（cmbus）点位的数据源；未配置的点位使用 Default。
+	Simulation struct {
+		Default SimGenerator   `mapstructure:"default"`
+		Points  []SimGenerator `mapstructure:"points"`
+	} `mapstructure:"simulation"`
+	Auth struct {
 		JWT struct {
 			Secret string `mapstructure:"secret"`
 			Issuer string `mapstructure:"issuer"`
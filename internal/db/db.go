@@ -100,6 +100,9 @@ func SeedDefaultSettings(gdb *gorm.DB) error {
 //   - update UpdatedAt if exists / 存在则更新时间（也可更新其他字段）
 //
 // 2) Devices NOT present in list: delete the record / 不在列表中的设备删除记录
+//
+// Only serial channels are touched; TCP channels are managed through the API.
+// 只处理串口通道，TCP 通道通过 API 管理。
 func SyncSerials(gdb *gorm.DB, devices []config.Serial) error {
 	// Normalize, trim, deduplicate / 规范化、去空格、去重
 	set := make(map[string]struct{}, len(devices))
@@ -153,13 +156,13 @@ func SyncSerials(gdb *gorm.DB, devices []config.Serial) error {
 		// Delete records not in list / 删除不在列表中的记录
 		// If startup list is empty, it means delete all records / 如果启动参数为空，则删除全部记录
 		if len(normalized) == 0 {
-			if err := tx.Where("1 = 1").Delete(&models.Channel{}).Error; err != nil {
+			if err := tx.Where("physical_link = ?", "serial").Delete(&models.Channel{}).Error; err != nil {
 				return fmt.Errorf("delete all serials failed: %w", err)
 			}
 			return nil
 		}
 
-		if err := tx.Where("device NOT IN ?", normalized).Delete(&models.Channel{}).Error; err != nil {
+		if err := tx.Where("physical_link = ? AND device NOT IN ?", "serial", normalized).Delete(&models.Channel{}).Error; err != nil {
 			return fmt.Errorf("delete missing serials failed: %w", err)
 		}
 		return nil
@@ -9,13 +9,19 @@ import (
 )
 
 type ChannelStatus struct {
-	Working         bool          `gorm:"-" json:"working"`           // 工作状态
-	Linking         bool          `gorm:"-" json:"linking"`           // 连接状态
-	CurrentDelay    time.Duration `gorm:"-" json:"current_delay"`     // 当前采集延迟
-	BytesSent       uint64        `gorm:"-" json:"bytes_sent"`        // bytes sent
-	BytesReceived   uint64        `gorm:"-" json:"bytes_received"`    // bytes received
-	PointsToalRead  uint64        `gorm:"-" json:"points_total_read"` // 点位读取数总计
-	PointsErrorRead uint64        `gorm:"-" json:"points_error_read"` // 点位读取错误数总计
+	Working          bool          `gorm:"-" json:"working"`             // 工作状态
+	Linking          bool          `gorm:"-" json:"linking"`             // 连接状态
+	CurrentDelay     time.Duration `gorm:"-" json:"current_delay"`       // 当前采集延迟
+	BytesSent        uint64        `gorm:"-" json:"bytes_sent"`          // bytes sent
+	BytesReceived    uint64        `gorm:"-" json:"bytes_received"`      // bytes received
+	PointsToalRead   uint64        `gorm:"-" json:"points_total_read"`   // 点位读取数总计
+	PointsErrorRead  uint64        `gorm:"-" json:"points_error_read"`   // 点位读取错误数总计
+	ActiveEndpoint   string        `gorm:"-" json:"active_endpoint"`     // 当前使用的端点（主/备地址）
+	Tracing          bool          `gorm:"-" json:"tracing"`             // 调试抓包窗口是否开启
+	BusQueueDepth    int           `gorm:"-" json:"bus_queue_depth"`     // 串口总线排队请求数
+	BusMaxQueueDepth int           `gorm:"-" json:"bus_max_queue_depth"` // 串口总线最大排队数
+	BusAvgLatency    time.Duration `gorm:"-" json:"bus_avg_latency"`     // 串口总线平均请求时延（含排队）
+	BusMaxLatency    time.Duration `gorm:"-" json:"bus_max_latency"`     // 串口总线最大请求时延（含排队）
 }
 
 // Channel 通道
@@ -33,6 +39,7 @@ type Channel struct {
 	WordOrder     uint          `gorm:"column:word_order" json:"word_order"`         // word ordering
 	SendInterval  time.Duration `gorm:"column:send_interval" json:"send_interval"`   // 指令发送间隔 (ms)
 	PhysicalLink  string        `gorm:"column:physical_link" json:"physical_link"`   // serial、RTUclient、RTUserver、TCPclient、TCPserver
+	Sniffer       bool          `gorm:"column:sniffer" json:"sniffer"`               // 监听模式：只读监听第三方主站的串口总线，不发送任何报文
 	UUID          string        `gorm:"column:uuid;size:36;uniqueIndex;not null" json:"uuid"`
 	Disable       bool          `gorm:"column:disable" json:"disable"` // disable
 	Plugin        string        `gorm:"column:plugin;size:128;not null" json:"plugin"`
@@ -50,6 +57,7 @@ type Channel struct {
 	TCPPort         uint16
 	BackupTCPIPAddr string
 	BackupTCPPort   uint16
+	FailoverAfter   uint // 连续失败多少次后切换主/备地址，0 表示 3
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -39,20 +40,26 @@ const (
 )
 
 type Device struct {
-	ChannelID string
-	Channel   Channel `gorm:"references:UUID"`
+	ChannelID string  `json:"channel_id"`
+	Channel   Channel `gorm:"references:UUID" json:"-"`
 	Base
 	Name            string `gorm:"column:name;size:150;uniqueIndex;not null" json:"name"`
 	DeviceType      string `gorm:"column:device_type;size:128;not null;index" json:"device_type"` // Requirement #4: weak association by type_key (no FK)
-	Transport       string `gorm:"size:16;not null"`                                              // tcp/rtu/rtu_over_tcp...
-	Endpoint        string `gorm:"size:256;not null"`                                             // host:port or /dev/ttyS1
-	SlaveID         int    `gorm:"not null;default:1"`
-	PollIntervalMs  int    `gorm:"not null;default:1000"`
+	Transport       string `gorm:"size:16;not null" json:"transport"`                             // tcp/rtu/rtu_over_tcp...
+	Endpoint        string `gorm:"size:256;not null" json:"endpoint"`                             // host:port or /dev/ttyS1
+	SlaveID         int    `gorm:"not null;default:1" json:"slave_id"`
+	PollIntervalMs  int    `gorm:"not null;default:1000" json:"poll_interval_ms"`
 	SN              string `gorm:"column:sn;size:128;not null" json:"sn"`
 	DevicePlugin    string `gorm:"column:device_plugin;size:128;not null" json:"device_plugin"`
 	SoftwareVersion string `gorm:"column:software_version;size:128;not null" json:"software_version"`
 	Model           string `gorm:"column:model;size:128;not null" json:"model"`
 	Disable         bool   `gorm:"column:disable;size:128;not null" json:"disable"`
+
+	// 读请求合并参数，0 表示使用默认值（协议上限 / 仅合并相邻地址）
+	// Read coalescing overrides; 0 means the default (protocol limit / adjacent addresses only).
+	MaxReadRegs uint16 `gorm:"column:max_read_regs;not null;default:0" json:"max_read_regs"` // 单次最多读取寄存器数 / max registers per read
+	MaxReadBits uint16 `gorm:"column:max_read_bits;not null;default:0" json:"max_read_bits"` // 单次最多读取位数 / max coils per read
+	ReadGap     uint16 `gorm:"column:read_gap;not null;default:0" json:"read_gap"`           // 允许跨越的空地址数 / tolerated address gap
 }
 
 type DeviceState struct {
@@ -88,6 +95,39 @@ type DeviceType struct {
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+// MatchDeviceType 按设备上报的厂商、型号与版本查找设备类型（忽略大小写与首尾空格）；
+// 没有版本一致的类型时，退回到该厂商+型号下唯一的类型。均未找到时返回 gorm.ErrRecordNotFound。
+// MatchDeviceType finds the device type for a device's reported vendor, model
+// and version (ignoring case and surrounding spaces); when no version matches,
+// it falls back to the only type of that vendor and model. It returns
+// gorm.ErrRecordNotFound when nothing matches.
+func MatchDeviceType(db *gorm.DB, vendor, model, version string) (*DeviceType, error) {
+	vendor = strings.ToLower(strings.TrimSpace(vendor))
+	model = strings.ToLower(strings.TrimSpace(model))
+	version = strings.TrimSpace(version)
+	if vendor == "" || model == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var types []DeviceType
+	if err := db.
+		Where("LOWER(TRIM(vendor)) = ? AND LOWER(TRIM(model)) = ?", vendor, model).
+		Order("type_key asc").
+		Find(&types).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range types {
+		if strings.EqualFold(strings.TrimSpace(types[i].Version), version) {
+			return &types[i], nil
+		}
+	}
+	if len(types) == 1 {
+		return &types[0], nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
 // I18nMap is stored as a single JSON column, no extra lang table.
 // English key "en" is required by validation.
 type I18nMap map[string]string
@@ -156,6 +196,10 @@ type DeviceTypePoint struct {
 
 	EnumMapJSON []byte `gorm:"type:json"` // optional enums, e.g. {"0":"Off","1":"On"}
 
+	// optional engineering range; values outside it are flagged out-of-range
+	RangeMin *float64 `gorm:""`
+	RangeMax *float64 `gorm:""`
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
@@ -180,6 +224,8 @@ type PointSpec struct {
 	RW       string     `json:"rw" yaml:"rw"`
 	Unit     string     `json:"unit" yaml:"unit"`
 	NameI18n I18nMap    `json:"name_i18n" yaml:"name_i18n"`
+	Min      *float64   `json:"min" yaml:"min"` // optional engineering range
+	Max      *float64   `json:"max" yaml:"max"`
 	Modbus   ModbusSpec `json:"modbus" yaml:"modbus"`
 }
 
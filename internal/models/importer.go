@@ -156,6 +156,9 @@ func ImportDeviceType(db *gorm.DB, spec TypeSpec, opt ImportOptions) error {
 				Precision: p.Modbus.Precision,
 
 				EnumMapJSON: enumJSON,
+
+				RangeMin: p.Min,
+				RangeMax: p.Max,
 			}
 
 			if err := tx.Clauses(clause.OnConflict{
@@ -168,6 +171,7 @@ func ImportDeviceType(db *gorm.DB, spec TypeSpec, opt ImportOptions) error {
 					"fc", "address", "quantity", "data_type", "bit_index", "byte_order",
 					"scale", "offset", "precision",
 					"enum_map_json",
+					"range_min", "range_max",
 					"updated_at",
 					"deleted_at", // important: revive if previously deleted
 				}),
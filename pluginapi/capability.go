@@ -0,0 +1,137 @@
+package pluginapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+	"github.com/fluxionwatt/gridbeat/utils/modbus"
+)
+
+// 可选能力：实例按需实现，宿主通过类型断言使用
+// Optional capabilities: instances implement them as needed and the host uses them via type assertion.
+
+// 写入在发送到总线之前被拒绝时返回的错误，宿主据此区分请求错误与总线故障
+// Errors returned when a write is rejected before anything is sent on the bus;
+// the host uses them to tell bad requests from bus failures.
+var (
+	ErrReadOnlyPoint        = errors.New("point is read-only")
+	ErrBroadcastUnsupported = errors.New("broadcast requires a serial channel")
+	ErrSnifferMode          = errors.New("channel is in sniffer mode, the bus is read-only")
+)
+
+// WriteRequest 描述一次点位写入
+// WriteRequest describes a single point write.
+type WriteRequest struct {
+	Device models.Device
+	Point  models.DeviceTypePoint
+	Value  models.Scalar // 工程值 / engineering value
+
+	// Readback 为 true 时写入后回读确认
+	// Readback asks for a read after the write to confirm the value.
+	Readback bool
+}
+
+// WriteResult 是点位写入的结果
+// WriteResult is the outcome of a point write.
+type WriteResult struct {
+	Old      models.Scalar `json:"old"`      // 写入前的值（未知时为空）/ value before the write (empty if unknown)
+	New      models.Scalar `json:"new"`      // 回读值或写入值 / read-back value, or the written value
+	Verified bool          `json:"verified"` // 回读与写入一致 / read-back matched the write
+}
+
+// PointWriter 由支持下发控制命令的南向实例实现
+// PointWriter is implemented by south instances that can send control commands.
+type PointWriter interface {
+	WritePoint(ctx context.Context, req WriteRequest) (WriteResult, error)
+}
+
+// BroadcastRequest 描述一次广播写入（站号 0，所有从站执行、均不应答）
+// BroadcastRequest describes a broadcast write (unit id 0, executed by every
+// slave on the bus, answered by none).
+type BroadcastRequest struct {
+	Point models.DeviceTypePoint
+	Value models.Scalar // 工程值 / engineering value
+}
+
+// BroadcastWriter 由能在串行总线上广播写入的南向实例实现，返回按写入数据解码出的值；
+// 广播无应答，无法确认从站是否执行
+// BroadcastWriter is implemented by south instances that can broadcast writes
+// on a serial bus; it returns the value the written data decodes to. Broadcasts
+// are unanswered, so execution by the slaves cannot be confirmed.
+type BroadcastWriter interface {
+	Broadcast(ctx context.Context, req BroadcastRequest) (models.Scalar, error)
+}
+
+// Reloader 由需要在关联数据（如设备列表）变化后重新加载的实例实现，不重建实例
+// Reloader is implemented by instances that re-read related data (such as
+// their device list) after it changes, without being recreated.
+type Reloader interface {
+	Reload() error
+}
+
+// BusForwarder 由南向 Modbus 实例实现：在其总线上执行一次转发请求，与轮询共享同一仲裁，
+// 总线不可用时返回 modbus.ErrGWPathUnavailable
+// BusForwarder is implemented by south Modbus instances: it runs one forwarded
+// request on their bus through the same arbitration as polling, and returns
+// modbus.ErrGWPathUnavailable when the bus is not available.
+type BusForwarder interface {
+	Forward(ctx context.Context, slaveID uint8, fn func(client *modbus.ModbusClient) error) error
+}
+
+// FrameTraceReader 由能在调试窗口内抓取原始报文的南向 Modbus 实例实现
+// FrameTraceReader is implemented by south Modbus instances that capture raw
+// frames while their debug window is open.
+type FrameTraceReader interface {
+	Trace() []modbus.Frame
+}
+
+// DeviceIdentity 是设备通过 Modbus 读设备标识（0x2B/0x0E）上报的基本信息
+// DeviceIdentity is the basic information a device reports through Modbus
+// Read Device Identification (0x2B/0x0E).
+type DeviceIdentity struct {
+	Vendor   string `json:"vendor"`   // VendorName
+	Product  string `json:"product"`  // ProductCode
+	Revision string `json:"revision"` // MajorMinorRevision
+}
+
+// DeviceIdentifier 由能读取设备标识的南向实例实现
+// DeviceIdentifier is implemented by south instances that can read a device's identification.
+type DeviceIdentifier interface {
+	IdentifyDevice(ctx context.Context, slaveID int) (DeviceIdentity, error)
+}
+
+// FaultProfile 描述模拟器的故障注入，用于测试重试、主备切换与告警逻辑
+// FaultProfile describes the faults a simulator injects, for testing retry,
+// failover and alarm logic.
+type FaultProfile struct {
+	Units []uint8 `json:"units,omitempty"` // 受影响的站号，为空表示全部 / affected unit ids, empty means all
+
+	Latency time.Duration `json:"latency"` // 附加应答延迟 / added response latency
+	Jitter  time.Duration `json:"jitter"`  // 额外随机延迟 0..jitter / extra random latency, 0..jitter
+
+	// 以下概率取值 0..1，三者互斥，总和不超过 1
+	// The rates below are probabilities, 0..1; the faults exclude each other
+	// and the rates add up to at most 1.
+	ExceptionRate float64  `json:"exception_rate"`       // 以异常应答 / answer with an exception
+	Exceptions    []string `json:"exceptions,omitempty"` // 随机异常的候选，默认 busy / exceptions to pick from, busy by default
+	DropRate      float64  `json:"drop_rate"`            // 不应答 / leave unanswered
+	BadCRCRate    float64  `json:"bad_crc_rate"`         // 损坏 CRC（仅 RTU）/ corrupt the CRC (RTU only)
+
+	// Script 按请求顺序循环执行，优先于随机故障：ok、drop、bad_crc 或异常名
+	// Script applies to successive requests in a loop, instead of the random
+	// faults: ok, drop, bad_crc or an exception name.
+	Script []string `json:"script,omitempty"`
+
+	DisconnectEvery time.Duration `json:"disconnect_every"` // 周期性断开（停止服务），0 表示不断开 / periodic disconnect (server stopped), 0 disables
+	DisconnectFor   time.Duration `json:"disconnect_for"`   // 每次断开的时长，0 表示 5s / length of each disconnect, 0 means 5s
+}
+
+// FaultInjector 由支持故障注入的模拟器实例实现，故障配置可在运行时修改
+// FaultInjector is implemented by simulator instances that inject faults;
+// the profile can change at runtime.
+type FaultInjector interface {
+	Faults() FaultProfile
+	SetFaults(p FaultProfile) error
+}
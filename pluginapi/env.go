@@ -3,6 +3,7 @@ package pluginapi
 import (
 	"sync"
 
+	"github.com/fluxionwatt/gridbeat/core/rtdb"
 	"github.com/fluxionwatt/gridbeat/internal/config"
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/sirupsen/logrus"
@@ -24,6 +25,14 @@ type HostEnv struct {
 	PluginLog logrus.FieldLogger
 	MQTT      *mqtt.Server
 	WG        *sync.WaitGroup
+
+	// RTDB：实时点位库，南向插件写入采集值
+	// RTDB: real-time point database that south plugins write values into.
+	RTDB *rtdb.DB
+
+	// Instances：查找其他运行中的实例（如网关查找通道实例）
+	// Instances: looks up other running instances (e.g. the gateway finding channel instances).
+	Instances InstanceLookup
 }
 
 const depsKey = "__global_deps__"
@@ -37,6 +37,12 @@ type Instance interface {
 	Get() any
 }
 
+// InstanceLookup 按类型与 ID 查找运行中的实例
+// InstanceLookup finds a running instance by type and ID.
+type InstanceLookup interface {
+	Get(typ, id string) (Instance, bool)
+}
+
 // Factory 表示某个插件类型（驱动），负责创建多个实例
 // Factory represents a plugin type (driver), responsible for creating instances.
 type Factory interface {
@@ -0,0 +1,204 @@
+package modbus
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Function codes, for use in access control rules.
+const (
+	FC_READ_COILS                    uint8 = fcReadCoils
+	FC_READ_DISCRETE_INPUTS          uint8 = fcReadDiscreteInputs
+	FC_READ_HOLDING_REGISTERS        uint8 = fcReadHoldingRegisters
+	FC_READ_INPUT_REGISTERS          uint8 = fcReadInputRegisters
+	FC_WRITE_SINGLE_COIL             uint8 = fcWriteSingleCoil
+	FC_WRITE_SINGLE_REGISTER         uint8 = fcWriteSingleRegister
+	FC_WRITE_MULTIPLE_COILS          uint8 = fcWriteMultipleCoils
+	FC_WRITE_MULTIPLE_REGISTERS      uint8 = fcWriteMultipleRegisters
+	FC_READ_FILE_RECORD              uint8 = fcReadFileRecord
+	FC_WRITE_FILE_RECORD             uint8 = fcWriteFileRecord
+	FC_MASK_WRITE_REGISTER           uint8 = fcMaskWriteRegister
+	FC_READ_WRITE_MULTIPLE_REGISTERS uint8 = fcReadWriteMultipleRegisters
+	FC_READ_FIFO_QUEUE               uint8 = fcReadFifoQueue
+	FC_ENCAPSULATED_INTERFACE        uint8 = fcEncapsulatedInterface
+)
+
+// ACLRange is an inclusive range of addresses.
+type ACLRange struct {
+	First	uint16
+	Last	uint16
+}
+
+// ACLRule allows or denies the requests matching all of its criteria.
+type ACLRule struct {
+	// Roles lists the client roles the rule applies to. An empty list
+	// matches all clients, including those without a role (clients of
+	// transports other than tcp+tls, or with a certificate lacking the
+	// Modbus Role extension).
+	Roles		[]string
+	// Units lists the unit ids the rule applies to. An empty list matches
+	// all unit ids.
+	Units		[]uint8
+	// FunctionCodes lists the function codes the rule applies to (see the
+	// FC_* constants). An empty list matches all function codes.
+	FunctionCodes	[]uint8
+	// Ranges lists the address ranges the rule applies to, file numbers
+	// for file record requests. An empty list matches all addresses, as
+	// well as requests without any (device identification).
+	// Allow rules match requests lying entirely within one of the ranges,
+	// deny rules match requests touching any of them.
+	Ranges		[]ACLRange
+	// Allow sets the verdict of the rule.
+	Allow		bool
+}
+
+// ACL is an ordered list of access control rules, evaluated by the server
+// before passing a request to the handler: the first matching rule decides.
+// Denied requests are logged and answered with an illegal function
+// exception when the rule covers all addresses (or when the role has no
+// access to the function code at all), and with an illegal data address
+// exception otherwise.
+// Read/write multiple registers requests must be allowed for both their
+// read and their write ranges, file record requests for every file number
+// they reference.
+type ACL struct {
+	Rules		[]ACLRule
+	// DefaultAllow sets the verdict for requests no rule matches.
+	DefaultAllow	bool
+}
+
+// aclSpan is a range of addresses touched by a request.
+type aclSpan struct {
+	addr		uint16
+	quantity	uint16
+}
+
+// Validates the rules of the ACL.
+func (acl *ACL) validate() (err error) {
+	for i, rule := range acl.Rules {
+		for _, r := range rule.Ranges {
+			if r.Last < r.First {
+				err = fmt.Errorf("acl rule #%v: range %v-%v is empty",
+						 i, r.First, r.Last)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Evaluates the ACL for a request. spans lists the address ranges touched by
+// the request, none for requests without addresses.
+func (acl *ACL) check(role string, unitId uint8, functionCode uint8,
+		      spans []aclSpan) (err error) {
+	if len(spans) == 0 {
+		err	= acl.checkSpan(role, unitId, functionCode, nil)
+		return
+	}
+
+	for i := range spans {
+		err	= acl.checkSpan(role, unitId, functionCode, &spans[i])
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Evaluates the ACL for one address range of a request.
+func (acl *ACL) checkSpan(role string, unitId uint8, functionCode uint8,
+			  span *aclSpan) (err error) {
+	for _, rule := range acl.Rules {
+		if !rule.appliesTo(role, unitId, functionCode) ||
+		   !rule.covers(span) {
+			continue
+		}
+
+		switch {
+		case rule.Allow:
+			err	= nil
+		case len(rule.Ranges) == 0:
+			err	= ErrIllegalFunction
+		default:
+			err	= ErrIllegalDataAddress
+		}
+		return
+	}
+
+	if acl.DefaultAllow {
+		return
+	}
+
+	// no rule matched: report an address error if the role may use the
+	// function code elsewhere, an illegal function otherwise
+	err	= ErrIllegalFunction
+	for _, rule := range acl.Rules {
+		if rule.Allow && rule.appliesTo(role, unitId, functionCode) {
+			err	= ErrIllegalDataAddress
+			break
+		}
+	}
+
+	return
+}
+
+// Returns true if the rule applies to the role, unit id and function code.
+func (rule *ACLRule) appliesTo(role string, unitId uint8, functionCode uint8) (yes bool) {
+	yes	= (len(rule.Roles) == 0 || slices.Contains(rule.Roles, role)) &&
+		  (len(rule.Units) == 0 || slices.Contains(rule.Units, unitId)) &&
+		  (len(rule.FunctionCodes) == 0 ||
+		   slices.Contains(rule.FunctionCodes, functionCode))
+
+	return
+}
+
+// Returns true if the rule's address ranges match the span (see ACLRule).
+func (rule *ACLRule) covers(span *aclSpan) (yes bool) {
+	var first	uint32
+	var last	uint32
+
+	if len(rule.Ranges) == 0 {
+		yes	= true
+		return
+	}
+
+	// requests without addresses only match rules without ranges
+	if span == nil {
+		return
+	}
+
+	first	= uint32(span.addr)
+	last	= uint32(span.addr) + uint32(span.quantity) - 1
+
+	for _, r := range rule.Ranges {
+		if rule.Allow {
+			yes	= first >= uint32(r.First) && last <= uint32(r.Last)
+		} else {
+			yes	= first <= uint32(r.Last) && last >= uint32(r.First)
+		}
+		if yes {
+			return
+		}
+	}
+
+	return
+}
+
+// Returns the span as a first-last address range.
+func (span aclSpan) String() (s string) {
+	s	= fmt.Sprintf("%v-%v", span.addr,
+			      uint32(span.addr) + uint32(span.quantity) - 1)
+
+	return
+}
+
+// Returns the file numbers referenced by file record requests, one span each.
+func fileRecordSpans(records []FileRecord) (spans []aclSpan) {
+	for _, record := range records {
+		spans	= append(spans, aclSpan{record.FileNumber, 1})
+	}
+
+	return
+}
@@ -0,0 +1,105 @@
+package modbus
+
+import (
+	"testing"
+)
+
+func TestACLCheck(t *testing.T) {
+	var acl	*ACL
+	var err	error
+
+	// operators may write holding registers 100-199 except 150-159,
+	// everyone may read anything, everything else is denied
+	acl = &ACL{
+		Rules: []ACLRule{
+			{
+				Roles:         []string{"operator"},
+				FunctionCodes: []uint8{FC_WRITE_SINGLE_REGISTER, FC_WRITE_MULTIPLE_REGISTERS},
+				Ranges:        []ACLRange{{150, 159}},
+				Allow:         false,
+			},
+			{
+				Roles:         []string{"operator"},
+				FunctionCodes: []uint8{FC_WRITE_SINGLE_REGISTER, FC_WRITE_MULTIPLE_REGISTERS,
+				                       FC_READ_WRITE_MULTIPLE_REGISTERS},
+				Ranges:        []ACLRange{{100, 199}},
+				Allow:         true,
+			},
+			{
+				FunctionCodes: []uint8{FC_READ_HOLDING_REGISTERS, FC_READ_INPUT_REGISTERS,
+				                       FC_READ_WRITE_MULTIPLE_REGISTERS, FC_ENCAPSULATED_INTERFACE},
+				Allow:         true,
+			},
+			{
+				Units:         []uint8{9},
+				Allow:         false,
+			},
+		},
+	}
+
+	for i, tc := range []struct {
+		role	string
+		unitId	uint8
+		fc	uint8
+		spans	[]aclSpan
+		expect	error
+	}{
+		// reads are allowed to anyone
+		{"", 1, FC_READ_HOLDING_REGISTERS, []aclSpan{{0, 125}}, nil},
+		{"operator", 1, FC_READ_INPUT_REGISTERS, []aclSpan{{65000, 10}}, nil},
+		{"", 1, FC_ENCAPSULATED_INTERFACE, nil, nil},
+		// writes within the allowed range
+		{"operator", 1, FC_WRITE_SINGLE_REGISTER, []aclSpan{{100, 1}}, nil},
+		{"operator", 1, FC_WRITE_MULTIPLE_REGISTERS, []aclSpan{{190, 10}}, nil},
+		// writes by other roles
+		{"", 1, FC_WRITE_SINGLE_REGISTER, []aclSpan{{100, 1}}, ErrIllegalFunction},
+		{"viewer", 1, FC_WRITE_MULTIPLE_REGISTERS, []aclSpan{{100, 2}}, ErrIllegalFunction},
+		// writes touching the denied range
+		{"operator", 1, FC_WRITE_SINGLE_REGISTER, []aclSpan{{155, 1}}, ErrIllegalDataAddress},
+		{"operator", 1, FC_WRITE_MULTIPLE_REGISTERS, []aclSpan{{145, 10}}, ErrIllegalDataAddress},
+		// writes spilling out of the allowed range
+		{"operator", 1, FC_WRITE_MULTIPLE_REGISTERS, []aclSpan{{195, 10}}, ErrIllegalDataAddress},
+		{"operator", 1, FC_WRITE_SINGLE_REGISTER, []aclSpan{{99, 1}}, ErrIllegalDataAddress},
+		// both ranges of read/write requests are checked
+		{"operator", 1, FC_READ_WRITE_MULTIPLE_REGISTERS, []aclSpan{{0, 10}, {100, 10}}, nil},
+		{"", 1, FC_READ_WRITE_MULTIPLE_REGISTERS, []aclSpan{{0, 10}, {100, 10}}, nil},
+		// function codes no rule mentions
+		{"operator", 1, FC_WRITE_SINGLE_COIL, []aclSpan{{0, 1}}, ErrIllegalFunction},
+		{"operator", 9, FC_WRITE_SINGLE_COIL, []aclSpan{{0, 1}}, ErrIllegalFunction},
+	} {
+		err = acl.check(tc.role, tc.unitId, tc.fc, tc.spans)
+		if err != tc.expect {
+			t.Errorf("case #%v: expected %v, got: %v", i, tc.expect, err)
+		}
+	}
+
+	// read/write requests need the read range allowed too
+	acl.Rules[2].Ranges = []ACLRange{{0, 99}}
+	err = acl.check("operator", 1, FC_READ_WRITE_MULTIPLE_REGISTERS,
+			[]aclSpan{{200, 10}, {100, 10}})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected %v, got: %v", ErrIllegalDataAddress, err)
+	}
+
+	// requests without addresses do not match rules with ranges
+	err = acl.check("", 1, FC_ENCAPSULATED_INTERFACE, nil)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected %v, got: %v", ErrIllegalDataAddress, err)
+	}
+
+	// unmatched requests are allowed when asked to
+	acl.DefaultAllow = true
+	err = acl.check("operator", 1, FC_WRITE_SINGLE_COIL, []aclSpan{{0, 1}})
+	if err != nil {
+		t.Errorf("expected nil, got: %v", err)
+	}
+
+	// empty ranges are rejected
+	acl.Rules[0].Ranges = []ACLRange{{10, 9}}
+	err = acl.validate()
+	if err == nil {
+		t.Errorf("validate() should have failed")
+	}
+
+	return
+}
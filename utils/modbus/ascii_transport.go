@@ -0,0 +1,279 @@
+package modbus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// ':' + 2 hex chars per byte of unit id, PDU (253 bytes max) and LRC
+	// + CR LF
+	maxASCIIFrameLength	int = 1 + 2 * (1 + 253 + 1) + 2
+)
+
+type asciiTransport struct {
+	logger		*logger
+	link		rtuLink
+	timeout		time.Duration
+	turnaround	time.Duration
+	rxbuf		[]byte
+	rxpos		int
+	rxlen		int
+	tracer		FrameTracer
+}
+
+// Returns a new ASCII transport.
+func newASCIITransport(link rtuLink, addr string, timeout time.Duration, customLogger *log.Logger) (at *asciiTransport) {
+	at = &asciiTransport{
+		logger:		newLogger(fmt.Sprintf("ascii-transport(%s)", addr), customLogger),
+		link:		link,
+		rxbuf:		make([]byte, maxASCIIFrameLength),
+		timeout:	timeout,
+	}
+
+	return
+}
+
+// Closes the ascii link.
+func (at *asciiTransport) Close() (err error) {
+	err = at.link.Close()
+
+	return
+}
+
+// Runs a request across the ascii link and returns a response.
+func (at *asciiTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
+	var adu	[]byte
+
+	// set an i/o deadline on the link
+	err	= at.link.SetDeadline(time.Now().Add(at.timeout))
+	if err != nil {
+		return
+	}
+
+	// drop any stale data left over from a previous exchange
+	at.rxpos	= at.rxlen
+
+	// build an ASCII ADU out of the request object and
+	// send it on the wire
+	adu	= at.assembleASCIIFrame(req)
+	traceFrame(at.tracer, FRAME_ASCII, FRAME_TX, adu)
+
+	_, err	= at.link.Write(adu)
+	if err != nil {
+		return
+	}
+
+	// broadcast requests (unit id 0) get no response: wait out the
+	// turnaround delay to give devices time to process the request
+	if req.unitId == 0x00 {
+		time.Sleep(at.turnaround)
+		return
+	}
+
+	// read the response back from the wire
+	res, err = at.readASCIIFrame()
+
+	return
+}
+
+// Reads a request from the ascii link.
+// As ASCII frames are delimited, frames which fail to decode are logged
+// and skipped rather than returned as errors: only link errors (including
+// idle timeouts) cause ReadRequest to return an error.
+func (at *asciiTransport) ReadRequest() (req *pdu, err error) {
+	for {
+		// set an i/o deadline on the link
+		err	= at.link.SetDeadline(time.Now().Add(at.timeout))
+		if err != nil {
+			return
+		}
+
+		req, err = at.readASCIIFrame()
+		switch err {
+		case ErrBadLRC, ErrShortFrame, ErrProtocolError:
+			at.logger.Warningf("discarding invalid frame: %v", err)
+			continue
+		}
+
+		return
+	}
+}
+
+// Writes a response to the ascii link.
+func (at *asciiTransport) WriteResponse(res *pdu) (err error) {
+	_, err	= at.link.Write(at.assembleASCIIFrame(res))
+
+	return
+}
+
+// Waits for, reads and decodes a frame from the ascii link.
+func (at *asciiTransport) readASCIIFrame() (res *pdu, err error) {
+	var line	string
+	var raw		[]byte
+	var lrc		lrc
+
+	// skip anything up to the start of frame character
+	line, err = at.readLine(':')
+	if err != nil {
+		return
+	}
+	if len(line) > 1 {
+		at.logger.Warningf("discarding %v bytes before start of frame",
+				   len(line) - 1)
+	}
+
+	// read up to the end of frame (CR LF)
+	line, err = at.readLine('\n')
+	if err != nil {
+		return
+	}
+	traceFrame(at.tracer, FRAME_ASCII, FRAME_RX, []byte(":" + line))
+
+	if !strings.HasSuffix(line, "\r\n") {
+		err	= ErrProtocolError
+		return
+	}
+	line	= line[:len(line) - 2]
+
+	// decode the hex-encoded unit id, function code, payload and LRC
+	raw, err = hex.DecodeString(line)
+	if err != nil {
+		err	= ErrProtocolError
+		return
+	}
+
+	if len(raw) < 3 {
+		err	= ErrShortFrame
+		return
+	}
+
+	// compute the LRC on the entire frame, excluding the LRC
+	lrc.init()
+	lrc.add(raw[0:len(raw) - 1])
+
+	// compare LRC values
+	if !lrc.isEqual(raw[len(raw) - 1]) {
+		err	= ErrBadLRC
+		return
+	}
+
+	res	= &pdu{
+		unitId:       raw[0],
+		functionCode: raw[1],
+		payload:      raw[2:len(raw) - 1],
+	}
+
+	return
+}
+
+// Reads up to and including delim, enforcing the maximum frame length.
+func (at *asciiTransport) readLine(delim byte) (line string, err error) {
+	var b	byte
+	var sb	strings.Builder
+
+	for {
+		b, err	= at.readByte()
+		if err != nil {
+			return
+		}
+
+		sb.WriteByte(b)
+		if b == delim {
+			line	= sb.String()
+			return
+		}
+
+		// a new start of frame character aborts the current frame
+		if b == ':' && delim == '\n' {
+			at.logger.Warningf("unexpected start of frame, resyncing")
+			sb.Reset()
+			continue
+		}
+
+		if sb.Len() >= maxASCIIFrameLength {
+			err	= ErrProtocolError
+			return
+		}
+	}
+}
+
+// Reads a single byte from the ascii link, buffering whatever the link
+// returns. Empty reads (e.g. masked serial port timeouts) are retried until
+// either data comes in or the link returns an error.
+func (at *asciiTransport) readByte() (b byte, err error) {
+	for at.rxpos == at.rxlen {
+		at.rxpos	= 0
+		at.rxlen, err	= at.link.Read(at.rxbuf)
+		if at.rxlen > 0 {
+			// hand out buffered data before any error
+			err	= nil
+			break
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	b	= at.rxbuf[at.rxpos]
+	at.rxpos++
+
+	return
+}
+
+// Turns a PDU object into an ASCII frame.
+func (at *asciiTransport) assembleASCIIFrame(p *pdu) (adu []byte) {
+	var lrc		lrc
+	var raw		[]byte
+
+	raw	= append(raw, p.unitId, p.functionCode)
+	raw	= append(raw, p.payload...)
+
+	// compute the LRC
+	lrc.init()
+	lrc.add(raw)
+	raw	= append(raw, lrc.value())
+
+	// start of frame, hex-encoded (upper case) frame and end of frame
+	adu	= append(adu, ':')
+	adu	= append(adu, strings.ToUpper(hex.EncodeToString(raw))...)
+	adu	= append(adu, '\r', '\n')
+
+	return
+}
+
+type lrc struct {
+	sum uint8
+}
+
+// Prepares the LRC generator for use.
+func (l *lrc) init() {
+	l.sum	= 0
+
+	return
+}
+
+// Adds the given bytes to the LRC.
+func (l *lrc) add(in []byte) {
+	for _, b := range in {
+		l.sum	+= b
+	}
+
+	return
+}
+
+// Returns the LRC, i.e. the two's complement of the sum of all bytes.
+func (l *lrc) value() (value byte) {
+	value	= -l.sum
+
+	return
+}
+
+func (l *lrc) isEqual(value byte) (yes bool) {
+	yes	= (value == l.value())
+
+	return
+}
@@ -0,0 +1,197 @@
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLRC(t *testing.T) {
+	var l	lrc
+
+	// example from the modbus serial line spec: unit id 0x11, read holding
+	// registers, addr 0x006b, quantity 3
+	l.init()
+	l.add([]byte{0x11, 0x03, 0x00, 0x6b, 0x00, 0x03})
+	if l.value() != 0x7e {
+		t.Errorf("expected 0x7e, got 0x%02x", l.value())
+	}
+	if !l.isEqual(0x7e) {
+		t.Errorf("isEqual(0x7e) should have returned true")
+	}
+	if l.isEqual(0x7f) {
+		t.Errorf("isEqual(0x7f) should have returned false")
+	}
+
+	// the LRC of an empty frame is zero
+	l.init()
+	if l.value() != 0x00 {
+		t.Errorf("expected 0x00, got 0x%02x", l.value())
+	}
+
+	return
+}
+
+func TestAssembleASCIIFrame(t *testing.T) {
+	var at		*asciiTransport
+	var frame	[]byte
+
+	at = &asciiTransport{}
+
+	frame = at.assembleASCIIFrame(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	if string(frame) != ":1103006B00037E\r\n" {
+		t.Errorf("unexpected frame: %q", frame)
+	}
+
+	frame = at.assembleASCIIFrame(&pdu{
+		unitId:       0xf7,
+		functionCode: 0x86,
+		payload:      []byte{0x02},
+	})
+	if string(frame) != ":F7860281\r\n" {
+		t.Errorf("unexpected frame: %q", frame)
+	}
+
+	return
+}
+
+func TestASCIITransportReadASCIIFrame(t *testing.T) {
+	var at		*asciiTransport
+	var p1, p2	net.Conn
+	var txchan	chan []byte
+	var err		error
+	var res		*pdu
+
+	txchan = make(chan []byte, 4)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	at = newASCIITransport(p2, "", 10 * time.Millisecond, nil)
+
+	// arm a read/write timeout to avoid deadlocked tests
+	p2.SetDeadline(time.Now().Add(100*time.Millisecond))
+
+	// read a valid response (illegal data address), with garbage before
+	// the start of frame and lower case hex digits
+	txchan <- []byte("\x00\xff:3182024b\r\n")
+	res, err = at.readASCIIFrame()
+	if err != nil {
+		t.Errorf("readASCIIFrame() should have succeeded, got %v", err)
+	}
+	if res.unitId != 0x31 {
+		t.Errorf("expected 0x31 as unit id, got 0x%02x", res.unitId)
+	}
+	if res.functionCode != 0x82 {
+		t.Errorf("expected 0x82 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 1 || res.payload[0] != 0x02 {
+		t.Errorf("expected {0x02} as payload, got %v", res.payload)
+	}
+
+	// read a frame with a bad lrc
+	txchan <- []byte(":3182024A\r\n")
+	res, err = at.readASCIIFrame()
+	if err != ErrBadLRC {
+		t.Errorf("readASCIIFrame() should have returned ErrBadLRC, got %v", err)
+	}
+
+	// read a frame with non-hex characters
+	txchan <- []byte(":3182024G\r\n")
+	res, err = at.readASCIIFrame()
+	if err != ErrProtocolError {
+		t.Errorf("readASCIIFrame() should have returned ErrProtocolError, got %v", err)
+	}
+
+	// read a truncated frame followed by a valid one: the second start of
+	// frame character should cause the transport to resync
+	txchan <- []byte(":3103:310304112233441E\r\n")
+	res, err = at.readASCIIFrame()
+	if err != nil {
+		t.Errorf("readASCIIFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x03 {
+		t.Errorf("expected 0x03 as function code, got 0x%02x", res.functionCode)
+	}
+	for i, b := range []byte{0x04, 0x11, 0x22, 0x33, 0x44} {
+		if res.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+				 b, i, res.payload[i])
+		}
+	}
+
+	// read a frame too short to hold a function code
+	txchan <- []byte(":3131\r\n")
+	res, err = at.readASCIIFrame()
+	if err != ErrShortFrame {
+		t.Errorf("readASCIIFrame() should have returned ErrShortFrame, got %v", err)
+	}
+
+	p1.Close()
+	p2.Close()
+
+	return
+}
+
+func TestASCIIOverTCPClientAndServer(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var th		*tcpTestHandler
+	var err		error
+	var regs	[]uint16
+
+	th = &tcpTestHandler{}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"asciiovertcp://localhost:5508",
+		MaxClients:	2,
+	}, th)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"asciiovertcp://localhost:5508",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	err		= client.WriteRegisters(2, []uint16{0x1234, 0xabcd})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	regs, err	= client.ReadRegisters(1, 3, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 3 || regs[0] != 0 || regs[1] != 0x1234 || regs[2] != 0xabcd {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// exceptions are carried over ascii frames too
+	_, err		= client.ReadRegisters(9, 2, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
@@ -0,0 +1,408 @@
+package modbus
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Bus priorities, used to order requests queued on a shared serial bus.
+// Requests of higher priority run first, requests of equal priority run in
+// the order they were queued.
+// Arbitration is per process only: it orders the requests of the clients of
+// this process opened on the same serial device, and knows nothing of other
+// processes or masters on the bus.
+type Priority uint
+
+const (
+	PRIORITY_POLL     Priority = 1 // reads (default)
+	PRIORITY_WRITE    Priority = 2 // writes, which never run below this priority
+)
+
+// Serial bus statistics, as returned by ModbusClient.BusStats().
+type BusStats struct {
+	Device        string        // serial device of the bus
+	Clients       int           // number of clients sharing the bus
+	QueueDepth    int           // number of requests waiting for the bus
+	MaxQueueDepth int           // highest queue depth seen
+	Requests      uint64        // number of requests run on the bus
+	AvgWait       time.Duration // mean time requests spent queued
+	MaxWait       time.Duration // longest time a request spent queued
+	AvgLatency    time.Duration // mean time from queueing to response
+	MaxLatency    time.Duration // longest time from queueing to response
+}
+
+// Serial buses currently open, keyed by serial device.
+var (
+	busesLock	sync.Mutex
+	buses		= map[string]*serialBus{}
+)
+
+type busConfig struct {
+	serial		serialPortConfig
+	transportType	transportType
+}
+
+// A serial bus, owning the serial port and shared by all clients of the same
+// device in this process. Requests from all clients go through a single
+// priority queue, served one at a time by a dedicated goroutine.
+type serialBus struct {
+	conf		busConfig
+	logger		*logger
+	link		rtuLink
+	exec		func(br *busRequest) (*pdu, error)
+	clients		int // protected by busesLock
+
+	lock		sync.Mutex
+	queue		busQueue
+	seq		uint64
+	wake		chan struct{}
+	done		chan struct{}
+	lastFrame	time.Time
+	stats		BusStats
+	totalWait	time.Duration
+	totalLatency	time.Duration
+}
+
+// A request queued on a serial bus.
+type busRequest struct {
+	req		*pdu
+	priority	Priority
+	timeout		time.Duration
+	silence		time.Duration
+	turnaround	time.Duration
+	tracer		FrameTracer
+	seq		uint64
+	queued		time.Time
+	res		*pdu
+	err		error
+	done		chan struct{}
+}
+
+// Returns the bus of the configured serial device, opening it if no other
+// client is using it yet.
+func acquireBus(conf *busConfig, customLogger *log.Logger) (sb *serialBus, err error) {
+	var spw	*serialPortWrapper
+
+	busesLock.Lock()
+	defer busesLock.Unlock()
+
+	sb	= buses[conf.serial.Device]
+	if sb != nil {
+		// all clients of a bus must agree on the serial settings
+		if sb.conf != *conf {
+			sb.logger.Errorf("bus already open with different serial settings")
+			err	= ErrConfigurationError
+			sb	= nil
+			return
+		}
+		sb.clients++
+		return
+	}
+
+	spw	= newSerialPortWrapper(&serialPortConfig{
+		Device:		conf.serial.Device,
+		Speed:		conf.serial.Speed,
+		DataBits:	conf.serial.DataBits,
+		Parity:		conf.serial.Parity,
+		StopBits:	conf.serial.StopBits,
+	})
+
+	// open the serial device
+	err	= spw.Open()
+	if err != nil {
+		return
+	}
+
+	// discard potentially stale serial data
+	discard(spw)
+
+	sb, err	= newSerialBus(conf, spw, customLogger)
+	if err != nil {
+		spw.Close()
+		return
+	}
+
+	buses[conf.serial.Device]	= sb
+
+	return
+}
+
+// Returns a new bus serving requests over link, with a single client.
+func newSerialBus(conf *busConfig, link rtuLink, customLogger *log.Logger) (sb *serialBus, err error) {
+	sb	= &serialBus{
+		conf:		*conf,
+		logger:		newLogger(fmt.Sprintf("serial-bus(%s)", conf.serial.Device), customLogger),
+		link:		link,
+		clients:	1,
+		wake:		make(chan struct{}, 1),
+		done:		make(chan struct{}),
+	}
+	sb.stats.Device	= conf.serial.Device
+
+	// a single transport serves all clients, so that framing state (e.g.
+	// RTU inter-frame delays) is tracked across clients
+	switch conf.transportType {
+	case modbusRTU:
+		var rt	= newRTUTransport(link, conf.serial.Device, conf.serial.Speed, 0, customLogger)
+
+		sb.exec	= func(br *busRequest) (*pdu, error) {
+			rt.timeout	= br.timeout
+			rt.turnaround	= br.turnaround
+			rt.tracer	= br.tracer
+			return rt.ExecuteRequest(br.req)
+		}
+
+	case modbusASCII:
+		var at	= newASCIITransport(link, conf.serial.Device, 0, customLogger)
+
+		sb.exec	= func(br *busRequest) (*pdu, error) {
+			at.timeout	= br.timeout
+			at.turnaround	= br.turnaround
+			at.tracer	= br.tracer
+			return at.ExecuteRequest(br.req)
+		}
+
+	default:
+		err	= ErrConfigurationError
+		sb	= nil
+		return
+	}
+
+	go sb.run()
+
+	return
+}
+
+// Releases a client's hold on the bus, closing the serial port once the last
+// client is gone.
+func (sb *serialBus) release() {
+	busesLock.Lock()
+	defer busesLock.Unlock()
+
+	sb.clients--
+	if sb.clients > 0 {
+		return
+	}
+
+	delete(buses, sb.conf.serial.Device)
+	// let the bus goroutine finish the request in flight (if any) and
+	// close the port
+	close(sb.done)
+
+	return
+}
+
+// Queues a request and waits for its response.
+func (sb *serialBus) execute(br *busRequest) (res *pdu, err error) {
+	br.queued	= time.Now()
+	br.done		= make(chan struct{})
+
+	sb.lock.Lock()
+	sb.seq++
+	br.seq	= sb.seq
+	heap.Push(&sb.queue, br)
+	if sb.queue.Len() > sb.stats.MaxQueueDepth {
+		sb.stats.MaxQueueDepth	= sb.queue.Len()
+	}
+	sb.lock.Unlock()
+
+	// wake the bus goroutine up if it is idle
+	select {
+	case sb.wake <- struct{}{}:
+	default:
+	}
+
+	<-br.done
+	res, err	= br.res, br.err
+
+	return
+}
+
+// Serves queued requests, highest priority first, until the last client
+// releases the bus.
+func (sb *serialBus) run() {
+	var br		*busRequest
+	var started	time.Time
+	var wait	time.Duration
+	var latency	time.Duration
+
+	for {
+		sb.lock.Lock()
+		for sb.queue.Len() == 0 {
+			sb.lock.Unlock()
+			select {
+			case <-sb.wake:
+			case <-sb.done:
+				sb.link.Close()
+				return
+			}
+			sb.lock.Lock()
+		}
+		br	= heap.Pop(&sb.queue).(*busRequest)
+		sb.lock.Unlock()
+
+		// keep the line silent for at least the requested time since the
+		// end of the previous exchange
+		if gap := br.silence - time.Since(sb.lastFrame); gap > 0 {
+			time.Sleep(gap)
+		}
+
+		started		= time.Now()
+		br.res, br.err	= sb.exec(br)
+		sb.lastFrame	= time.Now()
+
+		wait	= started.Sub(br.queued)
+		latency	= sb.lastFrame.Sub(br.queued)
+
+		sb.lock.Lock()
+		sb.stats.Requests++
+		sb.totalWait	+= wait
+		sb.totalLatency	+= latency
+		if wait > sb.stats.MaxWait {
+			sb.stats.MaxWait	= wait
+		}
+		if latency > sb.stats.MaxLatency {
+			sb.stats.MaxLatency	= latency
+		}
+		sb.lock.Unlock()
+
+		close(br.done)
+	}
+}
+
+// Returns a snapshot of the bus statistics.
+func (sb *serialBus) getStats() (stats BusStats) {
+	busesLock.Lock()
+	stats.Clients	= sb.clients
+	busesLock.Unlock()
+
+	sb.lock.Lock()
+	defer sb.lock.Unlock()
+
+	stats.Device		= sb.stats.Device
+	stats.QueueDepth	= sb.queue.Len()
+	stats.MaxQueueDepth	= sb.stats.MaxQueueDepth
+	stats.Requests		= sb.stats.Requests
+	stats.MaxWait		= sb.stats.MaxWait
+	stats.MaxLatency	= sb.stats.MaxLatency
+	if sb.stats.Requests > 0 {
+		stats.AvgWait		= sb.totalWait / time.Duration(sb.stats.Requests)
+		stats.AvgLatency	= sb.totalLatency / time.Duration(sb.stats.Requests)
+	}
+
+	return
+}
+
+// Queue of bus requests, ordered by decreasing priority then queueing order
+// (implements heap.Interface).
+type busQueue []*busRequest
+
+func (q busQueue) Len() int {
+	return len(q)
+}
+
+func (q busQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q busQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *busQueue) Push(x any) {
+	*q = append(*q, x.(*busRequest))
+}
+
+func (q *busQueue) Pop() any {
+	var old	= *q
+	var br	= old[len(old) - 1]
+
+	old[len(old) - 1]	= nil
+	*q			= old[:len(old) - 1]
+
+	return br
+}
+
+// Client-side view of a serial bus, satisfying the transport interface.
+type busClient struct {
+	bus		*serialBus
+	priority	Priority
+	timeout		time.Duration
+	silence		time.Duration
+	turnaround	time.Duration
+	tracer		FrameTracer
+	closed		bool
+}
+
+// Releases the bus.
+func (bc *busClient) Close() (err error) {
+	if bc.closed {
+		err	= os.ErrClosed
+		return
+	}
+
+	bc.closed	= true
+	bc.bus.release()
+
+	return
+}
+
+// Runs a request on the bus, writes preempting queued reads.
+func (bc *busClient) ExecuteRequest(req *pdu) (res *pdu, err error) {
+	var priority	= bc.priority
+
+	if bc.closed {
+		err	= os.ErrClosed
+		return
+	}
+
+	if isWriteFunctionCode(req.functionCode) && priority < PRIORITY_WRITE {
+		priority	= PRIORITY_WRITE
+	}
+
+	res, err	= bc.bus.execute(&busRequest{
+		req:		req,
+		priority:	priority,
+		timeout:	bc.timeout,
+		silence:	bc.silence,
+		turnaround:	bc.turnaround,
+		tracer:		bc.tracer,
+	})
+
+	return
+}
+
+// Reading requests from a shared bus is unsupported.
+func (bc *busClient) ReadRequest() (req *pdu, err error) {
+	err	= fmt.Errorf("unimplemented")
+
+	return
+}
+
+// Writing responses to a shared bus is unsupported.
+func (bc *busClient) WriteResponse(res *pdu) (err error) {
+	err	= fmt.Errorf("unimplemented")
+
+	return
+}
+
+// Returns true if the function code modifies device state.
+func isWriteFunctionCode(fc uint8) (yes bool) {
+	switch fc {
+	case fcWriteSingleCoil, fcWriteMultipleCoils,
+	     fcWriteSingleRegister, fcWriteMultipleRegisters,
+	     fcMaskWriteRegister, fcReadWriteMultipleRegisters,
+	     fcWriteFileRecord:
+		yes	= true
+	}
+
+	return
+}
@@ -0,0 +1,200 @@
+package modbus
+
+import (
+	"container/heap"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBusQueueOrder(t *testing.T) {
+	var q	busQueue
+	var br	*busRequest
+
+	for i, p := range []Priority{
+		PRIORITY_POLL, PRIORITY_POLL, PRIORITY_WRITE,
+		PRIORITY_POLL, PRIORITY_WRITE, PRIORITY_WRITE,
+	} {
+		heap.Push(&q, &busRequest{priority: p, seq: uint64(i + 1)})
+	}
+
+	// highest priority first, then in queueing order
+	for _, seq := range []uint64{3, 5, 6, 1, 2, 4} {
+		br = heap.Pop(&q).(*busRequest)
+		if br.seq != seq {
+			t.Errorf("expected request #%v, got #%v", seq, br.seq)
+		}
+	}
+
+	if q.Len() != 0 {
+		t.Errorf("expected an empty queue, got %v requests", q.Len())
+	}
+
+	return
+}
+
+func TestIsWriteFunctionCode(t *testing.T) {
+	for _, fc := range []uint8{0x05, 0x06, 0x0f, 0x10, 0x15, 0x16, 0x17} {
+		if !isWriteFunctionCode(fc) {
+			t.Errorf("function code 0x%02x should be a write", fc)
+		}
+	}
+
+	for _, fc := range []uint8{0x01, 0x02, 0x03, 0x04, 0x14, 0x18, 0x2b} {
+		if isWriteFunctionCode(fc) {
+			t.Errorf("function code 0x%02x should not be a write", fc)
+		}
+	}
+
+	return
+}
+
+func TestSerialBusPriorities(t *testing.T) {
+	var sb		*serialBus
+	var p1, p2	net.Conn
+	var err		error
+	var seen	chan uint16
+	var hold	chan struct{}
+	var results	chan error
+	var stats	BusStats
+	var poll, other	*busClient
+
+	p1, p2	= net.Pipe()
+	seen	= make(chan uint16, 8)
+	hold	= make(chan struct{})
+	results	= make(chan error, 8)
+
+	// fake device: answers read holding registers (0x03) and write single
+	// register (0x06) requests, reporting the address of each request and
+	// holding the first answer until released
+	go func() {
+		var req		[]byte
+		var res		[]byte
+		var first	= true
+		var c		crc
+
+		defer p1.Close()
+
+		for {
+			req	= make([]byte, 8)
+			if _, err := io.ReadFull(p1, req); err != nil {
+				return
+			}
+			seen <- bytesToUint16(BIG_ENDIAN, req[2:4])
+
+			if first {
+				<-hold
+				first	= false
+			}
+
+			switch req[1] {
+			case fcReadHoldingRegisters:
+				res	= []byte{req[0], req[1], 0x02, 0x12, 0x34}
+			default:
+				res	= req[0:6]
+			}
+			c.init()
+			c.add(res)
+			res	= append(res, c.value()...)
+
+			if _, err := p1.Write(res); err != nil {
+				return
+			}
+		}
+	}()
+
+	sb, err	= newSerialBus(&busConfig{
+		serial:		serialPortConfig{Device: "test", Speed: 19200},
+		transportType:	modbusRTU,
+	}, p2, nil)
+	if err != nil {
+		t.Fatalf("newSerialBus() should have succeeded, got: %v", err)
+	}
+	sb.clients	= 2
+
+	poll	= &busClient{bus: sb, priority: PRIORITY_POLL, timeout: 500 * time.Millisecond}
+	other	= &busClient{bus: sb, priority: PRIORITY_POLL, timeout: 500 * time.Millisecond}
+
+	read	:= func(bc *busClient, addr uint16) {
+		_, err := bc.ExecuteRequest(&pdu{
+			unitId:		0x01,
+			functionCode:	fcReadHoldingRegisters,
+			payload:	append(uint16ToBytes(BIG_ENDIAN, addr), 0x00, 0x01),
+		})
+		results <- err
+	}
+
+	// occupy the bus, then queue two polls and a write while the
+	// first request is in flight
+	go read(poll, 1)
+	if addr := <-seen; addr != 1 {
+		t.Fatalf("expected address 1 on the bus, got %v", addr)
+	}
+
+	go read(other, 2)
+	time.Sleep(10 * time.Millisecond)
+	go read(poll, 3)
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		// writes preempt queued reads even when issued by a poll client
+		_, err := other.ExecuteRequest(&pdu{
+			unitId:		0x01,
+			functionCode:	fcWriteSingleRegister,
+			payload:	[]byte{0x00, 0x04, 0xab, 0xcd},
+		})
+		results <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	stats	= sb.getStats()
+	if stats.QueueDepth != 3 {
+		t.Errorf("expected a queue depth of 3, got %v", stats.QueueDepth)
+	}
+	close(hold)
+
+	for _, expected := range []uint16{4, 2, 3} {
+		if addr := <-seen; addr != expected {
+			t.Errorf("expected address %v on the bus, got %v", expected, addr)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if err = <-results; err != nil {
+			t.Errorf("request should have succeeded, got: %v", err)
+		}
+	}
+
+	stats	= sb.getStats()
+	if stats.Device != "test" {
+		t.Errorf("expected device test, got %v", stats.Device)
+	}
+	if stats.Clients != 2 {
+		t.Errorf("expected 2 clients, got %v", stats.Clients)
+	}
+	if stats.Requests != 4 {
+		t.Errorf("expected 4 requests, got %v", stats.Requests)
+	}
+	if stats.QueueDepth != 0 {
+		t.Errorf("expected an empty queue, got %v", stats.QueueDepth)
+	}
+	if stats.MaxQueueDepth != 3 {
+		t.Errorf("expected a max queue depth of 3, got %v", stats.MaxQueueDepth)
+	}
+	if stats.MaxWait < 20 * time.Millisecond || stats.MaxWait > stats.MaxLatency {
+		t.Errorf("unexpected wait times: max wait %v, max latency %v",
+			 stats.MaxWait, stats.MaxLatency)
+	}
+
+	// releasing the last client closes the link
+	poll.Close()
+	other.Close()
+	if err = poll.Close(); err == nil {
+		t.Errorf("closing a client twice should have failed")
+	}
+	if _, err = poll.ExecuteRequest(&pdu{unitId: 0x01, functionCode: fcReadHoldingRegisters}); err == nil {
+		t.Errorf("requests on a closed client should have failed")
+	}
+
+	return
+}
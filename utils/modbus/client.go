@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -15,6 +16,7 @@ import (
 type RegType	uint
 type Endianness uint
 type WordOrder	uint
+type DeviceIdCode	uint8
 const (
 	PARITY_NONE         uint = 0
 	PARITY_EVEN         uint = 1
@@ -30,6 +32,20 @@ const (
 	// word order of 32-bit registers
 	HIGH_WORD_FIRST     WordOrder = 1
 	LOW_WORD_FIRST      WordOrder = 2
+
+	// read device identification codes (object categories)
+	DEVICE_ID_BASIC     DeviceIdCode = 0x01
+	DEVICE_ID_REGULAR   DeviceIdCode = 0x02
+	DEVICE_ID_EXTENDED  DeviceIdCode = 0x03
+
+	// device identification object ids
+	OBJ_VENDOR_NAME           uint8 = 0x00
+	OBJ_PRODUCT_CODE          uint8 = 0x01
+	OBJ_MAJOR_MINOR_REVISION  uint8 = 0x02
+	OBJ_VENDOR_URL            uint8 = 0x03
+	OBJ_PRODUCT_NAME          uint8 = 0x04
+	OBJ_MODEL_NAME            uint8 = 0x05
+	OBJ_USER_APPLICATION_NAME uint8 = 0x06
 )
 
 // Modbus client configuration object.
@@ -37,16 +53,41 @@ type ClientConfiguration struct {
 	// URL sets the client mode and target location in the form
 	// <mode>://<serial device or host:port> e.g. tcp://plc:502
 	URL           string
-	// Speed sets the serial link speed (in bps, rtu only)
+	// Speed sets the serial link speed (in bps, rtu and ascii only)
 	Speed         uint
-	// DataBits sets the number of bits per serial character (rtu only)
+	// DataBits sets the number of bits per serial character (rtu and
+	// ascii only)
 	DataBits      uint
-	// Parity sets the serial link parity mode (rtu only)
+	// Parity sets the serial link parity mode (rtu and ascii only)
 	Parity        uint
-	// StopBits sets the number of serial stop bits (rtu only)
+	// StopBits sets the number of serial stop bits (rtu and ascii only)
 	StopBits      uint
 	// Timeout sets the request timeout value
 	Timeout       time.Duration
+	// ConnectTimeout sets the connection timeout for network transports
+	// (defaults to 5s)
+	ConnectTimeout time.Duration
+	// Priority sets the bus priority of requests (rtu and ascii only):
+	// clients of the same serial device in this process share the port
+	// through a request queue, and writes always run at PRIORITY_WRITE
+	// (defaults to PRIORITY_POLL)
+	Priority      Priority
+	// BusSilence sets the minimum time the serial line is kept silent
+	// between frames (rtu and ascii only, defaults to 3.5 character times)
+	BusSilence    time.Duration
+	// TurnaroundDelay sets how long to wait after a broadcast request
+	// (unit id 0), which devices do not answer, before the next request
+	// (rtu and ascii framings only, defaults to 100ms)
+	TurnaroundDelay time.Duration
+	// PipelineWindow sets the maximum number of requests in flight on the
+	// connection (tcp and tcp+tls only). With a window of 2 or more,
+	// requests issued concurrently from several goroutines are sent without
+	// waiting for earlier responses, which are matched by transaction id
+	// (defaults to 1, i.e. no pipelining)
+	PipelineWindow uint
+	// Tracer, if set, receives a copy of every frame sent and received
+	// (see FrameRing for a bounded in-memory trace)
+	Tracer        FrameTracer
 	// TLSClientCert sets the client-side TLS key pair (tcp+tls only)
 	TLSClientCert *tls.Certificate
 	// TLSRootCAs sets the list of CA certificates used to authenticate
@@ -62,7 +103,11 @@ type ClientConfiguration struct {
 type ModbusClient struct {
 	conf          ClientConfiguration
 	logger        *logger
-	lock          sync.Mutex
+	// requests hold lock for reading while in flight, so that settings
+	// (unit id, encoding) and the transport only change between requests;
+	// txLock serializes requests on transports without pipelining
+	lock          sync.RWMutex
+	txLock        sync.Mutex
 	endianness    Endianness
 	wordOrder     WordOrder
 	transport     transport
@@ -70,6 +115,21 @@ type ModbusClient struct {
 	transportType transportType
 }
 
+// File record object, used by the read file record (0x14) and write file
+// record (0x15) function codes.
+// Each file holds up to 10000 records of one 16-bit register each.
+type FileRecord struct {
+	// FileNumber selects the file (0x0001-0xffff)
+	FileNumber    uint16
+	// RecordNumber sets the first record to read or write (0x0000-0x270f)
+	RecordNumber  uint16
+	// RecordLength sets the number of records to read (reads only, writes
+	// use the length of Values)
+	RecordLength  uint16
+	// Values holds the record contents: values to write, or values read
+	Values        []uint16
+}
+
 // NewClient creates, configures and returns a modbus client object.
 func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 	var clientType string
@@ -117,8 +177,60 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 			mc.conf.Timeout = 300 * time.Millisecond
 		}
 
+		if mc.conf.Priority == 0 {
+			mc.conf.Priority = PRIORITY_POLL
+		}
+
+		if mc.conf.BusSilence == 0 {
+			mc.conf.BusSilence = serialInterFrameDelay(mc.conf.Speed)
+		}
+
 		mc.transportType    = modbusRTU
 
+	case "ascii":
+		// set useful defaults
+		if mc.conf.Speed == 0 {
+			mc.conf.Speed	= 19200
+		}
+
+		// ASCII mode uses 7-bit characters. The spec defaults to even
+		// parity with 1 stop bit, and 2 stop bits without parity: as
+		// with rtu, parity defaults to none here, so set Parity to
+		// PARITY_EVEN for 7/E/1 devices.
+		if mc.conf.DataBits == 0 {
+			mc.conf.DataBits = 7
+		}
+
+		if mc.conf.StopBits == 0 {
+			if mc.conf.Parity == PARITY_NONE {
+				mc.conf.StopBits = 2
+			} else {
+				mc.conf.StopBits = 1
+			}
+		}
+
+		// ASCII devices may leave up to 1s between characters
+		if mc.conf.Timeout == 0 {
+			mc.conf.Timeout = 1 * time.Second
+		}
+
+		if mc.conf.Priority == 0 {
+			mc.conf.Priority = PRIORITY_POLL
+		}
+
+		if mc.conf.BusSilence == 0 {
+			mc.conf.BusSilence = serialInterFrameDelay(mc.conf.Speed)
+		}
+
+		mc.transportType    = modbusASCII
+
+	case "asciiovertcp":
+		if mc.conf.Timeout == 0 {
+			mc.conf.Timeout = 1 * time.Second
+		}
+
+		mc.transportType    = modbusASCIIOverTCP
+
 	case "rtuovertcp":
 		if mc.conf.Speed == 0 {
 			mc.conf.Speed   = 19200
@@ -189,6 +301,14 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 		return
 	}
 
+	if mc.conf.ConnectTimeout == 0 {
+		mc.conf.ConnectTimeout = 5 * time.Second
+	}
+
+	if mc.conf.TurnaroundDelay == 0 {
+		mc.conf.TurnaroundDelay = 100 * time.Millisecond
+	}
+
 	mc.unitId     = 1
 	mc.endianness = BIG_ENDIAN
 	mc.wordOrder  = HIGH_WORD_FIRST
@@ -198,39 +318,60 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 
 // Opens the underlying transport (network socket or serial line).
 func (mc *ModbusClient) Open() (err error) {
-	var spw		*serialPortWrapper
+	var sb		*serialBus
 	var sock	net.Conn
+	var rt		*rtuTransport
+	var at		*asciiTransport
 
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
 	switch mc.transportType {
-	case modbusRTU:
-		// create a serial port wrapper object
-		spw = newSerialPortWrapper(&serialPortConfig{
-			Device:		mc.conf.URL,
-			Speed:		mc.conf.Speed,
-			DataBits:	mc.conf.DataBits,
-			Parity:		mc.conf.Parity,
-			StopBits:	mc.conf.StopBits,
-		})
-
-		// open the serial device
-		err = spw.Open()
+	case modbusRTU, modbusASCII:
+		// join the bus of the serial device, opening the port if this
+		// is the first client of the device in this process
+		sb, err = acquireBus(&busConfig{
+			serial:	serialPortConfig{
+				Device:		mc.conf.URL,
+				Speed:		mc.conf.Speed,
+				DataBits:	mc.conf.DataBits,
+				Parity:		mc.conf.Parity,
+				StopBits:	mc.conf.StopBits,
+			},
+			transportType:	mc.transportType,
+		}, mc.conf.Logger)
+		if err != nil {
+			return
+		}
+
+		mc.transport = &busClient{
+			bus:		sb,
+			priority:	mc.conf.Priority,
+			timeout:	mc.conf.Timeout,
+			silence:	mc.conf.BusSilence,
+			turnaround:	mc.conf.TurnaroundDelay,
+			tracer:		mc.conf.Tracer,
+		}
+
+	case modbusASCIIOverTCP:
+		// connect to the remote host
+		sock, err = net.DialTimeout("tcp", mc.conf.URL, mc.conf.ConnectTimeout)
 		if err != nil {
 			return
 		}
 
 		// discard potentially stale serial data
-		discard(spw)
+		discard(sock)
 
-		// create the RTU transport
-		mc.transport = newRTUTransport(
-			spw, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
+		// create the ASCII transport
+		at = newASCIITransport(
+			sock, mc.conf.URL, mc.conf.Timeout, mc.conf.Logger)
+		at.turnaround = mc.conf.TurnaroundDelay
+		mc.transport = at
 
 	case modbusRTUOverTCP:
 		// connect to the remote host
-		sock, err = net.DialTimeout("tcp", mc.conf.URL, 5 * time.Second)
+		sock, err = net.DialTimeout("tcp", mc.conf.URL, mc.conf.ConnectTimeout)
 		if err != nil {
 			return
 		}
@@ -239,13 +380,15 @@ func (mc *ModbusClient) Open() (err error) {
 		discard(sock)
 
 		// create the RTU transport
-		mc.transport = newRTUTransport(
+		rt = newRTUTransport(
 			sock, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
+		rt.turnaround = mc.conf.TurnaroundDelay
+		mc.transport = rt
 
 	case modbusRTUOverUDP:
 		// open a socket to the remote host (note: no actual connection is
 		// being made as UDP is connection-less)
-		sock, err = net.DialTimeout("udp", mc.conf.URL, 5 * time.Second)
+		sock, err = net.DialTimeout("udp", mc.conf.URL, mc.conf.ConnectTimeout)
 		if err != nil {
 			return
 		}
@@ -253,19 +396,26 @@ func (mc *ModbusClient) Open() (err error) {
 		// create the RTU transport, wrapping the UDP socket in
 		// an adapter to allow the transport to read the stream of
 		// packets byte per byte
-		mc.transport = newRTUTransport(
+		rt = newRTUTransport(
 			newUDPSockWrapper(sock),
 			mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
+		rt.turnaround = mc.conf.TurnaroundDelay
+		mc.transport = rt
 
 	case modbusTCP:
 		// connect to the remote host
-		sock, err = net.DialTimeout("tcp", mc.conf.URL, 5 * time.Second)
+		sock, err = net.DialTimeout("tcp", mc.conf.URL, mc.conf.ConnectTimeout)
 		if err != nil {
 			return
 		}
 
 		// create the TCP transport
-		mc.transport = newTCPTransport(sock, mc.conf.Timeout, mc.conf.Logger)
+		if mc.conf.PipelineWindow > 1 {
+			mc.transport = newPipelinedTCPTransport(
+				sock, mc.conf.Timeout, mc.conf.PipelineWindow, mc.conf.Logger)
+		} else {
+			mc.transport = newTCPTransport(sock, mc.conf.Timeout, mc.conf.Logger)
+		}
 
 	case modbusTCPOverTLS:
 		// connect to the remote host with TLS
@@ -295,13 +445,19 @@ func (mc *ModbusClient) Open() (err error) {
 		// create the TCP transport, wrapping the TLS socket in
 		// an adapter to work around write timeouts corrupting internal
 		// state (see https://pkg.go.dev/crypto/tls#Conn.SetWriteDeadline)
-		mc.transport = newTCPTransport(
-			newTLSSockWrapper(sock), mc.conf.Timeout, mc.conf.Logger)
+		if mc.conf.PipelineWindow > 1 {
+			mc.transport = newPipelinedTCPTransport(
+				newTLSSockWrapper(sock), mc.conf.Timeout,
+				mc.conf.PipelineWindow, mc.conf.Logger)
+		} else {
+			mc.transport = newTCPTransport(
+				newTLSSockWrapper(sock), mc.conf.Timeout, mc.conf.Logger)
+		}
 
 	case modbusTCPOverUDP:
 		// open a socket to the remote host (note: no actual connection is
 		// being made as UDP is connection-less)
-		sock, err = net.DialTimeout("udp", mc.conf.URL, 5 * time.Second)
+		sock, err = net.DialTimeout("udp", mc.conf.URL, mc.conf.ConnectTimeout)
 		if err != nil {
 			return
 		}
@@ -317,6 +473,26 @@ func (mc *ModbusClient) Open() (err error) {
 		err = ErrConfigurationError
 	}
 
+	if err == nil && mc.conf.Tracer != nil {
+		mc.setTracer(mc.conf.Tracer)
+	}
+
+	return
+}
+
+// Hooks a frame tracer up to the transport.
+func (mc *ModbusClient) setTracer(tracer FrameTracer) {
+	switch t := mc.transport.(type) {
+	case *tcpTransport:
+		t.tracer	= tracer
+	case *pipelinedTCPTransport:
+		t.framer.tracer	= tracer
+	case *rtuTransport:
+		t.tracer	= tracer
+	case *asciiTransport:
+		t.tracer	= tracer
+	}
+
 	return
 }
 
@@ -332,6 +508,26 @@ func (mc *ModbusClient) Close() (err error) {
 	return
 }
 
+// Returns statistics of the serial bus this client shares with other clients
+// of the same serial device (rtu and ascii only).
+func (mc *ModbusClient) BusStats() (stats BusStats, err error) {
+	var bc	*busClient
+	var ok	bool
+
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+
+	bc, ok	= mc.transport.(*busClient)
+	if !ok {
+		err	= ErrConfigurationError
+		return
+	}
+
+	stats	= bc.bus.getStats()
+
+	return
+}
+
 // Sets the unit id of subsequent requests.
 func (mc *ModbusClient) SetUnitId(id uint8) (err error) {
 	mc.lock.Lock()
@@ -365,6 +561,20 @@ func (mc *ModbusClient) SetEncoding(endianness Endianness, wordOrder WordOrder)
 	return
 }
 
+// Returns the encoding of subsequent requests.
+// Register encoding and decoding happen outside of mc.lock, so each call takes
+// a snapshot when it starts and sticks to it, even if SetEncoding() is called
+// while the request is in flight.
+func (mc *ModbusClient) encoding() (endianness Endianness, wordOrder WordOrder) {
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+
+	endianness	= mc.endianness
+	wordOrder	= mc.wordOrder
+
+	return
+}
+
 // Reads multiple coils (function code 01).
 func (mc *ModbusClient) ReadCoils(addr uint16, quantity uint16) (values []bool, err error) {
 	values, err	= mc.readBools(addr, quantity, false)
@@ -406,6 +616,9 @@ func (mc *ModbusClient) ReadDiscreteInput(addr uint16) (value bool, err error) {
 // Reads multiple 16-bit registers (function code 03 or 04).
 func (mc *ModbusClient) ReadRegisters(addr uint16, quantity uint16, regType RegType) (values []uint16, err error) {
 	var mbPayload	[]byte
+	var endianness	Endianness
+
+	endianness, _	= mc.encoding()
 
 	// read quantity uint16 registers, as bytes
 	mbPayload, err	= mc.readRegisters(addr, quantity, regType)
@@ -414,7 +627,7 @@ func (mc *ModbusClient) ReadRegisters(addr uint16, quantity uint16, regType RegT
 	}
 
 	// decode payload bytes as uint16s
-	values	= bytesToUint16s(mc.endianness, mbPayload)
+	values	= bytesToUint16s(endianness, mbPayload)
 
 	return
 }
@@ -435,6 +648,10 @@ func (mc *ModbusClient) ReadRegister(addr uint16, regType RegType) (value uint16
 // Reads multiple 32-bit registers.
 func (mc *ModbusClient) ReadUint32s(addr uint16, quantity uint16, regType RegType) (values []uint32, err error) {
 	var mbPayload	[]byte
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
 
 	// read 2 * quantity uint16 registers, as bytes
 	mbPayload, err	= mc.readRegisters(addr, quantity * 2, regType)
@@ -443,7 +660,7 @@ func (mc *ModbusClient) ReadUint32s(addr uint16, quantity uint16, regType RegTyp
 	}
 
 	// decode payload bytes as uint32s
-	values	= bytesToUint32s(mc.endianness, mc.wordOrder, mbPayload)
+	values	= bytesToUint32s(endianness, wordOrder, mbPayload)
 
 	return
 }
@@ -463,6 +680,10 @@ func (mc *ModbusClient) ReadUint32(addr uint16, regType RegType) (value uint32,
 // Reads multiple 32-bit float registers.
 func (mc *ModbusClient) ReadFloat32s(addr uint16, quantity uint16, regType RegType) (values []float32, err error) {
 	var mbPayload	[]byte
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
 
 	// read 2 * quantity uint16 registers, as bytes
 	mbPayload, err	= mc.readRegisters(addr, quantity * 2, regType)
@@ -471,7 +692,7 @@ func (mc *ModbusClient) ReadFloat32s(addr uint16, quantity uint16, regType RegTy
 	}
 
 	// decode payload bytes as float32s
-	values	= bytesToFloat32s(mc.endianness, mc.wordOrder, mbPayload)
+	values	= bytesToFloat32s(endianness, wordOrder, mbPayload)
 
 	return
 }
@@ -491,6 +712,10 @@ func (mc *ModbusClient) ReadFloat32(addr uint16, regType RegType) (value float32
 // Reads multiple 64-bit registers.
 func (mc *ModbusClient) ReadUint64s(addr uint16, quantity uint16, regType RegType) (values []uint64, err error) {
 	var mbPayload	[]byte
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
 
 	// read 4 * quantity uint16 registers, as bytes
 	mbPayload, err	= mc.readRegisters(addr, quantity * 4, regType)
@@ -499,7 +724,7 @@ func (mc *ModbusClient) ReadUint64s(addr uint16, quantity uint16, regType RegTyp
 	}
 
 	// decode payload bytes as uint64s
-	values	= bytesToUint64s(mc.endianness, mc.wordOrder, mbPayload)
+	values	= bytesToUint64s(endianness, wordOrder, mbPayload)
 
 	return
 }
@@ -519,6 +744,10 @@ func (mc *ModbusClient) ReadUint64(addr uint16, regType RegType) (value uint64,
 // Reads multiple 64-bit float registers.
 func (mc *ModbusClient) ReadFloat64s(addr uint16, quantity uint16, regType RegType) (values []float64, err error) {
 	var mbPayload	[]byte
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
 
 	// read 4 * quantity uint16 registers, as bytes
 	mbPayload, err	= mc.readRegisters(addr, quantity * 4, regType)
@@ -527,7 +756,7 @@ func (mc *ModbusClient) ReadFloat64s(addr uint16, quantity uint16, regType RegTy
 	}
 
 	// decode payload bytes as float64s
-	values	= bytesToFloat64s(mc.endianness, mc.wordOrder, mbPayload)
+	values	= bytesToFloat64s(endianness, wordOrder, mbPayload)
 
 	return
 }
@@ -565,8 +794,8 @@ func (mc *ModbusClient) ReadRawBytes(addr uint16, quantity uint16, regType RegTy
 func (mc *ModbusClient) WriteCoil(addr uint16, value bool) (err error) {
 	var payload uint16
 
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
 
 	if value {
 		payload = 0xff00
@@ -585,8 +814,8 @@ func (mc *ModbusClient) WriteCoil(addr uint16, value bool) (err error) {
 // but a handful of vendors seem to be hiding various DO/coil control modes
 // behind it (e.g. toggle, interlock, delayed open/close, etc.).
 func (mc *ModbusClient) WriteCoilValue(addr uint16, payload uint16) (err error) {
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
 
 	err = mc.writeCoil(addr, payload)
 
@@ -600,8 +829,8 @@ func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) (err error) {
 	var quantity      uint16
 	var encodedValues []byte
 
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
 
 	quantity	= uint16(len(values))
 	if quantity == 0 {
@@ -645,6 +874,11 @@ func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) (err error) {
 		return
 	}
 
+	// broadcast requests get no response
+	if res == nil {
+		return
+	}
+
 	// validate the response code
 	switch {
 	case res.functionCode == req.functionCode:
@@ -679,8 +913,8 @@ func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) (err error) {
 	var req	*pdu
 	var res	*pdu
 
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
 
 	// create and fill in the request object
 	req	= &pdu{
@@ -699,6 +933,11 @@ func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) (err error) {
 		return
 	}
 
+	// broadcast requests get no response
+	if res == nil {
+		return
+	}
+
 	// validate the response code
 	switch {
 	case res.functionCode == req.functionCode:
@@ -731,10 +970,13 @@ func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) (err error) {
 // Writes multiple 16-bit registers (function code 16).
 func (mc *ModbusClient) WriteRegisters(addr uint16, values []uint16) (err error) {
 	var payload	[]byte
+	var endianness	Endianness
+
+	endianness, _	= mc.encoding()
 
 	// turn registers to bytes
 	for _, value := range values {
-		payload	= append(payload, uint16ToBytes(mc.endianness, value)...)
+		payload	= append(payload, uint16ToBytes(endianness, value)...)
 	}
 
 	err = mc.writeRegisters(addr, payload)
@@ -745,10 +987,14 @@ func (mc *ModbusClient) WriteRegisters(addr uint16, values []uint16) (err error)
 // Writes multiple 32-bit registers.
 func (mc *ModbusClient) WriteUint32s(addr uint16, values []uint32) (err error) {
 	var payload	[]byte
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
 
 	// turn registers to bytes
 	for _, value := range values {
-		payload	= append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, value)...)
+		payload	= append(payload, uint32ToBytes(endianness, wordOrder, value)...)
 	}
 
 	err = mc.writeRegisters(addr, payload)
@@ -758,7 +1004,12 @@ func (mc *ModbusClient) WriteUint32s(addr uint16, values []uint32) (err error) {
 
 // Writes a single 32-bit register.
 func (mc *ModbusClient) WriteUint32(addr uint16, value uint32) (err error) {
-	err = mc.writeRegisters(addr, uint32ToBytes(mc.endianness, mc.wordOrder, value))
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
+
+	err = mc.writeRegisters(addr, uint32ToBytes(endianness, wordOrder, value))
 
 	return
 }
@@ -766,10 +1017,14 @@ func (mc *ModbusClient) WriteUint32(addr uint16, value uint32) (err error) {
 // Writes multiple 32-bit float registers.
 func (mc *ModbusClient) WriteFloat32s(addr uint16, values []float32) (err error) {
 	var payload	[]byte
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
 
 	// turn registers to bytes
 	for _, value := range values {
-		payload	= append(payload, float32ToBytes(mc.endianness, mc.wordOrder, value)...)
+		payload	= append(payload, float32ToBytes(endianness, wordOrder, value)...)
 	}
 
 	err = mc.writeRegisters(addr, payload)
@@ -779,7 +1034,12 @@ func (mc *ModbusClient) WriteFloat32s(addr uint16, values []float32) (err error)
 
 // Writes a single 32-bit float register.
 func (mc *ModbusClient) WriteFloat32(addr uint16, value float32) (err error) {
-	err = mc.writeRegisters(addr, float32ToBytes(mc.endianness, mc.wordOrder, value))
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
+
+	err = mc.writeRegisters(addr, float32ToBytes(endianness, wordOrder, value))
 
 	return
 }
@@ -787,10 +1047,14 @@ func (mc *ModbusClient) WriteFloat32(addr uint16, value float32) (err error) {
 // Writes multiple 64-bit registers.
 func (mc *ModbusClient) WriteUint64s(addr uint16, values []uint64) (err error) {
 	var payload	[]byte
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
 
 	// turn registers to bytes
 	for _, value := range values {
-		payload	= append(payload, uint64ToBytes(mc.endianness, mc.wordOrder, value)...)
+		payload	= append(payload, uint64ToBytes(endianness, wordOrder, value)...)
 	}
 
 	err = mc.writeRegisters(addr, payload)
@@ -800,7 +1064,12 @@ func (mc *ModbusClient) WriteUint64s(addr uint16, values []uint64) (err error) {
 
 // Writes a single 64-bit register.
 func (mc *ModbusClient) WriteUint64(addr uint16, value uint64) (err error) {
-	err = mc.writeRegisters(addr, uint64ToBytes(mc.endianness, mc.wordOrder, value))
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
+
+	err = mc.writeRegisters(addr, uint64ToBytes(endianness, wordOrder, value))
 
 	return
 }
@@ -808,10 +1077,14 @@ func (mc *ModbusClient) WriteUint64(addr uint16, value uint64) (err error) {
 // Writes multiple 64-bit float registers.
 func (mc *ModbusClient) WriteFloat64s(addr uint16, values []float64) (err error) {
 	var payload	[]byte
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
 
 	// turn registers to bytes
 	for _, value := range values {
-		payload	= append(payload, float64ToBytes(mc.endianness, mc.wordOrder, value)...)
+		payload	= append(payload, float64ToBytes(endianness, wordOrder, value)...)
 	}
 
 	err = mc.writeRegisters(addr, payload)
@@ -821,7 +1094,12 @@ func (mc *ModbusClient) WriteFloat64s(addr uint16, values []float64) (err error)
 
 // Writes a single 64-bit float register.
 func (mc *ModbusClient) WriteFloat64(addr uint16, value float64) (err error) {
-	err = mc.writeRegisters(addr, float64ToBytes(mc.endianness, mc.wordOrder, value))
+	var endianness	Endianness
+	var wordOrder	WordOrder
+
+	endianness, wordOrder	= mc.encoding()
+
+	err = mc.writeRegisters(addr, float64ToBytes(endianness, wordOrder, value))
 
 	return
 }
@@ -845,10 +1123,594 @@ func (mc *ModbusClient) WriteRawBytes(addr uint16, values []byte) (err error) {
 	return
 }
 
+// Modifies a single holding register using an AND and an OR mask (function code 0x16).
+// The server sets the register to (current AND andMask) OR (orMask AND NOT andMask),
+// which allows flipping individual bits without a read-modify-write race.
+func (mc *ModbusClient) MaskWriteRegister(addr uint16, andMask uint16, orMask uint16) (err error) {
+	var req	*pdu
+	var res	*pdu
+
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcMaskWriteRegister,
+	}
+
+	// register address
+	req.payload	= uint16ToBytes(BIG_ENDIAN, addr)
+	// AND and OR masks, always big-endian since bit positions are defined
+	// on the wire value of the register
+	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, andMask)...)
+	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, orMask)...)
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// broadcast requests get no response
+	if res == nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the request (2 bytes of address + 2 bytes of
+		// AND mask + 2 bytes of OR mask)
+		if len(res.payload) != 6 ||
+		   bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
+		   bytesToUint16(BIG_ENDIAN, res.payload[2:4]) != andMask ||
+		   bytesToUint16(BIG_ENDIAN, res.payload[4:6]) != orMask {
+			   err = ErrProtocolError
+			   return
+		   }
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Writes values to holding registers starting at writeAddr, then reads
+// readQuantity holding registers starting at readAddr, in a single
+// transaction (function code 0x17). The write is performed before the read.
+func (mc *ModbusClient) ReadWriteMultipleRegisters(readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint16) (res []uint16, err error) {
+	var req		 *pdu
+	var resPdu	 *pdu
+	var writeQuantity uint16
+
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+
+	writeQuantity	= uint16(len(values))
+
+	if readQuantity == 0 || writeQuantity == 0 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers is 0")
+		return
+	}
+
+	if readQuantity > 125 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers to read exceeds 125")
+		return
+	}
+
+	if len(values) > 121 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers to write exceeds 121")
+		return
+	}
+
+	if uint32(readAddr) + uint32(readQuantity) - 1 > 0xffff ||
+	   uint32(writeAddr) + uint32(writeQuantity) - 1 > 0xffff {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("end register address is past 0xffff")
+		return
+	}
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcReadWriteMultipleRegisters,
+	}
+
+	// read address and quantity
+	req.payload	= uint16ToBytes(BIG_ENDIAN, readAddr)
+	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, readQuantity)...)
+	// write address and quantity
+	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, writeAddr)...)
+	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, writeQuantity)...)
+	// byte count (2 bytes per register)
+	req.payload	= append(req.payload, byte(writeQuantity * 2))
+	// register values
+	req.payload	= append(req.payload, uint16sToBytes(mc.endianness, values)...)
+
+	// run the request across the transport and wait for a response
+	resPdu, err	= mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case resPdu.functionCode == req.functionCode:
+		// make sure the payload length is what we expect
+		// (1 byte of length + 2 bytes per register)
+		if len(resPdu.payload) != 1 + 2 * int(readQuantity) ||
+		   uint(resPdu.payload[0]) != 2 * uint(readQuantity) {
+			err = ErrProtocolError
+			return
+		}
+
+		res	= bytesToUint16s(mc.endianness, resPdu.payload[1:])
+
+	case resPdu.functionCode == (req.functionCode | 0x80):
+		if len(resPdu.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(resPdu.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", resPdu.functionCode)
+	}
+
+	return
+}
+
+// Reads the contents of a first-in-first-out queue of holding registers
+// whose count register lives at addr (function code 0x18).
+// Up to 31 queued values are returned, oldest first.
+func (mc *ModbusClient) ReadFIFOQueue(addr uint16) (values []uint16, err error) {
+	var req		*pdu
+	var res		*pdu
+	var byteCount	uint16
+	var fifoCount	uint16
+
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcReadFifoQueue,
+	}
+
+	// FIFO pointer address
+	req.payload	= uint16ToBytes(BIG_ENDIAN, addr)
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 2 bytes of byte count + 2 bytes of FIFO count + 2 bytes
+		// per queued register
+		if len(res.payload) < 4 {
+			err = ErrProtocolError
+			return
+		}
+
+		byteCount	= bytesToUint16(BIG_ENDIAN, res.payload[0:2])
+		fifoCount	= bytesToUint16(BIG_ENDIAN, res.payload[2:4])
+
+		if fifoCount > 31 ||
+		   byteCount != 2 + 2 * fifoCount ||
+		   len(res.payload) != 2 + int(byteCount) {
+			err = ErrProtocolError
+			return
+		}
+
+		values	= bytesToUint16s(mc.endianness, res.payload[4:])
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads quantity records starting at recordNumber from file fileNumber
+// (function code 0x14).
+func (mc *ModbusClient) ReadFileRecord(fileNumber uint16, recordNumber uint16, quantity uint16) (values []uint16, err error) {
+	var records	[]FileRecord
+
+	records, err	= mc.ReadFileRecords([]FileRecord{{
+		FileNumber:   fileNumber,
+		RecordNumber: recordNumber,
+		RecordLength: quantity,
+	}})
+	if err != nil {
+		return
+	}
+
+	values	= records[0].Values
+
+	return
+}
+
+// Reads multiple groups of records, possibly spread over several files, in a
+// single transaction (function code 0x14).
+// Each group is described by the FileNumber, RecordNumber and RecordLength
+// fields of a FileRecord object. Groups are returned in request order, with
+// their Values field set.
+func (mc *ModbusClient) ReadFileRecords(records []FileRecord) (res []FileRecord, err error) {
+	var req		*pdu
+	var resPdu	*pdu
+	var resLength	int
+	var subLength	int
+	var offset	int
+
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+
+	if len(records) == 0 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("no file record to read")
+		return
+	}
+
+	// 7 bytes per sub-request (reference type, file number, record number
+	// and record length), at most 0xf5 bytes in total
+	if len(records) * 7 > 0xf5 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("too many file record sub-requests")
+		return
+	}
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcReadFileRecord,
+		payload:      []byte{byte(len(records) * 7)},
+	}
+
+	for _, record := range records {
+		if record.FileNumber == 0 || record.RecordNumber > maxFileRecordNumber {
+			err = ErrUnexpectedParameters
+			mc.logger.Errorf("invalid file record reference (file %v, record %v)",
+					 record.FileNumber, record.RecordNumber)
+			return
+		}
+
+		if record.RecordLength == 0 {
+			err = ErrUnexpectedParameters
+			mc.logger.Error("quantity of records is 0")
+			return
+		}
+
+		// each sub-response carries 1 byte of length, 1 byte of reference
+		// type and 2 bytes per record
+		resLength	+= 2 + 2 * int(record.RecordLength)
+
+		req.payload	= append(req.payload, fileRecordRefType)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.FileNumber)...)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordNumber)...)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordLength)...)
+	}
+
+	if resLength > 0xf5 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("file record response would exceed the maximum PDU length")
+		return
+	}
+
+	// run the request across the transport and wait for a response
+	resPdu, err	= mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case resPdu.functionCode == req.functionCode:
+		// make sure the payload length is what we expect
+		// (1 byte of response data length + sub-responses)
+		if len(resPdu.payload) != 1 + resLength ||
+		   int(resPdu.payload[0]) != resLength {
+			err = ErrProtocolError
+			return
+		}
+
+		offset	= 1
+		for _, record := range records {
+			subLength	= int(resPdu.payload[offset])
+
+			// each sub-response should match its sub-request
+			if subLength != 1 + 2 * int(record.RecordLength) ||
+			   resPdu.payload[offset + 1] != fileRecordRefType {
+				err = ErrProtocolError
+				return
+			}
+
+			record.Values	= bytesToUint16s(mc.endianness,
+							 resPdu.payload[offset + 2:offset + 1 + subLength])
+			res		= append(res, record)
+			offset		+= 1 + subLength
+		}
+
+	case resPdu.functionCode == (req.functionCode | 0x80):
+		if len(resPdu.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(resPdu.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", resPdu.functionCode)
+	}
+
+	return
+}
+
+// Writes values to consecutive records of file fileNumber, starting at
+// recordNumber (function code 0x15).
+func (mc *ModbusClient) WriteFileRecord(fileNumber uint16, recordNumber uint16, values []uint16) (err error) {
+	err	= mc.WriteFileRecords([]FileRecord{{
+		FileNumber:   fileNumber,
+		RecordNumber: recordNumber,
+		Values:       values,
+	}})
+
+	return
+}
+
+// Writes multiple groups of records, possibly spread over several files, in
+// a single transaction (function code 0x15).
+// Each group is described by the FileNumber, RecordNumber and Values fields
+// of a FileRecord object (RecordLength is ignored).
+func (mc *ModbusClient) WriteFileRecords(records []FileRecord) (err error) {
+	var req		*pdu
+	var res		*pdu
+	var reqLength	int
+
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+
+	if len(records) == 0 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("no file record to write")
+		return
+	}
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcWriteFileRecord,
+		payload:      []byte{0},
+	}
+
+	for _, record := range records {
+		if record.FileNumber == 0 || record.RecordNumber > maxFileRecordNumber {
+			err = ErrUnexpectedParameters
+			mc.logger.Errorf("invalid file record reference (file %v, record %v)",
+					 record.FileNumber, record.RecordNumber)
+			return
+		}
+
+		if len(record.Values) == 0 {
+			err = ErrUnexpectedParameters
+			mc.logger.Error("quantity of records is 0")
+			return
+		}
+
+		// 7 bytes of header (reference type, file number, record number and
+		// record length) + 2 bytes per record
+		reqLength	+= 7 + 2 * len(record.Values)
+		if reqLength > 0xfb {
+			err = ErrUnexpectedParameters
+			mc.logger.Error("file record request would exceed the maximum PDU length")
+			return
+		}
+
+		req.payload	= append(req.payload, fileRecordRefType)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.FileNumber)...)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordNumber)...)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, uint16(len(record.Values)))...)
+		req.payload	= append(req.payload, uint16sToBytes(mc.endianness, record.Values)...)
+	}
+
+	// request data length
+	req.payload[0]	= byte(reqLength)
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// broadcast requests get no response
+	if res == nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the request
+		if !bytes.Equal(res.payload, req.payload) {
+			err = ErrProtocolError
+			return
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads device identification objects (function code 0x2b, MEI type 0x0e).
+// readDeviceIdCode selects the basic (vendor name, product code and revision),
+// regular (basic + vendor URL, product/model name, ...) or extended (regular +
+// vendor-specific) object category.
+// Responses flagged "more follows" are followed up on until the whole category
+// is received. Objects are returned keyed by object id (see OBJ_* constants).
+func (mc *ModbusClient) ReadDeviceIdentification(readDeviceIdCode DeviceIdCode) (objects map[uint8]string, err error) {
+	var req		*pdu
+	var res		*pdu
+	var objectId	uint8
+	var moreFollows	bool
+	var nextId	uint8
+	var offset	int
+	var objCount	int
+	var objLen	int
+
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+
+	if readDeviceIdCode < DEVICE_ID_BASIC || readDeviceIdCode > DEVICE_ID_EXTENDED {
+		err = ErrUnexpectedParameters
+		mc.logger.Errorf("unexpected read device id code (%v)", readDeviceIdCode)
+		return
+	}
+
+	objects	= make(map[uint8]string)
+
+	// start with the first object of the category and keep requesting
+	// as long as the device reports more objects
+	for {
+		// create and fill in the request object
+		req	= &pdu{
+			unitId:	      mc.unitId,
+			functionCode: fcEncapsulatedInterface,
+			payload:      []byte{meiReadDeviceId, uint8(readDeviceIdCode), objectId},
+		}
+
+		// run the request across the transport and wait for a response
+		res, err	= mc.executeRequest(req)
+		if err != nil {
+			objects	= nil
+			return
+		}
+
+		// validate the response code
+		switch {
+		case res.functionCode == req.functionCode:
+			// expect 6 bytes of header (MEI type, read device id code,
+			// conformity level, more follows, next object id and number
+			// of objects) followed by the object list
+			if len(res.payload) < 6 ||
+			   res.payload[0] != meiReadDeviceId ||
+			   res.payload[1] != uint8(readDeviceIdCode) {
+				err = ErrProtocolError
+				break
+			}
+
+			moreFollows	= res.payload[3] == 0xff
+			nextId		= res.payload[4]
+			objCount	= int(res.payload[5])
+
+			// each object is made of 1 byte of id, 1 byte of length and
+			// the object value
+			offset		= 6
+			for i := 0; i < objCount; i++ {
+				if len(res.payload) < offset + 2 {
+					err = ErrProtocolError
+					break
+				}
+
+				objLen	= int(res.payload[offset + 1])
+				if len(res.payload) < offset + 2 + objLen {
+					err = ErrProtocolError
+					break
+				}
+
+				objects[res.payload[offset]] =
+					string(res.payload[offset + 2:offset + 2 + objLen])
+				offset	+= 2 + objLen
+			}
+
+			if err == nil && offset != len(res.payload) {
+				err = ErrProtocolError
+			}
+
+		case res.functionCode == (req.functionCode | 0x80):
+			if len(res.payload) != 1 {
+				err	= ErrProtocolError
+				break
+			}
+
+			err	= mapExceptionCodeToError(res.payload[0])
+
+		default:
+			err	= ErrProtocolError
+			mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		}
+
+		if err != nil {
+			objects	= nil
+			return
+		}
+
+		if !moreFollows {
+			break
+		}
+
+		// object ids only ever move forward: anything else would loop
+		// forever
+		if nextId <= objectId {
+			err	= ErrProtocolError
+			objects	= nil
+			mc.logger.Warningf("next object id (%v) does not follow %v", nextId, objectId)
+			return
+		}
+		objectId	= nextId
+	}
+
+	return
+}
+
 /*** unexported methods ***/
 // Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
 func (mc *ModbusClient) readBytes(addr uint16, quantity uint16, regType RegType, observeEndianness bool) (values []byte, err error) {
 	var regCount uint16
+	var endianness	Endianness
+
+	endianness, _	= mc.encoding()
 
 	// read enough registers to get the requested number of bytes
 	// (2 bytes per reg)
@@ -861,7 +1723,7 @@ func (mc *ModbusClient) readBytes(addr uint16, quantity uint16, regType RegType,
 
 	// swap bytes on register boundaries if requested by the caller
 	// and endianness is set to little endian
-	if observeEndianness && mc.endianness == LITTLE_ENDIAN {
+	if observeEndianness && endianness == LITTLE_ENDIAN {
 		for i := 0; i < len(values); i += 2 {
 			values[i], values[i+1] = values[i+1], values[i]
 		}
@@ -877,6 +1739,10 @@ func (mc *ModbusClient) readBytes(addr uint16, quantity uint16, regType RegType,
 
 // Writes the given slice of bytes to 16-bit registers starting at addr.
 func (mc *ModbusClient) writeBytes(addr uint16, values []byte, observeEndianness bool) (err error) {
+	var endianness	Endianness
+
+	endianness, _	= mc.encoding()
+
 	// pad odd quantities to make for full registers
 	if len(values) % 2 == 1 {
 		values = append(values, 0x00)
@@ -884,7 +1750,7 @@ func (mc *ModbusClient) writeBytes(addr uint16, values []byte, observeEndianness
 
 	// swap bytes on register boundaries if requested by the caller
 	// and endianness is set to little endian
-	if observeEndianness && mc.endianness == LITTLE_ENDIAN {
+	if observeEndianness && endianness == LITTLE_ENDIAN {
 		for i := 0; i < len(values); i += 2 {
 			values[i], values[i+1] = values[i+1], values[i]
 		}
@@ -902,8 +1768,8 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) (values
 	var res	        *pdu
 	var expectedLen int
 
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
 
 	if quantity == 0 {
 		err	= ErrUnexpectedParameters
@@ -991,8 +1857,8 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 	var req	*pdu
 	var res	*pdu
 
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
 
 	// create and fill in the request object
 	req	= &pdu{
@@ -1095,6 +1961,11 @@ func (mc *ModbusClient) writeCoil(addr uint16, payload uint16) (err error) {
 		return
 	}
 
+	// broadcast requests get no response
+	if res == nil {
+		return
+	}
+
 	// validate the response code
 	switch {
 	case res.functionCode == req.functionCode:
@@ -1133,8 +2004,8 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
 	var payloadLength uint16
 	var quantity      uint16
 
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
 
 	payloadLength = uint16(len(values))
 	quantity      = payloadLength / 2
@@ -1178,6 +2049,11 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
 		return
 	}
 
+	// broadcast requests get no response
+	if res == nil {
+		return
+	}
+
 	// validate the response code
 	switch {
 	case res.functionCode == req.functionCode:
@@ -1208,8 +2084,32 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
 }
 
 func (mc *ModbusClient) executeRequest(req *pdu) (res *pdu, err error) {
+	var pt	*pipelinedTCPTransport
+	var ok	bool
+
+	// broadcast requests (unit id 0) are only defined for writes on serial
+	// line framings
+	if req.unitId == 0x00 && mc.isSerialFramed() &&
+	   !isBroadcastFunctionCode(req.functionCode) {
+		err	= ErrUnexpectedParameters
+		mc.logger.Errorf("function code 0x%02x cannot be broadcast",
+				 req.functionCode)
+		return
+	}
+
 	// send the request over the wire, wait for and decode the response
-	res, err	= mc.transport.ExecuteRequest(req)
+	// (note: the caller holds mc.lock for reading, which other requests
+	// share but settings changes wait for)
+	pt, ok	= mc.transport.(*pipelinedTCPTransport)
+	if ok {
+		// requests from other goroutines go out while this one is in
+		// flight, the transport matching responses by transaction id
+		res, err	= pt.ExecuteRequest(req)
+	} else {
+		mc.txLock.Lock()
+		res, err	= mc.transport.ExecuteRequest(req)
+		mc.txLock.Unlock()
+	}
 	if err != nil {
 		// map i/o timeouts to ErrRequestTimedOut
 		if os.IsTimeout(err) {
@@ -1218,6 +2118,11 @@ func (mc *ModbusClient) executeRequest(req *pdu) (res *pdu, err error) {
 		return
 	}
 
+	// broadcast requests get no response
+	if res == nil {
+		return
+	}
+
 	// make sure the source unit id matches that of the request
 	if (res.functionCode & 0x80) == 0x00 && res.unitId != req.unitId {
 		err = ErrBadUnitId
@@ -1232,3 +2137,28 @@ func (mc *ModbusClient) executeRequest(req *pdu) (res *pdu, err error) {
 
 	return
 }
+
+// Returns true if the client uses serial line (rtu or ascii) framing, where
+// unit id 0 addresses all devices of the bus.
+func (mc *ModbusClient) isSerialFramed() (yes bool) {
+	switch mc.transportType {
+	case modbusRTU, modbusRTUOverTCP, modbusRTUOverUDP,
+	     modbusASCII, modbusASCIIOverTCP:
+		yes	= true
+	}
+
+	return
+}
+
+// Returns true if requests of the function code may be broadcast, i.e.
+// writes whose response carries no data.
+func isBroadcastFunctionCode(fc uint8) (yes bool) {
+	switch fc {
+	case fcWriteSingleCoil, fcWriteMultipleCoils,
+	     fcWriteSingleRegister, fcWriteMultipleRegisters,
+	     fcMaskWriteRegister, fcWriteFileRecord:
+		yes	= true
+	}
+
+	return
+}
@@ -39,6 +39,12 @@ const (
 	// file access
 	fcReadFileRecord             uint8 = 0x14
 	fcWriteFileRecord            uint8 = 0x15
+	fileRecordRefType            uint8 = 0x06
+	maxFileRecordNumber          uint16 = 0x270f
+
+	// encapsulated interface transport
+	fcEncapsulatedInterface      uint8 = 0x2b
+	meiReadDeviceId              uint8 = 0x0e
 
 	// exception codes
 	exIllegalFunction            uint8 = 0x01
@@ -64,12 +70,15 @@ const (
 	ErrGWPathUnavailable         Error = "gateway path unavailable"
 	ErrGWTargetFailedToRespond   Error = "gateway target device failed to respond"
 	ErrBadCRC                    Error = "bad crc"
+	ErrBadLRC                    Error = "bad lrc"
 	ErrShortFrame                Error = "short frame"
 	ErrProtocolError             Error = "protocol error"
 	ErrBadUnitId                 Error = "bad unit id"
 	ErrBadTransactionId          Error = "bad transaction id"
 	ErrUnknownProtocolId         Error = "unknown protocol identifier"
 	ErrUnexpectedParameters      Error = "unexpected parameters"
+	// returned by server request handlers to leave a request unanswered
+	ErrNoResponse                Error = "no response"
 )
 
 // mapExceptionCodeToError turns a modbus exception code into a higher level Error object.
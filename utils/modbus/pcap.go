@@ -0,0 +1,183 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// pcap link types
+const (
+	// raw IPv4 packets: MBAP frames are wrapped in synthesized IPv4/TCP
+	// headers (port 502) so that capture tools decode them as Modbus/TCP
+	linkTypeRaw	uint32 = 101
+	// DLT_USER0, used for RTU frames (e.g. map it to the mbrtu dissector
+	// in Wireshark's DLT_USER preferences)
+	linkTypeUser0	uint32 = 147
+	// DLT_USER1, used for ASCII frames
+	linkTypeUser1	uint32 = 148
+
+	pcapSnapLen	uint32 = 65535
+)
+
+// Synthesized endpoints of MBAP frames: the client (this end) and the server.
+var (
+	pcapClientAddr	= [4]byte{10, 0, 0, 1}
+	pcapServerAddr	= [4]byte{10, 0, 0, 2}
+)
+
+const (
+	pcapClientPort	uint16 = 49152
+	pcapServerPort	uint16 = 502
+)
+
+// Writes frames as a pcap capture file to w.
+// A capture file has a single link type: the format of the first frame
+// selects it and frames of any other format are skipped.
+func WritePcap(w io.Writer, frames []Frame) (err error) {
+	var format	FrameFormat
+	var linkType	uint32
+	var header	[]byte
+	var packet	[]byte
+	var seq		[3]uint32
+	var ipId	uint16
+
+	format	= FRAME_MBAP
+	if len(frames) > 0 {
+		format	= frames[0].Format
+	}
+
+	switch format {
+	case FRAME_RTU:
+		linkType	= linkTypeUser0
+	case FRAME_ASCII:
+		linkType	= linkTypeUser1
+	default:
+		linkType	= linkTypeRaw
+	}
+
+	// global header: magic, version 2.4, GMT, accuracy, snap length and
+	// link type
+	header	= make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:24], linkType)
+
+	_, err	= w.Write(header)
+	if err != nil {
+		return
+	}
+
+	// TCP sequence numbers of each direction
+	seq[FRAME_TX], seq[FRAME_RX]	= 1, 1
+
+	for _, f := range frames {
+		if f.Format != format {
+			continue
+		}
+
+		packet	= f.ADU
+		if format == FRAME_MBAP {
+			ipId++
+			packet	= wrapInIPv4TCP(f, seq, ipId)
+			if f.Direction == FRAME_RX {
+				seq[FRAME_RX]	+= uint32(len(f.ADU))
+			} else {
+				seq[FRAME_TX]	+= uint32(len(f.ADU))
+			}
+		}
+
+		// record header: timestamp (seconds, microseconds), captured
+		// and original lengths
+		header	= make([]byte, 16)
+		binary.LittleEndian.PutUint32(header[0:4], uint32(f.Time.Unix()))
+		binary.LittleEndian.PutUint32(header[4:8], uint32(f.Time.Nanosecond() / 1000))
+		binary.LittleEndian.PutUint32(header[8:12], uint32(len(packet)))
+		binary.LittleEndian.PutUint32(header[12:16], uint32(len(packet)))
+
+		_, err	= w.Write(append(header, packet...))
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Wraps an MBAP frame in IPv4 and TCP headers, as exchanged between the
+// synthesized client and server endpoints.
+func wrapInIPv4TCP(f Frame, seq [3]uint32, ipId uint16) (packet []byte) {
+	var ip		[]byte
+	var tcp		[]byte
+	var src, dst	[4]byte
+	var sport, dport uint16
+	var pseudo	[]byte
+
+	src, dst	= pcapClientAddr, pcapServerAddr
+	sport, dport	= pcapClientPort, pcapServerPort
+	if f.Direction == FRAME_RX {
+		src, dst	= dst, src
+		sport, dport	= dport, sport
+	}
+
+	// TCP header (no options): ports, sequence and ack numbers, data
+	// offset (5 words), PSH+ACK flags and window
+	tcp	= make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:2], sport)
+	binary.BigEndian.PutUint16(tcp[2:4], dport)
+	if f.Direction == FRAME_RX {
+		binary.BigEndian.PutUint32(tcp[4:8], seq[FRAME_RX])
+		binary.BigEndian.PutUint32(tcp[8:12], seq[FRAME_TX])
+	} else {
+		binary.BigEndian.PutUint32(tcp[4:8], seq[FRAME_TX])
+		binary.BigEndian.PutUint32(tcp[8:12], seq[FRAME_RX])
+	}
+	tcp[12]	= 0x50
+	tcp[13]	= 0x18
+	binary.BigEndian.PutUint16(tcp[14:16], 0xffff)
+	tcp	= append(tcp, f.ADU...)
+
+	// TCP checksum over the pseudo header, TCP header and payload
+	pseudo	= append(pseudo, src[:]...)
+	pseudo	= append(pseudo, dst[:]...)
+	pseudo	= append(pseudo, 0x00, 0x06)
+	pseudo	= binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:18], inetChecksum(append(pseudo, tcp...)))
+
+	// IPv4 header (no options): version/IHL, total length, id, don't
+	// fragment, TTL 64, protocol TCP and addresses
+	ip	= make([]byte, 20)
+	ip[0]	= 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20 + len(tcp)))
+	binary.BigEndian.PutUint16(ip[4:6], ipId)
+	ip[6]	= 0x40
+	ip[8]	= 64
+	ip[9]	= 0x06
+	copy(ip[12:16], src[:])
+	copy(ip[16:20], dst[:])
+	binary.BigEndian.PutUint16(ip[10:12], inetChecksum(ip))
+
+	packet	= append(ip, tcp...)
+
+	return
+}
+
+// Returns the internet checksum (RFC 1071) of buf.
+func inetChecksum(buf []byte) (sum uint16) {
+	var acc	uint32
+
+	for i := 0; i + 1 < len(buf); i += 2 {
+		acc	+= uint32(buf[i]) << 8 | uint32(buf[i + 1])
+	}
+	if len(buf) % 2 == 1 {
+		acc	+= uint32(buf[len(buf) - 1]) << 8
+	}
+	for acc > 0xffff {
+		acc	= acc >> 16 + acc & 0xffff
+	}
+
+	sum	= ^uint16(acc)
+
+	return
+}
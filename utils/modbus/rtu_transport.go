@@ -18,6 +18,8 @@ type rtuTransport struct {
 	lastActivity time.Time
 	t35          time.Duration
 	t1           time.Duration
+	turnaround   time.Duration
+	tracer       FrameTracer
 }
 
 type rtuLink interface {
@@ -34,15 +36,7 @@ func newRTUTransport(link rtuLink, addr string, speed uint, timeout time.Duratio
 		link:    link,
 		timeout: timeout,
 		t1:      serialCharTime(speed),
-	}
-
-	if speed >= 19200 {
-		// for baud rates equal to or greater than 19200 bauds, a fixed value of
-		// 1750 uS is specified for t3.5.
-		rt.t35 = 1750 * time.Microsecond
-	} else {
-		// for lower baud rates, the inter-frame delay should be 3.5 character times
-		rt.t35 = (serialCharTime(speed) * 35) / 10
+		t35:     serialInterFrameDelay(speed),
 	}
 
 	discard(rt.link)
@@ -59,9 +53,11 @@ func (rt *rtuTransport) Close() (err error) {
 
 // Runs a request across the rtu link and returns a response.
 func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
-	var ts time.Time
-	var t  time.Duration
-	var n  int
+	var ts  time.Time
+	var t   time.Duration
+	var n   int
+	var adu []byte
+	var rec *recordingLink
 
 	// set an i/o deadline on the link
 	err	= rt.link.SetDeadline(time.Now().Add(rt.timeout))
@@ -80,7 +76,10 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 
 	// build an RTU ADU out of the request object and
 	// send the final ADU+CRC on the wire
-	n, err	= rt.link.Write(rt.assembleRTUFrame(req))
+	adu	= rt.assembleRTUFrame(req)
+	traceFrame(rt.tracer, FRAME_RTU, FRAME_TX, adu)
+
+	n, err	= rt.link.Write(adu)
 	if err != nil {
 		return
 	}
@@ -93,9 +92,30 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 	// observe inter-frame delays
 	time.Sleep(rt.lastActivity.Add(rt.t35).Sub(time.Now()))
 
-	// read the response back from the wire
+	// broadcast requests (unit id 0) get no response: wait out the
+	// turnaround delay to give devices time to process the request
+	if req.unitId == 0x00 {
+		time.Sleep(rt.turnaround)
+		rt.lastActivity	= time.Now()
+		return
+	}
+
+	// read the response back from the wire, recording the bytes
+	// received for tracing (including those of invalid frames)
+	if rt.tracer != nil {
+		rec	= &recordingLink{rtuLink: rt.link}
+		rt.link	= rec
+	}
+
 	res, err = rt.readRTUFrame()
 
+	if rec != nil {
+		rt.link	= rec.rtuLink
+		if len(rec.rx) > 0 {
+			rt.tracer.TraceFrame(FRAME_RTU, FRAME_RX, rec.rx)
+		}
+	}
+
 	if err == ErrBadCRC || err == ErrProtocolError || err == ErrShortFrame {
 		// wait for and flush any data coming off the link to allow
 		// devices to re-sync
@@ -111,12 +131,42 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 	return
 }
 
+// Returns a new RTU transport serving requests off a network link
+// (rtuovertcp and rtuoverudp servers).
+// Unlike newRTUTransport, the link is not flushed as clients may send their
+// first request right after connecting, and no serial timings apply.
+func newRTUServerTransport(link rtuLink, addr string, timeout time.Duration, customLogger *log.Logger) (rt *rtuTransport) {
+	rt = &rtuTransport{
+		logger:  newLogger(fmt.Sprintf("rtu-transport(%s)", addr), customLogger),
+		link:    link,
+		timeout: timeout,
+	}
+
+	return
+}
+
 // Reads a request from the rtu link.
+// Frames which fail to decode are logged and discarded, the link being
+// flushed to re-sync: only link errors (including idle timeouts) cause
+// ReadRequest to return an error.
 func (rt *rtuTransport) ReadRequest() (req *pdu, err error) {
-	// reading requests from RTU links is currently unsupported
-	err	= fmt.Errorf("unimplemented")
+	for {
+		// set an i/o deadline on the link
+		err	= rt.link.SetDeadline(time.Now().Add(rt.timeout))
+		if err != nil {
+			return
+		}
+
+		req, err = rt.readRTURequest()
+		switch err {
+		case ErrBadCRC, ErrShortFrame, ErrProtocolError:
+			rt.logger.Warningf("discarding invalid frame: %v", err)
+			discard(rt.link)
+			continue
+		}
 
-	return
+		return
+	}
 }
 
 // Writes a response to the rtu link.
@@ -140,6 +190,7 @@ func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 	var rxbuf       []byte
 	var byteCount   int
 	var bytesNeeded int
+	var headerLen   int = 3
 	var crc         crc
 
 	rxbuf = make([]byte, maxRTUFrameLength)
@@ -155,22 +206,44 @@ func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 		return
 	}
 
-	// figure out how many further bytes to read
-	bytesNeeded, err = expectedResponseLenth(uint8(rxbuf[1]), uint8(rxbuf[2]))
-	if err != nil {
-		return
+	// read FIFO queue responses carry a 2-byte byte count: fetch its low
+	// byte before working out how many further bytes to read
+	if rxbuf[1] == fcReadFifoQueue {
+		byteCount, err	= io.ReadFull(rt.link, rxbuf[3:4])
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return
+		}
+		if byteCount != 1 {
+			err = ErrShortFrame
+			return
+		}
+		headerLen	= 4
+		bytesNeeded	= int(bytesToUint16(BIG_ENDIAN, rxbuf[2:4]))
+	} else if rxbuf[1] == fcEncapsulatedInterface {
+		// read device identification responses carry no byte count:
+		// walk the object list to find out where the frame ends
+		headerLen, err	= rt.readDeviceIdBody(rxbuf)
+		if err != nil {
+			return
+		}
+	} else {
+		// figure out how many further bytes to read
+		bytesNeeded, err = expectedResponseLenth(uint8(rxbuf[1]), uint8(rxbuf[2]))
+		if err != nil {
+			return
+		}
 	}
 
 	// we need to read 2 additional bytes of CRC after the payload
 	bytesNeeded	+= 2
 
 	// never read more than the max allowed frame length
-	if byteCount + bytesNeeded > maxRTUFrameLength {
+	if headerLen + bytesNeeded > maxRTUFrameLength {
 		err	= ErrProtocolError
 		return
 	}
 
-	byteCount, err	= io.ReadFull(rt.link, rxbuf[3:3 + bytesNeeded])
+	byteCount, err	= io.ReadFull(rt.link, rxbuf[headerLen:headerLen + bytesNeeded])
 	if err != nil && err != io.ErrUnexpectedEOF {
 		return
 	}
@@ -182,10 +255,10 @@ func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 
 	// compute the CRC on the entire frame, excluding the CRC
 	crc.init()
-	crc.add(rxbuf[0:3 + bytesNeeded - 2])
+	crc.add(rxbuf[0:headerLen + bytesNeeded - 2])
 
 	// compare CRC values
-	if !crc.isEqual(rxbuf[3 + bytesNeeded - 2], rxbuf[3 + bytesNeeded - 1]) {
+	if !crc.isEqual(rxbuf[headerLen + bytesNeeded - 2], rxbuf[headerLen + bytesNeeded - 1]) {
 		err = ErrBadCRC
 		return
 	}
@@ -194,12 +267,165 @@ func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 		unitId:	      rxbuf[0],
 		functionCode: rxbuf[1],
 		// pass the byte count + trailing data as payload, withtout the CRC
-		payload:      rxbuf[2:3 + bytesNeeded  - 2],
+		payload:      rxbuf[2:headerLen + bytesNeeded  - 2],
+	}
+
+	return
+}
+
+// Waits for, reads and decodes a request frame from the rtu link.
+func (rt *rtuTransport) readRTURequest() (req *pdu, err error) {
+	var rxbuf	[]byte
+	var frameLen	int
+	var byteCount	int
+	var counted	bool
+	var crc		crc
+
+	rxbuf = make([]byte, maxRTUFrameLength)
+
+	// read the unit id and function code
+	err	= rt.readChunk(rxbuf[0:2])
+	if err != nil {
+		return
+	}
+
+	// work out the length of the fixed part of the request, up to and
+	// including the byte count field of requests carrying one
+	switch rxbuf[1] {
+	case fcReadCoils, fcReadDiscreteInputs, fcReadHoldingRegisters,
+	     fcReadInputRegisters, fcWriteSingleCoil, fcWriteSingleRegister:
+		frameLen	= 2 + 4
+	case fcWriteMultipleCoils, fcWriteMultipleRegisters:
+		frameLen	= 2 + 5
+		counted		= true
+	case fcReadFileRecord, fcWriteFileRecord:
+		frameLen	= 2 + 1
+		counted		= true
+	case fcMaskWriteRegister:
+		frameLen	= 2 + 6
+	case fcReadWriteMultipleRegisters:
+		frameLen	= 2 + 9
+		counted		= true
+	case fcReadFifoQueue:
+		frameLen	= 2 + 2
+	case fcEncapsulatedInterface:
+		frameLen	= 2 + 3
+	default:
+		// the frame length of unknown function codes can't be known
+		err	= ErrProtocolError
+		return
+	}
+
+	err	= rt.readChunk(rxbuf[2:frameLen])
+	if err != nil {
+		return
+	}
+
+	// read the variable part of the request, then the CRC
+	if counted {
+		byteCount	= int(rxbuf[frameLen - 1])
+		if frameLen + byteCount + 2 > maxRTUFrameLength {
+			err	= ErrProtocolError
+			return
+		}
+		err		= rt.readChunk(rxbuf[frameLen:frameLen + byteCount])
+		if err != nil {
+			return
+		}
+		frameLen	+= byteCount
+	}
+
+	err	= rt.readChunk(rxbuf[frameLen:frameLen + 2])
+	if err != nil {
+		return
+	}
+
+	// compute the CRC on the entire frame, excluding the CRC
+	crc.init()
+	crc.add(rxbuf[0:frameLen])
+
+	if !crc.isEqual(rxbuf[frameLen], rxbuf[frameLen + 1]) {
+		err = ErrBadCRC
+		return
+	}
+
+	req	= &pdu{
+		unitId:	      rxbuf[0],
+		functionCode: rxbuf[1],
+		payload:      rxbuf[2:frameLen],
 	}
 
 	return
 }
 
+// Reads the remainder of a read device identification response (function
+// code 0x2b, MEI type 0x0e) into rxbuf, after the 3-byte ADU header.
+// Returns the number of bytes held in rxbuf, excluding the CRC.
+func (rt *rtuTransport) readDeviceIdBody(rxbuf []byte) (frameLen int, err error) {
+	var objCount	int
+	var objLen	int
+
+	if rxbuf[2] != meiReadDeviceId {
+		err	= ErrProtocolError
+		return
+	}
+
+	// read device id code, conformity level, more follows, next object id
+	// and number of objects
+	frameLen	= 3
+	err		= rt.readChunk(rxbuf[frameLen:frameLen + 5])
+	if err != nil {
+		return
+	}
+	frameLen	+= 5
+	objCount	= int(rxbuf[frameLen - 1])
+
+	for i := 0; i < objCount; i++ {
+		// object id and object length, leaving room for the CRC
+		if frameLen + 2 + 2 > maxRTUFrameLength {
+			err	= ErrProtocolError
+			return
+		}
+		err	= rt.readChunk(rxbuf[frameLen:frameLen + 2])
+		if err != nil {
+			return
+		}
+		objLen		= int(rxbuf[frameLen + 1])
+		frameLen	+= 2
+
+		// object value
+		if frameLen + objLen + 2 > maxRTUFrameLength {
+			err	= ErrProtocolError
+			return
+		}
+		err	= rt.readChunk(rxbuf[frameLen:frameLen + objLen])
+		if err != nil {
+			return
+		}
+		frameLen	+= objLen
+	}
+
+	return
+}
+
+// Reads exactly len(buf) bytes from the rtu link.
+func (rt *rtuTransport) readChunk(buf []byte) (err error) {
+	var byteCount	int
+
+	byteCount, err	= io.ReadFull(rt.link, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return
+	}
+	if byteCount != len(buf) {
+		rt.logger.Warningf("expected %v bytes, received %v", len(buf), byteCount)
+		err = ErrShortFrame
+		return
+	}
+	err	= nil
+
+	return
+}
+
 // Turns a PDU object into bytes.
 func (rt *rtuTransport) assembleRTUFrame(p *pdu) (adu []byte) {
 	var crc		crc
@@ -224,7 +450,10 @@ func expectedResponseLenth(responseCode uint8, responseLength uint8) (byteCount
 	case fcReadHoldingRegisters,
 	     fcReadInputRegisters,
 	     fcReadCoils,
-	     fcReadDiscreteInputs:            byteCount = int(responseLength)
+	     fcReadDiscreteInputs,
+	     fcReadWriteMultipleRegisters,
+	     fcReadFileRecord,
+	     fcWriteFileRecord:               byteCount = int(responseLength)
 	case fcWriteSingleRegister,
 	     fcWriteMultipleRegisters,
 	     fcWriteSingleCoil,
@@ -238,7 +467,12 @@ func expectedResponseLenth(responseCode uint8, responseLength uint8) (byteCount
 	     fcWriteMultipleRegisters | 0x80,
 	     fcWriteSingleCoil | 0x80,
 	     fcWriteMultipleCoils | 0x80,
-	     fcMaskWriteRegister | 0x80:      byteCount = 0
+	     fcMaskWriteRegister | 0x80,
+	     fcReadWriteMultipleRegisters | 0x80,
+	     fcReadFifoQueue | 0x80,
+	     fcReadFileRecord | 0x80,
+	     fcWriteFileRecord | 0x80,
+	     fcEncapsulatedInterface | 0x80:  byteCount = 0
 	default: err = ErrProtocolError
 	}
 
@@ -257,6 +491,21 @@ func discard(link rtuLink) {
 	return
 }
 
+// Returns the inter-frame delay (t3.5) of a serial line at the specified
+// baud rate.
+func serialInterFrameDelay(rate_bps uint) (t35 time.Duration) {
+	if rate_bps >= 19200 {
+		// for baud rates equal to or greater than 19200 bauds, a fixed value of
+		// 1750 uS is specified for t3.5.
+		t35 = 1750 * time.Microsecond
+	} else {
+		// for lower baud rates, the inter-frame delay should be 3.5 character times
+		t35 = (serialCharTime(rate_bps) * 35) / 10
+	}
+
+	return
+}
+
 // Returns how long it takes to send 1 byte on a serial line at the
 // specified baud rate.
 func serialCharTime(rate_bps uint) (ct time.Duration) {
@@ -148,6 +148,87 @@ func TestRTUTransportReadRTUFrame(t *testing.T) {
 		}
 	}
 
+	// read a FIFO queue response, which carries a 2-byte byte count
+	txchan <- []byte{
+		0x31, 0x18, // unit id and response code
+		0x00, 0x06, // byte count
+		0x00, 0x02, // FIFO count
+		0x01, 0xb8, // register #1
+		0x12, 0x84, // register #2
+		0x19, 0xe7, // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x18 {
+		t.Errorf("expected 0x18 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 8 {
+		t.Errorf("expected a length of 8, got %v", len(res.payload))
+	}
+	for i, b := range []byte{
+		0x00, 0x06,
+		0x00, 0x02,
+		0x01, 0xb8,
+		0x12, 0x84,
+	} {
+		if res.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+				 b, i, res.payload[i])
+		}
+	}
+
+	// read a read file record response made of two sub-responses
+	txchan <- []byte{
+		0x31, 0x14, // unit id and response code
+		0x0c,       // response data length
+		0x05, 0x06, // sub-response #1 length and reference type
+		0x0d, 0xfe, // record #1
+		0x00, 0x20, // record #2
+		0x05, 0x06, // sub-response #2 length and reference type
+		0x33, 0xcd, // record #1
+		0x00, 0x40, // record #2
+		0x49, 0xb5, // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x14 {
+		t.Errorf("expected 0x14 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 13 {
+		t.Errorf("expected a length of 13, got %v", len(res.payload))
+	}
+	if res.payload[0] != 0x0c || res.payload[12] != 0x40 {
+		t.Errorf("unexpected payload: %v", res.payload)
+	}
+
+	// read a device identification response, which carries no byte count
+	txchan <- []byte{
+		0x31, 0x2b, 0x0e, // unit id, response code and MEI type
+		0x01, 0x81,       // read device id code and conformity level
+		0x00, 0x00,       // more follows and next object id
+		0x02,             // number of objects
+		0x00, 0x02, 0x41, 0x42, // object #0: "AB"
+		0x01, 0x01, 0x43,       // object #1: "C"
+		0x65, 0x1e,       // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x2b {
+		t.Errorf("expected 0x2b as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 13 {
+		t.Errorf("expected a length of 13, got %v", len(res.payload))
+	}
+	if res.payload[0] != 0x0e || res.payload[12] != 0x43 {
+		t.Errorf("unexpected payload: %v", res.payload)
+	}
+
 	p1.Close()
 	p2.Close()
 
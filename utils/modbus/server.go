@@ -25,6 +25,14 @@ type ServerConfiguration struct {
 	// Timeout sets the idle session timeout (client connections will
 	// be closed if idle for this long)
 	Timeout	      time.Duration
+	// Speed sets the serial link speed (in bps, ascii only)
+	Speed         uint
+	// DataBits sets the number of bits per serial character (ascii only)
+	DataBits      uint
+	// Parity sets the serial link parity mode (ascii only)
+	Parity        uint
+	// StopBits sets the number of serial stop bits (ascii only)
+	StopBits      uint
 	// MaxClients sets the maximum number of concurrent client connections
 	MaxClients    uint
 	// TLSServerCert sets the server-side TLS key pair (tcp+tls only)
@@ -33,6 +41,11 @@ type ServerConfiguration struct {
 	// client connections (tcp+tls only). Leaf (i.e. client) certificates can
 	// also be used in case of self-signed certs, or if cert pinning is required.
 	TLSClientCAs  *x509.CertPool
+	// ACL sets the access control rules applied to requests before they
+	// reach the handler, e.g. to restrict writes to clients presenting a
+	// given role in their certificate (tcp+tls). If nil, all requests are
+	// passed to the handler.
+	ACL           *ACL
 	// Logger provides a custom sink for log messages.
 	// If nil, messages will be written to stdout.
 	Logger        *log.Logger
@@ -47,6 +60,8 @@ type CoilsRequest struct {
 	Quantity   uint16  // the number of consecutive coils covered by this request
 	                   // (first address: Addr, last address: Addr + Quantity - 1)
 	IsWrite    bool    // true if the request is a write, false if a read
+	IsSingleWrite bool // true if the write came in as a write single coil
+	                   // (0x05) rather than write multiple coils (0x0f)
 	Args       []bool  // a slice of bool values of the coils to be set, ordered
 	                   // from Addr to Addr + Quantity - 1 (for writes only)
 }
@@ -68,6 +83,8 @@ type HoldingRegistersRequest struct {
 	Addr       uint16   // the base register address requested
 	Quantity   uint16   // the number of consecutive registers covered by this request
 	IsWrite    bool     // true if the request is a write, false if a read
+	IsSingleWrite bool  // true if the write came in as a write single register
+	                    // (0x06) rather than write multiple registers (0x10)
 	Args       []uint16 // a slice of register values to be set, ordered from
 	                    // Addr to Addr + Quantity - 1 (for writes only)
 }
@@ -81,6 +98,56 @@ type InputRegistersRequest struct {
 	Quantity   uint16   // the number of consecutive registers covered by this request
 }
 
+// Request object passed to the mask write register handler.
+type MaskWriteRegisterRequest struct {
+	ClientAddr string   // the source (client) IP address
+	ClientRole string   // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8    // the requested unit id (slave id)
+	Addr       uint16   // the holding register address to modify
+	AndMask    uint16   // the AND mask
+	OrMask     uint16   // the OR mask: the new register value is
+	                    // (current AND AndMask) OR (OrMask AND NOT AndMask)
+}
+
+// Request object passed to the read/write multiple registers handler.
+type ReadWriteRegistersRequest struct {
+	ClientAddr    string   // the source (client) IP address
+	ClientRole    string   // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId        uint8    // the requested unit id (slave id)
+	ReadAddr      uint16   // the base holding register address to read
+	ReadQuantity  uint16   // the number of consecutive registers to read
+	WriteAddr     uint16   // the base holding register address to write
+	WriteQuantity uint16   // the number of consecutive registers to write
+	Args          []uint16 // a slice of register values to be set, ordered from
+	                       // WriteAddr to WriteAddr + WriteQuantity - 1
+}
+
+// Request object passed to the FIFO queue handler.
+type FIFOQueueRequest struct {
+	ClientAddr string   // the source (client) IP address
+	ClientRole string   // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8    // the requested unit id (slave id)
+	Addr       uint16   // the FIFO pointer (count register) address
+}
+
+// Request object passed to the file record handler.
+type FileRecordRequest struct {
+	ClientAddr string       // the source (client) IP address
+	ClientRole string       // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8        // the requested unit id (slave id)
+	IsWrite    bool         // true if the request is a write, false if a read
+	Records    []FileRecord // the sub-requests, in request order: RecordLength
+	                        // holds the number of records to read (reads only),
+	                        // Values the records to write (writes only)
+}
+
+// Request object passed to the device identification handler.
+type DeviceIdentificationRequest struct {
+	ClientAddr string   // the source (client) IP address
+	ClientRole string   // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8    // the requested unit id (slave id)
+}
+
 // The RequestHandler interface should be implemented by the handler
 // object passed to NewServer (see reqHandler in NewServer()).
 // After decoding and validating an incoming request, the server will
@@ -139,6 +206,84 @@ type RequestHandler interface {
 	HandleInputRegisters	(req *InputRegistersRequest) (res []uint16, err error)
 }
 
+// The following interfaces are optional: a handler passed to NewServer may
+// implement any of them to serve the matching function code natively.
+
+// MaskWriteRegisterHandler handles the mask write register (0x16) function code.
+// If the request handler does not implement it, the server falls back to a
+// read followed by a write through HandleHoldingRegisters, which is not atomic
+// with respect to other clients.
+type MaskWriteRegisterHandler interface {
+	// Expected return values:
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleMaskWriteRegister	(req *MaskWriteRegisterRequest) (err error)
+}
+
+// ReadWriteRegistersHandler handles the read/write multiple registers (0x17)
+// function code. The write must be performed before the read.
+// If the request handler does not implement it, the server falls back to a
+// write followed by a read through HandleHoldingRegisters.
+type ReadWriteRegistersHandler interface {
+	// Expected return values:
+	// - res:	a slice of ReadQuantity uint16 values read after the write,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleReadWriteRegisters	(req *ReadWriteRegistersRequest) (res []uint16, err error)
+}
+
+// FIFOQueueHandler handles the read FIFO queue (0x18) function code.
+// Servers whose handler does not implement it reply with an illegal function
+// exception.
+type FIFOQueueHandler interface {
+	// Expected return values:
+	// - res:	the queued values, oldest first (at most 31),
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleFIFOQueue	(req *FIFOQueueRequest) (res []uint16, err error)
+}
+
+// FileRecordHandler handles the read file record (0x14) and write file record
+// (0x15) function codes.
+// Servers whose handler does not implement it reply with an illegal function
+// exception.
+type FileRecordHandler interface {
+	// Expected return values:
+	// - res:	one slice of RecordLength uint16 values per sub-request, in
+	//		request order (only sent for reads),
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleFileRecords	(req *FileRecordRequest) (res [][]uint16, err error)
+}
+
+// DeviceIdentificationHandler handles the read device identification (0x2b,
+// MEI type 0x0e) function code. The server takes care of selecting objects
+// by category and of splitting them across "more follows" responses.
+// Servers whose handler does not implement it reply with an illegal function
+// exception.
+type DeviceIdentificationHandler interface {
+	// Expected return values:
+	// - res:	the device identification objects keyed by object id (see
+	//		OBJ_* constants): vendor name, product code and revision are
+	//		mandatory, values are at most 244 bytes long,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleDeviceIdentification	(req *DeviceIdentificationRequest) (res map[uint8]string, err error)
+}
+
+// UnitHandler is an optional interface for handlers serving a given set of
+// unit ids, e.g. simulating several devices on one bus. On serial links,
+// requests to other unit ids are left unanswered as they would be on a
+// real bus; network transports pass them to the handler all the same.
+type UnitHandler interface {
+	ServesUnit	(unitId uint8) (yes bool)
+}
+
 // Modbus server object.
 type ModbusServer struct {
 	conf		ServerConfiguration
@@ -148,6 +293,8 @@ type ModbusServer struct {
 	handler		RequestHandler
 	tcpListener	net.Listener
 	tcpClients	[]net.Conn
+	udpSock		*net.UDPConn
+	serialPort	*serialPortWrapper
 	transportType	transportType
 }
 
@@ -179,6 +326,15 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 		return
 	}
 
+	if ms.conf.ACL != nil {
+		err = ms.conf.ACL.validate()
+		if err != nil {
+			ms.logger.Errorf("%v", err)
+			err = ErrConfigurationError
+			return
+		}
+	}
+
 	switch serverType {
 	case "tcp":
 		if ms.conf.Timeout == 0 {
@@ -217,6 +373,62 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 
 		ms.transportType	= modbusTCPOverTLS
 
+	case "asciiovertcp":
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 120 * time.Second
+		}
+
+		if ms.conf.MaxClients == 0 {
+			ms.conf.MaxClients = 10
+		}
+
+		ms.transportType	= modbusASCIIOverTCP
+
+	case "rtuovertcp":
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 120 * time.Second
+		}
+
+		if ms.conf.MaxClients == 0 {
+			ms.conf.MaxClients = 10
+		}
+
+		ms.transportType	= modbusRTUOverTCP
+
+	case "udp":
+		// UDP is connection-less: each datagram is expected to carry
+		// a single request and is answered on its own
+		ms.transportType	= modbusTCPOverUDP
+
+	case "rtuoverudp":
+		ms.transportType	= modbusRTUOverUDP
+
+	case "ascii":
+		// use the same serial defaults as the client
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed = 19200
+		}
+
+		if ms.conf.DataBits == 0 {
+			ms.conf.DataBits = 7
+		}
+
+		if ms.conf.StopBits == 0 {
+			if ms.conf.Parity == PARITY_NONE {
+				ms.conf.StopBits = 2
+			} else {
+				ms.conf.StopBits = 1
+			}
+		}
+
+		// there are no sessions on serial links: idle timeouts only
+		// cause the link handler to re-arm
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 120 * time.Second
+		}
+
+		ms.transportType	= modbusASCII
+
 	default:
 		err	= ErrConfigurationError
 		return
@@ -235,7 +447,7 @@ func (ms *ModbusServer) Start() (err error) {
 	}
 
 	switch ms.transportType {
-	case modbusTCP, modbusTCPOverTLS:
+	case modbusTCP, modbusTCPOverTLS, modbusASCIIOverTCP, modbusRTUOverTCP:
 		// bind to a TCP socket
 		ms.tcpListener, err	= net.Listen("tcp", ms.conf.URL)
 		if err != nil {
@@ -245,6 +457,41 @@ func (ms *ModbusServer) Start() (err error) {
 		// accept client connections in a goroutine
 		go ms.acceptTCPClients()
 
+	case modbusASCII:
+		// open the serial device
+		ms.serialPort	= newSerialPortWrapper(&serialPortConfig{
+			Device:		ms.conf.URL,
+			Speed:		ms.conf.Speed,
+			DataBits:	ms.conf.DataBits,
+			Parity:		ms.conf.Parity,
+			StopBits:	ms.conf.StopBits,
+		})
+
+		err	= ms.serialPort.Open()
+		if err != nil {
+			return
+		}
+
+		// serve requests off the serial link in a goroutine
+		go ms.handleSerialLink(ms.serialPort)
+
+	case modbusTCPOverUDP, modbusRTUOverUDP:
+		var addr	*net.UDPAddr
+
+		// bind to a UDP socket
+		addr, err	= net.ResolveUDPAddr("udp", ms.conf.URL)
+		if err != nil {
+			return
+		}
+
+		ms.udpSock, err	= net.ListenUDP("udp", addr)
+		if err != nil {
+			return
+		}
+
+		// serve datagrams in a goroutine
+		go ms.serveUDP(ms.udpSock)
+
 	default:
 		err = ErrConfigurationError
 		return
@@ -266,7 +513,16 @@ func (ms *ModbusServer) Stop() (err error) {
 
 	ms.started = false
 
-	if ms.transportType == modbusTCP || ms.transportType == modbusTCPOverTLS {
+	switch ms.transportType {
+	case modbusASCII:
+		// close the serial port, causing the serial link handler to return
+		err	= ms.serialPort.Close()
+
+	case modbusTCPOverUDP, modbusRTUOverUDP:
+		// close the UDP socket, causing the datagram handler to return
+		err	= ms.udpSock.Close()
+
+	default:
 		// close the server socket if we're listening over TCP
 		err	= ms.tcpListener.Close()
 
@@ -354,6 +610,20 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 				sock.RemoteAddr().String(), clientRole)
 		}
 
+	case modbusASCIIOverTCP:
+		// serve ASCII-framed modbus requests over the raw TCP connection
+		ms.handleTransport(
+			newASCIITransport(sock, sock.RemoteAddr().String(),
+					  ms.conf.Timeout, ms.conf.Logger),
+			sock.RemoteAddr().String(), "")
+
+	case modbusRTUOverTCP:
+		// serve RTU-framed modbus requests over the raw TCP connection
+		ms.handleTransport(
+			newRTUServerTransport(sock, sock.RemoteAddr().String(),
+					      ms.conf.Timeout, ms.conf.Logger),
+			sock.RemoteAddr().String(), "")
+
 	default:
 		ms.logger.Errorf("unimplemented transport type %v", ms.transportType)
 	}
@@ -375,6 +645,107 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 	return
 }
 
+// Serves requests off a UDP socket until the server is stopped.
+// Datagrams are served one at a time, each expected to hold a single request
+// (MBAP or RTU framed depending on the transport type), the response being
+// sent back to the source address of the datagram.
+func (ms *ModbusServer) serveUDP(sock *net.UDPConn) {
+	var rxbuf	[]byte
+	var n		int
+	var addr	*net.UDPAddr
+	var conn	*udpDatagramConn
+	var err		error
+
+	rxbuf	= make([]byte, maxTCPFrameLength)
+
+	for {
+		n, addr, err	= sock.ReadFromUDP(rxbuf)
+		if err != nil {
+			// return once the socket has been closed by Stop()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			ms.logger.Warningf("failed to read datagram: %v", err)
+			continue
+		}
+
+		conn	= &udpDatagramConn{
+			sock:	sock,
+			addr:	addr,
+			rxbuf:	rxbuf[0:n],
+		}
+
+		// handleTransport() returns once the datagram is consumed
+		if ms.transportType == modbusRTUOverUDP {
+			ms.handleTransport(
+				newRTUServerTransport(conn, addr.String(),
+						      ms.conf.Timeout, ms.conf.Logger),
+				addr.String(), "")
+		} else {
+			ms.handleTransport(
+				newTCPTransport(conn, ms.conf.Timeout, ms.conf.Logger),
+				addr.String(), "")
+		}
+	}
+}
+
+// Serves requests off a serial link until the server is stopped.
+// handleTransport() returns on idle timeouts and closes the transport on
+// protocol errors: as the serial port must stay open until Stop() is called,
+// the transport is handed a link which ignores Close() calls and is served
+// again for as long as the server is running.
+func (ms *ModbusServer) handleSerialLink(spw *serialPortWrapper) {
+	var t		*asciiTransport
+	var started	bool
+
+	t	= newASCIITransport(&serverSerialLink{spw}, ms.conf.URL,
+				    ms.conf.Timeout, ms.conf.Logger)
+
+	for {
+		ms.handleTransport(t, ms.conf.URL, "")
+
+		ms.lock.Lock()
+		started	= ms.started && ms.serialPort == spw
+		ms.lock.Unlock()
+
+		if !started {
+			return
+		}
+	}
+}
+
+// Returns true if the handler serves unitId, which is the case of handlers
+// not implementing UnitHandler for any unit id.
+func (ms *ModbusServer) servesUnit(unitId uint8) (yes bool) {
+	var uh	UnitHandler
+	var ok	bool
+
+	uh, ok	= ms.handler.(UnitHandler)
+	yes	= !ok || uh.ServesUnit(unitId)
+
+	return
+}
+
+// Returns true if the server uses serial line (rtu or ascii) framing, where
+// unit id 0 addresses all devices of the bus.
+func (ms *ModbusServer) isSerialFramed() (yes bool) {
+	switch ms.transportType {
+	case modbusRTUOverTCP, modbusRTUOverUDP, modbusASCII, modbusASCIIOverTCP:
+		yes	= true
+	}
+
+	return
+}
+
+// Serial link wrapper keeping the port open when the transport is closed.
+type serverSerialLink struct {
+	*serialPortWrapper
+}
+
+func (ssl *serverSerialLink) Close() (err error) {
+	return
+}
+
 // For each request read from the transport, performs decoding and validation,
 // calls the user-provided handler, then encodes and writes the response
 // to the transport.
@@ -391,6 +762,11 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			return
 		}
 
+		// stay silent on requests to devices not on the bus
+		if ms.transportType == modbusASCII && !ms.servesUnit(req.unitId) {
+			continue
+		}
+
 		switch req.functionCode {
 		case fcReadCoils, fcReadDiscreteInputs:
 			var coils	[]bool
@@ -416,6 +792,12 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 				break
 			}
 
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, aclSpan{addr, quantity})
+			if err != nil {
+				break
+			}
+
 			// invoke the appropriate handler
 			if req.functionCode == fcReadCoils {
 				coils, err	= ms.handler.HandleCoils(&CoilsRequest{
@@ -483,6 +865,12 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 				break
 			}
 
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, aclSpan{addr, 1})
+			if err != nil {
+				break
+			}
+
 			// invoke the coil handler
 			_, err	= ms.handler.HandleCoils(&CoilsRequest{
 				ClientAddr: clientAddr,
@@ -491,6 +879,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 				Addr:       addr,
 				Quantity:   1, // request for a single coil
 				IsWrite:    true, // this is a write request
+				IsSingleWrite: true,
 				Args:       []bool{(req.payload[2] == 0xff)},
 			})
 
@@ -550,6 +939,12 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 				break
 			}
 
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, aclSpan{addr, quantity})
+			if err != nil {
+				break
+			}
+
 			// invoke the coil handler
 			_, err	= ms.handler.HandleCoils(&CoilsRequest{
 				ClientAddr: clientAddr,
@@ -601,6 +996,12 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 				break
 			}
 
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, aclSpan{addr, quantity})
+			if err != nil {
+				break
+			}
+
 			// invoke the appropriate handler
 			if req.functionCode == fcReadHoldingRegisters {
 				regs, err	= ms.handler.HandleHoldingRegisters(
@@ -663,6 +1064,12 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			addr	= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
 			value	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
 
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, aclSpan{addr, 1})
+			if err != nil {
+				break
+			}
+
 			// invoke the handler
 			_, err	= ms.handler.HandleHoldingRegisters(
 				&HoldingRegistersRequest{
@@ -672,6 +1079,7 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 					Addr:       addr,
 					Quantity:   1, // request for a single register
 					IsWrite:    true, // request is a write
+					IsSingleWrite: true,
 					Args:       []uint16{value},
 				})
 
@@ -728,6 +1136,12 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 				break
 			}
 
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, aclSpan{addr, quantity})
+			if err != nil {
+				break
+			}
+
 			// invoke the holding register handler
 			_, err		= ms.handler.HandleHoldingRegisters(
 				&HoldingRegistersRequest{
@@ -755,6 +1169,296 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 			res.payload	= append(res.payload,
 						 uint16ToBytes(BIG_ENDIAN, quantity)...)
 
+		case fcMaskWriteRegister:
+			var andMask	uint16
+			var orMask	uint16
+
+			if len(req.payload) != 6 {
+				err = ErrProtocolError
+				break
+			}
+
+			// decode address and mask fields
+			addr	= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+			andMask	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+			orMask	= bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, aclSpan{addr, 1})
+			if err != nil {
+				break
+			}
+
+			err	= ms.maskWriteRegister(&MaskWriteRegisterRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				Addr:       addr,
+				AndMask:    andMask,
+				OrMask:     orMask,
+			})
+			if err != nil {
+				break
+			}
+
+			// assemble a response PDU echoing the request
+			res = &pdu{
+				unitId:		req.unitId,
+				functionCode:	req.functionCode,
+				payload:	req.payload,
+			}
+
+		case fcReadWriteMultipleRegisters:
+			var regs	  []uint16
+			var writeAddr	  uint16
+			var writeQuantity uint16
+			var expectedLen	  int
+
+			if len(req.payload) < 11 {
+				err = ErrProtocolError
+				break
+			}
+
+			// decode address and quantity fields
+			addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+			quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+			writeAddr	= bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+			writeQuantity	= bytesToUint16(BIG_ENDIAN, req.payload[6:8])
+
+			// ensure the reply never exceeds the maximum PDU length and we
+			// never read or write past 0xffff
+			if quantity > 0x007d || quantity == 0 ||
+			   writeQuantity > 0x0079 || writeQuantity == 0 {
+				err	= ErrProtocolError
+				break
+			}
+			if uint32(addr) + uint32(quantity) - 1 > 0xffff ||
+			   uint32(writeAddr) + uint32(writeQuantity) - 1 > 0xffff {
+				err	= ErrIllegalDataAddress
+				break
+			}
+
+			// validate the byte count field (2 bytes per register)
+			expectedLen	= int(writeQuantity) * 2
+
+			if req.payload[8] != uint8(expectedLen) ||
+			   len(req.payload) - 9 != expectedLen {
+				err	= ErrProtocolError
+				break
+			}
+
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole,
+				aclSpan{addr, quantity}, aclSpan{writeAddr, writeQuantity})
+			if err != nil {
+				break
+			}
+
+			regs, err	= ms.readWriteRegisters(&ReadWriteRegistersRequest{
+				ClientAddr:    clientAddr,
+				ClientRole:    clientRole,
+				UnitId:        req.unitId,
+				ReadAddr:      addr,
+				ReadQuantity:  quantity,
+				WriteAddr:     writeAddr,
+				WriteQuantity: writeQuantity,
+				Args:          bytesToUint16s(BIG_ENDIAN, req.payload[9:]),
+			})
+
+			// make sure the handler returned the expected number of items
+			if err == nil && len(regs) != int(quantity) {
+				ms.logger.Errorf("handler returned %v 16-bit values, " +
+					         "expected %v", len(regs), quantity)
+				err = ErrServerDeviceFailure
+				break
+			}
+
+			if err != nil {
+				break
+			}
+
+			// assemble a response PDU
+			res = &pdu{
+				unitId:		req.unitId,
+				functionCode:	req.functionCode,
+				payload:	[]byte{uint8(len(regs) * 2)},
+			}
+
+			// register values
+			res.payload	= append(res.payload,
+						 uint16sToBytes(BIG_ENDIAN, regs)...)
+
+		case fcReadFifoQueue:
+			var regs	[]uint16
+			var fh		FIFOQueueHandler
+			var ok		bool
+
+			if len(req.payload) != 2 {
+				err = ErrProtocolError
+				break
+			}
+
+			// decode the FIFO pointer address
+			addr	= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, aclSpan{addr, 1})
+			if err != nil {
+				break
+			}
+
+			fh, ok	= ms.handler.(FIFOQueueHandler)
+			if !ok {
+				err	= ErrIllegalFunction
+				break
+			}
+
+			regs, err	= fh.HandleFIFOQueue(&FIFOQueueRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				Addr:       addr,
+			})
+
+			// the queue holds at most 31 values
+			if err == nil && len(regs) > 31 {
+				ms.logger.Errorf("handler returned %v FIFO values, " +
+					         "expected at most 31", len(regs))
+				err = ErrIllegalDataValue
+				break
+			}
+
+			if err != nil {
+				break
+			}
+
+			// assemble a response PDU
+			res = &pdu{
+				unitId:		req.unitId,
+				functionCode:	req.functionCode,
+			}
+
+			// byte count (FIFO count + 2 bytes per register), FIFO count
+			// and register values
+			res.payload	= append(res.payload,
+						 uint16ToBytes(BIG_ENDIAN, uint16(2 + len(regs) * 2))...)
+			res.payload	= append(res.payload,
+						 uint16ToBytes(BIG_ENDIAN, uint16(len(regs)))...)
+			res.payload	= append(res.payload,
+						 uint16sToBytes(BIG_ENDIAN, regs)...)
+
+		case fcReadFileRecord, fcWriteFileRecord:
+			var records	[]FileRecord
+			var values	[][]uint16
+			var frh		FileRecordHandler
+			var ok		bool
+
+			records, err	= decodeFileRecords(req.functionCode, req.payload)
+			if err != nil {
+				break
+			}
+
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole, fileRecordSpans(records)...)
+			if err != nil {
+				break
+			}
+
+			frh, ok	= ms.handler.(FileRecordHandler)
+			if !ok {
+				err	= ErrIllegalFunction
+				break
+			}
+
+			values, err	= frh.HandleFileRecords(&FileRecordRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				IsWrite:    req.functionCode == fcWriteFileRecord,
+				Records:    records,
+			})
+			if err != nil {
+				break
+			}
+
+			// assemble a response PDU
+			res = &pdu{
+				unitId:		req.unitId,
+				functionCode:	req.functionCode,
+			}
+
+			if req.functionCode == fcWriteFileRecord {
+				// echo the request back
+				res.payload	= req.payload
+				break
+			}
+
+			// make sure the handler returned the expected number of items
+			err	= checkFileRecordValues(records, values)
+			if err != nil {
+				ms.logger.Errorf("%v", err)
+				err	= ErrServerDeviceFailure
+				break
+			}
+
+			res.payload	= encodeFileRecords(values)
+
+		case fcEncapsulatedInterface:
+			var objects	map[uint8]string
+			var dih		DeviceIdentificationHandler
+			var ok		bool
+
+			if len(req.payload) != 3 {
+				err = ErrProtocolError
+				break
+			}
+
+			// only the read device identification MEI type is supported
+			if req.payload[0] != meiReadDeviceId {
+				err	= ErrIllegalFunction
+				break
+			}
+
+			// read device id code: basic, regular, extended or specific
+			if req.payload[1] < 0x01 || req.payload[1] > 0x04 {
+				err	= ErrIllegalDataValue
+				break
+			}
+
+			// enforce the access control list
+			err	= ms.authorize(req, clientAddr, clientRole)
+			if err != nil {
+				break
+			}
+
+			dih, ok	= ms.handler.(DeviceIdentificationHandler)
+			if !ok {
+				err	= ErrIllegalFunction
+				break
+			}
+
+			objects, err	= dih.HandleDeviceIdentification(&DeviceIdentificationRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+			})
+			if err != nil {
+				break
+			}
+
+			// assemble a response PDU
+			res = &pdu{
+				unitId:		req.unitId,
+				functionCode:	req.functionCode,
+			}
+
+			res.payload, err = encodeDeviceIdentification(
+				req.payload[1], req.payload[2], objects)
+			if err != nil && err != ErrIllegalDataAddress {
+				ms.logger.Errorf("%v", err)
+				err = ErrServerDeviceFailure
+			}
+
 		default:
 			res = &pdu{
 				// reply with the request target unit ID
@@ -776,6 +1480,20 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 					 req, res, err)
 		}
 
+		// leave the request unanswered if the handler asked for it
+		if err == ErrNoResponse {
+			req	= nil
+			continue
+		}
+
+		// broadcast requests (unit id 0) are never answered on serial
+		// line framings
+		if req.unitId == 0x00 && ms.isSerialFramed() && err != ErrProtocolError {
+			req	= nil
+			res	= nil
+			continue
+		}
+
 		// map go errors to modbus errors, unless the error is a protocol error,
 		// in which case close the transport and return.
 		if err != nil {
@@ -809,6 +1527,303 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 	return
 }
 
+// authorize evaluates the access control list, if any, against a request
+// touching the given address ranges, and logs denied requests.
+func (ms *ModbusServer) authorize(req *pdu, clientAddr string, clientRole string,
+				  spans ...aclSpan) (err error) {
+	if ms.conf.ACL == nil {
+		return
+	}
+
+	err	= ms.conf.ACL.check(clientRole, req.unitId, req.functionCode, spans)
+	if err != nil {
+		ms.logger.Warningf("access denied (client address: '%s', role: '%s', " +
+				   "unit id: %v, function code: 0x%02x, addresses: %v): %v",
+				   clientAddr, clientRole, req.unitId, req.functionCode,
+				   spans, err)
+	}
+
+	return
+}
+
+// maskWriteRegister invokes the handler's HandleMaskWriteRegister method if
+// available, and otherwise emulates the request with a read then a write
+// through HandleHoldingRegisters.
+func (ms *ModbusServer) maskWriteRegister(req *MaskWriteRegisterRequest) (err error) {
+	var mh	 MaskWriteRegisterHandler
+	var ok	 bool
+	var regs []uint16
+
+	mh, ok	= ms.handler.(MaskWriteRegisterHandler)
+	if ok {
+		err	= mh.HandleMaskWriteRegister(req)
+		return
+	}
+
+	regs, err = ms.handler.HandleHoldingRegisters(&HoldingRegistersRequest{
+		ClientAddr: req.ClientAddr,
+		ClientRole: req.ClientRole,
+		UnitId:     req.UnitId,
+		Addr:       req.Addr,
+		Quantity:   1,
+		IsWrite:    false,
+	})
+	if err != nil {
+		return
+	}
+	if len(regs) != 1 {
+		ms.logger.Errorf("handler returned %v 16-bit values, expected 1", len(regs))
+		err	= ErrServerDeviceFailure
+		return
+	}
+
+	_, err	= ms.handler.HandleHoldingRegisters(&HoldingRegistersRequest{
+		ClientAddr: req.ClientAddr,
+		ClientRole: req.ClientRole,
+		UnitId:     req.UnitId,
+		Addr:       req.Addr,
+		Quantity:   1,
+		IsWrite:    true,
+		Args:       []uint16{(regs[0] & req.AndMask) | (req.OrMask &^ req.AndMask)},
+	})
+
+	return
+}
+
+// readWriteRegisters invokes the handler's HandleReadWriteRegisters method if
+// available, and otherwise emulates the request with a write then a read
+// through HandleHoldingRegisters.
+func (ms *ModbusServer) readWriteRegisters(req *ReadWriteRegistersRequest) (res []uint16, err error) {
+	var rwh	ReadWriteRegistersHandler
+	var ok	bool
+
+	rwh, ok	= ms.handler.(ReadWriteRegistersHandler)
+	if ok {
+		res, err = rwh.HandleReadWriteRegisters(req)
+		return
+	}
+
+	_, err	= ms.handler.HandleHoldingRegisters(&HoldingRegistersRequest{
+		ClientAddr: req.ClientAddr,
+		ClientRole: req.ClientRole,
+		UnitId:     req.UnitId,
+		Addr:       req.WriteAddr,
+		Quantity:   req.WriteQuantity,
+		IsWrite:    true,
+		Args:       req.Args,
+	})
+	if err != nil {
+		return
+	}
+
+	res, err = ms.handler.HandleHoldingRegisters(&HoldingRegistersRequest{
+		ClientAddr: req.ClientAddr,
+		ClientRole: req.ClientRole,
+		UnitId:     req.UnitId,
+		Addr:       req.ReadAddr,
+		Quantity:   req.ReadQuantity,
+		IsWrite:    false,
+	})
+
+	return
+}
+
+// decodeFileRecords decodes the sub-requests of a read file record (0x14) or
+// write file record (0x15) request payload.
+func decodeFileRecords(functionCode uint8, payload []byte) (records []FileRecord, err error) {
+	var record	FileRecord
+	var offset	int
+	var resLength	int
+
+	// validate the byte count field
+	if len(payload) < 1 || int(payload[0]) != len(payload) - 1 {
+		err	= ErrProtocolError
+		return
+	}
+	if (functionCode == fcReadFileRecord &&
+	    (payload[0] < 0x07 || payload[0] > 0xf5 || payload[0] % 7 != 0)) ||
+	   (functionCode == fcWriteFileRecord &&
+	    (payload[0] < 0x09 || payload[0] > 0xfb)) {
+		err	= ErrProtocolError
+		return
+	}
+
+	offset	= 1
+	for offset < len(payload) {
+		// reference type, file number, record number and record length
+		if len(payload) - offset < 7 {
+			err	= ErrProtocolError
+			return
+		}
+
+		record	= FileRecord{
+			FileNumber:   bytesToUint16(BIG_ENDIAN, payload[offset + 1:offset + 3]),
+			RecordNumber: bytesToUint16(BIG_ENDIAN, payload[offset + 3:offset + 5]),
+			RecordLength: bytesToUint16(BIG_ENDIAN, payload[offset + 5:offset + 7]),
+		}
+
+		if payload[offset] != fileRecordRefType || record.FileNumber == 0 ||
+		   record.RecordNumber > maxFileRecordNumber {
+			err	= ErrIllegalDataAddress
+			return
+		}
+		if record.RecordLength == 0 {
+			err	= ErrProtocolError
+			return
+		}
+		offset	+= 7
+
+		if functionCode == fcWriteFileRecord {
+			// record data (2 bytes per record)
+			if len(payload) - offset < 2 * int(record.RecordLength) {
+				err	= ErrProtocolError
+				return
+			}
+
+			record.Values	= bytesToUint16s(BIG_ENDIAN,
+					payload[offset:offset + 2 * int(record.RecordLength)])
+			offset		+= 2 * int(record.RecordLength)
+		} else {
+			// ensure the reply never exceeds the maximum PDU length
+			resLength	+= 2 + 2 * int(record.RecordLength)
+			if resLength > 0xf5 {
+				err	= ErrProtocolError
+				return
+			}
+		}
+
+		records	= append(records, record)
+	}
+
+	return
+}
+
+// checkFileRecordValues makes sure a file record handler returned one slice of
+// RecordLength values per read sub-request.
+func checkFileRecordValues(records []FileRecord, values [][]uint16) (err error) {
+	if len(values) != len(records) {
+		err	= fmt.Errorf("handler returned %v file records, expected %v",
+				     len(values), len(records))
+		return
+	}
+
+	for i := range records {
+		if len(values[i]) != int(records[i].RecordLength) {
+			err	= fmt.Errorf("handler returned %v 16-bit values for file record #%v, " +
+					     "expected %v", len(values[i]), i, records[i].RecordLength)
+			return
+		}
+	}
+
+	return
+}
+
+// encodeFileRecords assembles the payload of a read file record (0x14)
+// response out of one slice of values per sub-request.
+func encodeFileRecords(values [][]uint16) (payload []byte) {
+	// response data length, set once all sub-responses are appended
+	payload	= []byte{0}
+
+	for _, v := range values {
+		// sub-response length (reference type + 2 bytes per record),
+		// reference type and record data
+		payload	= append(payload, uint8(1 + len(v) * 2), fileRecordRefType)
+		payload	= append(payload, uint16sToBytes(BIG_ENDIAN, v)...)
+	}
+
+	payload[0]	= uint8(len(payload) - 1)
+
+	return
+}
+
+// encodeDeviceIdentification assembles the payload of a read device
+// identification response, starting at objectId and holding as many objects
+// of the requested category as fit in a single PDU.
+func encodeDeviceIdentification(readDeviceIdCode uint8, objectId uint8,
+	objects map[uint8]string) (payload []byte, err error) {
+	var lastId	uint8
+	var conformity	uint8
+	var value	string
+	var ok		bool
+	var count	int
+
+	// the basic objects are mandatory
+	for id := OBJ_VENDOR_NAME; id <= OBJ_MAJOR_MINOR_REVISION; id++ {
+		if _, ok = objects[id]; !ok {
+			err	= fmt.Errorf("handler returned no device identification object 0x%02x", id)
+			return
+		}
+	}
+
+	// conformity level: the highest category available, with both stream
+	// and individual access
+	conformity	= 0x81
+	for id := range objects {
+		if id > OBJ_MAJOR_MINOR_REVISION && conformity < 0x82 {
+			conformity	= 0x82
+		}
+		if id >= 0x80 {
+			conformity	= 0x83
+		}
+		if len(objects[id]) > 244 {
+			err	= fmt.Errorf("device identification object 0x%02x is %v bytes long, " +
+					     "expected at most 244", id, len(objects[id]))
+			return
+		}
+	}
+
+	// MEI type, read device id code, conformity level, more follows,
+	// next object id and number of objects
+	payload	= []byte{meiReadDeviceId, readDeviceIdCode, conformity, 0x00, 0x00, 0x00}
+
+	// individual access: return the requested object only
+	if readDeviceIdCode == 0x04 {
+		value, ok	= objects[objectId]
+		if !ok {
+			err	= ErrIllegalDataAddress
+			return
+		}
+
+		payload[5]	= 1
+		payload		= append(payload, objectId, uint8(len(value)))
+		payload		= append(payload, value...)
+		return
+	}
+
+	switch readDeviceIdCode {
+	case 0x01:	lastId = OBJ_MAJOR_MINOR_REVISION
+	case 0x02:	lastId = 0x7f
+	default:	lastId = 0xff
+	}
+
+	// unknown object ids restart the stream from the first object
+	if _, ok = objects[objectId]; !ok || objectId > lastId {
+		objectId	= OBJ_VENDOR_NAME
+	}
+
+	for id := int(objectId); id <= int(lastId); id++ {
+		value, ok	= objects[uint8(id)]
+		if !ok {
+			continue
+		}
+
+		// keep the response within the maximum PDU length (function
+		// code + 252 bytes), telling the client where to resume
+		if len(payload) + 2 + len(value) > 252 {
+			payload[3]	= 0xff
+			payload[4]	= uint8(id)
+			break
+		}
+
+		payload	= append(payload, uint8(id), uint8(len(value)))
+		payload	= append(payload, value...)
+		count++
+	}
+	payload[5]	= uint8(count)
+
+	return
+}
+
 // startTLS performs a full TLS handshake (with client authentication) on tcpSock
 // and returns a 'wrapped' clear-text socket suitable for use by the TCP transport.
 func (ms *ModbusServer) startTLS(tcpSock net.Conn) (
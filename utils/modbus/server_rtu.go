@@ -114,7 +114,7 @@ func (ms *ModbusRtuServer) Start() (err error) {
 	ms.logger.Infof("Connected!")
 	ms.started = true
 
-	go ms.listenAndServe()
+	go ms.listenAndServe(ms.port)
 
 	return
 }
@@ -142,13 +142,17 @@ func (ms *ModbusRtuServer) messageIsForMe(message []byte) (yes bool) {
 	if message == nil {
 		return false
 	}
-	if ms.conf.ModbusAddress == 0 {
-		return true
+	if ms.conf.ModbusAddress != 0 {
+		return message[0] == ms.conf.ModbusAddress
 	}
-	return message[0] == ms.conf.ModbusAddress
+	// Handlers serving several devices pick their unit ids
+	if uh, ok := ms.handler.(UnitHandler); ok {
+		return uh.ServesUnit(message[0])
+	}
+	return true
 }
 
-func (ms *ModbusRtuServer) sendErrorMessage(originalMessage []byte, errorCode uint8) {
+func (ms *ModbusRtuServer) sendErrorMessage(req any, originalMessage []byte, errorCode uint8) {
 	if originalMessage == nil || !ms.started {
 		return
 	}
@@ -159,21 +163,45 @@ func (ms *ModbusRtuServer) sendErrorMessage(originalMessage []byte, errorCode ui
 	c.add(errorMsg)
 	errorMsg = append(errorMsg, c.value()...)
 
-	ms.logger.Infof("Written %v bytes: 0x[% X]", len(errorMsg), errorMsg)
-	if _, err := ms.port.Write(errorMsg); err != nil {
+	if err := ms.write(req, errorMsg); err != nil {
 		ms.logger.Errorf("Send answer failed! (%v)", err)
 	}
 
 	return
 }
 
-func (ms *ModbusRtuServer) listenAndServe() {
-	if !ms.started {
-		return
-	}
+// RTUResponseFilter is an optional interface for handlers of RTU servers
+// wanting to alter response frames (CRC included) before they are written,
+// e.g. to simulate line noise.
+// req is the request pointer the handler was called with, so that decisions
+// taken while handling a request apply to its own response only (nil when
+// the request never reached the handler).
+type RTUResponseFilter interface {
+	FilterRTUResponse(req any, adu []byte) []byte
+}
+
+// isServing reports whether the server is started on port, so that the
+// listener of a stopped (or restarted) server returns.
+func (ms *ModbusRtuServer) isServing(port serial.Port) bool {
 	ms.lock.Lock()
 	defer ms.lock.Unlock()
 
+	return ms.started && ms.port == port
+}
+
+// write sends the response frame to req, through the handler's filter if it has one.
+func (ms *ModbusRtuServer) write(req any, adu []byte) (err error) {
+	if f, ok := ms.handler.(RTUResponseFilter); ok {
+		adu = f.FilterRTUResponse(req, adu)
+	}
+
+	ms.logger.Infof("Written %v bytes: 0x[% X]", len(adu), adu)
+	_, err = ms.port.Write(adu)
+	return
+}
+
+func (ms *ModbusRtuServer) listenAndServe(port serial.Port) {
+
 	// Receive strategy is as follow:
 	// Read single byte after byte. If pause time between two bytes is longer than minimal
 	// pause time, the message is complete (according to Modbus RTU standard.)
@@ -185,7 +213,12 @@ func (ms *ModbusRtuServer) listenAndServe() {
 		// Read one byte and append to buffer if we have received one.
 		// Listen for the next byte immediately.
 		buf := make([]byte, 1)
-		n, _ := ms.port.Read(buf)
+		n, rerr := port.Read(buf)
+		// Read errors other than timeouts mean the port was closed by Stop()
+		if rerr != nil && rerr != serial.ErrTimeout && !ms.isServing(port) {
+			ms.logger.Infof("Stop listening")
+			return
+		}
 		if n > 0 {
 			receivedData = append(receivedData, buf...)
 			continue
@@ -200,6 +233,7 @@ func (ms *ModbusRtuServer) listenAndServe() {
 		ms.logger.Infof("Received bytes: %v\n", receivedData)
 
 		var raw any
+		var req any
 		var err error
 		var response any
 		var bytesToSend []byte
@@ -218,7 +252,7 @@ func (ms *ModbusRtuServer) listenAndServe() {
 
 		if raw, err = createRequestFromBytes(receivedData); err != nil {
 			ms.logger.Warningf("Can't execute request! (%v)", err)
-			ms.sendErrorMessage(receivedData, exIllegalFunction)
+			ms.sendErrorMessage(req, receivedData, exIllegalFunction)
 			receivedData = nil
 			continue
 		}
@@ -226,27 +260,64 @@ func (ms *ModbusRtuServer) listenAndServe() {
 		switch raw.(type) {
 		case CoilsRequest:
 			request := raw.(CoilsRequest)
+			req = &request
 			response, err = ms.handler.HandleCoils(&request)
 		case DiscreteInputsRequest:
 			request := raw.(DiscreteInputsRequest)
+			req = &request
 			response, err = ms.handler.HandleDiscreteInputs(&request)
 		case HoldingRegistersRequest:
 			request := raw.(HoldingRegistersRequest)
+			req = &request
 			response, err = ms.handler.HandleHoldingRegisters(&request)
 		case InputRegistersRequest:
 			request := raw.(InputRegistersRequest)
+			req = &request
 			response, err = ms.handler.HandleInputRegisters(&request)
+		case FileRecordRequest:
+			request := raw.(FileRecordRequest)
+			req = &request
+			frh, ok := ms.handler.(FileRecordHandler)
+			if !ok {
+				ms.logger.Warningf("Can't execute request! (file records not supported)")
+				ms.sendErrorMessage(req, receivedData, exIllegalFunction)
+				receivedData = nil
+				continue
+			}
+			var values [][]uint16
+			values, err = frh.HandleFileRecords(&request)
+			if err == nil && !request.IsWrite {
+				err = checkFileRecordValues(request.Records, values)
+			}
+			response = values
+		case DeviceIdentificationRequest:
+			request := raw.(DeviceIdentificationRequest)
+			req = &request
+			dih, ok := ms.handler.(DeviceIdentificationHandler)
+			if !ok {
+				ms.logger.Warningf("Can't execute request! (device identification not supported)")
+				ms.sendErrorMessage(req, receivedData, exIllegalFunction)
+				receivedData = nil
+				continue
+			}
+			response, err = dih.HandleDeviceIdentification(&request)
 		default:
 			err = fmt.Errorf("Function code not implemented!")
 			ms.logger.Warningf("Can't execute request! (%v)", err)
-			ms.sendErrorMessage(receivedData, exIllegalFunction)
+			ms.sendErrorMessage(req, receivedData, exIllegalFunction)
+			receivedData = nil
+			continue
+		}
+
+		// Leave the request unanswered if the handler asked for it
+		if err == ErrNoResponse {
 			receivedData = nil
 			continue
 		}
 
 		if err != nil {
 			ms.logger.Warningf("Request execution failed! (%v)", err)
-			ms.sendErrorMessage(receivedData, exIllegalDataAddress)
+			ms.sendErrorMessage(req, receivedData, exIllegalDataAddress)
 			receivedData = nil
 			continue
 		}
@@ -254,13 +325,12 @@ func (ms *ModbusRtuServer) listenAndServe() {
 		ms.logger.Infof("Response = %v", response)
 		if bytesToSend, err = createBytesFromRequest(receivedData, response); err != nil {
 			ms.logger.Warningf("Compose answer failed! (%v)", err)
-			ms.sendErrorMessage(receivedData, exIllegalFunction)
+			ms.sendErrorMessage(req, receivedData, exIllegalFunction)
 			receivedData = nil
 			continue
 		}
 
-		ms.logger.Infof("Written %v bytes: 0x[% X]", len(bytesToSend), bytesToSend)
-		if _, err = ms.port.Write(bytesToSend); err != nil {
+		if err = ms.write(req, bytesToSend); err != nil {
 			ms.logger.Errorf("Send answer failed! (%v)", err)
 		}
 
@@ -302,6 +372,7 @@ func createRequestFromBytes(message []byte) (result any, err error) {
 
 		if reqType == fcWriteSingleCoil {
 			req.IsWrite = true
+			req.IsSingleWrite = true
 			req.Quantity = 1
 			req.Args = []bool{true}
 		}
@@ -347,6 +418,7 @@ func createRequestFromBytes(message []byte) (result any, err error) {
 
 		if reqType == fcWriteSingleRegister {
 			req.IsWrite = true
+			req.IsSingleWrite = true
 			req.Quantity = 1
 			req.Args = []uint16{uint16(message[4])<<8 + uint16(message[5])}
 		}
@@ -370,6 +442,29 @@ func createRequestFromBytes(message []byte) (result any, err error) {
 		req.Quantity = uint16(message[4])<<8 + uint16(message[5])
 		return req, nil
 
+	case fcReadFileRecord:
+		fallthrough
+	case fcWriteFileRecord:
+		var req = FileRecordRequest{}
+		req.ClientAddr = "127.0.0.1"
+		req.UnitId = message[0]
+		req.IsWrite = reqType == fcWriteFileRecord
+		if req.Records, err = decodeFileRecords(reqType, message[2:len(message)-2]); err != nil {
+			return nil, err
+		}
+		return req, nil
+
+	case fcEncapsulatedInterface:
+		// MEI type, read device id code and object id
+		if len(message) != 7 || message[2] != meiReadDeviceId ||
+			message[3] < 0x01 || message[3] > 0x04 {
+			return nil, fmt.Errorf("Unsupported encapsulated interface request [% X]", message)
+		}
+		var req = DeviceIdentificationRequest{}
+		req.ClientAddr = "127.0.0.1"
+		req.UnitId = message[0]
+		return req, nil
+
 	default:
 		return nil, fmt.Errorf("Function code not supported 0x%X", reqType)
 	}
@@ -428,6 +523,24 @@ func createBytesFromRequest(originalMessage []byte, requestResult any) (result [
 	case fcWriteMultipleRegisters:
 		// Write multiple holding register
 		result = append(result, originalMessage[:6]...)
+	case fcReadFileRecord:
+		result = append(result, originalMessage[0])
+		result = append(result, originalMessage[1])
+		result = append(result, encodeFileRecords(requestResult.([][]uint16))...)
+
+	case fcWriteFileRecord:
+		// Echo the request back, without its CRC
+		result = append(result, originalMessage[:len(originalMessage)-2]...)
+	case fcEncapsulatedInterface:
+		var payload []byte
+		if payload, err = encodeDeviceIdentification(originalMessage[3], originalMessage[4],
+			requestResult.(map[uint8]string)); err != nil {
+			return nil, err
+		}
+		result = append(result, originalMessage[0])
+		result = append(result, originalMessage[1])
+		result = append(result, payload...)
+
 	default:
 		return nil, fmt.Errorf("Could not compute bytes. Function code not supported 0x%X", reqType)
 	}
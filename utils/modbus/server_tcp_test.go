@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -568,11 +569,696 @@ func TestTCPServerHoldingAndInputRegisters(t *testing.T) {
 	return
 }
 
+func TestTCPServerMaskWriteReadWriteAndFIFO(t *testing.T) {
+	var server *ModbusServer
+	var err	   error
+	var client *ModbusClient
+	var th	   *tcpTestHandler
+	var regs   []uint16
+
+	th = &tcpTestHandler{}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5505",
+		MaxClients:	2,
+	}, th)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5505",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// mask write: the handler has no native support, so the server
+	// emulates it through HandleHoldingRegisters.
+	// example from the spec: current 0x0012, AND 0x00f2, OR 0x0025 -> 0x0017
+	th.holding[4]	= 0x0012
+	err		= client.MaskWriteRegister(0x0004, 0x00f2, 0x0025)
+	if err != nil {
+		t.Errorf("client.MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+	if th.holding[4] != 0x0017 {
+		t.Errorf("expected 0x0017, got: 0x%04x", th.holding[4])
+	}
+
+	// set bit 15 only
+	err		= client.MaskWriteRegister(0x0004, 0x7fff, 0x8000)
+	if err != nil {
+		t.Errorf("client.MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+	if th.holding[4] != 0x8017 {
+		t.Errorf("expected 0x8017, got: 0x%04x", th.holding[4])
+	}
+
+	err		= client.MaskWriteRegister(0x000a, 0x0000, 0x0001)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.MaskWriteRegister() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// masks are sent big-endian regardless of the client's encoding:
+	// clear bit 0 and set bit 8 with a little-endian client
+	client.SetEncoding(LITTLE_ENDIAN, LOW_WORD_FIRST)
+	err		= client.MaskWriteRegister(0x0004, 0xfefe, 0x0100)
+	if err != nil {
+		t.Errorf("client.MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+	if th.holding[4] != 0x8116 {
+		t.Errorf("expected 0x8116, got: 0x%04x", th.holding[4])
+	}
+	client.SetEncoding(BIG_ENDIAN, HIGH_WORD_FIRST)
+
+	// read/write multiple registers: the write happens before the read,
+	// so overlapping ranges return the new values
+	th.holding[0]	= 0x1111
+	regs, err	= client.ReadWriteMultipleRegisters(0x0000, 3, 0x0001, []uint16{
+		0xaaaa, 0xbbbb,
+	})
+	if err != nil {
+		t.Errorf("client.ReadWriteMultipleRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 3 {
+		t.Errorf("expected 3 values, got: %v", len(regs))
+	}
+	for i, v := range []uint16{0x1111, 0xaaaa, 0xbbbb} {
+		if regs[i] != v {
+			t.Errorf("expected 0x%04x at position %v, got: 0x%04x", v, i, regs[i])
+		}
+	}
+
+	_, err		= client.ReadWriteMultipleRegisters(0x0000, 1, 0x0009, []uint16{
+		0x0001, 0x0002,
+	})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.ReadWriteMultipleRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// read FIFO queue: empty first, then with queued values
+	regs, err	= client.ReadFIFOQueue(0x0004)
+	if err != nil {
+		t.Errorf("client.ReadFIFOQueue() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 0 {
+		t.Errorf("expected an empty queue, got: %v", regs)
+	}
+
+	th.fifo		= []uint16{0x01b8, 0x1284}
+	regs, err	= client.ReadFIFOQueue(0x0004)
+	if err != nil {
+		t.Errorf("client.ReadFIFOQueue() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0x01b8 || regs[1] != 0x1284 {
+		t.Errorf("expected {0x01b8, 0x1284}, got: %v", regs)
+	}
+
+	_, err		= client.ReadFIFOQueue(0x0005)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.ReadFIFOQueue() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestTCPServerNativeMaskWriteAndMissingFIFO(t *testing.T) {
+	var server *ModbusServer
+	var err	   error
+	var client *ModbusClient
+	var mh	   *maskTestHandler
+
+	mh = &maskTestHandler{RequestHandler: &tcpTestHandler{}}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5505",
+		MaxClients:	2,
+	}, mh)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5505",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// the native handler should receive the masks as sent
+	err		= client.MaskWriteRegister(0x0002, 0xfffe, 0x0001)
+	if err != nil {
+		t.Errorf("client.MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+	if mh.calls != 1 || mh.last.Addr != 0x0002 ||
+	   mh.last.AndMask != 0xfffe || mh.last.OrMask != 0x0001 {
+		t.Errorf("unexpected mask write request: %+v (calls: %v)", mh.last, mh.calls)
+	}
+
+	// maskTestHandler does not implement FIFOQueueHandler
+	_, err		= client.ReadFIFOQueue(0x0000)
+	if err != ErrIllegalFunction {
+		t.Errorf("client.ReadFIFOQueue() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestTCPServerFileRecords(t *testing.T) {
+	var server  *ModbusServer
+	var err	    error
+	var client  *ModbusClient
+	var th	    *tcpTestHandler
+	var regs    []uint16
+	var records []FileRecord
+
+	th = &tcpTestHandler{}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5506",
+		MaxClients:	2,
+	}, th)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5506",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// write two groups of records to two different files in one request
+	err		= client.WriteFileRecords([]FileRecord{
+		{FileNumber: 1, RecordNumber: 2, Values: []uint16{0x0dfe, 0x0020}},
+		{FileNumber: 2, RecordNumber: 0, Values: []uint16{0x33cd, 0x0040, 0x1234}},
+	})
+	if err != nil {
+		t.Errorf("client.WriteFileRecords() should have succeeded, got: %v", err)
+	}
+	if th.files[1][2] != 0x0dfe || th.files[1][3] != 0x0020 ||
+	   th.files[2][0] != 0x33cd || th.files[2][2] != 0x1234 {
+		t.Errorf("unexpected file contents: %v", th.files)
+	}
+
+	err		= client.WriteFileRecord(1, 0, []uint16{0xaaaa})
+	if err != nil {
+		t.Errorf("client.WriteFileRecord() should have succeeded, got: %v", err)
+	}
+
+	// read them back in a single request
+	records, err	= client.ReadFileRecords([]FileRecord{
+		{FileNumber: 1, RecordNumber: 0, RecordLength: 4},
+		{FileNumber: 2, RecordNumber: 1, RecordLength: 2},
+	})
+	if err != nil {
+		t.Errorf("client.ReadFileRecords() should have succeeded, got: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got: %v", len(records))
+	}
+	for i, v := range []uint16{0xaaaa, 0x0000, 0x0dfe, 0x0020} {
+		if records[0].Values[i] != v {
+			t.Errorf("expected 0x%04x at position %v, got: 0x%04x",
+				 v, i, records[0].Values[i])
+		}
+	}
+	if len(records[1].Values) != 2 || records[1].FileNumber != 2 ||
+	   records[1].Values[0] != 0x0040 || records[1].Values[1] != 0x1234 {
+		t.Errorf("unexpected second record: %+v", records[1])
+	}
+
+	regs, err	= client.ReadFileRecord(2, 0, 1)
+	if err != nil {
+		t.Errorf("client.ReadFileRecord() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 1 || regs[0] != 0x33cd {
+		t.Errorf("expected {0x33cd}, got: %v", regs)
+	}
+
+	// the handler only serves files 1 and 2, with 10 records each
+	_, err		= client.ReadFileRecord(3, 0, 1)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.ReadFileRecord() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	err		= client.WriteFileRecord(1, 9, []uint16{0x0001, 0x0002})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.WriteFileRecord() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// out of range record numbers and empty requests are rejected client-side
+	_, err		= client.ReadFileRecord(1, 0x2710, 1)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	err		= client.WriteFileRecords(nil)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.WriteFileRecords() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	// a handler without file record support replies with illegal function
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5506",
+		MaxClients:	2,
+	}, &maskTestHandler{RequestHandler: th})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	_, err		= client.ReadFileRecord(1, 0, 1)
+	if err != ErrIllegalFunction {
+		t.Errorf("client.ReadFileRecord() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestTCPServerDeviceIdentification(t *testing.T) {
+	var server  *ModbusServer
+	var err	    error
+	var client  *ModbusClient
+	var th	    *tcpTestHandler
+	var objects map[uint8]string
+
+	th = &tcpTestHandler{
+		deviceId: map[uint8]string{
+			OBJ_VENDOR_NAME:          "Acme",
+			OBJ_PRODUCT_CODE:         "INV-50K",
+			OBJ_MAJOR_MINOR_REVISION: "V1.2",
+			OBJ_PRODUCT_NAME:         "Acme string inverter",
+			// two 200-byte extended objects cannot fit in a single
+			// response
+			0x80:                     strings.Repeat("a", 200),
+			0x81:                     strings.Repeat("b", 200),
+		},
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5507",
+		MaxClients:	2,
+	}, th)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5507",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// basic objects only
+	objects, err	= client.ReadDeviceIdentification(DEVICE_ID_BASIC)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(objects) != 3 || objects[OBJ_VENDOR_NAME] != "Acme" ||
+	   objects[OBJ_PRODUCT_CODE] != "INV-50K" ||
+	   objects[OBJ_MAJOR_MINOR_REVISION] != "V1.2" {
+		t.Errorf("unexpected basic objects: %v", objects)
+	}
+
+	// regular objects include the basic ones
+	objects, err	= client.ReadDeviceIdentification(DEVICE_ID_REGULAR)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(objects) != 4 || objects[OBJ_PRODUCT_NAME] != "Acme string inverter" {
+		t.Errorf("unexpected regular objects: %v", objects)
+	}
+
+	// extended objects are streamed over two responses
+	objects, err	= client.ReadDeviceIdentification(DEVICE_ID_EXTENDED)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(objects) != 6 || objects[0x80] != strings.Repeat("a", 200) ||
+	   objects[0x81] != strings.Repeat("b", 200) {
+		t.Errorf("unexpected extended objects: %v", objects)
+	}
+	if th.deviceIdCalls != 4 {
+		t.Errorf("expected 4 handler calls, got: %v", th.deviceIdCalls)
+	}
+
+	_, err		= client.ReadDeviceIdentification(0x04)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadDeviceIdentification() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	// a handler without device identification support replies with
+	// illegal function
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5507",
+		MaxClients:	2,
+	}, &maskTestHandler{RequestHandler: th})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	_, err		= client.ReadDeviceIdentification(DEVICE_ID_BASIC)
+	if err != ErrIllegalFunction {
+		t.Errorf("client.ReadDeviceIdentification() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestTCPServerNoResponse(t *testing.T) {
+	var server  *ModbusServer
+	var err	    error
+	var client  *ModbusClient
+	var regs    []uint16
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5510",
+		MaxClients:	2,
+	}, &silentTestHandler{RequestHandler: &tcpTestHandler{}})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5510",
+		Timeout:	200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	// requests the handler declines to answer time out on the client side
+	client.SetUnitId(8)
+	_, err		= client.ReadRegisters(0, 2, HOLDING_REGISTER)
+	if err != ErrRequestTimedOut {
+		t.Errorf("client.ReadRegisters() should have returned ErrRequestTimedOut, got: %v", err)
+	}
+
+	// the connection remains usable afterwards
+	client.SetUnitId(9)
+	regs, err	= client.ReadRegisters(0, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 {
+		t.Errorf("expected 2 registers, got: %v", regs)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestRTUOverTCPServer(t *testing.T) {
+	var server  *ModbusServer
+	var err	    error
+	var client  *ModbusClient
+	var regs    []uint16
+	var coils   []bool
+	var objects map[uint8]string
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"rtuovertcp://localhost:5512",
+		MaxClients:	2,
+	}, &tcpTestHandler{
+		deviceId: map[uint8]string{
+			OBJ_VENDOR_NAME:          "Acme",
+			OBJ_PRODUCT_CODE:         "DTU-1",
+			OBJ_MAJOR_MINOR_REVISION: "V2.0",
+		},
+	})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"rtuovertcp://localhost:5512",
+		Timeout:	500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// single and multiple register writes, then a read
+	err		= client.WriteRegister(0, 0xcafe)
+	if err != nil {
+		t.Errorf("client.WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	err		= client.WriteRegisters(1, []uint16{0x0001, 0x0002, 0x0003})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	regs, err	= client.ReadRegisters(0, 4, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 4 || regs[0] != 0xcafe || regs[1] != 0x0001 ||
+	   regs[2] != 0x0002 || regs[3] != 0x0003 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// coils
+	err		= client.WriteCoils(4, []bool{true, true})
+	if err != nil {
+		t.Errorf("client.WriteCoils() should have succeeded, got: %v", err)
+	}
+
+	coils, err	= client.ReadCoils(3, 3)
+	if err != nil {
+		t.Errorf("client.ReadCoils() should have succeeded, got: %v", err)
+	}
+	if len(coils) != 3 || coils[0] || !coils[1] || !coils[2] {
+		t.Errorf("unexpected coil values: %v", coils)
+	}
+
+	// device identification
+	objects, err	= client.ReadDeviceIdentification(DEVICE_ID_BASIC)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if objects[OBJ_PRODUCT_CODE] != "DTU-1" {
+		t.Errorf("unexpected objects: %v", objects)
+	}
+
+	// exceptions
+	_, err		= client.ReadRegisters(9, 2, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.ReadRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	client.SetUnitId(1)
+	_, err		= client.ReadRegisters(0, 1, INPUT_REGISTER)
+	if err != ErrIllegalFunction {
+		t.Errorf("client.ReadRegisters() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestRTUOverTCPBroadcast(t *testing.T) {
+	var server  *ModbusServer
+	var err	    error
+	var client  *ModbusClient
+	var th	    *tcpTestHandler
+	var regs    []uint16
+	var start   time.Time
+
+	th	= &tcpTestHandler{}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"rtuovertcp://localhost:5513",
+		MaxClients:	2,
+	}, &broadcastTestHandler{RequestHandler: th})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"rtuovertcp://localhost:5513",
+		Timeout:	500 * time.Millisecond,
+		TurnaroundDelay: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err		= client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(0)
+
+	// broadcast writes return after the turnaround delay, without a response
+	start		= time.Now()
+	err		= client.WriteRegister(1, 0x1234)
+	if err != nil {
+		t.Errorf("client.WriteRegister() should have succeeded, got: %v", err)
+	}
+	if time.Since(start) < 50 * time.Millisecond {
+		t.Errorf("client.WriteRegister() should have waited out the turnaround delay")
+	}
+
+	err		= client.WriteRegisters(2, []uint16{0x5678, 0x9abc})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	err		= client.WriteCoil(0, true)
+	if err != nil {
+		t.Errorf("client.WriteCoil() should have succeeded, got: %v", err)
+	}
+
+	// reads cannot be broadcast
+	_, err		= client.ReadRegisters(1, 1, HOLDING_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// the link should still be in sync
+	client.SetUnitId(9)
+	regs, err	= client.ReadRegisters(1, 3, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 3 || regs[0] != 0x1234 || regs[1] != 0x5678 || regs[2] != 0x9abc {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+	if !th.coils[0] {
+		t.Errorf("coil #0 should have been set")
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
 type tcpTestHandler struct {
 	coils	[10]bool
 	di	[10]bool
 	input	[10]uint16
 	holding	[10]uint16
+	fifo	[]uint16
+	files	map[uint16][]uint16
+
+	deviceId	map[uint8]string
+	deviceIdCalls	int
 }
 
 func (th *tcpTestHandler) HandleCoils(req *CoilsRequest) (res []bool, err error) {
@@ -656,3 +1342,116 @@ func (th *tcpTestHandler) HandleInputRegisters(req *InputRegistersRequest) (res
 
 	return
 }
+
+func (th *tcpTestHandler) HandleFIFOQueue(req *FIFOQueueRequest) (res []uint16, err error) {
+	if req.UnitId != 9 {
+		// only reply to unit ID #9
+		err	= ErrIllegalFunction
+		return
+	}
+
+	// a single queue lives behind holding register #4
+	if req.Addr != 4 {
+		err = ErrIllegalDataAddress
+		return
+	}
+
+	res	= th.fifo
+
+	return
+}
+
+func (th *tcpTestHandler) HandleFileRecords(req *FileRecordRequest) (res [][]uint16, err error) {
+	var file []uint16
+	var ok	 bool
+
+	if th.files == nil {
+		th.files	= map[uint16][]uint16{
+			1: make([]uint16, 10),
+			2: make([]uint16, 10),
+		}
+	}
+
+	// validate all sub-requests before touching any file
+	for _, record := range req.Records {
+		file, ok	= th.files[record.FileNumber]
+		if !ok || int(record.RecordNumber) + int(record.RecordLength) > len(file) {
+			err = ErrIllegalDataAddress
+			return
+		}
+	}
+
+	for _, record := range req.Records {
+		file	= th.files[record.FileNumber]
+		if req.IsWrite {
+			copy(file[record.RecordNumber:], record.Values)
+		} else {
+			res	= append(res, append([]uint16(nil),
+				file[record.RecordNumber:record.RecordNumber + record.RecordLength]...))
+		}
+	}
+
+	return
+}
+
+func (th *tcpTestHandler) HandleDeviceIdentification(req *DeviceIdentificationRequest) (res map[uint8]string, err error) {
+	th.deviceIdCalls++
+	res	= th.deviceId
+
+	return
+}
+
+// maskTestHandler serves mask writes natively and records the last request.
+// Embedding the RequestHandler interface only promotes the base handler
+// methods, so it does not implement FIFOQueueHandler.
+type maskTestHandler struct {
+	RequestHandler
+	calls	int
+	last	MaskWriteRegisterRequest
+}
+
+func (mh *maskTestHandler) HandleMaskWriteRegister(req *MaskWriteRegisterRequest) (err error) {
+	mh.calls++
+	mh.last	= *req
+
+	return
+}
+
+
+// silentTestHandler leaves holding register reads to unit #8 unanswered.
+type silentTestHandler struct {
+	RequestHandler
+}
+
+func (sh *silentTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	if req.UnitId == 8 {
+		err	= ErrNoResponse
+		return
+	}
+	res, err	= sh.RequestHandler.HandleHoldingRegisters(req)
+
+	return
+}
+
+// broadcastTestHandler applies broadcast writes to unit #9.
+type broadcastTestHandler struct {
+	RequestHandler
+}
+
+func (bh *broadcastTestHandler) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	if req.UnitId == 0 {
+		req.UnitId	= 9
+	}
+	res, err	= bh.RequestHandler.HandleCoils(req)
+
+	return
+}
+
+func (bh *broadcastTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	if req.UnitId == 0 {
+		req.UnitId	= 9
+	}
+	res, err	= bh.RequestHandler.HandleHoldingRegisters(req)
+
+	return
+}
@@ -312,6 +312,156 @@ func TestTLSServer(t *testing.T) {
 	return
 }
 
+// TestTLSServerACL tests access control rules based on the client role.
+func TestTLSServerACL(t *testing.T) {
+	var err            error
+	var server         *ModbusServer
+	var serverKeyPair  tls.Certificate
+	var client1KeyPair tls.Certificate
+	var client2KeyPair tls.Certificate
+	var clientCp       *x509.CertPool
+	var serverCp       *x509.CertPool
+	var th	           *tcpTestHandler
+	var c1	           *ModbusClient
+	var c2	           *ModbusClient
+	var regs           []uint16
+
+	th = &tcpTestHandler{}
+
+	serverKeyPair, err = tls.X509KeyPair([]byte(serverCert), []byte(serverKey))
+	if err != nil {
+		t.Errorf("failed to load test server key pair: %v", err)
+		return
+	}
+
+	// client #1 has no role, client #2 has the "operator2" role
+	client1KeyPair, err = tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+	if err != nil {
+		t.Errorf("failed to load test client key pair: %v", err)
+		return
+	}
+	client2KeyPair, err = tls.X509KeyPair(
+		[]byte(clientCertWithRoleOID), []byte(clientKeyWithRoleOID))
+	if err != nil {
+		t.Errorf("failed to load test client key pair: %v", err)
+		return
+	}
+
+	clientCp = x509.NewCertPool()
+	if !clientCp.AppendCertsFromPEM([]byte(serverCert)) {
+		t.Errorf("failed to load test server cert into cert pool")
+	}
+
+	serverCp = x509.NewCertPool()
+	if !serverCp.AppendCertsFromPEM([]byte(clientCert)) {
+		t.Errorf("failed to load client#1 cert into cert pool")
+	}
+	if !serverCp.AppendCertsFromPEM([]byte(clientCertWithRoleOID)) {
+		t.Errorf("failed to load client#2 cert into cert pool")
+	}
+
+	// anyone may read holding registers, only "operator2" may write them
+	// and only within 0-4
+	server, err = NewServer(&ServerConfiguration{
+		URL:           "tcp+tls://localhost:5803",
+		MaxClients:    2,
+		TLSServerCert: &serverKeyPair,
+		TLSClientCAs:  serverCp,
+		ACL:           &ACL{
+			Rules: []ACLRule{
+				{
+					FunctionCodes: []uint8{FC_READ_HOLDING_REGISTERS},
+					Allow:         true,
+				},
+				{
+					Roles:         []string{"operator2"},
+					FunctionCodes: []uint8{FC_WRITE_SINGLE_REGISTER,
+					                       FC_WRITE_MULTIPLE_REGISTERS},
+					Ranges:        []ACLRange{{0, 4}},
+					Allow:         true,
+				},
+			},
+		},
+	}, th)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	c1, err	= NewClient(&ClientConfiguration{
+		URL:           "tcp+tls://localhost:5803",
+		TLSClientCert: &client1KeyPair,
+		TLSRootCAs:    clientCp,
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+	c2, err	= NewClient(&ClientConfiguration{
+		URL:           "tcp+tls://localhost:5803",
+		TLSClientCert: &client2KeyPair,
+		TLSRootCAs:    clientCp,
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = c1.Open()
+	if err != nil {
+		t.Error("c1.Open() should have succeeded")
+	}
+	err = c2.Open()
+	if err != nil {
+		t.Error("c2.Open() should have succeeded")
+	}
+	c1.SetUnitId(9)
+	c2.SetUnitId(9)
+
+	// client #1 may not write at all
+	err = c1.WriteRegister(2, 100)
+	if err != ErrIllegalFunction {
+		t.Errorf("c1.WriteRegister() should have failed with %v, got: %v",
+			ErrIllegalFunction, err)
+	}
+
+	// client #2 may write within 0-4 only
+	err = c2.WriteRegisters(3, []uint16{200, 201})
+	if err != nil {
+		t.Errorf("c2.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	err = c2.WriteRegisters(4, []uint16{300, 301})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("c2.WriteRegisters() should have failed with %v, got: %v",
+			ErrIllegalDataAddress, err)
+	}
+
+	// denied requests never reach the handler
+	regs, err = c1.ReadRegisters(2, 4, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("c1.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if regs[0] != 0 || regs[1] != 200 || regs[2] != 201 || regs[3] != 0 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// no rule allows coil reads
+	_, err = c2.ReadCoils(0, 1)
+	if err != ErrIllegalFunction {
+		t.Errorf("c2.ReadCoils() should have failed with %v, got: %v",
+			ErrIllegalFunction, err)
+	}
+
+	c1.Close()
+	c2.Close()
+	server.Stop()
+
+	return
+}
+
 type tlsTestHandler struct {
 	coils      [10]bool
 	holdingId1 [10]uint16
@@ -0,0 +1,525 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modbus sniffer configuration object.
+type SnifferConfiguration struct {
+	// URL sets the serial device to listen on, in the form rtu://<device>
+	URL		string
+	// Speed sets the serial link speed (in bps, defaults to 19200)
+	Speed		uint
+	// DataBits sets the number of bits per serial character (defaults to 8)
+	DataBits	uint
+	// Parity sets the serial link parity mode (defaults to none)
+	Parity		uint
+	// StopBits sets the number of serial stop bits (defaults to 2 without
+	// parity, 1 otherwise)
+	StopBits	uint
+	// FrameGap sets the line silence marking the end of a frame (defaults
+	// to 3.5 character times, see serialInterFrameDelay())
+	FrameGap	time.Duration
+	// Timeout sets how long a request waits for its response before being
+	// reported unanswered (defaults to 1s)
+	Timeout		time.Duration
+	// Endianness sets the byte order used to decode registers (defaults
+	// to BIG_ENDIAN)
+	Endianness	Endianness
+	// Tracer, if set, receives a copy of every frame seen on the bus, as
+	// received frames
+	Tracer		FrameTracer
+	// Logger provides a custom sink for log messages.
+	// If nil, messages will be written to stdout.
+	Logger		*log.Logger
+}
+
+// A request seen on the bus, paired with its response if one was seen.
+type Transaction struct {
+	// Time is when the request was seen
+	Time		time.Time
+	UnitId		uint8
+	FunctionCode	uint8
+	// Addr and Quantity hold the first address and the number of coils or
+	// registers touched by the request (read range of read/write multiple
+	// registers requests, zero for function codes not decoded)
+	Addr		uint16
+	Quantity	uint16
+	// Request and Response hold the raw ADUs. Response is nil when no
+	// response was seen (broadcasts and unanswered requests).
+	Request		[]byte
+	Response	[]byte
+	// Err holds ErrRequestTimedOut for unanswered requests and the
+	// exception of exception responses (nil otherwise)
+	Err		error
+	// Coils holds the coils or discrete inputs read (0x01, 0x02) or
+	// written (0x05, 0x0f)
+	Coils		[]bool
+	// Registers holds the registers read (0x03, 0x04, 0x17) or written
+	// (0x06, 0x10)
+	Registers	[]uint16
+}
+
+// Modbus sniffer object.
+// A sniffer listens to an RTU bus owned by another master without ever
+// transmitting: frames are delimited by line silences and requests are
+// paired with the responses following them.
+type Sniffer struct {
+	conf		SnifferConfiguration
+	logger		*logger
+	lock		sync.Mutex
+	link		rtuLink
+	buf		[]byte
+	lastRx		time.Time
+	frames		[][]byte
+	pending		*Transaction
+	ready		*Transaction
+}
+
+// NewSniffer creates, configures and returns a modbus sniffer object.
+func NewSniffer(conf *SnifferConfiguration) (s *Sniffer, err error) {
+	var splitURL	[]string
+
+	s = &Sniffer{
+		conf:	*conf,
+	}
+
+	splitURL = strings.SplitN(s.conf.URL, "://", 2)
+	if len(splitURL) != 2 || splitURL[0] != "rtu" || splitURL[1] == "" {
+		err	= fmt.Errorf("%w: unsupported sniffer url '%s'",
+				     ErrConfigurationError, s.conf.URL)
+		s	= nil
+		return
+	}
+	s.conf.URL	= splitURL[1]
+
+	s.logger	= newLogger(fmt.Sprintf("modbus-sniffer(%s)", s.conf.URL), conf.Logger)
+
+	// set useful defaults, matching those of rtu clients
+	if s.conf.Speed == 0 {
+		s.conf.Speed	= 19200
+	}
+
+	if s.conf.DataBits == 0 {
+		s.conf.DataBits	= 8
+	}
+
+	if s.conf.StopBits == 0 {
+		if s.conf.Parity == PARITY_NONE {
+			s.conf.StopBits	= 2
+		} else {
+			s.conf.StopBits	= 1
+		}
+	}
+
+	if s.conf.FrameGap == 0 {
+		s.conf.FrameGap	= serialInterFrameDelay(s.conf.Speed)
+	}
+
+	if s.conf.Timeout == 0 {
+		s.conf.Timeout	= 1 * time.Second
+	}
+
+	if s.conf.Endianness == 0 {
+		s.conf.Endianness	= BIG_ENDIAN
+	}
+
+	return
+}
+
+// Opens the serial device, read-only as far as the sniffer is concerned:
+// nothing is ever written to it.
+func (s *Sniffer) Open() (err error) {
+	var spw		*serialPortWrapper
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	spw	= newSerialPortWrapper(&serialPortConfig{
+		Device:		s.conf.URL,
+		Speed:		s.conf.Speed,
+		DataBits:	s.conf.DataBits,
+		Parity:		s.conf.Parity,
+		StopBits:	s.conf.StopBits,
+	})
+
+	err	= spw.Open()
+	if err != nil {
+		return
+	}
+
+	s.link		= spw
+	s.buf		= nil
+	s.frames	= nil
+	s.pending	= nil
+	s.ready		= nil
+
+	return
+}
+
+// Closes the serial device. Next() calls in progress return with an error.
+func (s *Sniffer) Close() (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.link != nil {
+		err	= s.link.Close()
+		s.link	= nil
+	}
+
+	return
+}
+
+// Waits for and returns the next transaction seen on the bus.
+// Transactions are returned once their response is seen, right away for
+// broadcasts and once Timeout has elapsed for unanswered requests.
+// If the bus stays silent for Timeout, Next() returns ErrRequestTimedOut
+// with a nil transaction, allowing the caller to check for shutdown before
+// calling it again.
+// Next() is not safe for concurrent use.
+func (s *Sniffer) Next() (tx *Transaction, err error) {
+	var adu		[]byte
+	var until	time.Time
+
+	for {
+		// broadcasts seen right after an unanswered request
+		if s.ready != nil {
+			tx, s.ready	= s.ready, nil
+			return
+		}
+
+		// report requests left unanswered for too long
+		if s.pending != nil &&
+		   time.Since(s.pending.Time) >= s.conf.Timeout {
+			tx, s.pending	= s.pending, nil
+			tx.Err		= ErrRequestTimedOut
+			return
+		}
+
+		until	= time.Now().Add(s.conf.Timeout)
+		if s.pending != nil {
+			until	= s.pending.Time.Add(s.conf.Timeout)
+		}
+
+		adu, err = s.readFrame(until)
+		if err == ErrRequestTimedOut {
+			if s.pending != nil {
+				continue
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		if s.conf.Tracer != nil {
+			s.conf.Tracer.TraceFrame(FRAME_RTU, FRAME_RX, adu)
+		}
+
+		// responses complete the pending request
+		if s.pending != nil && s.isResponse(s.pending, adu) {
+			tx, s.pending	= s.pending, nil
+			s.decodeResponse(tx, adu)
+			return
+		}
+
+		// anything else should be a new request
+		if !isPlausibleRequest(adu) {
+			s.logger.Warningf("dropped unmatched frame: % x", adu)
+			continue
+		}
+
+		tx		= s.parseRequest(adu)
+
+		// a new request means the previous one went unanswered
+		if s.pending != nil {
+			if tx.UnitId == 0x00 {
+				// broadcasts get no response: report it next
+				s.ready		= tx
+				tx, s.pending	= s.pending, nil
+			} else {
+				tx, s.pending	= s.pending, tx
+			}
+			tx.Err		= ErrRequestTimedOut
+			return
+		}
+
+		// broadcasts get no response
+		if tx.UnitId == 0x00 {
+			return
+		}
+
+		s.pending	= tx
+		tx		= nil
+	}
+}
+
+// Reads from the link until a frame ends with a line silence, returning
+// ErrRequestTimedOut if no data is seen until the deadline.
+func (s *Sniffer) readFrame(until time.Time) (adu []byte, err error) {
+	var rxbuf	[]byte
+	var link	rtuLink
+	var n		int
+
+	s.lock.Lock()
+	link	= s.link
+	s.lock.Unlock()
+
+	if link == nil {
+		err	= net.ErrClosed
+		return
+	}
+
+	rxbuf	= make([]byte, maxRTUFrameLength)
+
+	for len(s.frames) == 0 {
+		if len(s.buf) == 0 {
+			link.SetDeadline(until)
+		} else {
+			link.SetDeadline(s.lastRx.Add(s.conf.FrameGap))
+		}
+
+		n, err	= link.Read(rxbuf)
+		if n > 0 {
+			s.buf		= append(s.buf, rxbuf[0:n]...)
+			s.lastRx	= time.Now()
+		}
+
+		if err != nil && !isTimeout(err) {
+			s.buf	= nil
+			return
+		}
+		err	= nil
+
+		switch {
+		case len(s.buf) == 0:
+			if !time.Now().Before(until) {
+				err	= ErrRequestTimedOut
+				return
+			}
+
+		// the line went silent, or the buffer grew beyond any frame
+		// (e.g. noise): split what was received into frames
+		case time.Since(s.lastRx) >= s.conf.FrameGap ||
+		     len(s.buf) >= 4 * maxRTUFrameLength:
+			s.frames	= s.splitFrames(s.buf)
+			s.buf		= nil
+		}
+	}
+
+	adu, s.frames	= s.frames[0], s.frames[1:]
+
+	return
+}
+
+// Splits the bytes received between two line silences into frames.
+// Usually they form a single frame, but the OS or a USB adapter may merge
+// a request and its response together: frames are then found by their CRC.
+// Bytes not belonging to any frame are logged and dropped.
+func (s *Sniffer) splitFrames(buf []byte) (frames [][]byte) {
+	var frameLen	int
+	var dropped	[]byte
+	var crc		crc
+
+	if len(buf) >= 4 && len(buf) <= maxRTUFrameLength {
+		crc.init()
+		crc.add(buf[0:len(buf) - 2])
+		if crc.isEqual(buf[len(buf) - 2], buf[len(buf) - 1]) {
+			frames	= append(frames, buf)
+			return
+		}
+	}
+
+	for start := 0; start < len(buf); {
+		// find the shortest frame (at least unit id, function code and
+		// CRC) starting here
+		frameLen	= 0
+		crc.init()
+		for i := start; i + 2 < len(buf) && i - start + 3 <= maxRTUFrameLength; i++ {
+			crc.add(buf[i:i + 1])
+			if i - start >= 1 && crc.isEqual(buf[i + 1], buf[i + 2]) {
+				frameLen	= i - start + 3
+				break
+			}
+		}
+
+		if frameLen == 0 {
+			dropped	= append(dropped, buf[start])
+			start++
+			continue
+		}
+
+		frames	= append(frames, buf[start:start + frameLen])
+		start	+= frameLen
+	}
+
+	if len(dropped) > 0 {
+		s.logger.Warningf("dropped %v byte(s) not forming a valid frame: % x",
+				  len(dropped), dropped)
+	}
+
+	return
+}
+
+// Returns true if adu looks like the response to req.
+func (s *Sniffer) isResponse(req *Transaction, adu []byte) (yes bool) {
+	var reqAdu	[]byte
+
+	if adu[0] != req.UnitId || adu[1] & 0x7f != req.FunctionCode {
+		return
+	}
+
+	// exception responses: unit id, function code, exception code, crc
+	if adu[1] & 0x80 != 0 {
+		yes	= len(adu) == 5
+		return
+	}
+
+	reqAdu	= req.Request
+
+	switch req.FunctionCode {
+	case fcReadCoils, fcReadDiscreteInputs:
+		yes	= len(adu) == 5 + int(adu[2]) &&
+			  int(adu[2]) == (int(req.Quantity) + 7) / 8
+
+	case fcReadHoldingRegisters, fcReadInputRegisters,
+	     fcReadWriteMultipleRegisters:
+		yes	= len(adu) == 5 + int(adu[2]) &&
+			  int(adu[2]) == 2 * int(req.Quantity)
+
+	// write responses echo the address and quantity (or value) of
+	// the request
+	case fcWriteSingleCoil, fcWriteSingleRegister,
+	     fcWriteMultipleCoils, fcWriteMultipleRegisters:
+		yes	= len(adu) == 8 && len(reqAdu) >= 8 &&
+			  string(adu[2:6]) == string(reqAdu[2:6])
+
+	case fcMaskWriteRegister:
+		yes	= len(adu) == 10 && string(adu) == string(reqAdu)
+
+	// other function codes are not decoded: any frame carrying the
+	// same unit id and function code will do
+	default:
+		yes	= true
+	}
+
+	return
+}
+
+// Returns true if adu can be a request, i.e. has the length its function
+// code calls for.
+func isPlausibleRequest(adu []byte) (yes bool) {
+	switch adu[1] {
+	case fcReadCoils, fcReadDiscreteInputs,
+	     fcReadHoldingRegisters, fcReadInputRegisters,
+	     fcWriteSingleCoil, fcWriteSingleRegister:
+		yes	= len(adu) == 8
+
+	case fcWriteMultipleCoils, fcWriteMultipleRegisters:
+		yes	= len(adu) >= 9 && len(adu) == 9 + int(adu[6])
+
+	case fcMaskWriteRegister:
+		yes	= len(adu) == 10
+
+	case fcReadWriteMultipleRegisters:
+		yes	= len(adu) >= 13 && len(adu) == 13 + int(adu[10])
+
+	default:
+		// exception responses are never requests
+		yes	= adu[1] & 0x80 == 0
+	}
+
+	return
+}
+
+// Decodes a request frame into a transaction.
+func (s *Sniffer) parseRequest(adu []byte) (tx *Transaction) {
+	var payload	[]byte
+
+	tx	= &Transaction{
+		Time:		time.Now(),
+		UnitId:		adu[0],
+		FunctionCode:	adu[1],
+		Request:	adu,
+	}
+
+	// strip the unit id, function code and CRC
+	payload	= adu[2:len(adu) - 2]
+
+	switch tx.FunctionCode {
+	case fcReadCoils, fcReadDiscreteInputs,
+	     fcReadHoldingRegisters, fcReadInputRegisters,
+	     fcReadWriteMultipleRegisters:
+		tx.Addr		= bytesToUint16(BIG_ENDIAN, payload[0:2])
+		tx.Quantity	= bytesToUint16(BIG_ENDIAN, payload[2:4])
+
+	case fcWriteSingleCoil:
+		tx.Addr		= bytesToUint16(BIG_ENDIAN, payload[0:2])
+		tx.Quantity	= 1
+		tx.Coils	= []bool{payload[2] == 0xff && payload[3] == 0x00}
+
+	case fcWriteSingleRegister:
+		tx.Addr		= bytesToUint16(BIG_ENDIAN, payload[0:2])
+		tx.Quantity	= 1
+		tx.Registers	= bytesToUint16s(s.conf.Endianness, payload[2:4])
+
+	case fcWriteMultipleCoils:
+		tx.Addr		= bytesToUint16(BIG_ENDIAN, payload[0:2])
+		tx.Quantity	= bytesToUint16(BIG_ENDIAN, payload[2:4])
+		if len(payload[5:]) * 8 >= int(tx.Quantity) {
+			tx.Coils	= decodeBools(tx.Quantity, payload[5:])
+		}
+
+	case fcWriteMultipleRegisters:
+		tx.Addr		= bytesToUint16(BIG_ENDIAN, payload[0:2])
+		tx.Quantity	= bytesToUint16(BIG_ENDIAN, payload[2:4])
+		if len(payload[5:]) == 2 * int(tx.Quantity) {
+			tx.Registers	= bytesToUint16s(s.conf.Endianness, payload[5:])
+		}
+
+	case fcMaskWriteRegister:
+		tx.Addr		= bytesToUint16(BIG_ENDIAN, payload[0:2])
+		tx.Quantity	= 1
+	}
+
+	return
+}
+
+// Decodes a response frame into its transaction.
+func (s *Sniffer) decodeResponse(tx *Transaction, adu []byte) {
+	tx.Response	= adu
+
+	if adu[1] & 0x80 != 0 {
+		tx.Err	= mapExceptionCodeToError(adu[2])
+		return
+	}
+
+	switch tx.FunctionCode {
+	case fcReadCoils, fcReadDiscreteInputs:
+		tx.Coils	= decodeBools(tx.Quantity, adu[3:len(adu) - 2])
+
+	case fcReadHoldingRegisters, fcReadInputRegisters,
+	     fcReadWriteMultipleRegisters:
+		tx.Registers	= bytesToUint16s(s.conf.Endianness, adu[3:len(adu) - 2])
+	}
+
+	return
+}
+
+// Returns true if err is a read timeout, i.e. the line stayed silent.
+func isTimeout(err error) (yes bool) {
+	var netErr	net.Error
+
+	yes	= err == ErrRequestTimedOut ||
+		  errors.Is(err, os.ErrDeadlineExceeded) ||
+		  (errors.As(err, &netErr) && netErr.Timeout())
+
+	return
+}
@@ -0,0 +1,166 @@
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// rtuLink wrapper failing the test on any attempt to transmit.
+type readOnlyTestLink struct {
+	net.Conn
+	t	*testing.T
+}
+
+func (l *readOnlyTestLink) Write(txbuf []byte) (cnt int, err error) {
+	l.t.Errorf("sniffer should never transmit, got: % x", txbuf)
+	cnt	= len(txbuf)
+
+	return
+}
+
+func TestSniffer(t *testing.T) {
+	var s		*Sniffer
+	var p1, p2	net.Conn
+	var rt		rtuTransport
+	var tx		*Transaction
+	var err		error
+	var chunks	[][]byte
+	var frame	= func(unitId uint8, fc uint8, payload ...byte) []byte {
+		return rt.assembleRTUFrame(&pdu{unitId, fc, payload})
+	}
+
+	_, err	= NewSniffer(&SnifferConfiguration{URL: "tcp://localhost:502"})
+	if err == nil {
+		t.Errorf("NewSniffer() should have failed")
+	}
+
+	s, err	= NewSniffer(&SnifferConfiguration{
+		URL:		"rtu:///dev/null",
+		Timeout:	100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewSniffer() should have succeeded, got: %v", err)
+	}
+
+	p1, p2	= net.Pipe()
+	defer p1.Close()
+	s.link	= &readOnlyTestLink{Conn: p2, t: t}
+
+	chunks	= [][]byte{
+		// read holding registers 0x10-0x11 of unit 1, and its response
+		frame(0x01, 0x03, 0x00, 0x10, 0x00, 0x02),
+		frame(0x01, 0x03, 0x04, 0x12, 0x34, 0xab, 0xcd),
+		// noise followed by a write single register request and its
+		// echo, merged into a single burst
+		append(append([]byte{0xfa},
+			      frame(0x02, 0x06, 0x00, 0x20, 0x00, 0x2a)...),
+			      frame(0x02, 0x06, 0x00, 0x20, 0x00, 0x2a)...),
+		// unanswered read coils request, then a broadcast write
+		frame(0x03, 0x01, 0x00, 0x00, 0x00, 0x0a),
+		frame(0x00, 0x10, 0x00, 0x30, 0x00, 0x02, 0x04,
+		      0x00, 0x01, 0x00, 0x02),
+		// read input registers rejected with an exception
+		frame(0x04, 0x04, 0x01, 0x00, 0x00, 0x01),
+		frame(0x04, 0x84, 0x02),
+		// read coils and its response
+		frame(0x05, 0x01, 0x00, 0x08, 0x00, 0x0a),
+		frame(0x05, 0x01, 0x02, 0x05, 0x02),
+	}
+
+	go func() {
+		for _, chunk := range chunks {
+			// leave more than t3.5 of silence between bursts
+			time.Sleep(10 * time.Millisecond)
+			_, err := p1.Write(chunk)
+			if err != nil {
+				t.Errorf("failed to write to test pipe: %v", err)
+				return
+			}
+		}
+	}()
+
+	tx, err	= s.Next()
+	if err != nil {
+		t.Fatalf("Next() should have succeeded, got: %v", err)
+	}
+	if tx.UnitId != 0x01 || tx.FunctionCode != 0x03 ||
+	   tx.Addr != 0x10 || tx.Quantity != 2 || tx.Err != nil ||
+	   len(tx.Response) != 9 || len(tx.Registers) != 2 ||
+	   tx.Registers[0] != 0x1234 || tx.Registers[1] != 0xabcd {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+
+	tx, err	= s.Next()
+	if err != nil {
+		t.Fatalf("Next() should have succeeded, got: %v", err)
+	}
+	if tx.UnitId != 0x02 || tx.FunctionCode != 0x06 ||
+	   tx.Addr != 0x20 || tx.Quantity != 1 || tx.Err != nil ||
+	   tx.Response == nil || len(tx.Registers) != 1 ||
+	   tx.Registers[0] != 42 {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+
+	// the read coils request was followed by another request
+	tx, err	= s.Next()
+	if err != nil {
+		t.Fatalf("Next() should have succeeded, got: %v", err)
+	}
+	if tx.UnitId != 0x03 || tx.FunctionCode != 0x01 ||
+	   tx.Err != ErrRequestTimedOut || tx.Response != nil {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+
+	tx, err	= s.Next()
+	if err != nil {
+		t.Fatalf("Next() should have succeeded, got: %v", err)
+	}
+	if tx.UnitId != 0x00 || tx.FunctionCode != 0x10 ||
+	   tx.Addr != 0x30 || tx.Quantity != 2 || tx.Err != nil ||
+	   tx.Response != nil || len(tx.Registers) != 2 ||
+	   tx.Registers[0] != 1 || tx.Registers[1] != 2 {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+
+	tx, err	= s.Next()
+	if err != nil {
+		t.Fatalf("Next() should have succeeded, got: %v", err)
+	}
+	if tx.UnitId != 0x04 || tx.FunctionCode != 0x04 ||
+	   tx.Addr != 0x100 || tx.Err != ErrIllegalDataAddress ||
+	   tx.Registers != nil {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+
+	tx, err	= s.Next()
+	if err != nil {
+		t.Fatalf("Next() should have succeeded, got: %v", err)
+	}
+	if tx.UnitId != 0x05 || tx.FunctionCode != 0x01 ||
+	   tx.Addr != 0x08 || tx.Quantity != 10 || tx.Err != nil ||
+	   len(tx.Coils) != 10 {
+		t.Fatalf("unexpected transaction: %+v", tx)
+	}
+	for i, b := range []bool{
+		true, false, true, false, false, false, false, false,
+		false, true,
+	} {
+		if tx.Coils[i] != b {
+			t.Errorf("expected %v for coil #%v, got %v", b, i, tx.Coils[i])
+		}
+	}
+
+	// the bus is now silent
+	tx, err	= s.Next()
+	if err != ErrRequestTimedOut || tx != nil {
+		t.Errorf("expected ErrRequestTimedOut, got: %v (%+v)", err, tx)
+	}
+
+	err	= s.Close()
+	if err != nil {
+		t.Errorf("Close() should have succeeded, got: %v", err)
+	}
+
+	return
+}
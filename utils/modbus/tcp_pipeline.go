@@ -0,0 +1,227 @@
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A pipelined TCP transport, keeping up to window requests in flight on a
+// single connection and matching responses to requests by MBAP transaction
+// id. Responses may arrive in any order.
+type pipelinedTCPTransport struct {
+	logger		*logger
+	socket		net.Conn
+	framer		*tcpTransport
+	timeout		time.Duration
+	window		chan struct{}
+	writeLock	sync.Mutex
+	start		sync.Once
+
+	lock		sync.Mutex
+	lastTxnId	uint16
+	pending		map[uint16]chan *pdu
+	err		error // set once the connection is unusable
+	done		chan struct{}
+}
+
+// Returns a new pipelined TCP transport. The response reader starts with
+// the first request, so that the transport can be set up (e.g. tracing)
+// until then.
+func newPipelinedTCPTransport(socket net.Conn, timeout time.Duration, window uint,
+	customLogger *log.Logger) (pt *pipelinedTCPTransport) {
+	pt = &pipelinedTCPTransport{
+		socket:		socket,
+		timeout:	timeout,
+		window:		make(chan struct{}, window),
+		pending:	map[uint16]chan *pdu{},
+		done:		make(chan struct{}),
+		logger:		newLogger(fmt.Sprintf("tcp-pipeline(%s)", socket.RemoteAddr()), customLogger),
+	}
+
+	// reuse the MBAP framing of the plain TCP transport
+	pt.framer	= &tcpTransport{
+		socket:		socket,
+		timeout:	timeout,
+		logger:		pt.logger,
+	}
+
+	return
+}
+
+// Closes the underlying tcp socket, failing requests in flight.
+func (pt *pipelinedTCPTransport) Close() (err error) {
+	err	= pt.socket.Close()
+
+	return
+}
+
+// Runs a request across the socket and waits for the matching response,
+// concurrently with other requests.
+func (pt *pipelinedTCPTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
+	var txnId	uint16
+	var resChan	chan *pdu
+	var timer	*time.Timer
+	var adu		[]byte
+
+	pt.start.Do(func() {
+		go pt.readResponses()
+	})
+
+	timer	= time.NewTimer(pt.timeout)
+	defer timer.Stop()
+
+	// wait for a free slot in the window
+	select {
+	case pt.window <- struct{}{}:
+	case <-timer.C:
+		err	= ErrRequestTimedOut
+		return
+	case <-pt.done:
+		err	= pt.closeError()
+		return
+	}
+	defer func() { <-pt.window }()
+
+	// pick a transaction id not used by a request in flight and register
+	// the request
+	resChan	= make(chan *pdu, 1)
+
+	pt.lock.Lock()
+	if pt.err != nil {
+		err	= pt.err
+		pt.lock.Unlock()
+		return
+	}
+	for {
+		pt.lastTxnId++
+		if _, inUse := pt.pending[pt.lastTxnId]; !inUse {
+			break
+		}
+	}
+	txnId			= pt.lastTxnId
+	pt.pending[txnId]	= resChan
+	pt.lock.Unlock()
+
+	// send the request
+	adu	= pt.framer.assembleMBAPFrame(txnId, req)
+
+	pt.writeLock.Lock()
+	err	= pt.socket.SetWriteDeadline(time.Now().Add(pt.timeout))
+	if err == nil {
+		traceFrame(pt.framer.tracer, FRAME_MBAP, FRAME_TX, adu)
+		_, err	= pt.socket.Write(adu)
+	}
+	pt.writeLock.Unlock()
+
+	if err != nil {
+		pt.forget(txnId)
+		// a partially written frame desyncs the stream for every other
+		// request: give up on the connection
+		pt.socket.Close()
+		if os.IsTimeout(err) {
+			err	= ErrRequestTimedOut
+		}
+		return
+	}
+
+	// wait for the response
+	select {
+	case res = <-resChan:
+		if res == nil {
+			err	= pt.closeError()
+		}
+	case <-timer.C:
+		// a late response will be logged and dropped by the reader
+		pt.forget(txnId)
+		err	= ErrRequestTimedOut
+	}
+
+	return
+}
+
+// Reading requests from a pipelined client transport is unsupported.
+func (pt *pipelinedTCPTransport) ReadRequest() (req *pdu, err error) {
+	err	= fmt.Errorf("unimplemented")
+
+	return
+}
+
+// Writing responses to a pipelined client transport is unsupported.
+func (pt *pipelinedTCPTransport) WriteResponse(res *pdu) (err error) {
+	err	= fmt.Errorf("unimplemented")
+
+	return
+}
+
+// Reads responses off the socket and hands them to the matching requests,
+// until the connection fails or is closed.
+func (pt *pipelinedTCPTransport) readResponses() {
+	var res		*pdu
+	var txnId	uint16
+	var resChan	chan *pdu
+	var ok		bool
+	var err		error
+
+	for {
+		res, txnId, err	= pt.framer.readMBAPFrame()
+
+		// ignore unknown protocol identifiers
+		if err == ErrUnknownProtocolId {
+			continue
+		}
+
+		// any other error leaves the stream in an unknown state
+		if err != nil {
+			break
+		}
+
+		pt.lock.Lock()
+		resChan, ok	= pt.pending[txnId]
+		delete(pt.pending, txnId)
+		pt.lock.Unlock()
+
+		// ignore responses to requests which timed out or were never sent
+		if !ok {
+			pt.logger.Warningf("received unexpected transaction id 0x%04x", txnId)
+			continue
+		}
+
+		resChan <- res
+	}
+
+	// fail all requests in flight
+	pt.lock.Lock()
+	pt.err	= err
+	for txnId, resChan = range pt.pending {
+		delete(pt.pending, txnId)
+		close(resChan)
+	}
+	pt.lock.Unlock()
+
+	pt.socket.Close()
+	close(pt.done)
+
+	return
+}
+
+// Removes a request from the set of requests in flight.
+func (pt *pipelinedTCPTransport) forget(txnId uint16) {
+	pt.lock.Lock()
+	delete(pt.pending, txnId)
+	pt.lock.Unlock()
+
+	return
+}
+
+// Returns the error which caused the connection to fail.
+func (pt *pipelinedTCPTransport) closeError() (err error) {
+	pt.lock.Lock()
+	err	= pt.err
+	pt.lock.Unlock()
+
+	return
+}
@@ -0,0 +1,291 @@
+package modbus
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelinedTCPTransportOutOfOrderResponses(t *testing.T) {
+	var pt		*pipelinedTCPTransport
+	var p1, p2	net.Conn
+	var framer	*tcpTransport
+	var results	chan *pdu
+	var txnIds	[]uint16
+	var err		error
+
+	p1, p2	= net.Pipe()
+	framer	= &tcpTransport{socket: p1, logger: newLogger("test", nil)}
+	results	= make(chan *pdu, 4)
+
+	pt	= newPipelinedTCPTransport(p2, 500 * time.Millisecond, 3, nil)
+	defer pt.Close()
+
+	// issue 4 requests concurrently, tagging each with a distinct unit id
+	for i := 1; i <= 4; i++ {
+		go func(unitId uint8) {
+			res, err := pt.ExecuteRequest(&pdu{
+				unitId:		unitId,
+				functionCode:	fcReadHoldingRegisters,
+				payload:	[]byte{0x00, 0x00, 0x00, 0x01},
+			})
+			if err != nil {
+				t.Errorf("ExecuteRequest() should have succeeded, got: %v", err)
+			}
+			results <- res
+		}(uint8(i))
+	}
+
+	// a window of 3 lets 3 requests through before any response is sent
+	p1.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	for i := 0; i < 3; i++ {
+		_, txnId, err := framer.readMBAPFrame()
+		if err != nil {
+			t.Fatalf("failed to read request #%v: %v", i, err)
+		}
+		txnIds	= append(txnIds, txnId)
+	}
+
+	p1.SetDeadline(time.Now().Add(20 * time.Millisecond))
+	_, _, err = framer.readMBAPFrame()
+	if err == nil {
+		t.Fatalf("the 4th request should have been held back by the window")
+	}
+
+	// send a response with an unknown transaction id, which should be
+	// ignored, then answer in reverse order, echoing the transaction id as
+	// a register value
+	p1.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	writeTestMBAPFrame(t, framer, 0xbeef)
+	for i := len(txnIds) - 1; i >= 0; i-- {
+		writeTestMBAPFrame(t, framer, txnIds[i])
+	}
+
+	// the 4th request goes out once a slot is free
+	_, txnId, err := framer.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("failed to read request #4: %v", err)
+	}
+	writeTestMBAPFrame(t, framer, txnId)
+
+	txnIds	= append(txnIds, txnId)
+	for i := 0; i < 4; i++ {
+		var res = <-results
+		var found bool
+
+		if res == nil {
+			continue
+		}
+		for _, txnId := range txnIds {
+			if bytesToUint16(BIG_ENDIAN, res.payload[1:3]) == txnId {
+				found	= true
+			}
+		}
+		if !found {
+			t.Errorf("unexpected response payload %v", res.payload)
+		}
+	}
+
+	// closing the peer fails pending and subsequent requests
+	p1.Close()
+	_, err = pt.ExecuteRequest(&pdu{unitId: 0x01, functionCode: fcReadHoldingRegisters})
+	if err == nil {
+		t.Errorf("ExecuteRequest() should have failed on a closed connection")
+	}
+
+	return
+}
+
+func TestPipelinedTCPTransportTimeout(t *testing.T) {
+	var pt		*pipelinedTCPTransport
+	var p1, p2	net.Conn
+	var err		error
+
+	p1, p2	= net.Pipe()
+	defer p1.Close()
+
+	// swallow requests without ever answering
+	go io.Copy(io.Discard, p1)
+
+	pt	= newPipelinedTCPTransport(p2, 20 * time.Millisecond, 2, nil)
+	defer pt.Close()
+
+	_, err	= pt.ExecuteRequest(&pdu{
+		unitId:		0x01,
+		functionCode:	fcReadHoldingRegisters,
+		payload:	[]byte{0x00, 0x00, 0x00, 0x01},
+	})
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	// the timed out request no longer holds a slot nor a transaction id
+	pt.lock.Lock()
+	if len(pt.pending) != 0 {
+		t.Errorf("expected no pending request, got %v", len(pt.pending))
+	}
+	pt.lock.Unlock()
+	if len(pt.window) != 0 {
+		t.Errorf("expected an empty window, got %v", len(pt.window))
+	}
+
+	return
+}
+
+func TestPipelinedTCPClient(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var th		*tcpTestHandler
+	var wg		sync.WaitGroup
+	var err		error
+
+	th	= &tcpTestHandler{}
+
+	server, err	= NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5509",
+		MaxClients:	1,
+	}, th)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err	= server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5509",
+		PipelineWindow:	4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(9)
+
+	// have each goroutine own one holding register and check that every
+	// response matches its own request
+	for i := 0; i < len(th.holding); i++ {
+		wg.Add(1)
+		go func(addr uint16) {
+			defer wg.Done()
+
+			for j := uint16(0); j < 50; j++ {
+				var value	= addr << 8 | j
+
+				err := client.WriteRegister(addr, value)
+				if err != nil {
+					t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+					return
+				}
+
+				reg, err := client.ReadRegister(addr, HOLDING_REGISTER)
+				if err != nil {
+					t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+					return
+				}
+				if reg != value {
+					t.Errorf("expected 0x%04x at address %v, got 0x%04x",
+						 value, addr, reg)
+					return
+				}
+			}
+		}(uint16(i))
+	}
+	wg.Wait()
+
+	// exceptions are matched to their request as well
+	_, err	= client.ReadRegisters(8, 4, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	return
+}
+
+func TestPipelinedTCPClientSettingsWaitForRequests(t *testing.T) {
+	var client	*ModbusClient
+	var p1, p2	net.Conn
+	var framer	*tcpTransport
+	var readDone	chan uint16
+	var setDone	chan struct{}
+	var txnId	uint16
+	var err		error
+
+	p1, p2	= net.Pipe()
+	defer p1.Close()
+	framer	= &tcpTransport{socket: p1, logger: newLogger("test", nil)}
+	client	= &ModbusClient{
+		logger:		newLogger("test", nil),
+		transport:	newPipelinedTCPTransport(p2, 500 * time.Millisecond, 2, nil),
+		transportType:	modbusTCP,
+		unitId:		0x01,
+		endianness:	BIG_ENDIAN,
+		wordOrder:	HIGH_WORD_FIRST,
+	}
+	defer client.Close()
+
+	readDone	= make(chan uint16, 1)
+	setDone		= make(chan struct{})
+
+	go func() {
+		reg, err := client.ReadRegister(0x0000, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+		}
+		readDone <- reg
+	}()
+
+	p1.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	_, txnId, err	= framer.readMBAPFrame()
+	if err != nil {
+		t.Fatalf("failed to read request: %v", err)
+	}
+
+	// changing the encoding waits for the request in flight
+	go func() {
+		client.SetEncoding(LITTLE_ENDIAN, LOW_WORD_FIRST)
+		close(setDone)
+	}()
+
+	select {
+	case <-setDone:
+		t.Errorf("SetEncoding() should have waited for the request in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the response is decoded with the encoding of the request
+	writeTestMBAPFrame(t, framer, txnId)
+	if reg := <-readDone; reg != txnId {
+		t.Errorf("expected 0x%04x, got 0x%04x", txnId, reg)
+	}
+	<-setDone
+
+	return
+}
+
+// Writes a read holding registers response carrying a single register
+// holding the transaction id.
+func writeTestMBAPFrame(t *testing.T, framer *tcpTransport, txnId uint16) {
+	var err	error
+
+	_, err	= framer.socket.Write(framer.assembleMBAPFrame(txnId, &pdu{
+		unitId:		0x01,
+		functionCode:	fcReadHoldingRegisters,
+		payload:	append([]byte{0x02}, uint16ToBytes(BIG_ENDIAN, txnId)...),
+	}))
+	if err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+
+	return
+}
@@ -18,6 +18,7 @@ type tcpTransport struct {
 	socket		net.Conn
 	timeout		time.Duration
 	lastTxnId	uint16
+	tracer		FrameTracer
 }
 
 // Returns a new TCP transport.
@@ -40,6 +41,8 @@ func (tt *tcpTransport) Close() (err error) {
 
 // Runs a request across the socket and returns a response.
 func (tt *tcpTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
+	var adu	[]byte
+
 	// set an i/o deadline on the socket (read and write)
 	err	= tt.socket.SetDeadline(time.Now().Add(tt.timeout))
 	if err != nil {
@@ -49,7 +52,10 @@ func (tt *tcpTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 	// increase the transaction ID counter
 	tt.lastTxnId++
 
-	_, err	= tt.socket.Write(tt.assembleMBAPFrame(tt.lastTxnId, req))
+	adu	= tt.assembleMBAPFrame(tt.lastTxnId, req)
+	traceFrame(tt.tracer, FRAME_MBAP, FRAME_TX, adu)
+
+	_, err	= tt.socket.Write(adu)
 	if err != nil {
 		return
 	}
@@ -125,17 +131,19 @@ func (tt *tcpTransport) readResponse() (res *pdu, err error) {
 
 // Reads an entire frame (MBAP header + modbus PDU) from the socket.
 func (tt *tcpTransport) readMBAPFrame() (p *pdu, txnId uint16, err error) {
+	var header	[]byte
 	var rxbuf	[]byte
 	var bytesNeeded	int
 	var protocolId	uint16
 	var unitId	uint8
 
 	// read the MBAP header
-	rxbuf		= make([]byte, mbapHeaderLength)
-	_, err		= io.ReadFull(tt.socket, rxbuf)
+	header		= make([]byte, mbapHeaderLength)
+	_, err		= io.ReadFull(tt.socket, header)
 	if err != nil {
 		return
 	}
+	rxbuf		= header
 
 	// decode the transaction identifier
 	txnId		= bytesToUint16(BIG_ENDIAN, rxbuf[0:2])
@@ -169,6 +177,10 @@ func (tt *tcpTransport) readMBAPFrame() (p *pdu, txnId uint16, err error) {
 		return
 	}
 
+	if tt.tracer != nil {
+		tt.tracer.TraceFrame(FRAME_MBAP, FRAME_RX, append(header, rxbuf...))
+	}
+
 	// validate the protocol identifier
 	if protocolId != 0x0000 {
 		err = ErrUnknownProtocolId
@@ -0,0 +1,182 @@
+package modbus
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Direction of a traced frame.
+type FrameDirection uint
+
+const (
+	FRAME_TX	FrameDirection = 1 // sent by this end
+	FRAME_RX	FrameDirection = 2 // received by this end
+)
+
+// Framing of a traced ADU.
+type FrameFormat uint
+
+const (
+	FRAME_MBAP	FrameFormat = 1 // MBAP header + PDU (tcp, tcp+tls, udp)
+	FRAME_RTU	FrameFormat = 2 // unit id + PDU + CRC (rtu, rtuovertcp, rtuoverudp)
+	FRAME_ASCII	FrameFormat = 3 // ':' + hex unit id, PDU and LRC + CR LF (ascii, asciiovertcp)
+)
+
+// FrameTracer receives a copy of every ADU sent or received by a client
+// transport. TraceFrame is called from the goroutine doing the i/o and must
+// not retain adu past the call.
+type FrameTracer interface {
+	TraceFrame(format FrameFormat, dir FrameDirection, adu []byte)
+}
+
+// A traced frame.
+type Frame struct {
+	Time		time.Time
+	Direction	FrameDirection
+	Format		FrameFormat
+	// ADU holds the raw bytes of the frame as seen on the wire
+	ADU		[]byte
+	// FunctionCode holds the function code of the frame, with bit 7 set
+	// on exception responses (0 if the frame is too short to tell)
+	FunctionCode	uint8
+	// Exception holds the exception code of exception responses (0
+	// otherwise)
+	Exception	uint8
+}
+
+// FrameRing is a FrameTracer keeping the most recent frames in a bounded
+// ring buffer. It is safe for concurrent use.
+type FrameRing struct {
+	lock	sync.Mutex
+	frames	[]Frame
+	next	int
+	full	bool
+}
+
+// Returns a new frame ring holding up to size frames.
+func NewFrameRing(size int) (fr *FrameRing) {
+	if size <= 0 {
+		size	= 1
+	}
+
+	fr = &FrameRing{
+		frames:	make([]Frame, size),
+	}
+
+	return
+}
+
+// Records a frame, overwriting the oldest one if the ring is full.
+func (fr *FrameRing) TraceFrame(format FrameFormat, dir FrameDirection, adu []byte) {
+	var f	Frame
+
+	f	= Frame{
+		Time:		time.Now(),
+		Direction:	dir,
+		Format:		format,
+		ADU:		append([]byte(nil), adu...),
+	}
+	f.FunctionCode, f.Exception	= parseADU(format, adu)
+
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	fr.frames[fr.next]	= f
+	fr.next++
+	if fr.next == len(fr.frames) {
+		fr.next	= 0
+		fr.full	= true
+	}
+
+	return
+}
+
+// Returns the frames held in the ring, oldest first.
+func (fr *FrameRing) Frames() (frames []Frame) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	if fr.full {
+		frames	= append(frames, fr.frames[fr.next:]...)
+	}
+	frames	= append(frames, fr.frames[:fr.next]...)
+
+	return
+}
+
+// Drops all frames held in the ring.
+func (fr *FrameRing) Reset() {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	for i := range fr.frames {
+		fr.frames[i]	= Frame{}
+	}
+	fr.next	= 0
+	fr.full	= false
+
+	return
+}
+
+// Extracts the function code and exception code (if any) of an ADU.
+func parseADU(format FrameFormat, adu []byte) (fc uint8, exception uint8) {
+	var body	[]byte
+	var digits	[]byte
+
+	switch format {
+	case FRAME_MBAP:
+		// 7 bytes of MBAP header (the last one being the unit id)
+		if len(adu) > mbapHeaderLength {
+			body	= adu[mbapHeaderLength - 1:]
+		}
+
+	case FRAME_RTU:
+		body	= adu
+
+	case FRAME_ASCII:
+		// decode the unit id, function code and exception code digits
+		// (decoding stops at the first non-hex character)
+		if len(adu) > 1 && adu[0] == ':' {
+			digits	= adu[1:]
+			if len(digits) > 6 {
+				digits	= digits[:6]
+			}
+			body, _	= hex.DecodeString(string(digits[:len(digits) / 2 * 2]))
+		}
+	}
+
+	// unit id + function code (+ exception code)
+	if len(body) < 2 {
+		return
+	}
+
+	fc	= body[1]
+	if fc & 0x80 != 0 && len(body) > 2 {
+		exception	= body[2]
+	}
+
+	return
+}
+
+// Passes a frame to tracer, if any.
+func traceFrame(tracer FrameTracer, format FrameFormat, dir FrameDirection, adu []byte) {
+	if tracer != nil {
+		tracer.TraceFrame(format, dir, adu)
+	}
+
+	return
+}
+
+// Link wrapper recording the bytes read through it.
+type recordingLink struct {
+	rtuLink
+	rx	[]byte
+}
+
+func (rl *recordingLink) Read(buf []byte) (rlen int, err error) {
+	rlen, err	= rl.rtuLink.Read(buf)
+	rl.rx		= append(rl.rx, buf[:rlen]...)
+
+	return
+}
@@ -0,0 +1,255 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFrameRing(t *testing.T) {
+	var fr		*FrameRing
+	var frames	[]Frame
+
+	fr	= NewFrameRing(3)
+
+	if len(fr.Frames()) != 0 {
+		t.Errorf("expected an empty ring")
+	}
+
+	// push 4 frames into a 3-frame ring: the first one should be dropped
+	for i := byte(1); i <= 4; i++ {
+		fr.TraceFrame(FRAME_RTU, FRAME_TX, []byte{i, 0x03, 0x00, 0x00, 0x00, 0x01})
+	}
+
+	frames	= fr.Frames()
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 frames, got %v", len(frames))
+	}
+	for i, f := range frames {
+		if f.ADU[0] != byte(i + 2) {
+			t.Errorf("expected frame #%v to be from unit %v, got %v", i, i + 2, f.ADU[0])
+		}
+		if f.FunctionCode != 0x03 || f.Exception != 0x00 {
+			t.Errorf("unexpected function/exception codes 0x%02x/0x%02x",
+				 f.FunctionCode, f.Exception)
+		}
+	}
+
+	fr.Reset()
+	if len(fr.Frames()) != 0 {
+		t.Errorf("expected an empty ring after Reset()")
+	}
+
+	return
+}
+
+func TestParseADU(t *testing.T) {
+	for _, tc := range []struct {
+		format		FrameFormat
+		adu		[]byte
+		fc		uint8
+		exception	uint8
+	}{
+		// MBAP exception response (illegal data address)
+		{FRAME_MBAP, []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x09, 0x83, 0x02}, 0x83, 0x02},
+		// MBAP request
+		{FRAME_MBAP, []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x09, 0x03, 0x00, 0x00, 0x00, 0x01}, 0x03, 0x00},
+		// truncated MBAP header
+		{FRAME_MBAP, []byte{0x00, 0x01, 0x00}, 0x00, 0x00},
+		// RTU exception response (illegal function), with CRC
+		{FRAME_RTU, []byte{0x31, 0x81, 0x01, 0x00, 0x00}, 0x81, 0x01},
+		// lone RTU byte
+		{FRAME_RTU, []byte{0x31}, 0x00, 0x00},
+		// ASCII exception response (illegal data address)
+		{FRAME_ASCII, []byte(":3182024b\r\n"), 0x82, 0x02},
+		// ASCII request
+		{FRAME_ASCII, []byte(":1103006B00037E\r\n"), 0x03, 0x00},
+		// ASCII garbage
+		{FRAME_ASCII, []byte(":zz\r\n"), 0x00, 0x00},
+	} {
+		fc, exception := parseADU(tc.format, tc.adu)
+		if fc != tc.fc || exception != tc.exception {
+			t.Errorf("%x: expected 0x%02x/0x%02x, got 0x%02x/0x%02x",
+				 tc.adu, tc.fc, tc.exception, fc, exception)
+		}
+	}
+
+	return
+}
+
+func TestTCPTransportTrace(t *testing.T) {
+	var tt		*tcpTransport
+	var fr		*FrameRing
+	var p1, p2	net.Conn
+	var frames	[]Frame
+	var err		error
+
+	p1, p2	= net.Pipe()
+	defer p1.Close()
+
+	fr	= NewFrameRing(10)
+	tt	= newTCPTransport(p2, 100 * time.Millisecond, nil)
+	tt.tracer	= fr
+
+	// answer with an exception
+	go func() {
+		var req	= make([]byte, 12)
+
+		_, err := p1.Read(req)
+		if err != nil {
+			return
+		}
+		p1.Write([]byte{req[0], req[1], 0x00, 0x00, 0x00, 0x03, 0x09, 0x83, 0x02})
+	}()
+
+	_, err	= tt.ExecuteRequest(&pdu{
+		unitId:		0x09,
+		functionCode:	fcReadHoldingRegisters,
+		payload:	[]byte{0x00, 0x00, 0x00, 0x01},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteRequest() should have succeeded, got: %v", err)
+	}
+
+	frames	= fr.Frames()
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %v", len(frames))
+	}
+	if frames[0].Direction != FRAME_TX || frames[0].Format != FRAME_MBAP ||
+	   !bytes.Equal(frames[0].ADU, []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06,
+	   0x09, 0x03, 0x00, 0x00, 0x00, 0x01}) {
+		t.Errorf("unexpected tx frame %+v", frames[0])
+	}
+	if frames[1].Direction != FRAME_RX || frames[1].FunctionCode != 0x83 ||
+	   frames[1].Exception != 0x02 || len(frames[1].ADU) != 9 {
+		t.Errorf("unexpected rx frame %+v", frames[1])
+	}
+
+	return
+}
+
+func TestRTUTransportTrace(t *testing.T) {
+	var rt		*rtuTransport
+	var fr		*FrameRing
+	var p1, p2	net.Conn
+	var frames	[]Frame
+	var err		error
+
+	p1, p2	= net.Pipe()
+	defer p1.Close()
+
+	fr	= NewFrameRing(10)
+	rt	= newRTUTransport(p2, "", 19200, 100 * time.Millisecond, nil)
+	rt.tracer	= fr
+
+	// answer with a frame carrying a bad CRC, which should be traced
+	// all the same
+	go func() {
+		var req	= make([]byte, 8)
+
+		_, err := p1.Read(req)
+		if err != nil {
+			return
+		}
+		p1.Write([]byte{0x01, 0x83, 0x02, 0xde, 0xad})
+	}()
+
+	_, err	= rt.ExecuteRequest(&pdu{
+		unitId:		0x01,
+		functionCode:	fcReadHoldingRegisters,
+		payload:	[]byte{0x00, 0x00, 0x00, 0x01},
+	})
+	if err != ErrBadCRC {
+		t.Fatalf("expected ErrBadCRC, got: %v", err)
+	}
+
+	frames	= fr.Frames()
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %v", len(frames))
+	}
+	if frames[0].Direction != FRAME_TX || len(frames[0].ADU) != 8 {
+		t.Errorf("unexpected tx frame %+v", frames[0])
+	}
+	if frames[1].Direction != FRAME_RX ||
+	   !bytes.Equal(frames[1].ADU, []byte{0x01, 0x83, 0x02, 0xde, 0xad}) ||
+	   frames[1].Exception != 0x02 {
+		t.Errorf("unexpected rx frame %+v", frames[1])
+	}
+
+	return
+}
+
+func TestWritePcap(t *testing.T) {
+	var buf		bytes.Buffer
+	var frames	[]Frame
+	var out		[]byte
+	var packet	[]byte
+	var ts		= time.Unix(1700000000, 123456000)
+	var err		error
+
+	frames	= []Frame{
+		{Time: ts, Direction: FRAME_TX, Format: FRAME_MBAP,
+		 ADU: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x09, 0x03, 0x00, 0x00, 0x00, 0x01}},
+		{Time: ts, Direction: FRAME_RX, Format: FRAME_MBAP,
+		 ADU: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x09, 0x03, 0x02, 0x12, 0x34}},
+		// frames of another format are skipped
+		{Time: ts, Direction: FRAME_TX, Format: FRAME_RTU, ADU: []byte{0x01}},
+	}
+
+	err	= WritePcap(&buf, frames)
+	if err != nil {
+		t.Fatalf("WritePcap() should have succeeded, got: %v", err)
+	}
+	out	= buf.Bytes()
+
+	// global header
+	if binary.LittleEndian.Uint32(out[0:4]) != 0xa1b2c3d4 ||
+	   binary.LittleEndian.Uint32(out[20:24]) != linkTypeRaw {
+		t.Fatalf("unexpected global header %x", out[0:24])
+	}
+	out	= out[24:]
+
+	// first record: 20 bytes of IPv4 + 20 bytes of TCP + 12 bytes of ADU
+	if binary.LittleEndian.Uint32(out[0:4]) != 1700000000 ||
+	   binary.LittleEndian.Uint32(out[4:8]) != 123456 ||
+	   binary.LittleEndian.Uint32(out[8:12]) != 52 {
+		t.Fatalf("unexpected record header %x", out[0:16])
+	}
+	packet	= out[16:68]
+	if inetChecksum(packet[0:20]) != 0 {
+		t.Errorf("bad IPv4 header checksum")
+	}
+	if binary.BigEndian.Uint16(packet[22:24]) != 502 {
+		t.Errorf("expected a request to port 502, got %v",
+			 binary.BigEndian.Uint16(packet[22:24]))
+	}
+	if !bytes.Equal(packet[40:], frames[0].ADU) {
+		t.Errorf("unexpected payload %x", packet[40:])
+	}
+	out	= out[68:]
+
+	// second record: response from port 502, acknowledging the request
+	packet	= out[16:]
+	if len(packet) != 51 {
+		t.Fatalf("expected a 51-byte packet, got %v", len(packet))
+	}
+	if binary.BigEndian.Uint16(packet[20:22]) != 502 ||
+	   binary.BigEndian.Uint32(packet[28:32]) != 13 {
+		t.Errorf("unexpected tcp header %x", packet[20:40])
+	}
+
+	// RTU captures carry bare ADUs
+	buf.Reset()
+	err	= WritePcap(&buf, frames[2:])
+	if err != nil {
+		t.Fatalf("WritePcap() should have succeeded, got: %v", err)
+	}
+	out	= buf.Bytes()
+	if binary.LittleEndian.Uint32(out[20:24]) != linkTypeUser0 || len(out) != 24 + 16 + 1 {
+		t.Errorf("unexpected RTU capture %x", out)
+	}
+
+	return
+}
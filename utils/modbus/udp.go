@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"io"
 	"net"
 	"time"
 )
@@ -100,3 +101,61 @@ func (usw *udpSockWrapper) RemoteAddr() (addr net.Addr) {
 
 	return
 }
+
+// udpDatagramConn serves a datagram received on a server-side UDP socket
+// as a net.Conn: reads consume the datagram, writes are sent back to its
+// source address.
+type udpDatagramConn struct {
+	sock  *net.UDPConn
+	addr  *net.UDPAddr
+	rxbuf []byte
+}
+
+func (udc *udpDatagramConn) Read(buf []byte) (rlen int, err error) {
+	// the datagram holds a single request: once consumed, there's nothing
+	// left to read
+	if len(udc.rxbuf) == 0 {
+		err = io.EOF
+		return
+	}
+
+	rlen      = copy(buf, udc.rxbuf)
+	udc.rxbuf = udc.rxbuf[rlen:]
+
+	return
+}
+
+func (udc *udpDatagramConn) Write(buf []byte) (wlen int, err error) {
+	wlen, err = udc.sock.WriteToUDP(buf, udc.addr)
+
+	return
+}
+
+// Close is a no-op as the socket is shared by all clients.
+func (udc *udpDatagramConn) Close() (err error) {
+	return
+}
+
+func (udc *udpDatagramConn) SetDeadline(deadline time.Time) (err error) {
+	return
+}
+
+func (udc *udpDatagramConn) SetReadDeadline(deadline time.Time) (err error) {
+	return
+}
+
+func (udc *udpDatagramConn) SetWriteDeadline(deadline time.Time) (err error) {
+	return
+}
+
+func (udc *udpDatagramConn) LocalAddr() (addr net.Addr) {
+	addr = udc.sock.LocalAddr()
+
+	return
+}
+
+func (udc *udpDatagramConn) RemoteAddr() (addr net.Addr) {
+	addr = udc.addr
+
+	return
+}
@@ -164,3 +164,127 @@ func TestUDPSockWrapper(t *testing.T) {
 
 	return
 }
+
+func TestUDPServer(t *testing.T) {
+	var err     error
+	var server  *ModbusServer
+	var c1      *ModbusClient
+	var c2      *ModbusClient
+	var sock    *net.UDPConn
+	var addr    *net.UDPAddr
+	var rxbuf   []byte
+	var regs    []uint16
+	var coils   []bool
+
+	for _, scheme := range []string{"udp", "rtuoverudp"} {
+		server, err = NewServer(&ServerConfiguration{
+			URL:	scheme + "://localhost:5511",
+		}, &tcpTestHandler{})
+		if err != nil {
+			t.Errorf("failed to create %s server: %v", scheme, err)
+			return
+		}
+
+		err = server.Start()
+		if err != nil {
+			t.Errorf("failed to start %s server: %v", scheme, err)
+			return
+		}
+
+		// two clients share the server socket: each should get its own
+		// responses
+		c1, err = NewClient(&ClientConfiguration{
+			URL:		scheme + "://localhost:5511",
+			Timeout:	500 * time.Millisecond,
+		})
+		if err != nil {
+			t.Errorf("failed to create client: %v", err)
+		}
+		c2, err = NewClient(&ClientConfiguration{
+			URL:		scheme + "://localhost:5511",
+			Timeout:	500 * time.Millisecond,
+		})
+		if err != nil {
+			t.Errorf("failed to create client: %v", err)
+		}
+
+		err = c1.Open()
+		if err != nil {
+			t.Errorf("c1.Open() should have succeeded, got: %v", err)
+		}
+		err = c2.Open()
+		if err != nil {
+			t.Errorf("c2.Open() should have succeeded, got: %v", err)
+		}
+		c1.SetUnitId(9)
+		c2.SetUnitId(9)
+
+		err = c1.WriteRegisters(2, []uint16{0x1234, 0x5678})
+		if err != nil {
+			t.Errorf("%s: c1.WriteRegisters() should have succeeded, got: %v", scheme, err)
+		}
+
+		regs, err = c2.ReadRegisters(1, 3, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("%s: c2.ReadRegisters() should have succeeded, got: %v", scheme, err)
+		}
+		if len(regs) != 3 || regs[0] != 0 || regs[1] != 0x1234 || regs[2] != 0x5678 {
+			t.Errorf("%s: unexpected register values: %v", scheme, regs)
+		}
+
+		err = c2.WriteCoils(0, []bool{true, false, true})
+		if err != nil {
+			t.Errorf("%s: c2.WriteCoils() should have succeeded, got: %v", scheme, err)
+		}
+
+		coils, err = c1.ReadCoils(0, 3)
+		if err != nil {
+			t.Errorf("%s: c1.ReadCoils() should have succeeded, got: %v", scheme, err)
+		}
+		if len(coils) != 3 || !coils[0] || coils[1] || !coils[2] {
+			t.Errorf("%s: unexpected coil values: %v", scheme, coils)
+		}
+
+		// exceptions are returned as usual
+		_, err = c1.ReadRegisters(8, 3, HOLDING_REGISTER)
+		if err != ErrIllegalDataAddress {
+			t.Errorf("%s: c1.ReadRegisters() should have failed with %v, got: %v",
+				 scheme, ErrIllegalDataAddress, err)
+		}
+
+		c1.Close()
+		c2.Close()
+
+		if scheme == "rtuoverudp" {
+			// datagrams with a bad CRC are dropped without a response
+			addr, err = net.ResolveUDPAddr("udp", "localhost:5511")
+			if err != nil {
+				t.Errorf("failed to resolve udp address: %v", err)
+			}
+			sock, err = net.DialUDP("udp", nil, addr)
+			if err != nil {
+				t.Errorf("failed to open udp socket: %v", err)
+			}
+
+			_, err = sock.Write([]byte{
+				0x09, 0x03, 0x00, 0x00, 0x00, 0x01,
+				0x00, 0x00, // bad CRC
+			})
+			if err != nil {
+				t.Errorf("failed to write datagram: %v", err)
+			}
+
+			sock.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			rxbuf = make([]byte, 256)
+			_, err = sock.Read(rxbuf)
+			if !os.IsTimeout(err) {
+				t.Errorf("sock.Read() should have failed with a timeout error, got: %v", err)
+			}
+			sock.Close()
+		}
+
+		server.Stop()
+	}
+
+	return
+}
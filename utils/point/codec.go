@@ -0,0 +1,637 @@
+package point
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+var (
+	ErrShortData      = errors.New("point: not enough data for data type")
+	ErrOutOfRange     = errors.New("point: value out of range for data type")
+	ErrUnknownLabel   = errors.New("point: unknown enum label")
+	ErrNotWritable    = errors.New("point: value kind cannot be written to this point")
+	ErrInvalidBCD     = errors.New("point: invalid bcd digit")
+	ErrNeedsReadWrite = errors.New("point: bit-in-register point needs the current register value")
+)
+
+// dataType：内部数据类型
+// dataType: internal data type.
+type dataType uint8
+
+const (
+	typeInt16 dataType = iota + 1
+	typeUint16
+	typeInt32
+	typeUint32
+	typeInt64
+	typeUint64
+	typeFloat32
+	typeFloat64
+	typeBool
+	typeString
+	typeBCD
+	typeBitmask
+)
+
+// parseDataType：解析 DataType 字段，兼容常见别名
+// parseDataType: parse the DataType column, accepting common aliases.
+func parseDataType(s string) (dataType, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "int16", "s16", "i16", "short":
+		return typeInt16, nil
+	case "uint16", "u16", "word", "":
+		return typeUint16, nil
+	case "int32", "s32", "i32":
+		return typeInt32, nil
+	case "uint32", "u32", "dword":
+		return typeUint32, nil
+	case "int64", "s64", "i64":
+		return typeInt64, nil
+	case "uint64", "u64":
+		return typeUint64, nil
+	case "float32", "float", "f32", "real":
+		return typeFloat32, nil
+	case "float64", "double", "f64":
+		return typeFloat64, nil
+	case "bool", "boolean", "bit":
+		return typeBool, nil
+	case "string", "ascii", "str":
+		return typeString, nil
+	case "bcd", "bcd16", "bcd32":
+		return typeBCD, nil
+	case "bitmask", "bitfield":
+		return typeBitmask, nil
+	}
+	return 0, fmt.Errorf("point: unknown data type %q", s)
+}
+
+// parseByteOrder：解析 ByteOrder 字段，返回是否交换字内字节、是否反转字顺序
+// 字母表示大端下的字节位置：ABCD 为标准大端，BADC 字内交换，CDAB 字序交换，DCBA 两者都交换。
+// parseByteOrder: parse the ByteOrder column into byte-swap and word-swap flags.
+// Letters name the big-endian byte positions: ABCD is plain big-endian, BADC
+// swaps bytes within words, CDAB swaps words, DCBA does both.
+func parseByteOrder(s string) (byteSwap, wordSwap bool, err error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "", "AB", "ABCD", "ABCDEFGH":
+		return false, false, nil
+	case "BA", "BADC", "BADCFEHG":
+		return true, false, nil
+	case "CDAB", "GHEFCDAB":
+		return false, true, nil
+	case "DCBA", "HGFEDCBA":
+		return true, true, nil
+	}
+	return false, false, fmt.Errorf("point: unknown byte order %q", s)
+}
+
+// Codec：单个点位的编解码器，按 DataType/ByteOrder/BitIndex/Scale/Offset/Precision/EnumMap 解释原始数据
+// Codec: per-point codec interpreting raw data according to
+// DataType/ByteOrder/BitIndex/Scale/Offset/Precision/EnumMap.
+type Codec struct {
+	typ       dataType
+	byteSwap  bool
+	wordSwap  bool
+	bitIndex  int // -1 表示未设置 / -1 when unset
+	quantity  int
+	scale     float64
+	offset    float64
+	precision int
+	bits      bool // 线圈/离散量点位 / coil or discrete input point
+	enum      map[int64]string
+}
+
+// NewCodec：根据点位定义创建编解码器
+// NewCodec: build a codec from a point definition.
+func NewCodec(p *models.DeviceTypePoint) (*Codec, error) {
+	typ, err := parseDataType(p.DataType)
+	if err != nil {
+		return nil, err
+	}
+
+	byteSwap, wordSwap, err := parseByteOrder(p.ByteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Codec{
+		typ:       typ,
+		byteSwap:  byteSwap,
+		wordSwap:  wordSwap,
+		bitIndex:  -1,
+		quantity:  int(max(p.Quantity, 1)),
+		scale:     p.Scale,
+		offset:    p.Offset,
+		precision: p.Precision,
+	}
+	if c.scale == 0 {
+		c.scale = 1
+	}
+	// 位掩码按 uint64 收发，最多 4 个寄存器
+	// Bitmasks are carried as a uint64, 4 registers at most.
+	if typ == typeBitmask && c.quantity > 4 {
+		return nil, fmt.Errorf("point: bitmask spans %d registers, at most 4", c.quantity)
+	}
+
+	fc := ReadFunctionCode(p)
+	c.bits = fc == 1 || fc == 2
+
+	if p.BitIndex != nil {
+		c.bitIndex = int(*p.BitIndex)
+		if !c.bits && c.bitIndex >= 16*c.registers() {
+			return nil, fmt.Errorf("point: bit index %d out of range", c.bitIndex)
+		}
+	}
+
+	if len(p.EnumMapJSON) > 0 && string(p.EnumMapJSON) != "null" {
+		var raw map[string]string
+		if err := json.Unmarshal(p.EnumMapJSON, &raw); err != nil {
+			return nil, fmt.Errorf("point: invalid enum map: %w", err)
+		}
+		c.enum = make(map[int64]string, len(raw))
+		for k, v := range raw {
+			n, err := strconv.ParseInt(strings.TrimSpace(k), 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("point: invalid enum key %q: %w", k, err)
+			}
+			c.enum[n] = v
+		}
+	}
+
+	return c, nil
+}
+
+// registers：数据类型占用的寄存器数
+// registers: number of registers used by the data type.
+func (c *Codec) registers() int {
+	switch c.typ {
+	case typeInt32, typeUint32, typeFloat32:
+		return 2
+	case typeInt64, typeUint64, typeFloat64:
+		return 4
+	case typeInt16, typeUint16:
+		return 1
+	case typeBool:
+		if c.bitIndex >= 16 {
+			return (c.bitIndex + 16) / 16
+		}
+		return 1
+	}
+	return c.quantity
+}
+
+// Registers：点位占用的寄存器数 / Registers: number of registers the point spans.
+func (c *Codec) Registers() int {
+	return c.registers()
+}
+
+// IsBitPoint：是否为寄存器中的某一位
+// IsBitPoint: report whether the point is a single bit inside registers.
+func (c *Codec) IsBitPoint() bool {
+	return !c.bits && c.bitIndex >= 0
+}
+
+// bytes：按字节序把寄存器转换为大端字节
+// bytes: turn registers into big-endian bytes according to the byte order.
+func (c *Codec) bytes(words []uint16, n int) ([]byte, error) {
+	if len(words) < n {
+		return nil, ErrShortData
+	}
+
+	out := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		w := words[i]
+		if c.wordSwap && c.typ != typeString {
+			w = words[n-1-i]
+		}
+		if c.byteSwap {
+			w = w<<8 | w>>8
+		}
+		binary.BigEndian.PutUint16(out[2*i:], w)
+	}
+	return out, nil
+}
+
+// words：bytes 的逆操作
+// words: inverse of bytes.
+func (c *Codec) words(b []byte) []uint16 {
+	n := len(b) / 2
+	out := make([]uint16, n)
+	for i := 0; i < n; i++ {
+		w := binary.BigEndian.Uint16(b[2*i:])
+		if c.byteSwap {
+			w = w<<8 | w>>8
+		}
+		if c.wordSwap && c.typ != typeString {
+			out[n-1-i] = w
+		} else {
+			out[i] = w
+		}
+	}
+	return out
+}
+
+// Decode：把寄存器数据解码为带类型的值
+// Decode: decode register data into a typed value.
+func (c *Codec) Decode(words []uint16) (v models.Scalar, err error) {
+	n := c.registers()
+	b, err := c.bytes(words, n)
+	if err != nil {
+		return
+	}
+
+	if c.bitIndex >= 0 {
+		// 第 0 位为最后一个寄存器的最低位
+		// Bit 0 is the least significant bit of the last register.
+		var u uint64
+		for _, x := range b {
+			u = u<<8 | uint64(x)
+		}
+		on := (u>>uint(c.bitIndex))&1 == 1
+		if c.typ == typeBool {
+			v.SetBool(on)
+		} else if on {
+			v.SetUint64(1)
+		} else {
+			v.SetUint64(0)
+		}
+		return
+	}
+
+	switch c.typ {
+	case typeInt16:
+		c.setInt(&v, int64(int16(binary.BigEndian.Uint16(b))))
+	case typeUint16:
+		c.setUint(&v, uint64(binary.BigEndian.Uint16(b)))
+	case typeInt32:
+		c.setInt(&v, int64(int32(binary.BigEndian.Uint32(b))))
+	case typeUint32:
+		c.setUint(&v, uint64(binary.BigEndian.Uint32(b)))
+	case typeInt64:
+		c.setInt(&v, int64(binary.BigEndian.Uint64(b)))
+	case typeUint64:
+		c.setUint(&v, binary.BigEndian.Uint64(b))
+	case typeFloat32:
+		c.setFloat(&v, float64(math.Float32frombits(binary.BigEndian.Uint32(b))))
+	case typeFloat64:
+		c.setFloat(&v, math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case typeBool:
+		v.SetBool(binary.BigEndian.Uint16(b) != 0)
+	case typeString:
+		v.SetString(strings.TrimRight(string(b), "\x00 "))
+	case typeBCD:
+		var u uint64
+		for _, x := range b {
+			hi, lo := x>>4, x&0x0f
+			if hi > 9 || lo > 9 {
+				err = ErrInvalidBCD
+				return
+			}
+			u = u*100 + uint64(hi)*10 + uint64(lo)
+		}
+		c.setUint(&v, u)
+	case typeBitmask:
+		var u uint64
+		for _, x := range b {
+			u = u<<8 | uint64(x)
+		}
+		v.SetUint64(u)
+	}
+	return
+}
+
+// DecodeBits：把线圈/离散量数据解码为布尔值
+// DecodeBits: decode coil/discrete input data into a bool.
+func (c *Codec) DecodeBits(bits []bool) (v models.Scalar, err error) {
+	idx := 0
+	if c.bitIndex >= 0 {
+		idx = c.bitIndex
+	}
+	if idx >= len(bits) {
+		err = ErrShortData
+		return
+	}
+	v.SetBool(bits[idx])
+	return
+}
+
+// setInt/setUint/setFloat：应用 Scale/Offset/Precision
+// 未缩放的整数保持整数类型，其余结果为 float64。
+// setInt/setUint/setFloat: apply Scale/Offset/Precision.
+// Unscaled integers keep an integer kind; everything else becomes float64.
+func (c *Codec) setInt(v *models.Scalar, raw int64) {
+	if c.scale == 1 && c.offset == 0 {
+		v.SetInt64(raw)
+		return
+	}
+	c.setFloat(v, float64(raw))
+}
+
+func (c *Codec) setUint(v *models.Scalar, raw uint64) {
+	if c.scale == 1 && c.offset == 0 {
+		v.SetUint64(raw)
+		return
+	}
+	c.setFloat(v, float64(raw))
+}
+
+func (c *Codec) setFloat(v *models.Scalar, raw float64) {
+	f := raw*c.scale + c.offset
+	if c.precision > 0 {
+		p := math.Pow10(c.precision)
+		f = math.Round(f*p) / p
+	}
+	v.SetFloat64(f)
+}
+
+// Label：返回值对应的枚举标签
+// Label: return the enum label of a value.
+func (c *Codec) Label(v models.Scalar) (string, bool) {
+	if c.enum == nil {
+		return "", false
+	}
+	n, ok := toInt(v)
+	if !ok {
+		return "", false
+	}
+	s, ok := c.enum[n]
+	return s, ok
+}
+
+// Encode：把值编码为待写入的寄存器数据（Decode 的逆操作）
+// 字符串值在有枚举表时按标签反查。
+// Encode: encode a value into register data to write (inverse of Decode).
+// String values are looked up as enum labels when the point has an enum map.
+func (c *Codec) Encode(v models.Scalar) (words []uint16, err error) {
+	if c.bitIndex >= 0 {
+		err = ErrNeedsReadWrite
+		return
+	}
+
+	n := c.registers()
+	b := make([]byte, 2*n)
+
+	switch c.typ {
+	case typeString:
+		s, e := v.String()
+		if e != nil {
+			err = ErrNotWritable
+			return
+		}
+		if len(s) > len(b) {
+			err = ErrOutOfRange
+			return
+		}
+		copy(b, s)
+		words = c.words(b)
+		return
+	case typeFloat32, typeFloat64:
+		var f float64
+		if f, err = c.raw(v); err != nil {
+			return
+		}
+		if c.typ == typeFloat32 {
+			if math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+				err = ErrOutOfRange
+				return
+			}
+			binary.BigEndian.PutUint32(b, math.Float32bits(float32(f)))
+		} else {
+			binary.BigEndian.PutUint64(b, math.Float64bits(f))
+		}
+		words = c.words(b)
+		return
+	}
+
+	f, err := c.raw(v)
+	if err != nil {
+		return
+	}
+	f = math.Round(f)
+
+	switch c.typ {
+	case typeInt16:
+		if f < math.MinInt16 || f > math.MaxInt16 {
+			err = ErrOutOfRange
+			return
+		}
+		binary.BigEndian.PutUint16(b, uint16(int16(f)))
+	case typeUint16, typeBool:
+		if f < 0 || f > math.MaxUint16 {
+			err = ErrOutOfRange
+			return
+		}
+		binary.BigEndian.PutUint16(b, uint16(f))
+	case typeInt32:
+		if f < math.MinInt32 || f > math.MaxInt32 {
+			err = ErrOutOfRange
+			return
+		}
+		binary.BigEndian.PutUint32(b, uint32(int32(f)))
+	case typeUint32:
+		if f < 0 || f > math.MaxUint32 {
+			err = ErrOutOfRange
+			return
+		}
+		binary.BigEndian.PutUint32(b, uint32(f))
+	case typeInt64:
+		if f < math.MinInt64 || f >= math.MaxInt64 {
+			err = ErrOutOfRange
+			return
+		}
+		binary.BigEndian.PutUint64(b, uint64(int64(f)))
+	case typeUint64:
+		if f < 0 || f >= math.MaxUint64 {
+			err = ErrOutOfRange
+			return
+		}
+		binary.BigEndian.PutUint64(b, uint64(f))
+	case typeBCD:
+		if f < 0 || f >= math.Pow10(2*len(b)) {
+			err = ErrOutOfRange
+			return
+		}
+		u := uint64(f)
+		for i := len(b) - 1; i >= 0; i-- {
+			b[i] = byte(u%10) | byte((u/10)%10)<<4
+			u /= 100
+		}
+	case typeBitmask:
+		if f < 0 || (len(b) < 8 && f >= math.Pow(2, float64(8*len(b)))) {
+			err = ErrOutOfRange
+			return
+		}
+		u := uint64(f)
+		for i := len(b) - 1; i >= 0 && i >= len(b)-8; i-- {
+			b[i] = byte(u)
+			u >>= 8
+		}
+	}
+
+	words = c.words(b)
+	return
+}
+
+// EncodeBit：编码线圈点位或寄存器位点位的目标状态
+// EncodeBit: encode the target state of a coil or bit-in-register point.
+func (c *Codec) EncodeBit(v models.Scalar) (on bool, err error) {
+	f, err := c.raw(v)
+	if err != nil {
+		return
+	}
+	switch f {
+	case 0:
+		on = false
+	case 1:
+		on = true
+	default:
+		err = ErrOutOfRange
+	}
+	return
+}
+
+// ApplyBit：在当前寄存器数据上设置/清除点位对应的位（读-改-写）
+// ApplyBit: set or clear the point's bit on the current register data (read-modify-write).
+func (c *Codec) ApplyBit(cur []uint16, on bool) (words []uint16, err error) {
+	n := c.registers()
+	b, err := c.bytes(cur, n)
+	if err != nil {
+		return
+	}
+
+	byteIdx := len(b) - 1 - c.bitIndex/8
+	mask := byte(1) << uint(c.bitIndex%8)
+	if on {
+		b[byteIdx] |= mask
+	} else {
+		b[byteIdx] &^= mask
+	}
+
+	words = c.words(b)
+	return
+}
+
+// BitMask：返回寄存器位点位所在寄存器的偏移与位掩码，用于掩码写（0x16）
+// BitMask: return the register offset and bit mask of a bit-in-register point,
+// for use with mask write (0x16).
+func (c *Codec) BitMask() (offset int, mask uint16, err error) {
+	if !c.IsBitPoint() {
+		return 0, 0, ErrNotWritable
+	}
+
+	words, err := c.ApplyBit(make([]uint16, c.registers()), true)
+	if err != nil {
+		return
+	}
+	for i, w := range words {
+		if w != 0 {
+			return i, w, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("point: bit index %d out of range", c.bitIndex)
+}
+
+// raw：把工程值还原为原始值（反向 Scale/Offset）
+// raw: turn an engineering value back into a raw value (reverse Scale/Offset).
+func (c *Codec) raw(v models.Scalar) (f float64, err error) {
+	switch v.Kind {
+	case models.KindBool:
+		b, _ := v.Bool()
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	case models.KindString:
+		// 枚举键是工程值，与数值一样反向缩放
+		// Enum keys are engineering values, reversed like numbers.
+		s, _ := v.String()
+		found := false
+		for k, label := range c.enum {
+			if label == s {
+				f, found = float64(k), true
+				break
+			}
+		}
+		if !found && c.enum != nil {
+			return 0, ErrUnknownLabel
+		}
+		if !found {
+			if f, err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+				return 0, ErrNotWritable
+			}
+		}
+	case models.KindInt64:
+		n, _ := v.Int64()
+		f = float64(n)
+	case models.KindUint64:
+		n, _ := v.Uint64()
+		f = float64(n)
+	case models.KindFloat64:
+		f, _ = v.Float64()
+	default:
+		return 0, ErrNotWritable
+	}
+
+	if math.IsNaN(f) {
+		return 0, ErrOutOfRange
+	}
+	f = (f - c.offset) / c.scale
+	return f, nil
+}
+
+// toInt：把数值型 Scalar 转换为整数（用于枚举查找）
+// toInt: convert a numeric Scalar to an integer (for enum lookups).
+func toInt(v models.Scalar) (int64, bool) {
+	switch v.Kind {
+	case models.KindBool:
+		b, _ := v.Bool()
+		if b {
+			return 1, true
+		}
+		return 0, true
+	case models.KindInt64:
+		n, err := v.Int64()
+		return n, err == nil
+	case models.KindUint64:
+		n, err := v.Uint64()
+		return int64(n), err == nil
+	case models.KindFloat64:
+		f, err := v.Float64()
+		if err != nil || f != math.Trunc(f) {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// Format：把值格式化为便于阅读的文本
+// Format: render a value as human-readable text.
+func Format(v models.Scalar) string {
+	switch v.Kind {
+	case models.KindBool:
+		b, _ := v.Bool()
+		return strconv.FormatBool(b)
+	case models.KindInt64:
+		n, _ := v.Int64()
+		return strconv.FormatInt(n, 10)
+	case models.KindUint64:
+		n, _ := v.Uint64()
+		return strconv.FormatUint(n, 10)
+	case models.KindFloat64:
+		f, _ := v.Float64()
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	case models.KindString:
+		s, _ := v.String()
+		return strconv.Quote(s)
+	case models.KindBytes:
+		return fmt.Sprintf("% x", v.Raw)
+	}
+	return "<invalid>"
+}
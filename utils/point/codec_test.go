@@ -0,0 +1,185 @@
+package point
+
+import (
+	"testing"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+func mustCodec(t *testing.T, p models.DeviceTypePoint) *Codec {
+	t.Helper()
+
+	c, err := NewCodec(&p)
+	if err != nil {
+		t.Fatalf("NewCodec() should have succeeded, got: %v", err)
+	}
+	return c
+}
+
+func TestCodecByteOrders(t *testing.T) {
+	// 0x12345678 as laid out on the wire for each byte order
+	for order, words := range map[string][]uint16{
+		"ABCD": {0x1234, 0x5678},
+		"BADC": {0x3412, 0x7856},
+		"CDAB": {0x5678, 0x1234},
+		"DCBA": {0x7856, 0x3412},
+	} {
+		c := mustCodec(t, models.DeviceTypePoint{FC: 3, Quantity: 2, DataType: "uint32", ByteOrder: order, Scale: 1})
+
+		v, err := c.Decode(words)
+		if err != nil {
+			t.Errorf("%s: Decode() should have succeeded, got: %v", order, err)
+			continue
+		}
+		u, err := v.Uint64()
+		if err != nil || u != 0x12345678 {
+			t.Errorf("%s: expected 0x12345678, got: 0x%x (%v)", order, u, err)
+		}
+
+		out, err := c.Encode(v)
+		if err != nil {
+			t.Errorf("%s: Encode() should have succeeded, got: %v", order, err)
+			continue
+		}
+		if out[0] != words[0] || out[1] != words[1] {
+			t.Errorf("%s: expected %04x, got: %04x", order, words, out)
+		}
+	}
+}
+
+func TestCodecScaling(t *testing.T) {
+	var v models.Scalar
+
+	c := mustCodec(t, models.DeviceTypePoint{FC: 4, DataType: "int16", Scale: 0.1, Offset: -10, Precision: 1})
+
+	v, err := c.Decode([]uint16{0xfffb}) // -5
+	if err != nil {
+		t.Fatalf("Decode() should have succeeded, got: %v", err)
+	}
+	f, err := v.Float64()
+	if err != nil || f != -10.5 {
+		t.Errorf("expected -10.5, got: %v (%v)", f, err)
+	}
+
+	v.SetFloat64(2.3)
+	words, err := c.Encode(v)
+	if err != nil || words[0] != 123 {
+		t.Errorf("expected 123, got: %v (%v)", words, err)
+	}
+
+	v.SetFloat64(5000)
+	if _, err = c.Encode(v); err != ErrOutOfRange {
+		t.Errorf("expected ErrOutOfRange, got: %v", err)
+	}
+
+	c = mustCodec(t, models.DeviceTypePoint{FC: 3, Quantity: 2, DataType: "float32", Precision: 2})
+	v, err = c.Decode([]uint16{0x4049, 0x0fdb}) // pi
+	if err != nil {
+		t.Fatalf("Decode() should have succeeded, got: %v", err)
+	}
+	f, err = v.Float64()
+	if err != nil || f != 3.14 {
+		t.Errorf("expected 3.14, got: %v (%v)", f, err)
+	}
+}
+
+func TestCodecBitsStringsBCDAndEnums(t *testing.T) {
+	var bit uint8 = 3
+
+	c := mustCodec(t, models.DeviceTypePoint{FC: 3, DataType: "bool", BitIndex: &bit})
+	v, err := c.Decode([]uint16{0x0008})
+	if b, _ := v.Bool(); err != nil || !b {
+		t.Errorf("expected bit 3 to be set, got: %v (%v)", b, err)
+	}
+	words, err := c.ApplyBit([]uint16{0x00f8}, false)
+	if err != nil || words[0] != 0x00f0 {
+		t.Errorf("expected 0x00f0, got: %04x (%v)", words, err)
+	}
+	if _, err = c.Encode(v); err != ErrNeedsReadWrite {
+		t.Errorf("expected ErrNeedsReadWrite, got: %v", err)
+	}
+	if off, mask, err := c.BitMask(); err != nil || off != 0 || mask != 0x0008 {
+		t.Errorf("expected offset 0 mask 0x0008, got: %d %04x (%v)", off, mask, err)
+	}
+
+	// bit 0 is the least significant bit of the last register
+	bit = 20
+	c = mustCodec(t, models.DeviceTypePoint{FC: 3, Quantity: 2, DataType: "bool", BitIndex: &bit})
+	if off, mask, err := c.BitMask(); err != nil || off != 0 || mask != 0x0010 {
+		t.Errorf("expected offset 0 mask 0x0010, got: %d %04x (%v)", off, mask, err)
+	}
+
+	c = mustCodec(t, models.DeviceTypePoint{FC: 3, Quantity: 3, DataType: "string", ByteOrder: "BADC"})
+	v, err = c.Decode([]uint16{0x4753, 0x3132, 0x0033}) // "SG213"
+	if s, _ := v.String(); err != nil || s != "SG213" {
+		t.Errorf("expected SG213, got: %q (%v)", s, err)
+	}
+
+	c = mustCodec(t, models.DeviceTypePoint{FC: 3, Quantity: 2, DataType: "bcd", Scale: 1})
+	v, err = c.Decode([]uint16{0x0012, 0x3456})
+	if u, _ := v.Uint64(); err != nil || u != 123456 {
+		t.Errorf("expected 123456, got: %v (%v)", u, err)
+	}
+	words, err = c.Encode(v)
+	if err != nil || words[0] != 0x0012 || words[1] != 0x3456 {
+		t.Errorf("expected [0012 3456], got: %04x (%v)", words, err)
+	}
+	if _, err = c.Decode([]uint16{0x00a0, 0}); err != ErrInvalidBCD {
+		t.Errorf("expected ErrInvalidBCD, got: %v", err)
+	}
+
+	c = mustCodec(t, models.DeviceTypePoint{FC: 3, DataType: "uint16", Scale: 1,
+		EnumMapJSON: []byte(`{"0":"Off","1":"On","0x10":"Fault"}`)})
+	v, _ = c.Decode([]uint16{0x10})
+	if s, ok := c.Label(v); !ok || s != "Fault" {
+		t.Errorf("expected Fault, got: %q", s)
+	}
+	v.SetString("On")
+	words, err = c.Encode(v)
+	if err != nil || words[0] != 1 {
+		t.Errorf("expected [1], got: %v (%v)", words, err)
+	}
+	v.SetString("Standby")
+	if _, err = c.Encode(v); err != ErrUnknownLabel {
+		t.Errorf("expected ErrUnknownLabel, got: %v", err)
+	}
+
+	// enum keys are engineering values: labels are written like numbers
+	c = mustCodec(t, models.DeviceTypePoint{FC: 3, DataType: "uint16", Scale: 0.5, Offset: 10,
+		EnumMapJSON: []byte(`{"10":"Idle","30":"Run"}`)})
+	v.SetString("Run")
+	words, err = c.Encode(v)
+	if err != nil || words[0] != 40 {
+		t.Errorf("expected [40], got: %v (%v)", words, err)
+	}
+	v, _ = c.Decode(words)
+	if s, ok := c.Label(v); !ok || s != "Run" {
+		t.Errorf("expected Run to read back, got: %q", s)
+	}
+
+	c = mustCodec(t, models.DeviceTypePoint{FC: 1, DataType: "bool"})
+	v, err = c.DecodeBits([]bool{true})
+	if b, _ := v.Bool(); err != nil || !b {
+		t.Errorf("expected true, got: %v (%v)", b, err)
+	}
+}
+
+func TestNewCodecRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       models.DeviceTypePoint
+		wantErr bool
+	}{
+		{"unknown data type", models.DeviceTypePoint{FC: 3, DataType: "int128"}, true},
+		{"unknown byte order", models.DeviceTypePoint{FC: 3, DataType: "int32", ByteOrder: "ACBD"}, true},
+		{"invalid enum map", models.DeviceTypePoint{FC: 3, DataType: "uint16", EnumMapJSON: []byte(`{"x":"On"}`)}, true},
+		{"bitmask of 4 registers", models.DeviceTypePoint{FC: 3, DataType: "bitmask", Quantity: 4}, false},
+		{"bitmask of 5 registers", models.DeviceTypePoint{FC: 3, DataType: "bitmask", Quantity: 5}, true},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewCodec(&tt.p); (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error=%v, got: %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
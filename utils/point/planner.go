@@ -0,0 +1,161 @@
+package point
+
+import (
+	"sort"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+// 协议规定的单次读取上限
+// Protocol limits of a single read request.
+const (
+	MaxReadRegisters uint16 = 125  // FC 3/4
+	MaxReadBits      uint16 = 2000 // FC 1/2
+)
+
+// PlanOptions：读请求合并参数
+// PlanOptions: read coalescing parameters.
+type PlanOptions struct {
+	// MaxGap 是同一块内允许跨越的未使用地址数，0 表示只合并相邻地址
+	// MaxGap is the number of unused addresses a block may span; 0 merges adjacent addresses only.
+	MaxGap uint16
+
+	// MaxRegisters/MaxBits 限制单块大小，0 表示使用协议上限
+	// MaxRegisters/MaxBits cap the block size; 0 means the protocol limit.
+	MaxRegisters uint16
+	MaxBits      uint16
+}
+
+// Block：一次合并后的读请求
+// Block: one coalesced read request.
+type Block struct {
+	FC       uint8  // 读功能码 1/2/3/4 / read function code 1/2/3/4
+	Address  uint16 // 起始地址 / start address
+	Quantity uint16 // 寄存器或位数量 / register or bit count
+	Points   []int  // 块内点位在输入切片中的下标 / indexes of the block's points in the input slice
+}
+
+// ReadFunctionCode：把点位的功能码映射为读功能码（1/2/3/4）
+// 写功能码 5/15 对应线圈读 1，6/16 对应保持寄存器读 3；FC 为 0 时按 PointKind 推断。
+// ReadFunctionCode: map a point's function code to its read function code (1/2/3/4).
+// Write codes 5/15 read back with 1 and 6/16 with 3; FC 0 falls back to PointKind.
+func ReadFunctionCode(p *models.DeviceTypePoint) uint8 {
+	switch p.FC {
+	case 1, 5, 15:
+		return 1
+	case 2:
+		return 2
+	case 3, 6, 16:
+		return 3
+	case 4:
+		return 4
+	case 0:
+		switch p.PointKind {
+		case models.RegCoil:
+			return 1
+		case models.RegDiscrete:
+			return 2
+		case models.RegInput:
+			return 4
+		case models.RegHolding:
+			return 3
+		}
+	}
+	return 0
+}
+
+// Plan：按功能码把点位合并为最少的读请求
+// 无法识别功能码的点位会被忽略；单个点位超过块上限时独占一块。
+// Plan: merge points into the fewest read requests per function code.
+// Points with an unknown function code are skipped; a point larger than the
+// block limit gets a block of its own.
+func Plan(points []models.DeviceTypePoint, opt PlanOptions) []Block {
+	byFC := make(map[uint8][]int)
+	for i := range points {
+		fc := ReadFunctionCode(&points[i])
+		if fc == 0 {
+			continue
+		}
+		byFC[fc] = append(byFC[fc], i)
+	}
+
+	var blocks []Block
+	for _, fc := range []uint8{1, 2, 3, 4} {
+		idx := byFC[fc]
+		if len(idx) == 0 {
+			continue
+		}
+
+		limit := opt.MaxRegisters
+		if limit == 0 || limit > MaxReadRegisters {
+			limit = MaxReadRegisters
+		}
+		if fc == 1 || fc == 2 {
+			limit = opt.MaxBits
+			if limit == 0 || limit > MaxReadBits {
+				limit = MaxReadBits
+			}
+		}
+
+		sort.SliceStable(idx, func(a, b int) bool {
+			return points[idx[a]].Address < points[idx[b]].Address
+		})
+
+		var cur *Block
+		var end int // 当前块的结束地址（不含） / exclusive end address of the current block
+		for _, i := range idx {
+			start := int(points[i].Address)
+			stop := start + int(Quantity(&points[i]))
+
+			if cur != nil && start <= end+int(opt.MaxGap) && max(stop, end)-int(cur.Address) <= int(limit) {
+				cur.Points = append(cur.Points, i)
+				end = max(end, stop)
+				cur.Quantity = uint16(end - int(cur.Address))
+				continue
+			}
+
+			blocks = append(blocks, Block{
+				FC:       fc,
+				Address:  points[i].Address,
+				Quantity: uint16(stop - start),
+				Points:   []int{i},
+			})
+			cur = &blocks[len(blocks)-1]
+			end = stop
+		}
+	}
+
+	return blocks
+}
+
+// Extract：从块的读取结果中取出单个点位的数据
+// Extract: take a single point's data out of a block's read result.
+func Extract[T any](b *Block, data []T, p *models.DeviceTypePoint) []T {
+	off := int(p.Address) - int(b.Address)
+	n := int(Quantity(p))
+	if off < 0 || off+n > len(data) {
+		return nil
+	}
+	return data[off : off+n]
+}
+
+// Quantity：点位占用的寄存器/位数量，0 按 1 处理；
+// 寄存器点位至少按数据类型所需的寄存器数计算（如 32 位类型为 2）
+// Quantity: registers/bits used by a point; 0 counts as 1. Register points
+// span at least as many registers as their data type needs (2 for 32-bit types).
+func Quantity(p *models.DeviceTypePoint) uint16 {
+	n := max(p.Quantity, 1)
+	if fc := ReadFunctionCode(p); fc == 1 || fc == 2 {
+		return n
+	}
+
+	typ, err := parseDataType(p.DataType)
+	if err != nil {
+		return n
+	}
+	c := Codec{typ: typ, bitIndex: -1, quantity: int(n)}
+	if p.BitIndex != nil {
+		c.bitIndex = int(*p.BitIndex)
+	}
+	return max(n, uint16(c.registers()))
+}
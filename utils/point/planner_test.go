@@ -0,0 +1,120 @@
+package point
+
+import (
+	"testing"
+
+	"github.com/fluxionwatt/gridbeat/internal/models"
+)
+
+func TestPlanMergesAdjacentAndGapped(t *testing.T) {
+	points := []models.DeviceTypePoint{
+		{PointCode: "c", FC: 3, Address: 10, Quantity: 2},
+		{PointCode: "a", FC: 3, Address: 0, Quantity: 2},
+		{PointCode: "b", FC: 3, Address: 2, Quantity: 1},
+		{PointCode: "d", FC: 4, Address: 0, Quantity: 1},
+		{PointCode: "e", FC: 1, Address: 5, Quantity: 1},
+		{PointCode: "f", FC: 5, Address: 6, Quantity: 1},
+	}
+
+	// without gap tolerance: {a,b} and {c} stay apart
+	blocks := Plan(points, PlanOptions{})
+	if len(blocks) != 4 {
+		t.Fatalf("expected 4 blocks, got %d: %+v", len(blocks), blocks)
+	}
+	if blocks[0].FC != 1 || blocks[0].Address != 5 || blocks[0].Quantity != 2 || len(blocks[0].Points) != 2 {
+		t.Errorf("unexpected coil block: %+v", blocks[0])
+	}
+	if blocks[1].FC != 3 || blocks[1].Address != 0 || blocks[1].Quantity != 3 {
+		t.Errorf("unexpected first holding block: %+v", blocks[1])
+	}
+	if blocks[2].FC != 3 || blocks[2].Address != 10 || blocks[2].Quantity != 2 {
+		t.Errorf("unexpected second holding block: %+v", blocks[2])
+	}
+	if blocks[3].FC != 4 || blocks[3].Address != 0 || blocks[3].Quantity != 1 {
+		t.Errorf("unexpected input block: %+v", blocks[3])
+	}
+
+	// with a gap of 7 unused registers the holding points collapse into one block
+	blocks = Plan(points, PlanOptions{MaxGap: 7})
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %d: %+v", len(blocks), blocks)
+	}
+	if blocks[1].Address != 0 || blocks[1].Quantity != 12 || len(blocks[1].Points) != 3 {
+		t.Errorf("unexpected merged holding block: %+v", blocks[1])
+	}
+
+	words := []uint16{1, 2, 3, 0, 0, 0, 0, 0, 0, 0, 4, 5}
+	got := Extract(&blocks[1], words, &points[0])
+	if len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Errorf("expected [4 5] for point c, got %v", got)
+	}
+	got = Extract(&blocks[1], words, &points[2])
+	if len(got) != 1 || got[0] != 3 {
+		t.Errorf("expected [3] for point b, got %v", got)
+	}
+}
+
+func TestPlanRespectsLimits(t *testing.T) {
+	var points []models.DeviceTypePoint
+
+	for addr := uint16(0); addr < 300; addr += 2 {
+		points = append(points, models.DeviceTypePoint{FC: 3, Address: addr, Quantity: 2})
+	}
+
+	blocks := Plan(points, PlanOptions{})
+	for _, b := range blocks {
+		if b.Quantity > MaxReadRegisters {
+			t.Errorf("block exceeds protocol limit: %+v", b)
+		}
+	}
+	if len(blocks) != 3 {
+		t.Errorf("expected 3 blocks, got %d", len(blocks))
+	}
+
+	// per-device override
+	blocks = Plan(points, PlanOptions{MaxRegisters: 10})
+	if len(blocks) != 30 {
+		t.Errorf("expected 30 blocks, got %d", len(blocks))
+	}
+	for _, b := range blocks {
+		if b.Quantity != 10 || len(b.Points) != 5 {
+			t.Errorf("unexpected block: %+v", b)
+		}
+	}
+
+	// coils use the 2000-bit limit
+	points = points[:0]
+	for addr := uint16(0); addr < 2500; addr++ {
+		points = append(points, models.DeviceTypePoint{FC: 1, Address: addr, Quantity: 1})
+	}
+	blocks = Plan(points, PlanOptions{})
+	if len(blocks) != 2 || blocks[0].Quantity != MaxReadBits || blocks[1].Quantity != 500 {
+		t.Errorf("unexpected coil blocks: %d", len(blocks))
+	}
+}
+
+func TestPlanSizesPointsByDataType(t *testing.T) {
+	points := []models.DeviceTypePoint{
+		{PointCode: "a", FC: 3, Address: 0, Quantity: 0, DataType: "float32"},
+		{PointCode: "b", FC: 3, Address: 2, Quantity: 1, DataType: "uint32"},
+		{PointCode: "c", FC: 3, Address: 4, Quantity: 1, DataType: "int16"},
+	}
+
+	blocks := Plan(points, PlanOptions{})
+	if len(blocks) != 1 || blocks[0].Address != 0 || blocks[0].Quantity != 5 {
+		t.Fatalf("expected a single block of 5 registers, got %+v", blocks)
+	}
+
+	words := []uint16{1, 2, 3, 4, 5}
+	for i, want := range [][]uint16{{1, 2}, {3, 4}, {5}} {
+		got := Extract(&blocks[0], words, &points[i])
+		if len(got) != len(want) || got[0] != want[0] || got[len(got)-1] != want[len(want)-1] {
+			t.Errorf("expected %v for point %s, got %v", want, points[i].PointCode, got)
+		}
+	}
+
+	// a 32-bit point at the end of a short read is incomplete
+	if got := Extract(&blocks[0], words[:3], &points[1]); got != nil {
+		t.Errorf("expected nil for a truncated point, got %v", got)
+	}
+}